@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/containerd/log"
+	"github.com/pelletier/go-toml"
+)
+
+// AliasConfig maps short, stable image names to the image references they stand for
+type AliasConfig struct {
+	Aliases map[string]string `toml:"aliases,omitempty"`
+}
+
+// NewAliasConfig unmarshalls an alias configuration file and sets up an AliasConfig
+func NewAliasConfig(aliasConfigFile string) (*AliasConfig, error) {
+	raw, err := os.ReadFile(aliasConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := AliasConfig{}
+	return &config, toml.Unmarshal(raw, &config)
+}
+
+// resolve returns the image reference that source is an alias for. Aliases may point to other
+// aliases, which are followed until a name that isn't an alias is reached. Sources that aren't
+// aliases are returned unchanged.
+func (aliasConfig *AliasConfig) resolve(source string) (string, error) {
+	seen := map[string]bool{}
+	ref := source
+	for {
+		target, ok := aliasConfig.Aliases[ref]
+		if !ok {
+			return ref, nil
+		}
+		if seen[ref] {
+			return "", fmt.Errorf("image alias %q is recursive", source)
+		}
+		seen[ref] = true
+		ref = target
+	}
+}
+
+// resolveImageAlias resolves source using the alias config at the given path, if a path is provided
+func resolveImageAlias(aliasConfigPath string, source string) (string, error) {
+	if aliasConfigPath == "" {
+		return source, nil
+	}
+	aliasConfig, err := NewAliasConfig(aliasConfigPath)
+	if err != nil {
+		log.L.WithError(err).WithField("alias-config", aliasConfigPath).Error("failed to read alias config")
+		return "", err
+	}
+	ref, err := aliasConfig.resolve(source)
+	if err != nil {
+		return "", err
+	}
+	if ref != source {
+		log.L.WithField("alias", source).WithField("source", ref).Info("resolved image alias")
+	}
+	return ref, nil
+}
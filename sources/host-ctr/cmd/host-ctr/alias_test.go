@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveAlias(t *testing.T) {
+	config := AliasConfig{
+		Aliases: map[string]string{
+			"control":       "public.ecr.aws/bottlerocket/bottlerocket-control:v0.7.17",
+			"admin":         "admin-pinned",
+			"admin-pinned":  "public.ecr.aws/bottlerocket/bottlerocket-admin:v0.11.13",
+			"self":          "self",
+			"cycle-a":       "cycle-b",
+			"cycle-b":       "cycle-a",
+			"points-at-bad": "cycle-a",
+		},
+	}
+	tests := []struct {
+		name        string
+		source      string
+		expectedErr bool
+		expectedRef string
+	}{
+		{
+			"Alias hit",
+			"control",
+			false,
+			"public.ecr.aws/bottlerocket/bottlerocket-control:v0.7.17",
+		},
+		{
+			"Alias miss",
+			"public.ecr.aws/bottlerocket/bottlerocket-control:v0.7.18",
+			false,
+			"public.ecr.aws/bottlerocket/bottlerocket-control:v0.7.18",
+		},
+		{
+			"Alias of an alias",
+			"admin",
+			false,
+			"public.ecr.aws/bottlerocket/bottlerocket-admin:v0.11.13",
+		},
+		{
+			"Alias of itself",
+			"self",
+			true,
+			"",
+		},
+		{
+			"Recursive aliases",
+			"cycle-a",
+			true,
+			"",
+		},
+		{
+			"Alias of a recursive alias",
+			"points-at-bad",
+			true,
+			"",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := config.resolve(tc.source)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedRef, ref)
+		})
+	}
+}
+
+func TestResolveImageAlias(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aliases.toml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+[aliases]
+control = "public.ecr.aws/bottlerocket/bottlerocket-control:v0.7.17"
+`), 0o644))
+
+	ref, err := resolveImageAlias(path, "control")
+	assert.NoError(t, err)
+	assert.Equal(t, "public.ecr.aws/bottlerocket/bottlerocket-control:v0.7.17", ref)
+
+	ref, err = resolveImageAlias("", "control")
+	assert.NoError(t, err)
+	assert.Equal(t, "control", ref)
+
+	_, err = resolveImageAlias(filepath.Join(t.TempDir(), "missing.toml"), "control")
+	assert.Error(t, err)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	"github.com/pkg/errors"
+)
+
+// platformContainerIDs returns the platform to run for each container ID when running a
+// container for every platform of the image. Container IDs are named after the platform's
+// architecture and variant, such as `id-amd64` or `id-arm-v7`.
+func platformContainerIDs(ctx context.Context, resolver remotes.Resolver, ref string, containerID string) (map[string]string, error) {
+	pins, err := resolvePlatformDigests(ctx, resolver, ref)
+	if err != nil {
+		return nil, err
+	}
+	var specifiers []string
+	for specifier := range pins {
+		specifiers = append(specifiers, specifier)
+	}
+	sort.Strings(specifiers)
+
+	containers := map[string]string{}
+	for _, specifier := range specifiers {
+		platform, err := platforms.Parse(specifier)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid platform %q in %q", specifier, ref)
+		}
+		id := containerID + "-" + platform.Architecture
+		if platform.Variant != "" {
+			id += "-" + platform.Variant
+		}
+		// The same architecture for different operating systems can't share the container ID
+		if previous, ok := containers[id]; ok {
+			return nil, fmt.Errorf("platforms %s and %s of %q would both run as container %s", previous, specifier, ref, id)
+		}
+		containers[id] = specifier
+	}
+	return containers, nil
+}
+
+// How long a container's task gets to exit after SIGTERM before it is killed
+const defaultStopGracePeriod = 20 * time.Second
+
+// shutdownEntry is a platform's container in the shutdown order, with how long its task gets to
+// exit after SIGTERM
+type shutdownEntry struct {
+	platform    string
+	gracePeriod time.Duration
+}
+
+// parseShutdownOrder parses the shutdown order from `platform[=grace-period]` entries, listed in
+// the order the containers depend on each other, such as `linux/amd64=30s`
+func parseShutdownOrder(entries []string) ([]shutdownEntry, error) {
+	var order []shutdownEntry
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		specifier, grace, hasGrace := strings.Cut(entry, "=")
+		platform, err := platforms.Parse(specifier)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid platform in shutdown order entry %q", entry)
+		}
+		e := shutdownEntry{platform: platforms.Format(platform), gracePeriod: defaultStopGracePeriod}
+		if hasGrace {
+			if e.gracePeriod, err = time.ParseDuration(grace); err != nil || e.gracePeriod <= 0 {
+				return nil, fmt.Errorf("invalid grace period in shutdown order entry %q", entry)
+			}
+		}
+		if seen[e.platform] {
+			return nil, fmt.Errorf("platform %s is listed twice in the shutdown order", e.platform)
+		}
+		seen[e.platform] = true
+		order = append(order, e)
+	}
+	return order, nil
+}
+
+// shutdownSequence returns the IDs of the containers in the order they are stopped in: the
+// containers of platforms missing from the shutdown order first, then the rest in reverse
+// shutdown order
+func shutdownSequence(containers map[string]string, order []shutdownEntry) []string {
+	var unordered, sequence []string
+	for id, platform := range containers {
+		if !slices.ContainsFunc(order, func(e shutdownEntry) bool { return e.platform == platform }) {
+			unordered = append(unordered, id)
+		}
+	}
+	sort.Strings(unordered)
+	for i := len(order) - 1; i >= 0; i-- {
+		for id, platform := range containers {
+			if platform == order[i].platform {
+				sequence = append(sequence, id)
+			}
+		}
+	}
+	return append(unordered, sequence...)
+}
+
+// stopInSequence stops the containers in sequence, waiting for each container to be done before
+// stopping the next
+func stopInSequence(ctx context.Context, sequence []string, stop map[string]chan struct{}, done map[string]chan struct{}) {
+	for _, id := range sequence {
+		log.G(ctx).WithField("ctr-id", id).Info("stopping container for platform")
+		close(stop[id])
+		<-done[id]
+	}
+}
+
+// runCtrAllPlatforms runs a container for each platform of the image in source, which is meant
+// for hosts that emulate other architectures. On SIGTERM or SIGINT, the containers are stopped one
+// after another as the shutdown order requires. It returns once every container exited, with the
+// first error.
+func runCtrAllPlatforms(containerdSocket string, namespace string, containerID string, source string, superpowered bool, cType containerType, ctrOpts containerOptions, pullOpts pullOptions, order []shutdownEntry) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ref, resolver, _, err := remoteResolver(ctx, source, pullOpts)
+	if err != nil {
+		return err
+	}
+	containers, err := platformContainerIDs(ctx, resolver, ref, containerID)
+	if err != nil {
+		log.G(ctx).WithField("ref", ref).Error(err)
+		return err
+	}
+	return runPlatformContainers(ctx, containers, ctrOpts, pullOpts, order, func(id string, ctrOpts containerOptions, pullOpts pullOptions) error {
+		return runCtr(containerdSocket, namespace, id, source, superpowered, cType, ctrOpts, pullOpts)
+	})
+}
+
+// runPlatformContainers runs the container for each platform with run, which is passed the
+// container ID and the options for the platform, and stops them in order on SIGINT or SIGTERM
+func runPlatformContainers(ctx context.Context, containers map[string]string, ctrOpts containerOptions, pullOpts pullOptions, order []shutdownEntry, run func(id string, ctrOpts containerOptions, pullOpts pullOptions) error) error {
+	// Stop the containers from here rather than have each of them stop on the signal at once
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		stop     = map[string]chan struct{}{}
+		done     = map[string]chan struct{}{}
+	)
+	for id, platform := range containers {
+		log.G(ctx).WithField("ctr-id", id).WithField("platform", platform).Info("running container for platform")
+		platformOpts := pullOpts
+		platformOpts.platform = platform
+		platformCtrOpts := ctrOpts
+		platformCtrOpts.stopGracePeriod = defaultStopGracePeriod
+		for _, e := range order {
+			if e.platform == platform {
+				platformCtrOpts.stopGracePeriod = e.gracePeriod
+			}
+		}
+		stop[id] = make(chan struct{})
+		done[id] = make(chan struct{})
+		platformCtrOpts.stopC = stop[id]
+		wg.Add(1)
+		go func(id string, done chan struct{}) {
+			defer wg.Done()
+			defer close(done)
+			if err := run(id, platformCtrOpts, platformOpts); err != nil {
+				log.G(ctx).WithError(err).WithField("ctr-id", id).Error("container for platform failed")
+				mu.Lock()
+				defer mu.Unlock()
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}(id, done[id])
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(exited)
+	}()
+	select {
+	case sigrecv := <-signals:
+		log.G(ctx).Info("received signal: ", sigrecv)
+		stopInSequence(ctx, shutdownSequence(containers, order), stop, done)
+	case <-exited:
+	}
+	<-exited
+	return firstErr
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlatformContainerIDs(t *testing.T) {
+	registry := newFakeRegistry(t)
+	amd64, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("amd64 layer"))
+	arm64, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "arm64"}, []byte("arm64 layer"))
+	registry.tag("bottlerocket/container", "latest", registry.addIndex(t, amd64, arm64))
+	armv7, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, []byte("arm layer"))
+	registry.tag("bottlerocket/container", "arm", registry.addIndex(t, amd64, armv7))
+	windows, _ := registry.addImage(t, ocispec.Platform{OS: "windows", Architecture: "amd64"}, []byte("windows layer"))
+	registry.tag("bottlerocket/container", "windows", registry.addIndex(t, amd64, windows))
+
+	containers, err := platformContainerIDs(context.TODO(), registry.resolver(), "registry.example.com/bottlerocket/container:latest", "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"admin-amd64": "linux/amd64",
+		"admin-arm64": "linux/arm64",
+	}, containers)
+
+	containers, err = platformContainerIDs(context.TODO(), registry.resolver(), "registry.example.com/bottlerocket/container:arm", "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"admin-amd64":  "linux/amd64",
+		"admin-arm-v7": "linux/arm/v7",
+	}, containers)
+
+	// Container IDs must be unique
+	_, err = platformContainerIDs(context.TODO(), registry.resolver(), "registry.example.com/bottlerocket/container:windows", "admin")
+	assert.Error(t, err)
+}
+
+func TestRunPlatformContainers(t *testing.T) {
+	registry := newFakeRegistry(t)
+	amd64, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("amd64 layer"))
+	arm64, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "arm64"}, []byte("arm64 layer"))
+	registry.tag("bottlerocket/container", "latest", registry.addIndex(t, amd64, arm64))
+	containers, err := platformContainerIDs(context.TODO(), registry.resolver(), "registry.example.com/bottlerocket/container:latest", "admin")
+	assert.NoError(t, err)
+
+	// One container is run for each platform in the index, pulling the platform's image
+	var (
+		mu      sync.Mutex
+		created = map[string]string{}
+		stopCs  = map[<-chan struct{}]bool{}
+	)
+	order := []shutdownEntry{{platform: "linux/arm64", gracePeriod: time.Second}}
+	err = runPlatformContainers(context.TODO(), containers, containerOptions{}, pullOptions{}, order, func(id string, ctrOpts containerOptions, pullOpts pullOptions) error {
+		mu.Lock()
+		defer mu.Unlock()
+		created[id] = pullOpts.platform
+		stopCs[ctrOpts.stopC] = true
+		if pullOpts.platform == "linux/arm64" {
+			assert.Equal(t, time.Second, ctrOpts.stopGracePeriod)
+		} else {
+			assert.Equal(t, defaultStopGracePeriod, ctrOpts.stopGracePeriod)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"admin-amd64": "linux/amd64",
+		"admin-arm64": "linux/arm64",
+	}, created)
+	assert.Len(t, stopCs, 2)
+}
+
+func TestParseShutdownOrder(t *testing.T) {
+	order, err := parseShutdownOrder([]string{"linux/amd64=30s", "linux/arm64"})
+	assert.NoError(t, err)
+	assert.Equal(t, []shutdownEntry{
+		{platform: "linux/amd64", gracePeriod: 30 * time.Second},
+		{platform: "linux/arm64", gracePeriod: defaultStopGracePeriod},
+	}, order)
+
+	for _, entries := range [][]string{
+		{"linux/amd64=soon"},
+		{"linux/amd64=0s"},
+		{"linux/amd64", "linux/amd64=5s"},
+		{"not a platform/x/y/z"},
+	} {
+		_, err := parseShutdownOrder(entries)
+		assert.Error(t, err, "expected %q to be invalid", entries)
+	}
+}
+
+func TestShutdownSequence(t *testing.T) {
+	containers := map[string]string{
+		"admin-amd64":  "linux/amd64",
+		"admin-arm64":  "linux/arm64",
+		"admin-arm-v7": "linux/arm/v7",
+		"admin-s390x":  "linux/s390x",
+	}
+	order := []shutdownEntry{{platform: "linux/arm64"}, {platform: "linux/amd64"}}
+	// Unlisted platforms stop first, then the listed ones in reverse order
+	assert.Equal(t, []string{"admin-arm-v7", "admin-s390x", "admin-amd64", "admin-arm64"}, shutdownSequence(containers, order))
+	assert.Equal(t, []string{"admin-amd64", "admin-arm-v7", "admin-arm64", "admin-s390x"}, shutdownSequence(containers, nil))
+}
+
+func TestStopInSequence(t *testing.T) {
+	sequence := []string{"admin-arm64", "admin-amd64"}
+	var (
+		mu      sync.Mutex
+		stopped []string
+		running = map[string]bool{}
+		stop    = map[string]chan struct{}{}
+		done    = map[string]chan struct{}{}
+	)
+	for _, id := range sequence {
+		stop[id] = make(chan struct{})
+		done[id] = make(chan struct{})
+		running[id] = true
+	}
+	for _, id := range sequence {
+		go func(id string, stop <-chan struct{}, done chan struct{}) {
+			<-stop
+			mu.Lock()
+			defer mu.Unlock()
+			// Every container stopped before this one already exited
+			for _, previous := range stopped {
+				assert.False(t, running[previous], "%s stopped before %s exited", id, previous)
+			}
+			stopped = append(stopped, id)
+			// Take a while to exit, as a task using its grace period would
+			time.Sleep(10 * time.Millisecond)
+			running[id] = false
+			close(done)
+		}(id, stop[id], done[id])
+	}
+	stopInSequence(context.TODO(), sequence, stop, done)
+	assert.Equal(t, sequence, stopped)
+}
+
+// fakeImageStore is an in-memory containerd image store
+type fakeImageStore struct {
+	images.Store
+	images map[string]images.Image
+}
+
+func (s *fakeImageStore) Get(_ context.Context, name string) (images.Image, error) {
+	img, ok := s.images[name]
+	if !ok {
+		return images.Image{}, errdefs.ErrNotFound
+	}
+	return img, nil
+}
+
+func TestGetImageForPlatform(t *testing.T) {
+	registry := newFakeRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	amd64Manifest, _ := registry.addImage(t, amd64, []byte("amd64 layer"))
+	arm64Manifest, _ := registry.addImage(t, arm64, []byte("arm64 layer"))
+	index := registry.addIndex(t, amd64Manifest, arm64Manifest)
+	registry.tag("bottlerocket/container", "latest", index)
+	ref := "registry.example.com/bottlerocket/container:latest"
+
+	// Both platforms of the image are cached, as after an --all-platforms run
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, platform := range []ocispec.Platform{amd64, arm64} {
+		_, err = fetchToContentStore(context.TODO(), registry.resolver(), ref, platforms.Only(platform), store, nil)
+		assert.NoError(t, err)
+	}
+	client, err := containerd.New("", containerd.WithServices(
+		containerd.WithContentStore(store),
+		containerd.WithImageStore(&fakeImageStore{images: map[string]images.Image{ref: {Name: ref, Target: index}}}),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each platform's container gets its own platform's config from the cached image
+	for _, platform := range []ocispec.Platform{amd64, arm64} {
+		img, err := getImage(context.TODO(), client, ref, platforms.Only(platform))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		spec, err := img.Spec(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, platform.Architecture, spec.Architecture)
+	}
+
+	_, err = getImage(context.TODO(), client, "registry.example.com/bottlerocket/missing:latest", platforms.Only(amd64))
+	assert.True(t, errdefs.IsNotFound(err))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+)
+
+// Whether to retry registry hosts anonymously when authenticating with the configured
+// credentials fails, set up from the command line
+var registryAnonymousFallback bool
+
+// anonymousFallbackAuthorizer authorizes requests with the configured credentials, switching a
+// host to anonymous access if authorizing with the credentials fails. This is for registries that
+// serve public images but reject the credentials, which are meant for other repositories.
+type anonymousFallbackAuthorizer struct {
+	authorizer docker.Authorizer
+	anonymous  docker.Authorizer
+
+	mu sync.Mutex
+	// The hosts that have fallen back to anonymous access
+	fallback map[string]bool
+}
+
+// newAnonymousFallbackAuthorizer wraps authorizer to fall back to anonymous access, requesting
+// anonymous tokens with client
+func newAnonymousFallbackAuthorizer(authorizer docker.Authorizer, client *http.Client) *anonymousFallbackAuthorizer {
+	return &anonymousFallbackAuthorizer{
+		authorizer: authorizer,
+		anonymous:  docker.NewDockerAuthorizer(docker.WithAuthClient(client)),
+		fallback:   map[string]bool{},
+	}
+}
+
+// isAnonymous returns whether the host has fallen back to anonymous access
+func (a *anonymousFallbackAuthorizer) isAnonymous(host string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.fallback[host]
+}
+
+// fallBack switches the host to anonymous access
+func (a *anonymousFallbackAuthorizer) fallBack(ctx context.Context, host string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.fallback[host] {
+		log.G(ctx).WithError(err).WithField("host", host).Warn("authentication failed, retrying anonymously")
+		a.fallback[host] = true
+	}
+}
+
+// Authorize adds the authorization for the request. Credentials are rejected when fetching a
+// token with them, in which case the request is sent without authorization so that the registry
+// responds with a new challenge for anonymous access.
+func (a *anonymousFallbackAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	host := req.URL.Host
+	if a.isAnonymous(host) {
+		return a.anonymous.Authorize(ctx, req)
+	}
+	if err := a.authorizer.Authorize(ctx, req); err != nil {
+		a.fallBack(ctx, host, err)
+		req.Header.Del("Authorization")
+		return a.anonymous.Authorize(ctx, req)
+	}
+	return nil
+}
+
+// AddResponses handles the registry's authentication challenges. A repeated challenge means the
+// registry rejected the credentials, which also falls back to anonymous access.
+func (a *anonymousFallbackAuthorizer) AddResponses(ctx context.Context, responses []*http.Response) error {
+	last := responses[len(responses)-1]
+	host := last.Request.URL.Host
+	if !a.isAnonymous(host) {
+		err := a.authorizer.AddResponses(ctx, responses)
+		if err == nil {
+			return nil
+		}
+		a.fallBack(ctx, host, err)
+	}
+	// Earlier responses were for the credentials, which would make the anonymous challenge look repeated
+	return a.anonymous.AddResponses(ctx, responses[len(responses)-1:])
+}
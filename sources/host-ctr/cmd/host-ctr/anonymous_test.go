@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTokenRegistry starts a registry whose token server rejects every credential but issues
+// tokens for anonymous access
+func newTokenRegistry(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.Method != http.MethodGet || r.Header.Get("Authorization") != "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token":"anonymous"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer anonymous" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:bottlerocket/container:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a")
+		w.Header().Set("Content-Length", "2")
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, "{}")
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAnonymousFallback(t *testing.T) {
+	server := newTokenRegistry(t)
+	config := &RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"*": {Endpoints: []string{server.URL}},
+		},
+		Credentials: map[string]Credential{
+			"registry.example.com": {Username: "user", Password: "wrong"},
+		},
+	}
+	ref := "registry.example.com/bottlerocket/container:latest"
+	tests := []struct {
+		name     string
+		fallback bool
+		success  bool
+	}{
+		{"without fallback", false, false},
+		{"with fallback", true, true},
+	}
+	defer func(fallback bool) { registryAnonymousFallback = fallback }(registryAnonymousFallback)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			registryAnonymousFallback = tc.fallback
+			registries, err := registryHosts(config, nil, ref)("registry.example.com")
+			assert.NoError(t, err)
+			// Only try the mirror, the upstream registry isn't reachable
+			resolver := docker.NewResolver(docker.ResolverOptions{
+				Hosts: func(string) ([]docker.RegistryHost, error) {
+					return registries[:1], nil
+				},
+			})
+			_, desc, err := resolver.Resolve(context.Background(), ref)
+			if !tc.success {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(desc.Digest.String(), "sha256:"))
+		})
+	}
+}
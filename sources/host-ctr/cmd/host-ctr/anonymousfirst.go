@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/containerd/remotes/docker/auth"
+	"github.com/containerd/log"
+)
+
+// Whether to authorize with an anonymous token before the configured credentials, set up from the
+// command line
+var registryAnonymousFirst bool
+
+// anonymousFirstAuthorizer authorizes requests to each host with an anonymous token until the
+// registry asks for more access than the anonymous token grants, then switches the host to the
+// configured credentials. This is for registries whose first challenge is for an anonymous token,
+// and which only challenge for an authenticated one once the anonymous token is presented for a
+// private repository.
+type anonymousFirstAuthorizer struct {
+	authorizer docker.Authorizer
+	anonymous  docker.Authorizer
+
+	mu sync.Mutex
+	// The hosts that have switched to the configured credentials
+	authenticated map[string]bool
+}
+
+// newAnonymousFirstAuthorizer wraps authorizer to start with anonymous tokens, requested with
+// client
+func newAnonymousFirstAuthorizer(authorizer docker.Authorizer, client *http.Client) *anonymousFirstAuthorizer {
+	return &anonymousFirstAuthorizer{
+		authorizer:    authorizer,
+		anonymous:     docker.NewDockerAuthorizer(docker.WithAuthClient(client)),
+		authenticated: map[string]bool{},
+	}
+}
+
+// isAuthenticated returns whether the host has switched to the configured credentials
+func (a *anonymousFirstAuthorizer) isAuthenticated(host string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.authenticated[host]
+}
+
+// authenticate switches the host to the configured credentials
+func (a *anonymousFirstAuthorizer) authenticate(ctx context.Context, host string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.authenticated[host] {
+		log.G(ctx).WithField("host", host).Info("anonymous token has insufficient scope, authenticating with credentials")
+		a.authenticated[host] = true
+	}
+}
+
+// Authorize adds the authorization for the request
+func (a *anonymousFirstAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	if a.isAuthenticated(req.URL.Host) {
+		return a.authorizer.Authorize(ctx, req)
+	}
+	return a.anonymous.Authorize(ctx, req)
+}
+
+// AddResponses handles the registry's authentication challenges. A challenge for a request that
+// presented an anonymous token, with an `insufficient_scope` error, switches the host to the
+// configured credentials.
+func (a *anonymousFirstAuthorizer) AddResponses(ctx context.Context, responses []*http.Response) error {
+	last := responses[len(responses)-1]
+	host := last.Request.URL.Host
+	if a.isAuthenticated(host) {
+		return a.authorizer.AddResponses(ctx, responses)
+	}
+	if last.Request.Header.Get("Authorization") != "" && insufficientScope(last) {
+		a.authenticate(ctx, host)
+		// Earlier responses were for the anonymous token, which would make the challenge look repeated
+		return a.authorizer.AddResponses(ctx, responses[len(responses)-1:])
+	}
+	return a.anonymous.AddResponses(ctx, responses)
+}
+
+// insufficientScope returns whether the response challenges for a bearer token because the
+// presented token doesn't grant the access the request needs
+func insufficientScope(resp *http.Response) bool {
+	for _, c := range auth.ParseAuthHeader(resp.Header) {
+		if c.Scheme == auth.BearerAuth && c.Parameters["error"] == "insufficient_scope" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// newStepUpRegistry starts a registry that first challenges for an anonymous token, which its
+// anonymous token server only issues to requests without credentials, and then challenges the
+// anonymous token with insufficient_scope for a token from its authenticated token server
+func newStepUpRegistry(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/anonymous-token":
+			if r.Method != http.MethodGet || r.Header.Get("Authorization") != "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token":"anonymous"}`)
+			return
+		case "/token":
+			if username, password, ok := r.BasicAuth(); r.Method != http.MethodGet || !ok || username != "user" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token":"authenticated"}`)
+			return
+		}
+		switch r.Header.Get("Authorization") {
+		case "Bearer authenticated":
+		case "Bearer anonymous":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:bottlerocket/container:pull",error="insufficient_scope"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		default:
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/anonymous-token",service="registry",scope="repository:bottlerocket/container:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a")
+		w.Header().Set("Content-Length", "2")
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, "{}")
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAnonymousTokenFirst(t *testing.T) {
+	server := newStepUpRegistry(t)
+	config := &RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"*": {Endpoints: []string{server.URL}},
+		},
+		Credentials: map[string]Credential{
+			"registry.example.com": {Username: "user", Password: "secret"},
+		},
+	}
+	ref := "registry.example.com/bottlerocket/container:latest"
+	tests := []struct {
+		name           string
+		anonymousFirst bool
+		success        bool
+	}{
+		{"without anonymous token first", false, false},
+		{"with anonymous token first", true, true},
+	}
+	defer func(first bool) { registryAnonymousFirst = first }(registryAnonymousFirst)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			registryAnonymousFirst = tc.anonymousFirst
+			registries, err := registryHosts(config, nil, ref)("registry.example.com")
+			assert.NoError(t, err)
+			// Only try the mirror, the upstream registry isn't reachable
+			resolver := docker.NewResolver(docker.ResolverOptions{
+				Hosts: func(string) ([]docker.RegistryHost, error) {
+					return registries[:1], nil
+				},
+			})
+			_, desc, err := resolver.Resolve(context.Background(), ref)
+			if !tc.success {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", desc.Digest.String())
+		})
+	}
+}
+
+// pathRecordingTransport records the paths of the requests it sends
+type pathRecordingTransport struct {
+	http.RoundTripper
+	paths []string
+}
+
+func (t *pathRecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.paths = append(t.paths, req.URL.Path)
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func TestAnonymousTokenClient(t *testing.T) {
+	server := newStepUpRegistry(t)
+	ref := "registry.example.com/bottlerocket/container:latest"
+	// Anonymous tokens are requested with the client the endpoint's tokens are requested with
+	transport := &pathRecordingTransport{RoundTripper: http.DefaultTransport}
+	authorizer := newAnonymousFirstAuthorizer(docker.NewDockerAuthorizer(), &http.Client{Transport: transport})
+	resolver := docker.NewResolver(docker.ResolverOptions{
+		Hosts: func(string) ([]docker.RegistryHost, error) {
+			return []docker.RegistryHost{{
+				Authorizer:   authorizer,
+				Host:         strings.TrimPrefix(server.URL, "http://"),
+				Scheme:       "http",
+				Path:         "/v2",
+				Capabilities: docker.HostCapabilityResolve | docker.HostCapabilityPull,
+			}}, nil
+		},
+	})
+	_, _, err := resolver.Resolve(context.Background(), ref)
+	// Only the anonymous token is requested, there are no credentials to step up with
+	assert.Error(t, err)
+	assert.Equal(t, []string{"/anonymous-token"}, transport.paths)
+}
+
+func TestInsufficientScope(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		expected  bool
+	}{
+		{"Insufficient scope", `Bearer realm="https://auth.example.com/token",service="registry",error="insufficient_scope"`, true},
+		{"Invalid token", `Bearer realm="https://auth.example.com/token",service="registry",error="invalid_token"`, false},
+		{"No error", `Bearer realm="https://auth.example.com/token",service="registry"`, false},
+		{"Basic", `Basic realm="registry"`, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{"Www-Authenticate": []string{tc.challenge}}}
+			assert.Equal(t, tc.expected, insufficientScope(resp))
+		})
+	}
+}
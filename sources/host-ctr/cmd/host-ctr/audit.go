@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/log"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// auditAction is a container lifecycle action recorded in the audit log
+type auditAction string
+
+const (
+	auditPull   auditAction = "pull"
+	auditCreate auditAction = "create"
+	auditStart  auditAction = "start"
+	auditStop   auditAction = "stop"
+)
+
+// The outcomes of audited actions
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+)
+
+// auditEvent is a record in the audit log. The audit log is consumed by security monitoring, so
+// the JSON field names are a stable schema; fields can be added but never renamed or removed.
+type auditEvent struct {
+	Time      time.Time   `json:"time"`
+	Action    auditAction `json:"action"`
+	Actor     string      `json:"actor"`
+	Container string      `json:"container,omitempty"`
+	Image     string      `json:"image"`
+	Digest    string      `json:"digest,omitempty"`
+	Outcome   string      `json:"outcome"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// auditLogger appends an audit event to the audit log for each container lifecycle action, as
+// a line of JSON. A nil auditLogger records nothing.
+type auditLogger struct {
+	mu    sync.Mutex
+	file  *os.File
+	actor string
+	now   func() time.Time
+}
+
+// The audit log, set when host-ctr is configured to keep one
+var auditLog *auditLogger
+
+// openAuditLog opens the audit log at path for appending, creating it if needed
+func openAuditLog(path string) (*auditLogger, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, errors.Wrapf(checkReadOnly(path, err), "failed to open audit log %s", path)
+	}
+	return &auditLogger{
+		file:  file,
+		actor: fmt.Sprintf("host-ctr[%d] uid=%d", os.Getpid(), os.Getuid()),
+		now:   time.Now,
+	}, nil
+}
+
+// record appends the event for action on the image, and the container if there is one. The
+// action failed if err isn't nil. Failures to write the audit log are only logged, so they
+// don't take down host containers.
+func (a *auditLogger) record(action auditAction, container string, image string, dgst digest.Digest, err error) {
+	if a == nil {
+		return
+	}
+	event := auditEvent{
+		Time:      a.now().UTC(),
+		Action:    action,
+		Actor:     a.actor,
+		Container: container,
+		Image:     image,
+		Digest:    dgst.String(),
+		Outcome:   auditSuccess,
+	}
+	if err != nil {
+		event.Outcome = auditFailure
+		event.Error = err.Error()
+	}
+	line, marshalErr := json.Marshal(event)
+	if marshalErr != nil {
+		log.L.WithError(marshalErr).Warn("failed to encode audit event")
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, writeErr := a.file.Write(append(line, '\n')); writeErr != nil {
+		log.L.WithError(writeErr).WithField("action", action).Warn("failed to write audit event")
+	}
+}
+
+// recordImage records action on img, which may be nil if the action failed before it was known
+func (a *auditLogger) recordImage(action auditAction, container string, source string, img containerd.Image, err error) {
+	if img == nil {
+		a.record(action, container, source, "", err)
+		return
+	}
+	a.record(action, container, img.Name(), img.Target().Digest, err)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLogRecords(t *testing.T) {
+	const image = "public.ecr.aws/bottlerocket/admin:v1"
+	dgst := digest.FromString("admin")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		action    auditAction
+		container string
+		err       error
+		expected  auditEvent
+	}{
+		{
+			"pull",
+			auditPull,
+			"",
+			nil,
+			auditEvent{Time: now, Action: "pull", Actor: "tester", Image: image, Digest: dgst.String(), Outcome: "success"},
+		},
+		{
+			"failed pull",
+			auditPull,
+			"",
+			errors.New("not found"),
+			auditEvent{Time: now, Action: "pull", Actor: "tester", Image: image, Digest: dgst.String(), Outcome: "failure", Error: "not found"},
+		},
+		{
+			"create",
+			auditCreate,
+			"admin",
+			nil,
+			auditEvent{Time: now, Action: "create", Actor: "tester", Container: "admin", Image: image, Digest: dgst.String(), Outcome: "success"},
+		},
+		{
+			"start",
+			auditStart,
+			"admin",
+			nil,
+			auditEvent{Time: now, Action: "start", Actor: "tester", Container: "admin", Image: image, Digest: dgst.String(), Outcome: "success"},
+		},
+		{
+			"stop",
+			auditStop,
+			"admin",
+			errors.New("Container admin exited with non-zero status"),
+			auditEvent{Time: now, Action: "stop", Actor: "tester", Container: "admin", Image: image, Digest: dgst.String(), Outcome: "failure", Error: "Container admin exited with non-zero status"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			audit, err := openAuditLog(path)
+			require.NoError(t, err)
+			audit.actor = "tester"
+			audit.now = func() time.Time { return now }
+
+			audit.record(tc.action, tc.container, image, dgst, tc.err)
+
+			events := readAuditEvents(t, path)
+			assert.Equal(t, []auditEvent{tc.expected}, events)
+		})
+	}
+}
+
+func TestAuditLogSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := openAuditLog(path)
+	require.NoError(t, err)
+	audit.actor = "tester"
+	audit.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+
+	audit.record(auditStart, "admin", "admin:v1", digest.FromString("admin"), errors.New("oops"))
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"time": "2024-05-01T12:00:00Z",
+		"action": "start",
+		"actor": "tester",
+		"container": "admin",
+		"image": "admin:v1",
+		"digest": "`+digest.FromString("admin").String()+`",
+		"outcome": "failure",
+		"error": "oops"
+	}`, string(raw))
+}
+
+func TestAuditLogAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte(`{"action":"pull"}`+"\n"), 0o600))
+	audit, err := openAuditLog(path)
+	require.NoError(t, err)
+
+	audit.record(auditCreate, "admin", "admin:v1", "", nil)
+	audit.record(auditStart, "admin", "admin:v1", "", nil)
+
+	events := readAuditEvents(t, path)
+	require.Len(t, events, 3)
+	assert.Equal(t, []auditAction{auditPull, auditCreate, auditStart}, []auditAction{events[0].Action, events[1].Action, events[2].Action})
+	assert.Empty(t, events[1].Digest)
+}
+
+func TestAuditLogNil(t *testing.T) {
+	var audit *auditLogger
+	assert.NotPanics(t, func() {
+		audit.record(auditPull, "", "admin:v1", "", nil)
+		audit.recordImage(auditPull, "", "admin:v1", nil, errors.New("not found"))
+	})
+}
+
+func readAuditEvents(t *testing.T, path string) []auditEvent {
+	t.Helper()
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var events []auditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event auditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
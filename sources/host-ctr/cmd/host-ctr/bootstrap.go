@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+)
+
+// The one image that may be pulled without verifying TLS certificates, or over plain HTTP, while
+// bootstrapping a host that has no certificates yet, set up from the command line
+var bootstrapInsecureImage string
+
+// isBootstrapInsecure returns whether ref is the bootstrap image, exactly as it was given. Every
+// other image, including other tags of the same repository, is pulled with TLS verified.
+func isBootstrapInsecure(ref string) bool {
+	return bootstrapInsecureImage != "" && ref == bootstrapInsecureImage
+}
+
+// bootstrapInsecureHosts returns registry hosts whose connections skip TLS verification and fall
+// back to plain HTTP if the registry doesn't speak TLS
+func bootstrapInsecureHosts() docker.RegistryHosts {
+	transport := newTransport()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	return docker.ConfigureDefaultRegistries(docker.WithClient(&http.Client{Transport: docker.NewHTTPFallback(transport)}))
+}
+
+// withBootstrapInsecureResolver provides a resolver for the bootstrap image that doesn't verify
+// TLS certificates
+func withBootstrapInsecureResolver(ctx context.Context, ref string, pullOpts pullOptions) containerd.RemoteOpt {
+	return func(_ *containerd.Client, c *containerd.RemoteContext) error {
+		log.G(ctx).WithField("ref", ref).Warn("INSECURE: pulling bootstrap image without verifying TLS certificates, falling back to plain HTTP")
+		hosts := bootstrapInsecureHosts()
+		if pullOpts.endpointAttempts != nil {
+			hosts = pullOpts.endpointAttempts.wrapHosts(hosts)
+		}
+		if pullOpts.requestTimings != nil {
+			hosts = pullOpts.requestTimings.wrapHosts(hosts)
+		}
+		c.Resolver = docker.NewResolver(docker.ResolverOptions{
+			Headers: registryHeaders(pullOpts),
+			Hosts:   hosts,
+		})
+		return nil
+	}
+}
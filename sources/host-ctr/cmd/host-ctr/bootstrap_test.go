@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/platforms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBootstrapInsecure(t *testing.T) {
+	defer func(image string) { bootstrapInsecureImage = image }(bootstrapInsecureImage)
+	// Registries on loopback addresses are always allowed plain HTTP, so the test registries are
+	// reached through a hostname mapped to the loopback address
+	defer func(d *registryDialer) { defaultRegistryDialer = d }(defaultRegistryDialer)
+	dialer, err := newRegistryDialer("", []string{"bootstrap.invalid:127.0.0.1"}, defaultRegistryKeepAlive, "")
+	require.NoError(t, err)
+	defaultRegistryDialer = dialer
+	mappedHost := func(url string) string {
+		_, port, err := net.SplitHostPort(url[strings.LastIndex(url, "/")+1:])
+		require.NoError(t, err)
+		return net.JoinHostPort("bootstrap.invalid", port)
+	}
+
+	plainHTTP := newFakeRegistry(t)
+	withTLS := newFakeRegistry(t)
+	tlsServer := httptest.NewTLSServer(withTLS.Config.Handler)
+	defer tlsServer.Close()
+	for _, registry := range []*fakeRegistry{plainHTTP, withTLS} {
+		manifest, _ := registry.addImage(t, platforms.DefaultSpec(), []byte("layer"))
+		registry.tag("bottlerocket/bootstrap", "v1", manifest)
+		registry.tag("bottlerocket/bootstrap", "v2", manifest)
+		registry.tag("bottlerocket/admin", "v1", manifest)
+	}
+
+	tests := []struct {
+		name string
+		host string
+	}{
+		{"Plain HTTP registry", mappedHost(plainHTTP.URL)},
+		{"Registry with an untrusted certificate", mappedHost(tlsServer.URL)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bootstrapInsecureImage = tc.host + "/bottlerocket/bootstrap:v1"
+			resolve := func(source string) error {
+				ctx := context.Background()
+				ref, resolver, _, err := remoteResolver(ctx, source, pullOptions{})
+				require.NoError(t, err)
+				_, _, err = resolver.Resolve(ctx, ref)
+				return err
+			}
+
+			assert.NoError(t, resolve(tc.host+"/bottlerocket/bootstrap:v1"))
+			// Only the bootstrap image itself is affected
+			assert.Error(t, resolve(tc.host+"/bottlerocket/bootstrap:v2"))
+			assert.Error(t, resolve(tc.host+"/bottlerocket/admin:v1"))
+		})
+	}
+}
+
+func TestIsBootstrapInsecure(t *testing.T) {
+	defer func(image string) { bootstrapInsecureImage = image }(bootstrapInsecureImage)
+
+	bootstrapInsecureImage = ""
+	assert.False(t, isBootstrapInsecure(""))
+	assert.False(t, isBootstrapInsecure("registry.example.com/bootstrap:v1"))
+
+	bootstrapInsecureImage = "registry.example.com/bootstrap:v1"
+	assert.True(t, isBootstrapInsecure("registry.example.com/bootstrap:v1"))
+	assert.False(t, isBootstrapInsecure("registry.example.com/bootstrap:v2"))
+	assert.False(t, isBootstrapInsecure("registry.example.com/bootstrap"))
+}
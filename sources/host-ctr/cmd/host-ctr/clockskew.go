@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/pkg/errors"
+)
+
+// The clock skew within which the clock isn't suspected of causing authentication failures
+const defaultClockSkewTolerance = time.Minute
+
+// Fragments of the errors registries, token servers and AWS return for credentials that are
+// expired or not valid yet, which fresh credentials only are when the clocks disagree
+var clockSkewErrors = []string{
+	"token is expired",
+	"token has expired",
+	"token is not valid yet",
+	"token used before issued",
+	"signature expired",
+	"signature not yet current",
+	"requestexpired",
+	"certificate has expired or is not yet valid",
+}
+
+// isClockSkewError returns whether err, or the registry response body it carries, is an
+// authentication failure consistent with clock skew
+func isClockSkewError(err error) bool {
+	if err == nil {
+		return false
+	}
+	// The registry's error message may only be in the response body
+	_, body, _ := pullErrorStatus(err)
+	msg := strings.ToLower(err.Error() + " " + string(body))
+	for _, fragment := range clockSkewErrors {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// clockSkewDiagnostic returns a diagnostic if err is an authentication failure consistent with the
+// host's clock being wrong, which can happen at early boot before time is synchronized.
+// measureSkew is only called for such failures; if the measured skew is within the tolerance, the
+// clock isn't the cause.
+func clockSkewDiagnostic(err error, measureSkew func() (time.Duration, error), tolerance time.Duration) string {
+	if !isClockSkewError(err) {
+		return ""
+	}
+	skew, measureErr := measureSkew()
+	if measureErr != nil {
+		return "registry authentication failed with expired or not yet valid credentials, which suggests the system clock is wrong; check that time synchronization (NTP) is working"
+	}
+	if skew.Abs() <= tolerance {
+		return ""
+	}
+	direction := "ahead of"
+	if skew < 0 {
+		direction = "behind"
+	}
+	return fmt.Sprintf("registry authentication failed with expired or not yet valid credentials and the system clock is %s %s the registry's; check that time synchronization (NTP) is working", skew.Abs().Round(time.Second), direction)
+}
+
+// registryClockSkew returns how far the local clock is ahead of the clock of the first registry
+// host for ref that responds with a `Date` header
+func registryClockSkew(ctx context.Context, hosts docker.RegistryHosts, ref string) (time.Duration, error) {
+	spec, err := reference.Parse(ref)
+	if err != nil {
+		return 0, err
+	}
+	registries, err := hosts(spec.Hostname())
+	if err != nil {
+		return 0, err
+	}
+	lastErr := errors.New("no registry hosts")
+	for _, registry := range registries {
+		skew, err := hostClockSkew(ctx, registry)
+		if err == nil {
+			return skew, nil
+		}
+		lastErr = err
+	}
+	return 0, lastErr
+}
+
+// hostClockSkew returns how far the local clock is ahead of the registry host's clock
+func hostClockSkew(ctx context.Context, registry docker.RegistryHost) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, v2ProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, fmt.Sprintf("%s://%s%s/", registry.Scheme, registry.Host, registry.Path), nil)
+	if err != nil {
+		return 0, err
+	}
+	client := registry.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid Date header from %s", registry.Host)
+	}
+	return time.Since(date), nil
+}
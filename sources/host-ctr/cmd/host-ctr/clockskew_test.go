@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClockSkewDiagnostic(t *testing.T) {
+	measured := func(skew time.Duration) func() (time.Duration, error) {
+		return func() (time.Duration, error) { return skew, nil }
+	}
+	unmeasured := func() (time.Duration, error) { return 0, errors.New("connection refused") }
+	tests := []struct {
+		name     string
+		err      error
+		skew     func() (time.Duration, error)
+		expected string
+	}{
+		{
+			"unrelated error",
+			errors.New("dial tcp: connection refused"),
+			measured(time.Hour),
+			"",
+		},
+		{
+			"expired token ahead",
+			errors.New(`unexpected status 401 Unauthorized: {"errors":[{"code":"UNAUTHORIZED","message":"token is expired"}]}`),
+			measured(2 * time.Hour),
+			"registry authentication failed with expired or not yet valid credentials and the system clock is 2h0m0s ahead of the registry's; check that time synchronization (NTP) is working",
+		},
+		{
+			"token not valid yet behind",
+			errors.New("failed to authorize: Token used before issued"),
+			measured(-10 * time.Minute),
+			"registry authentication failed with expired or not yet valid credentials and the system clock is 10m0s behind the registry's; check that time synchronization (NTP) is working",
+		},
+		{
+			"expired signature within tolerance",
+			errors.New("InvalidSignatureException: Signature expired: 20240101T000000Z is now earlier than 20240101T000500Z"),
+			measured(30 * time.Second),
+			"",
+		},
+		{
+			"expired certificate unmeasured",
+			errors.New("tls: failed to verify certificate: x509: certificate has expired or is not yet valid"),
+			unmeasured,
+			"registry authentication failed with expired or not yet valid credentials, which suggests the system clock is wrong; check that time synchronization (NTP) is working",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, clockSkewDiagnostic(tc.err, tc.skew, defaultClockSkewTolerance))
+		})
+	}
+}
+
+func TestRegistryClockSkew(t *testing.T) {
+	registryTime := time.Now().Add(-time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", registryTime.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	// A mirror that refuses connections is skipped
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	config := &RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"*": {Endpoints: []string{down.URL, server.URL}},
+		},
+	}
+	ref := "registry.example.com/bottlerocket/container:latest"
+	skew, err := registryClockSkew(context.TODO(), registryHosts(config, nil, ref), ref)
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Hour.Seconds(), skew.Seconds(), 5)
+}
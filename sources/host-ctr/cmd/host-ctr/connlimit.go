@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// The maximum number of simultaneous connections to each registry endpoint during a pull, set up
+// from the command line. Zero leaves connections unlimited. Since pulls share each endpoint's
+// transport, pulls running at once, as with --all-platforms, share the limit too.
+var registryMaxConns int
+
+// connLimiter bounds the number of open connections dialed through it. Unlike limits on the
+// number of layers fetched at once, it bounds sockets, which is what registry mirrors limit.
+type connLimiter struct {
+	slots chan struct{}
+}
+
+func newConnLimiter(max int) *connLimiter {
+	return &connLimiter{slots: make(chan struct{}, max)}
+}
+
+// dialContext wraps dial so that it waits for a connection to be closed while max connections
+// are open
+func (l *connLimiter) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			<-l.slots
+			return nil, err
+		}
+		return &limitedConn{Conn: conn, release: func() { <-l.slots }}, nil
+	}
+}
+
+// limitedConn frees its connection limiter slot when it's closed
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
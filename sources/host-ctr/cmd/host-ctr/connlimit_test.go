@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnLimiterBoundsDials(t *testing.T) {
+	var open, maxOpen atomic.Int32
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		n := open.Add(1)
+		for {
+			max := maxOpen.Load()
+			if n <= max || maxOpen.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return &countedConn{Conn: client, open: &open}, nil
+	}
+	limited := newConnLimiter(2).dialContext(dial)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := limited(context.Background(), "tcp", "registry.example.com:443")
+			if !assert.NoError(t, err) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+			conn.Close()
+			// Closing twice only frees one slot
+			conn.Close()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), maxOpen.Load())
+	assert.Equal(t, int32(0), open.Load())
+}
+
+func TestConnLimiterCanceledDial(t *testing.T) {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	limited := newConnLimiter(1).dialContext(dial)
+	conn, err := limited(context.Background(), "tcp", "registry.example.com:443")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = limited(ctx, "tcp", "registry.example.com:443")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestMaxConnsPerPull(t *testing.T) {
+	defer func(max int) { registryMaxConns = max }(registryMaxConns)
+	registryMaxConns = 2
+	assert.True(t, customTransport())
+
+	var active, maxActive atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			n := active.Add(1)
+			for {
+				max := maxActive.Load()
+				if n <= max || maxActive.CompareAndSwap(max, n) {
+					break
+				}
+			}
+		case http.StateClosed, http.StateHijacked:
+			active.Add(-1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := &http.Client{Transport: newTransport()}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL + "/v2/bottlerocket/admin/blobs/sha256:0")
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, maxActive.Load(), int32(2))
+}
+
+// countedConn decrements the count of open connections when it's closed
+type countedConn struct {
+	net.Conn
+	open *atomic.Int32
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.open.Add(-1) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// The oldest containerd host-ctr supports by default, the release its client is built against
+const defaultMinContainerdVersion = "1.7.0"
+
+// The oldest containerd version host-ctr runs against, set up from the command line. Empty skips
+// the check.
+var minContainerdVersion = defaultMinContainerdVersion
+
+// errContainerdVersionUnknown is returned for containerd versions that can't be compared, such
+// as development builds
+var errContainerdVersionUnknown = errors.New("unknown containerd version")
+
+// containerdVersionError is returned when containerd is older than host-ctr supports
+type containerdVersionError struct {
+	Version string
+	Min     string
+}
+
+func (e *containerdVersionError) Error() string {
+	return fmt.Sprintf("containerd %s is older than %s, the oldest version host-ctr supports", e.Version, e.Min)
+}
+
+// snapshotterUnavailableError is returned when the snapshotter host-ctr unpacks images with isn't
+// loaded by containerd
+type snapshotterUnavailableError struct {
+	Snapshotter string
+	Reason      string
+}
+
+func (e *snapshotterUnavailableError) Error() string {
+	return fmt.Sprintf("containerd snapshotter %q is unavailable: %s", e.Snapshotter, e.Reason)
+}
+
+// parseContainerdVersion parses the major, minor and patch release of a containerd version such
+// as `v1.7.22`, `1.7.22-rc.1` or `1.7.22+bottlerocket`. Pre-releases compare as their release.
+func parseContainerdVersion(version string) ([3]int, error) {
+	var release [3]int
+	trimmed := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(trimmed, "-+"); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+	parts := strings.Split(trimmed, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return release, errors.Wrap(errContainerdVersionUnknown, version)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return release, errors.Wrap(errContainerdVersionUnknown, version)
+		}
+		release[i] = n
+	}
+	return release, nil
+}
+
+// checkContainerdVersion checks that the containerd version is at least min
+func checkContainerdVersion(version string, min string) error {
+	minRelease, err := parseContainerdVersion(min)
+	if err != nil {
+		return errors.Wrap(err, "invalid minimum containerd version")
+	}
+	release, err := parseContainerdVersion(version)
+	if err != nil {
+		return err
+	}
+	for i := range release {
+		if release[i] != minRelease[i] {
+			if release[i] < minRelease[i] {
+				return &containerdVersionError{Version: version, Min: min}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// pluginStatus is whether a containerd plugin loaded, as reported by containerd's introspection
+type pluginStatus struct {
+	ID string
+	// The reason the plugin failed to load, empty if it loaded
+	InitErr string
+}
+
+// checkSnapshotter checks that the snapshotter is one of the loaded snapshotter plugins
+func checkSnapshotter(plugins []pluginStatus, snapshotter string) error {
+	for _, plugin := range plugins {
+		if plugin.ID != snapshotter {
+			continue
+		}
+		if plugin.InitErr != "" {
+			return &snapshotterUnavailableError{Snapshotter: snapshotter, Reason: plugin.InitErr}
+		}
+		return nil
+	}
+	return &snapshotterUnavailableError{Snapshotter: snapshotter, Reason: "not loaded by containerd"}
+}
+
+// verifyContainerd checks that the connected containerd is a version host-ctr supports and has
+// the snapshotter images are unpacked with, so incompatibilities fail clearly at startup rather
+// than later. Versions that can't be compared are only warned about.
+func verifyContainerd(ctx context.Context, client *containerd.Client) error {
+	version, err := client.Version(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to get containerd version")
+	}
+	err = checkContainerdVersion(version.Version, minContainerdVersion)
+	if errors.Is(err, errContainerdVersionUnknown) {
+		log.G(ctx).WithError(err).Warn("unable to check containerd version compatibility")
+	} else if err != nil {
+		return err
+	}
+
+	resp, err := client.IntrospectionService().Plugins(ctx, []string{"type==io.containerd.snapshotter.v1"})
+	if err != nil {
+		return errors.Wrap(err, "failed to list containerd snapshotters")
+	}
+	var plugins []pluginStatus
+	for _, plugin := range resp.Plugins {
+		plugins = append(plugins, pluginStatus{ID: plugin.ID, InitErr: plugin.InitErr.GetMessage()})
+	}
+	if err := checkSnapshotter(plugins, containerd.DefaultSnapshotter); err != nil {
+		return err
+	}
+	log.G(ctx).WithField("version", version.Version).WithField("revision", version.Revision).Debug("containerd is compatible")
+	return nil
+}
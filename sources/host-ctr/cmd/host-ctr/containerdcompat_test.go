@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckContainerdVersion(t *testing.T) {
+	tests := []struct {
+		version     string
+		min         string
+		compatible  bool
+		expectedErr error
+	}{
+		{"v1.7.22", "1.7.0", true, nil},
+		{"1.7.0", "1.7.0", true, nil},
+		{"v1.7.22+bottlerocket", "1.7.20", true, nil},
+		{"v1.7.22-rc.1", "1.7.22", true, nil},
+		{"v2.0.0", "1.7.0", true, nil},
+		{"v1.8", "1.7.30", true, nil},
+		{"v1.6.36", "1.7.0", false, nil},
+		{"v1.7.19", "1.7.20", false, nil},
+		{"v0.9.0", "1.7", false, nil},
+		{"dev", "1.7.0", false, errContainerdVersionUnknown},
+		{"", "1.7.0", false, errContainerdVersionUnknown},
+		{"1.x.0", "1.7.0", false, errContainerdVersionUnknown},
+	}
+	for _, tc := range tests {
+		t.Run(tc.version+" against "+tc.min, func(t *testing.T) {
+			err := checkContainerdVersion(tc.version, tc.min)
+			switch {
+			case tc.compatible:
+				assert.NoError(t, err)
+			case tc.expectedErr != nil:
+				assert.ErrorIs(t, err, tc.expectedErr)
+			default:
+				var versionErr *containerdVersionError
+				assert.True(t, errors.As(err, &versionErr), "expected a version error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckSnapshotter(t *testing.T) {
+	tests := []struct {
+		name    string
+		plugins []pluginStatus
+		ok      bool
+	}{
+		{"Loaded", []pluginStatus{{"native", ""}, {"overlayfs", ""}}, true},
+		{"Failed to load", []pluginStatus{{"overlayfs", "/var/lib/containerd does not support d_type"}}, false},
+		{"Not loaded", []pluginStatus{{"native", ""}}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkSnapshotter(tc.plugins, "overlayfs")
+			if tc.ok {
+				assert.NoError(t, err)
+				return
+			}
+			var unavailable *snapshotterUnavailableError
+			assert.ErrorAs(t, err, &unavailable)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// parseContentStore parses a content store in `backend:path` format and returns its path.
+// Only the `local` backend, a content store directory that doesn't need containerd, is supported.
+func parseContentStore(spec string) (string, error) {
+	backend, path, ok := strings.Cut(spec, ":")
+	if !ok || backend != "local" {
+		return "", fmt.Errorf("invalid content store %q, expected `local:/path`", spec)
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("invalid content store %q, the path must be absolute", spec)
+	}
+	return path, nil
+}
+
+// pullImageToContentStore pulls the specified image's content into the local content store at
+// storePath, without a running containerd. Nothing is unpacked or added to an image store. If
+// basePath is set, the content store is layered over the read-only content store at basePath.
+func pullImageToContentStore(source string, storePath string, basePath string, pullOpts pullOptions) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ref, resolver, matcher, err := remoteResolver(ctx, source, pullOpts)
+	if err != nil {
+		return err
+	}
+	verifier, err := remoteImageVerifier(ctx, source, pullOpts)
+	if err != nil {
+		return err
+	}
+	store, err := openContentStore(storePath, basePath)
+	if err != nil {
+		return err
+	}
+	var desc ocispec.Descriptor
+	err = retryRemotePull(ctx, ref, pullOpts, func() error {
+		desc, err = fetchToContentStore(ctx, resolver, ref, matcher, store, verifier)
+		return err
+	})
+	if err != nil {
+		log.G(ctx).WithField("ref", ref).Error(err)
+		return err
+	}
+	log.G(ctx).WithField("ref", ref).WithField("digest", desc.Digest).WithField("content-store", storePath).Info("pulled image to content store")
+	return nil
+}
+
+// openContentStore opens the local content store at storePath, layered over the read-only content
+// store at basePath if it is set
+func openContentStore(storePath string, basePath string) (content.Store, error) {
+	store, err := local.NewStore(storePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open content store %s", storePath)
+	}
+	if basePath == "" {
+		return store, nil
+	}
+	if !filepath.IsAbs(basePath) {
+		return nil, fmt.Errorf("invalid base content store %q, the path must be absolute", basePath)
+	}
+	base, err := local.NewStore(basePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open base content store %s", basePath)
+	}
+	return newOverlayStore(base, store), nil
+}
+
+// fetchToContentStore resolves ref and fetches the image index, the manifest for the platform
+// selected by matcher, and its config and layers into the content store. If verifier is set, the
+// image must pass verification before anything is fetched.
+func fetchToContentStore(ctx context.Context, resolver remotes.Resolver, ref string, matcher platforms.MatchComparer, store content.Store, verifier imageVerifier) (ocispec.Descriptor, error) {
+	name, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return ocispec.Descriptor{}, errors.Wrapf(err, "failed to resolve %q", ref)
+	}
+	if verifier != nil {
+		if err := verifier.Verify(ctx, name, desc); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return ocispec.Descriptor{}, errors.Wrapf(err, "failed to get fetcher for %q", name)
+	}
+	// Same as containerd's pull, fetch only the best matching manifest from indexes
+	children := images.LimitManifests(images.FilterPlatforms(images.ChildrenHandler(store), matcher), matcher, 1)
+	handler := images.Handlers(remotes.FetchHandler(store, fetcher), children)
+	if err := images.Dispatch(ctx, handler, nil, desc); err != nil {
+		return ocispec.Descriptor{}, errors.Wrapf(err, "failed to fetch %q", name)
+	}
+	return desc, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseContentStore(t *testing.T) {
+	tests := []struct {
+		spec        string
+		expectedErr bool
+		expected    string
+	}{
+		{"local:/var/lib/host-ctr/content", false, "/var/lib/host-ctr/content"},
+		{"local:relative/path", true, ""},
+		{"containerd:/run/containerd.sock", true, ""},
+		{"/var/lib/host-ctr/content", true, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.spec, func(t *testing.T) {
+			path, err := parseContentStore(tc.spec)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, path)
+		})
+	}
+}
+
+func TestFetchToContentStore(t *testing.T) {
+	registry := newFakeRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	amd64Manifest, amd64Config := registry.addImage(t, amd64, []byte("amd64 layer"))
+	arm64Manifest, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "arm64"}, []byte("arm64 layer"))
+	index := registry.addIndex(t, amd64Manifest, arm64Manifest)
+	registry.tag("bottlerocket/container", "latest", index)
+
+	storePath := t.TempDir()
+	store, err := local.NewStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := fetchToContentStore(context.TODO(), registry.resolver(), "registry.example.com/bottlerocket/container:latest", platforms.Only(amd64), store, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, index.Digest, desc.Digest)
+
+	stored := func(dgst digest.Digest) bool {
+		_, err := os.Stat(filepath.Join(storePath, "blobs", dgst.Algorithm().String(), dgst.Encoded()))
+		return err == nil
+	}
+	for _, dgst := range []digest.Digest{index.Digest, amd64Manifest.Digest, amd64Config.Digest, digest.FromBytes([]byte("amd64 layer"))} {
+		assert.True(t, stored(dgst), "expected %s in the content store", dgst)
+	}
+	assert.False(t, stored(arm64Manifest.Digest))
+	assert.False(t, stored(digest.FromBytes([]byte("arm64 layer"))))
+}
+
+func TestFetchToContentStoreVerifiesSignature(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := loadKeyring(writeTestKeyring(t, pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := newFakeRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	manifest, config := registry.addImage(t, amd64, []byte("unsigned layer"))
+	registry.tag("bottlerocket/container", "latest", manifest)
+
+	storePath := t.TempDir()
+	store, err := local.NewStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := &keyringVerifier{
+		keyring: k,
+		signatures: func(context.Context, string, ocispec.Descriptor) ([]imageSignature, error) {
+			return nil, nil
+		},
+	}
+	_, err = fetchToContentStore(context.TODO(), registry.resolver(), "registry.example.com/bottlerocket/container:latest", platforms.Only(amd64), store, verifier)
+	assert.ErrorIs(t, err, errImageUnsigned)
+	assert.True(t, signatureRejected(err))
+
+	// Nothing of the unsigned image is fetched
+	for _, dgst := range []digest.Digest{manifest.Digest, config.Digest, digest.FromBytes([]byte("unsigned layer"))} {
+		_, err := store.Info(context.TODO(), dgst)
+		assert.True(t, errdefs.IsNotFound(err), "expected %s not to be in the content store", dgst)
+	}
+}
+
+func TestOverlayContentStore(t *testing.T) {
+	registry := newFakeRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	seeded, _ := registry.addImage(t, amd64, []byte("shared layer"))
+	registry.tag("bottlerocket/container", "seeded", seeded)
+	latest, _ := registry.addImage(t, amd64, []byte("shared layer"), []byte("new layer"))
+	registry.tag("bottlerocket/container", "latest", latest)
+	shared := digest.FromBytes([]byte("shared layer"))
+	added := digest.FromBytes([]byte("new layer"))
+
+	// Seed the base content store, as a golden image would
+	basePath := t.TempDir()
+	base, err := local.NewStore(basePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fetchToContentStore(context.TODO(), registry.resolver(), "registry.example.com/bottlerocket/container:seeded", platforms.Only(amd64), base, nil)
+	assert.NoError(t, err)
+
+	upperPath := t.TempDir()
+	store, err := openContentStore(upperPath, basePath)
+	assert.NoError(t, err)
+	_, err = fetchToContentStore(context.TODO(), registry.resolver(), "registry.example.com/bottlerocket/container:latest", platforms.Only(amd64), store, nil)
+	assert.NoError(t, err)
+
+	stored := func(storePath string, dgst digest.Digest) bool {
+		_, err := os.Stat(filepath.Join(storePath, "blobs", dgst.Algorithm().String(), dgst.Encoded()))
+		return err == nil
+	}
+	// Writes go to the upper content store, content in the base isn't written again
+	assert.True(t, stored(upperPath, added))
+	assert.True(t, stored(upperPath, latest.Digest))
+	assert.False(t, stored(upperPath, shared))
+	assert.False(t, stored(basePath, added))
+
+	// Reads come from the base for content that is only there
+	raw, err := content.ReadBlob(context.TODO(), store, ocispec.Descriptor{Digest: shared, Size: int64(len("shared layer"))})
+	assert.NoError(t, err)
+	assert.Equal(t, "shared layer", string(raw))
+	_, err = store.Info(context.TODO(), seeded.Digest)
+	assert.NoError(t, err)
+
+	// Content in the base is read-only
+	assert.True(t, errdefs.IsFailedPrecondition(store.Delete(context.TODO(), shared)))
+	assert.NoError(t, store.Delete(context.TODO(), added))
+	assert.True(t, stored(basePath, shared))
+}
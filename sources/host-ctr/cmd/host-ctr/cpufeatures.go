@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// The manifest annotation images can use to declare the CPU features they require, a comma
+// separated list of the flags as the kernel reports them in /proc/cpuinfo, e.g. `avx,avx2`
+const cpuFeaturesAnnotation = "io.bottlerocket.host-ctr.cpu-features"
+
+// Where the kernel reports the host's CPU features
+const cpuInfoPath = "/proc/cpuinfo"
+
+// missingCPUFeaturesError is returned when the host CPU lacks features the image requires
+type missingCPUFeaturesError struct {
+	Image   string
+	Missing []string
+}
+
+func (e *missingCPUFeaturesError) Error() string {
+	return fmt.Sprintf("image %s requires CPU features the host doesn't have: %s", e.Image, strings.Join(e.Missing, ", "))
+}
+
+// parseCPUFeatures parses the required CPU features in the annotations
+func parseCPUFeatures(annotations map[string]string) []string {
+	var features []string
+	for _, feature := range strings.Split(annotations[cpuFeaturesAnnotation], ",") {
+		if feature = strings.ToLower(strings.TrimSpace(feature)); feature != "" {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+// readCPUFeatures reads the CPU features of the first processor in cpuinfo, which are listed as
+// `flags` on x86 and `Features` on ARM
+func readCPUFeatures(cpuinfo io.Reader) (map[string]bool, error) {
+	scanner := bufio.NewScanner(cpuinfo)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		if key = strings.TrimSpace(key); key != "flags" && key != "Features" {
+			continue
+		}
+		features := map[string]bool{}
+		for _, feature := range strings.Fields(value) {
+			features[strings.ToLower(feature)] = true
+		}
+		return features, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("no CPU features in cpuinfo")
+}
+
+// checkCPUFeatures checks that the host has every CPU feature the image requires
+func checkCPUFeatures(image string, required []string, host map[string]bool) error {
+	var missing []string
+	for _, feature := range required {
+		if !host[feature] {
+			missing = append(missing, feature)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return &missingCPUFeaturesError{Image: image, Missing: missing}
+}
+
+// verifyImageCPUFeatures checks that the host CPU has the features the image's manifest declares
+// it requires, so the container doesn't crash with illegal instructions. Images that declare no
+// features pass.
+func verifyImageCPUFeatures(ctx context.Context, img containerd.Image) error {
+	manifest, err := images.Manifest(ctx, img.ContentStore(), img.Target(), img.Platform())
+	if err != nil {
+		return errors.Wrapf(err, "failed to read manifest for %s", img.Name())
+	}
+	required := parseCPUFeatures(manifest.Annotations)
+	if len(required) == 0 {
+		return nil
+	}
+	cpuinfo, err := os.Open(cpuInfoPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to read host CPU features")
+	}
+	defer cpuinfo.Close()
+	host, err := readCPUFeatures(cpuinfo)
+	if err != nil {
+		return errors.Wrap(err, "failed to read host CPU features")
+	}
+	log.G(ctx).WithField("img", img.Name()).WithField("features", strings.Join(required, ",")).Info("checking required CPU features")
+	return checkCPUFeatures(img.Name(), required, host)
+}
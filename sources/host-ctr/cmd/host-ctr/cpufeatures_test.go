@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	x86CPUInfo = `processor	: 0
+vendor_id	: GenuineIntel
+model name	: Intel(R) Xeon(R) Platinum 8259CL CPU @ 2.50GHz
+flags		: fpu vme de pse tsc msr sse sse2 ssse3 sse4_1 sse4_2 avx f16c avx2 bmi2
+
+processor	: 1
+flags		: fpu vme de pse tsc msr sse sse2 ssse3 sse4_1 sse4_2 avx f16c avx2 bmi2
+`
+	armCPUInfo = `processor	: 0
+BogoMIPS	: 243.75
+Features	: fp asimd evtstrm aes pmull sha1 sha2 crc32 atomics fphp asimdhp cpuid asimdrdm lrcpc dcpop asimddp ssbs
+CPU implementer	: 0x41
+`
+)
+
+func TestReadCPUFeatures(t *testing.T) {
+	features, err := readCPUFeatures(strings.NewReader(x86CPUInfo))
+	assert.NoError(t, err)
+	assert.True(t, features["avx2"])
+	assert.False(t, features["avx512f"])
+
+	features, err = readCPUFeatures(strings.NewReader(armCPUInfo))
+	assert.NoError(t, err)
+	assert.True(t, features["atomics"])
+	assert.False(t, features["sve"])
+
+	_, err = readCPUFeatures(strings.NewReader("processor\t: 0\n"))
+	assert.Error(t, err)
+}
+
+func TestCheckCPUFeatures(t *testing.T) {
+	host, err := readCPUFeatures(strings.NewReader(x86CPUInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name            string
+		annotation      string
+		expectedMissing []string
+	}{
+		{"No requirements", "", nil},
+		{"Satisfied", "avx,avx2", nil},
+		{"Satisfied with spaces and case", " AVX , sse4_2 ", nil},
+		{"Unsatisfied", "avx2,avx512f,avx512bw", []string{"avx512bw", "avx512f"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			required := parseCPUFeatures(map[string]string{cpuFeaturesAnnotation: tc.annotation})
+			err := checkCPUFeatures("registry.example.com/bottlerocket/container:latest", required, host)
+			if tc.expectedMissing == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var missing *missingCPUFeaturesError
+			if assert.ErrorAs(t, err, &missing) {
+				assert.Equal(t, tc.expectedMissing, missing.Missing)
+			}
+		})
+	}
+}
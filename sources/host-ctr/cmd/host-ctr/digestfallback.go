@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// parseDigestFallback parses the digest to pull if an image's tag no longer resolves
+func parseDigestFallback(fallback string) (digest.Digest, error) {
+	if fallback == "" {
+		return "", nil
+	}
+	dgst, err := digest.Parse(fallback)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid digest fallback %q", fallback)
+	}
+	return dgst, nil
+}
+
+// digestFallbackRef returns the reference to ref's repository by dgst, replacing any tag. Refs
+// that already have a digest have no fallback.
+func digestFallbackRef(ref string, dgst digest.Digest) (string, bool, error) {
+	spec, err := reference.Parse(ref)
+	if err != nil {
+		return "", false, errors.Wrapf(err, "failed to parse %q", ref)
+	}
+	if spec.Digest() != "" {
+		return "", false, nil
+	}
+	return spec.Locator + "@" + dgst.String(), true, nil
+}
+
+// selectPullRef returns the reference to pull for ref: ref itself if it resolves, and otherwise
+// the reference to its repository by the fallback digest if its tag is gone, e.g. deleted
+// upstream, but the image is still there. Errors other than the tag not being found are returned.
+func selectPullRef(ctx context.Context, resolver remotes.Resolver, ref string, fallback digest.Digest) (string, error) {
+	_, _, err := resolver.Resolve(ctx, ref)
+	if err == nil || !errdefs.IsNotFound(err) {
+		return ref, err
+	}
+	fallbackRef, ok, parseErr := digestFallbackRef(ref, fallback)
+	if parseErr != nil || !ok {
+		return ref, err
+	}
+	if _, _, fallbackErr := resolver.Resolve(ctx, fallbackRef); fallbackErr != nil {
+		return ref, errors.Wrapf(err, "digest fallback %s failed too: %v", fallback, fallbackErr)
+	}
+	log.G(ctx).WithField("ref", ref).WithField("digest", fallback).Warn("image tag not found, falling back to pulling the image by digest")
+	return fallbackRef, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestFallbackRef(t *testing.T) {
+	dgst := digest.FromString("admin")
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+		ok       bool
+	}{
+		{"Tag", "registry.example.com/bottlerocket/admin:v1", "registry.example.com/bottlerocket/admin@" + dgst.String(), true},
+		{"Port", "localhost:5000/bottlerocket/admin:v1", "localhost:5000/bottlerocket/admin@" + dgst.String(), true},
+		{"Digest", "registry.example.com/bottlerocket/admin@" + digest.FromString("other").String(), "", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, ok, err := digestFallbackRef(tc.ref, dgst)
+			require.NoError(t, err)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.expected, ref)
+		})
+	}
+}
+
+func TestParseDigestFallback(t *testing.T) {
+	dgst := digest.FromString("admin")
+	parsed, err := parseDigestFallback(dgst.String())
+	assert.NoError(t, err)
+	assert.Equal(t, dgst, parsed)
+
+	parsed, err = parseDigestFallback("")
+	assert.NoError(t, err)
+	assert.Empty(t, parsed)
+
+	_, err = parseDigestFallback("sha256:nope")
+	assert.Error(t, err)
+}
+
+func TestSelectPullRef(t *testing.T) {
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, platforms.DefaultSpec(), []byte("layer"))
+	registry.tag("bottlerocket/admin", "v1", manifest)
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		ref         string
+		fallback    digest.Digest
+		expected    string
+		expectedErr bool
+	}{
+		{
+			"Tag resolves",
+			"example.com/bottlerocket/admin:v1",
+			manifest.Digest,
+			"example.com/bottlerocket/admin:v1",
+			false,
+		},
+		{
+			"Tag is gone, digest resolves",
+			"example.com/bottlerocket/admin:deleted",
+			manifest.Digest,
+			"example.com/bottlerocket/admin@" + manifest.Digest.String(),
+			false,
+		},
+		{
+			"Tag and digest are gone",
+			"example.com/bottlerocket/admin:deleted",
+			digest.FromString("missing"),
+			"example.com/bottlerocket/admin:deleted",
+			true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := selectPullRef(ctx, registry.resolver(), tc.ref, tc.fallback)
+			assert.Equal(t, tc.expected, ref)
+			if tc.expectedErr {
+				assert.True(t, errdefs.IsNotFound(err), "expected a not found error, got %v", err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
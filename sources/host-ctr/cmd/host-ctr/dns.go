@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// The default interval between TCP keep-alive probes on registry connections
+const defaultRegistryKeepAlive = 30 * time.Second
+
+// registryDialer dials connections to registries, optionally bypassing the system DNS
+// configuration with a specific DNS server or fixed IP addresses for registry hosts
+type registryDialer struct {
+	// Registry hostnames mapped to the IP address to connect to instead of resolving them
+	hostIPs map[string]string
+	// The resolver to use instead of the system resolver, if any
+	resolver *net.Resolver
+	// The interval between TCP keep-alive probes, negative to disable them
+	keepAlive time.Duration
+	// The network registry connections are restricted to, `tcp4` or `tcp6`, empty for either
+	network string
+}
+
+// The dialer used for all registry connections, set up from the command line
+var defaultRegistryDialer = &registryDialer{keepAlive: defaultRegistryKeepAlive}
+
+// ipFamilies maps the IP families accepted in flags to the network connections are dialed on
+var ipFamilies = map[string]string{
+	"auto": "",
+	"ipv4": "tcp4",
+	"ipv6": "tcp6",
+}
+
+// newRegistryDialer sets up a dialer that resolves registry hosts with the DNS server at
+// dnsServer, if set, and connects to hosts mapped in `host:ip` format to the mapped IP address.
+// Connections send TCP keep-alive probes at the keepAlive interval, and only use addresses of
+// ipFamily unless it's `auto` or empty.
+func newRegistryDialer(dnsServer string, hostIPs []string, keepAlive time.Duration, ipFamily string) (*registryDialer, error) {
+	d := &registryDialer{hostIPs: map[string]string{}, keepAlive: keepAlive}
+	if ipFamily != "" {
+		network, ok := ipFamilies[ipFamily]
+		if !ok {
+			return nil, fmt.Errorf("invalid IP family %q, must be one of: [ipv4, ipv6, auto]", ipFamily)
+		}
+		d.network = network
+	}
+	for _, hostIP := range hostIPs {
+		host, ip, ok := strings.Cut(hostIP, ":")
+		if !ok || host == "" || net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid registry host IP %q, expected `host:ip`", hostIP)
+		}
+		d.hostIPs[strings.ToLower(host)] = ip
+	}
+	if dnsServer != "" {
+		server := dnsServer
+		if _, _, err := net.SplitHostPort(dnsServer); err != nil {
+			if net.ParseIP(dnsServer) == nil {
+				return nil, fmt.Errorf("invalid registry DNS server %q, expected an IP address", dnsServer)
+			}
+			server = net.JoinHostPort(dnsServer, "53")
+		}
+		d.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{Timeout: 5 * time.Second}).DialContext(ctx, network, server)
+			},
+		}
+	}
+	return d, nil
+}
+
+// configured returns whether the dialer behaves differently from the default HTTP client's
+// dialer, by bypassing the system DNS configuration, changing the keep-alive interval or
+// restricting the IP family
+func (d *registryDialer) configured() bool {
+	return d.resolver != nil || len(d.hostIPs) != 0 || d.keepAlive != defaultRegistryKeepAlive || d.network != ""
+}
+
+// netDialer returns the dialer for registry connections
+func (d *registryDialer) netDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:       30 * time.Second,
+		KeepAlive:     d.keepAlive,
+		FallbackDelay: 300 * time.Millisecond,
+		Resolver:      d.resolver,
+	}
+}
+
+// DialContext connects to addr, using the mapped IP address for the host if there is one. Only
+// addresses of the dialer's IP family are connected to, so a broken network path of the other
+// family doesn't delay connections while falling back from it.
+func (d *registryDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := d.netDialer()
+	if d.network != "" && network == "tcp" {
+		network = d.network
+	}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		if ip, ok := d.hostIPs[strings.ToLower(host)]; ok {
+			addr = net.JoinHostPort(ip, port)
+		}
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRegistryDialer(t *testing.T) {
+	tests := []struct {
+		name        string
+		dnsServer   string
+		hostIPs     []string
+		expectedErr bool
+		configured  bool
+	}{
+		{"Nothing configured", "", nil, false, false},
+		{"DNS server", "10.0.0.2", nil, false, true},
+		{"DNS server with port", "10.0.0.2:5353", nil, false, true},
+		{"Invalid DNS server", "dns.example.com", nil, true, false},
+		{"Host IP", "", []string{"mirror:10.0.0.5"}, false, true},
+		{"IPv6 host IP", "", []string{"mirror:fd00::5"}, false, true},
+		{"Host IP without IP", "", []string{"mirror"}, true, false},
+		{"Host IP with hostname", "", []string{"mirror:other"}, true, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dialer, err := newRegistryDialer(tc.dnsServer, tc.hostIPs, defaultRegistryKeepAlive, "")
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.configured, dialer.configured())
+		})
+	}
+}
+
+func TestRegistryDialerHostIP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	assert.NoError(t, err)
+
+	dialer, err := newRegistryDialer("", []string{"Mirror.Invalid:127.0.0.1"}, defaultRegistryKeepAlive, "")
+	assert.NoError(t, err)
+	// The `.invalid` TLD never resolves, so the connection only succeeds through the mapping
+	conn, err := dialer.DialContext(context.TODO(), "tcp", net.JoinHostPort("mirror.invalid", port))
+	if assert.NoError(t, err) {
+		assert.Equal(t, listener.Addr().String(), conn.RemoteAddr().String())
+		conn.Close()
+	}
+}
+
+func TestRegistryDialerKeepAlive(t *testing.T) {
+	dialer, err := newRegistryDialer("", nil, 10*time.Second, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, dialer.netDialer().KeepAlive)
+	assert.True(t, dialer.configured())
+
+	dialer, err = newRegistryDialer("", nil, defaultRegistryKeepAlive, "")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultRegistryKeepAlive, dialer.netDialer().KeepAlive)
+	assert.False(t, dialer.configured())
+	assert.Equal(t, defaultRegistryKeepAlive, defaultRegistryDialer.netDialer().KeepAlive)
+}
+
+func TestRegistryHostsWithDialer(t *testing.T) {
+	dialer, err := newRegistryDialer("", []string{"mirror.invalid:127.0.0.1"}, defaultRegistryKeepAlive, "")
+	assert.NoError(t, err)
+	defer func(d *registryDialer) { defaultRegistryDialer = d }(defaultRegistryDialer)
+	defaultRegistryDialer = dialer
+
+	registries, err := registryHosts(&RegistryConfig{}, nil, "")("docker.io")
+	assert.NoError(t, err)
+	for _, registry := range registries {
+		assert.NotNil(t, registry.Client)
+	}
+}
+
+func TestRegistryDialerIPFamily(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	assert.NoError(t, err)
+
+	tests := []struct {
+		family     string
+		addr       string
+		expectDial bool
+	}{
+		{"auto", listener.Addr().String(), true},
+		{"ipv4", listener.Addr().String(), true},
+		{"ipv6", listener.Addr().String(), false},
+		// Hosts mapped to an address of the other family aren't connected to either
+		{"ipv6", net.JoinHostPort("mirror.invalid", port), false},
+		{"ipv4", net.JoinHostPort("::1", port), false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.family+" "+tc.addr, func(t *testing.T) {
+			dialer, err := newRegistryDialer("", []string{"mirror.invalid:127.0.0.1"}, defaultRegistryKeepAlive, tc.family)
+			assert.NoError(t, err)
+			conn, err := dialer.DialContext(context.TODO(), "tcp", tc.addr)
+			if !tc.expectDial {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				conn.Close()
+			}
+		})
+	}
+
+	dialer, err := newRegistryDialer("", nil, defaultRegistryKeepAlive, "ipv4")
+	assert.NoError(t, err)
+	assert.True(t, dialer.configured())
+	_, err = newRegistryDialer("", nil, defaultRegistryKeepAlive, "ipv5")
+	assert.Error(t, err)
+}
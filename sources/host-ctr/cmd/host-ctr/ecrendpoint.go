@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	ecrsdk "github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/awslabs/amazon-ecr-containerd-resolver/ecr"
+	"github.com/containerd/containerd/remotes"
+)
+
+// parseECREndpoints parses ECR endpoint overrides in `region=URL` format. The URL's scheme is
+// kept, so ECR can be reached through a plain HTTP gateway in isolated environments.
+func parseECREndpoints(overrides []string) (map[string]string, error) {
+	if len(overrides) == 0 {
+		return nil, nil
+	}
+	ecrEndpoints := map[string]string{}
+	for _, override := range overrides {
+		region, endpoint, ok := strings.Cut(override, "=")
+		if !ok || region == "" {
+			return nil, fmt.Errorf("invalid ECR endpoint %q, expected `region=URL`", override)
+		}
+		parsed, err := url.Parse(endpoint)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid ECR endpoint %q for region %s, expected an http or https URL", endpoint, region)
+		}
+		ecrEndpoints[region] = endpoint
+	}
+	return ecrEndpoints, nil
+}
+
+// ecrEndpointResolver resolves the ECR API endpoint of the regions in overrides to the
+// overriding URL, and every other endpoint as the SDK would
+func ecrEndpointResolver(overrides map[string]string) endpoints.Resolver {
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		resolved, err := endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+		if endpoint, ok := overrides[region]; ok && service == ecrsdk.EndpointsID {
+			// Requests are still signed for the region, the gateway forwards them as they are
+			resolved.URL = endpoint
+			if resolved.SigningRegion == "" {
+				resolved.SigningRegion = region
+			}
+			return resolved, nil
+		}
+		return resolved, err
+	})
+}
+
+// newECRResolver creates the Amazon ECR resolver, with the ECR API endpoints of regions
+// overridden by overrides
+func newECRResolver(overrides map[string]string) (remotes.Resolver, error) {
+	if len(overrides) == 0 {
+		return ecr.NewResolver()
+	}
+	session, err := session.NewSession(aws.NewConfig().WithEndpointResolver(ecrEndpointResolver(overrides)))
+	if err != nil {
+		return nil, err
+	}
+	return ecr.NewResolver(ecr.WithSession(session))
+}
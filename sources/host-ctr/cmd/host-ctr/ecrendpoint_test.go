@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseECREndpoints(t *testing.T) {
+	tests := []struct {
+		overrides   []string
+		expectedErr bool
+		expected    map[string]string
+	}{
+		{nil, false, nil},
+		{[]string{"us-west-2=http://ecr-gateway.internal:8080"}, false, map[string]string{"us-west-2": "http://ecr-gateway.internal:8080"}},
+		{[]string{"us-west-2=https://ecr.internal", "us-east-1=http://10.0.0.1"}, false, map[string]string{"us-west-2": "https://ecr.internal", "us-east-1": "http://10.0.0.1"}},
+		{[]string{"us-west-2"}, true, nil},
+		{[]string{"=http://ecr.internal"}, true, nil},
+		{[]string{"us-west-2=ecr.internal"}, true, nil},
+		{[]string{"us-west-2=ftp://ecr.internal"}, true, nil},
+	}
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.overrides, ","), func(t *testing.T) {
+			endpoints, err := parseECREndpoints(tc.overrides)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, endpoints)
+		})
+	}
+}
+
+func TestECREndpointOverride(t *testing.T) {
+	// Keep the AWS SDK away from the host's configuration
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	manifest := `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`
+	var targets []string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets = append(targets, r.Header.Get("X-Amz-Target"))
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"images": []map[string]interface{}{{
+				"imageId":                map[string]string{"imageTag": "latest", "imageDigest": digest.FromString(manifest).String()},
+				"imageManifest":          manifest,
+				"imageManifestMediaType": ocispec.MediaTypeImageManifest,
+				"registryId":             "777777777777",
+				"repositoryName":         "bottlerocket/container",
+			}},
+		})
+	}))
+	defer gateway.Close()
+	assert.True(t, strings.HasPrefix(gateway.URL, "http://"))
+
+	resolver, err := newECRResolver(map[string]string{"us-west-2": gateway.URL})
+	assert.NoError(t, err)
+	_, desc, err := resolver.Resolve(context.TODO(), "ecr.aws/arn:aws:ecr:us-west-2:777777777777:repository/bottlerocket/container:latest")
+	assert.NoError(t, err)
+	assert.Equal(t, digest.FromString(manifest), desc.Digest)
+	assert.Equal(t, []string{"AmazonEC2ContainerRegistry_V20150921.BatchGetImage"}, targets)
+}
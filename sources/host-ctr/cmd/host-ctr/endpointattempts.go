@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/pkg/errors"
+)
+
+// endpointAttempts records the registry endpoints a pull attempt tried, in order, with the last
+// failure of each, so a failed pull can report why every endpoint failed
+type endpointAttempts struct {
+	mu       sync.Mutex
+	hosts    []string
+	failures map[string]string
+}
+
+func newEndpointAttempts() *endpointAttempts {
+	return &endpointAttempts{failures: map[string]string{}}
+}
+
+// record notes an attempt to use host, and its failure if reason isn't empty
+func (a *endpointAttempts) record(host string, reason string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !SliceContains(a.hosts, host) {
+		a.hosts = append(a.hosts, host)
+	}
+	if reason != "" {
+		a.failures[host] = reason
+	}
+}
+
+// wrapHosts wraps the clients of the registry hosts to record each request's outcome
+func (a *endpointAttempts) wrapHosts(hosts docker.RegistryHosts) docker.RegistryHosts {
+	return func(host string) ([]docker.RegistryHost, error) {
+		registries, err := hosts(host)
+		if err != nil {
+			return nil, err
+		}
+		for i := range registries {
+			client := http.Client{}
+			if registries[i].Client != nil {
+				client = *registries[i].Client
+			}
+			transport := client.Transport
+			if transport == nil {
+				transport = http.DefaultTransport
+			}
+			client.Transport = &recordingTransport{RoundTripper: transport, host: registries[i].Host, attempts: a}
+			registries[i].Client = &client
+		}
+		return registries, nil
+	}
+}
+
+// wrap adds the failure of each endpoint that failed to err
+func (a *endpointAttempts) wrap(err error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var failures []string
+	for _, host := range a.hosts {
+		if reason, ok := a.failures[host]; ok {
+			failures = append(failures, fmt.Sprintf("%s: %s", host, reason))
+		}
+	}
+	if len(failures) == 0 {
+		return err
+	}
+	return errors.Wrapf(err, "endpoints failed [%s]", strings.Join(failures, "; "))
+}
+
+// recordingTransport records the outcome of requests to a registry host
+type recordingTransport struct {
+	http.RoundTripper
+	host     string
+	attempts *endpointAttempts
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	switch {
+	case err != nil:
+		t.attempts.record(t.host, err.Error())
+	// Authentication challenges are answered by the resolver, they aren't failures
+	case resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusUnauthorized:
+		t.attempts.record(t.host, resp.Status)
+	default:
+		t.attempts.record(t.host, "")
+	}
+	return resp, err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointAttempts(t *testing.T) {
+	// A mirror that refuses connections
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	// A mirror that fails with a server error
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	// A mirror without the image
+	registry := newFakeRegistry(t)
+
+	config := &RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"*": {Endpoints: []string{closed.URL, broken.URL, registry.URL}},
+		},
+	}
+	ref := "registry.example.com/bottlerocket/container:latest"
+	attempts := newEndpointAttempts()
+	hosts := attempts.wrapHosts(func(host string) ([]docker.RegistryHost, error) {
+		registries, err := registryHosts(config, nil, ref)(host)
+		// Only try the mirrors, the upstream registry isn't reachable
+		return registries[:len(registries)-1], err
+	})
+	_, _, err := docker.NewResolver(docker.ResolverOptions{Hosts: hosts}).Resolve(context.TODO(), ref)
+	assert.Error(t, err)
+
+	err = attempts.wrap(err)
+	message := err.Error()
+	closedHost := strings.TrimPrefix(closed.URL, "http://")
+	brokenHost := strings.TrimPrefix(broken.URL, "http://")
+	registryHost := registry.registryHost().Host
+	assert.Contains(t, message, closedHost+": ")
+	assert.Contains(t, message, "connection refused")
+	assert.Contains(t, message, brokenHost+": 500 Internal Server Error")
+	assert.Contains(t, message, registryHost+": 404 Not Found")
+	// Endpoints are reported in the order they were tried
+	assert.Less(t, strings.Index(message, closedHost), strings.Index(message, brokenHost))
+	assert.Less(t, strings.Index(message, brokenHost), strings.Index(message, registryHost))
+}
+
+func TestEndpointAttemptsNoFailures(t *testing.T) {
+	attempts := newEndpointAttempts()
+	attempts.record("registry.example.com", "")
+	err := errors.New("failed to unpack")
+	assert.Equal(t, err, attempts.wrap(err))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+// entropyDiagnostic returns a diagnostic if err is a TLS handshake that timed out because the
+// kernel's random number generator hasn't been seeded yet, which can happen at early boot on
+// instances with few entropy sources. crngReady is only checked for handshake timeouts.
+func entropyDiagnostic(err error, crngReady func() bool) string {
+	if err == nil || !strings.Contains(err.Error(), "TLS handshake timeout") {
+		return ""
+	}
+	if crngReady() {
+		return ""
+	}
+	return "TLS handshake timed out while the kernel random number generator is not yet initialized; the host is waiting on entropy"
+}
+
+// crngReady returns whether the kernel's random number generator is initialized, without blocking
+func crngReady() bool {
+	_, err := unix.Getrandom(make([]byte, 1), unix.GRND_NONBLOCK)
+	return err != unix.EAGAIN
+}
+
+// waitForEntropy blocks until the kernel's random number generator is initialized or ctx ends
+func waitForEntropy(ctx context.Context) {
+	ready := make(chan struct{})
+	go func() {
+		// Without GRND_NONBLOCK, getrandom blocks until the random number generator is initialized
+		unix.Getrandom(make([]byte, 1), 0)
+		close(ready)
+	}()
+	select {
+	case <-ready:
+		log.G(ctx).Info("kernel random number generator initialized")
+	case <-ctx.Done():
+	}
+}
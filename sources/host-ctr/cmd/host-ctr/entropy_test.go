@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntropyDiagnostic(t *testing.T) {
+	handshakeTimeout := fmt.Errorf("failed to resolve reference: %w", errors.New("net/http: TLS handshake timeout"))
+	tests := []struct {
+		name       string
+		err        error
+		ready      bool
+		diagnostic bool
+	}{
+		{"No error", nil, false, false},
+		{"Other error", errors.New("connection refused"), false, false},
+		{"Handshake timeout with entropy", handshakeTimeout, true, false},
+		{"Handshake timeout without entropy", handshakeTimeout, false, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			checked := false
+			diagnostic := entropyDiagnostic(tc.err, func() bool {
+				checked = true
+				return tc.ready
+			})
+			assert.Equal(t, tc.diagnostic, diagnostic != "")
+			// Entropy is only checked for handshake timeouts
+			assert.Equal(t, tc.err == handshakeTimeout, checked)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/containerd/containerd"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// entrypointMismatchError is returned when the command an image runs isn't the expected one,
+// which can mean the image was tampered with or the wrong image was pulled
+type entrypointMismatchError struct {
+	Image    string
+	Command  []string
+	Expected []string
+}
+
+func (e *entrypointMismatchError) Error() string {
+	return fmt.Sprintf("image %s runs %q, which doesn't match the expected entrypoint %q", e.Image, e.Command, e.Expected)
+}
+
+// parseExpectedEntrypoint parses the expected entrypoint, a JSON array in the exec form of the
+// image's entrypoint followed by its cmd
+func parseExpectedEntrypoint(expected string) ([]string, error) {
+	if expected == "" {
+		return nil, nil
+	}
+	var command []string
+	if err := json.Unmarshal([]byte(expected), &command); err != nil || len(command) == 0 {
+		return nil, fmt.Errorf("invalid expected entrypoint %q, expected a non-empty JSON array such as [\"/usr/bin/start\", \"--flag\"]", expected)
+	}
+	return command, nil
+}
+
+// checkImageEntrypoint checks that the command the image config runs, its entrypoint followed
+// by its cmd, is the expected one
+func checkImageEntrypoint(image string, config ocispec.ImageConfig, expected []string) error {
+	command := append(append([]string{}, config.Entrypoint...), config.Cmd...)
+	if slices.Equal(command, expected) {
+		return nil
+	}
+	return &entrypointMismatchError{Image: image, Command: command, Expected: expected}
+}
+
+// verifyImageEntrypoint checks the entrypoint of the image's config against the expected one
+func verifyImageEntrypoint(ctx context.Context, img containerd.Image, expected []string) error {
+	spec, err := img.Spec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read image config for %s", img.Name())
+	}
+	return checkImageEntrypoint(img.Name(), spec.Config, expected)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExpectedEntrypoint(t *testing.T) {
+	tests := []struct {
+		expected    string
+		expectedErr bool
+		command     []string
+	}{
+		{"", false, nil},
+		{`["/usr/bin/start"]`, false, []string{"/usr/bin/start"}},
+		{`["/bin/sh", "-c", "start"]`, false, []string{"/bin/sh", "-c", "start"}},
+		{`[]`, true, nil},
+		{`/usr/bin/start`, true, nil},
+		{`{"entrypoint": "/usr/bin/start"}`, true, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.expected, func(t *testing.T) {
+			command, err := parseExpectedEntrypoint(tc.expected)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.command, command)
+		})
+	}
+}
+
+func TestCheckImageEntrypoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		entrypoint []string
+		cmd        []string
+		expected   []string
+		mismatch   bool
+	}{
+		{"entrypoint matches", []string{"/usr/bin/start"}, nil, []string{"/usr/bin/start"}, false},
+		{"entrypoint and cmd match", []string{"/bin/sh", "-c"}, []string{"start"}, []string{"/bin/sh", "-c", "start"}, false},
+		{"cmd only matches", nil, []string{"/usr/bin/start"}, []string{"/usr/bin/start"}, false},
+		{"entrypoint changed", []string{"/usr/bin/other"}, nil, []string{"/usr/bin/start"}, true},
+		{"arguments changed", []string{"/usr/bin/start"}, []string{"--debug"}, []string{"/usr/bin/start"}, true},
+		{"no entrypoint", nil, nil, []string{"/usr/bin/start"}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := ocispec.ImageConfig{Entrypoint: tc.entrypoint, Cmd: tc.cmd}
+			err := checkImageEntrypoint("registry.example.com/bottlerocket/container:latest", config, tc.expected)
+			if !tc.mismatch {
+				assert.NoError(t, err)
+				return
+			}
+			var mismatch *entrypointMismatchError
+			assert.ErrorAs(t, err, &mismatch)
+			assert.Equal(t, tc.expected, mismatch.Expected)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/containerd/events"
+	"github.com/containerd/log"
+	"github.com/containerd/typeurl/v2"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// The topics of the events host-ctr publishes to containerd's event exchange for image pulls
+const (
+	pullStartedTopic   = "/host-ctr/pull/started"
+	pullCompletedTopic = "/host-ctr/pull/completed"
+	pullFailedTopic    = "/host-ctr/pull/failed"
+)
+
+// Whether image pull events are published to containerd's event exchange, set up from the command line
+var emitPullEvents bool
+
+// The time allowed to publish a pull event, so a stuck event exchange can't hold up pulls
+const pullEventTimeout = time.Second
+
+// Whether a failure to publish a pull event was already logged as a warning
+var pullEventWarned atomic.Bool
+
+// pullEvent is the event published for each of the image pull topics. The digest is only set
+// when the pull completed, and the error only when it failed.
+type pullEvent struct {
+	Ref    string        `json:"ref"`
+	Digest digest.Digest `json:"digest,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+func init() {
+	// Events are sent to containerd as the JSON encoding of the event with this type URL
+	typeurl.Register(&pullEvent{}, "io.bottlerocket.host-ctr", "PullEvent")
+}
+
+// publishPullEvent publishes the event for the topic, only logging failures since the events
+// are informational for event consumers and never affect the pull. Only the first failure is
+// logged as a warning. Nothing is published if publisher is nil.
+func publishPullEvent(ctx context.Context, publisher events.Publisher, topic string, event *pullEvent) {
+	if publisher == nil {
+		return
+	}
+	publishCtx, cancel := context.WithTimeout(ctx, pullEventTimeout)
+	defer cancel()
+	err := publisher.Publish(publishCtx, topic, event)
+	if err == nil {
+		return
+	}
+	entry := log.G(ctx).WithError(err).WithField("topic", topic)
+	if pullEventWarned.CompareAndSwap(false, true) {
+		entry.Warn("failed to publish pull event")
+		return
+	}
+	entry.Debug("failed to publish pull event")
+}
+
+// publishPullResult publishes whether the pull of ref completed, with the digest it was pulled
+// at, or failed with err
+func publishPullResult(ctx context.Context, publisher events.Publisher, ref string, dgst digest.Digest, err error) {
+	if err != nil {
+		publishPullEvent(ctx, publisher, pullFailedTopic, &pullEvent{Ref: ref, Error: err.Error()})
+		return
+	}
+	publishPullEvent(ctx, publisher, pullCompletedTopic, &pullEvent{Ref: ref, Digest: dgst})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/events"
+	"github.com/containerd/typeurl/v2"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePublisher records the events published to it
+type fakePublisher struct {
+	topics []string
+	events []events.Event
+	err    error
+}
+
+func (p *fakePublisher) Publish(_ context.Context, topic string, event events.Event) error {
+	p.topics = append(p.topics, topic)
+	p.events = append(p.events, event)
+	return p.err
+}
+
+func TestPublishPullEvents(t *testing.T) {
+	const ref = "registry.example.com/bottlerocket/container:latest"
+	dgst := digest.FromString("manifest")
+	tests := []struct {
+		name     string
+		err      error
+		topic    string
+		expected *pullEvent
+	}{
+		{"Pull completed", nil, pullCompletedTopic, &pullEvent{Ref: ref, Digest: dgst}},
+		{"Pull failed", errors.New("unexpected status code: 503 Service Unavailable"), pullFailedTopic, &pullEvent{Ref: ref, Error: "unexpected status code: 503 Service Unavailable"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			publisher := &fakePublisher{}
+			publishPullEvent(context.TODO(), publisher, pullStartedTopic, &pullEvent{Ref: ref})
+			publishPullResult(context.TODO(), publisher, ref, dgst, tc.err)
+			assert.Equal(t, []string{pullStartedTopic, tc.topic}, publisher.topics)
+			assert.Equal(t, []events.Event{&pullEvent{Ref: ref}, tc.expected}, publisher.events)
+		})
+	}
+}
+
+func TestPullEventSchema(t *testing.T) {
+	event := &pullEvent{Ref: "registry.example.com/bottlerocket/container:latest", Digest: digest.FromString("manifest")}
+	encoded, err := typeurl.MarshalAny(event)
+	require.NoError(t, err)
+	assert.Equal(t, "io.bottlerocket.host-ctr/PullEvent", encoded.GetTypeUrl())
+	var fields map[string]string
+	require.NoError(t, json.Unmarshal(encoded.GetValue(), &fields))
+	assert.Equal(t, map[string]string{"ref": event.Ref, "digest": event.Digest.String()}, fields)
+}
+
+func TestPublishPullEventFailures(t *testing.T) {
+	// Publishing is skipped without a publisher, and failures to publish don't panic or block
+	publishPullEvent(context.TODO(), nil, pullStartedTopic, &pullEvent{Ref: "registry.example.com/bottlerocket/container:latest"})
+	publisher := &fakePublisher{err: errors.New("namespace is required")}
+	publishPullResult(context.TODO(), publisher, "registry.example.com/bottlerocket/container:latest", "", nil)
+	assert.Equal(t, []string{pullCompletedTopic}, publisher.topics)
+}
+
+// blockingPublisher blocks until the publish is canceled
+type blockingPublisher struct{}
+
+func (blockingPublisher) Publish(ctx context.Context, _ string, _ events.Event) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestPublishPullEventTimeout(t *testing.T) {
+	// A stuck event exchange holds up the pull for at most the publish timeout
+	start := time.Now()
+	publishPullEvent(context.TODO(), blockingPublisher{}, pullStartedTopic, &pullEvent{Ref: "registry.example.com/bottlerocket/container:latest"})
+	assert.Less(t, time.Since(start), 2*pullEventTimeout)
+}
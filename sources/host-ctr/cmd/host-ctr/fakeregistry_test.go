@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// fakeRegistry is a minimal in-memory OCI distribution registry used to test
+// pulls without network access. Content is served for any repository name.
+type fakeRegistry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	blobs     map[digest.Digest][]byte
+	mediaType map[digest.Digest]string
+	tags      map[string]digest.Digest
+	referrers map[digest.Digest][]ocispec.Descriptor
+	requests  []fakeRequest
+}
+
+// fakeRequest records a request received by the fake registry
+type fakeRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+}
+
+// newFakeRegistry starts a fake registry that is shut down when the test completes
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{
+		blobs:     map[digest.Digest][]byte{},
+		mediaType: map[digest.Digest]string{},
+		tags:      map[string]digest.Digest{},
+		referrers: map[digest.Digest][]ocispec.Descriptor{},
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// mirrorConfig returns a registry config that mirrors every registry to the fake registry
+func (r *fakeRegistry) mirrorConfig() *RegistryConfig {
+	return &RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"*": {Endpoints: []string{r.URL}},
+		},
+	}
+}
+
+// registryHost returns the registry host configuration for the fake registry
+func (r *fakeRegistry) registryHost() docker.RegistryHost {
+	return docker.RegistryHost{
+		Host:         strings.TrimPrefix(r.URL, "http://"),
+		Scheme:       "http",
+		Path:         "/v2",
+		Capabilities: docker.HostCapabilityResolve | docker.HostCapabilityPull,
+	}
+}
+
+// resolver returns a resolver that pulls every registry through the fake registry only, without
+// falling back to the unreachable upstream registry
+func (r *fakeRegistry) resolver() remotes.Resolver {
+	hosts := func(host string) ([]docker.RegistryHost, error) {
+		registries, err := registryHosts(r.mirrorConfig(), nil, "")(host)
+		if err != nil {
+			return nil, err
+		}
+		return registries[:len(registries)-1], nil
+	}
+	return docker.NewResolver(docker.ResolverOptions{Hosts: hosts})
+}
+
+// addBlob stores content in the registry and returns its descriptor
+func (r *fakeRegistry) addBlob(mediaType string, content []byte) ocispec.Descriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dgst := digest.FromBytes(content)
+	r.blobs[dgst] = content
+	r.mediaType[dgst] = mediaType
+	return ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Size:      int64(len(content)),
+	}
+}
+
+// addJSON stores the JSON encoding of v in the registry and returns its descriptor
+func (r *fakeRegistry) addJSON(t *testing.T, mediaType string, v interface{}) ocispec.Descriptor {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.addBlob(mediaType, raw)
+}
+
+// addImage stores a single-platform image with the given layers and returns the
+// descriptors for its manifest and config
+func (r *fakeRegistry) addImage(t *testing.T, platform ocispec.Platform, layers ...[]byte) (ocispec.Descriptor, ocispec.Descriptor) {
+	config := r.addJSON(t, ocispec.MediaTypeImageConfig, ocispec.Image{
+		Platform: platform,
+		Config: ocispec.ImageConfig{
+			Entrypoint: []string{"/bin/sh"},
+		},
+		RootFS: ocispec.RootFS{Type: "layers"},
+	})
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+	}
+	manifest.SchemaVersion = 2
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, r.addBlob(ocispec.MediaTypeImageLayer, layer))
+	}
+	desc := r.addJSON(t, ocispec.MediaTypeImageManifest, manifest)
+	desc.Platform = &platform
+	return desc, config
+}
+
+// addIndex stores an image index referencing the given manifests
+func (r *fakeRegistry) addIndex(t *testing.T, manifests ...ocispec.Descriptor) ocispec.Descriptor {
+	index := ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: manifests,
+	}
+	index.SchemaVersion = 2
+	return r.addJSON(t, ocispec.MediaTypeImageIndex, index)
+}
+
+// tag points `repository:tag` at desc
+func (r *fakeRegistry) tag(repository string, tag string, desc ocispec.Descriptor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tags[repository+":"+tag] = desc.Digest
+}
+
+// addReferrer makes desc a referrer of the manifest with digest subject
+func (r *fakeRegistry) addReferrer(subject digest.Digest, desc ocispec.Descriptor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.referrers[subject] = append(r.referrers[subject], desc)
+}
+
+// fetched returns whether the blob or manifest with the given digest was fetched
+func (r *fakeRegistry) fetched(dgst digest.Digest) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, request := range r.requests {
+		if request.Method == http.MethodGet && strings.HasSuffix(request.Path, "/"+dgst.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// received returns the requests received by the registry
+func (r *fakeRegistry) received() []fakeRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]fakeRequest(nil), r.requests...)
+}
+
+func (r *fakeRegistry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, fakeRequest{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Header: req.Header.Clone(),
+	})
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if path == "" || path == req.URL.Path {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if i := strings.LastIndex(path, "/referrers/"); i >= 0 {
+		index := ocispec.Index{
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: r.referrers[digest.Digest(path[i+len("/referrers/"):])],
+		}
+		index.SchemaVersion = 2
+		if index.Manifests == nil {
+			index.Manifests = []ocispec.Descriptor{}
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+		json.NewEncoder(w).Encode(index)
+		return
+	}
+
+	var (
+		dgst digest.Digest
+		ok   bool
+	)
+	if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+		repository, reference := path[:i], path[i+len("/manifests/"):]
+		if dgst, ok = r.tags[repository+":"+reference]; !ok {
+			dgst = digest.Digest(reference)
+		}
+	} else if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+		dgst = digest.Digest(path[i+len("/blobs/"):])
+	}
+	content, ok := r.blobs[dgst]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	mediaType := r.mediaType[dgst]
+	if !images.IsManifestType(mediaType) && !images.IsIndexType(mediaType) {
+		mediaType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(http.StatusOK)
+	if req.Method != http.MethodHead {
+		w.Write(content)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+)
+
+// The time allowed for a registry mirror to respond to the latency probe
+const mirrorLatencyTimeout = 5 * time.Second
+
+// Whether registry mirrors are ordered by their measured latency, set up from the command line
+var registryPickFastest bool
+
+// mirrorLatency measures the round trip time of a request to the registry's base `/v2/` API. Any
+// response counts, since the probe isn't authorized.
+func mirrorLatency(registry docker.RegistryHost) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorLatencyTimeout)
+	defer cancel()
+	client := registry.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s%s/", registry.Scheme, registry.Host, registry.Path), nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return time.Since(start), nil
+}
+
+// withFastestMirrorFirst wraps hosts to order the registry mirrors by the latency measured by
+// probe, fastest first. Mirrors that can't be probed follow the others in their configured order,
+// and the upstream registry, the last of the hosts, stays last.
+func withFastestMirrorFirst(hosts docker.RegistryHosts, probe func(docker.RegistryHost) (time.Duration, error)) docker.RegistryHosts {
+	return func(host string) ([]docker.RegistryHost, error) {
+		registries, err := hosts(host)
+		if err != nil || len(registries) < 3 {
+			return registries, err
+		}
+		mirrors := registries[:len(registries)-1]
+		latencies := make([]time.Duration, len(mirrors))
+		reachable := make([]bool, len(mirrors))
+		var wg sync.WaitGroup
+		for i := range mirrors {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				latency, err := probe(mirrors[i])
+				if err != nil {
+					log.L.WithError(err).WithField("host", mirrors[i].Host).Debug("failed to measure registry mirror latency")
+					return
+				}
+				latencies[i], reachable[i] = latency, true
+			}(i)
+		}
+		wg.Wait()
+
+		order := make([]int, len(mirrors))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			i, j := order[a], order[b]
+			if reachable[i] != reachable[j] {
+				return reachable[i]
+			}
+			return reachable[i] && latencies[i] < latencies[j]
+		})
+		sorted := make([]docker.RegistryHost, 0, len(registries))
+		for _, i := range order {
+			entry := log.L.WithField("host", mirrors[i].Host)
+			if reachable[i] {
+				entry = entry.WithField("latency", latencies[i].String())
+			}
+			entry.Debug("ordered registry mirror by latency")
+			sorted = append(sorted, mirrors[i])
+		}
+		return append(sorted, registries[len(registries)-1]), nil
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithFastestMirrorFirst(t *testing.T) {
+	registries := []docker.RegistryHost{
+		{Host: "far.example.com"},
+		{Host: "down.example.com"},
+		{Host: "near.example.com"},
+		{Host: "middle.example.com"},
+		{Host: "registry.example.com"},
+	}
+	hosts := func(string) ([]docker.RegistryHost, error) {
+		return append([]docker.RegistryHost(nil), registries...), nil
+	}
+	rtts := map[string]time.Duration{
+		"far.example.com":      180 * time.Millisecond,
+		"near.example.com":     5 * time.Millisecond,
+		"middle.example.com":   40 * time.Millisecond,
+		"registry.example.com": time.Millisecond,
+	}
+	probe := func(registry docker.RegistryHost) (time.Duration, error) {
+		rtt, ok := rtts[registry.Host]
+		if !ok {
+			return 0, errors.New("connection refused")
+		}
+		return rtt, nil
+	}
+
+	ordered, err := withFastestMirrorFirst(hosts, probe)("registry.example.com")
+	assert.NoError(t, err)
+	var order []string
+	for _, registry := range ordered {
+		order = append(order, registry.Host)
+	}
+	// The upstream registry stays last even though it's the fastest
+	assert.Equal(t, []string{"near.example.com", "middle.example.com", "far.example.com", "down.example.com", "registry.example.com"}, order)
+}
+
+func TestWithFastestMirrorFirstSingleMirror(t *testing.T) {
+	registries := []docker.RegistryHost{{Host: "mirror.example.com"}, {Host: "registry.example.com"}}
+	hosts := func(string) ([]docker.RegistryHost, error) { return registries, nil }
+	probe := func(docker.RegistryHost) (time.Duration, error) {
+		t.Fatal("expected a single mirror not to be probed")
+		return 0, nil
+	}
+	ordered, err := withFastestMirrorFirst(hosts, probe)("registry.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, registries, ordered)
+}
+
+func TestMirrorLatency(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/v2/", req.URL.Path)
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(server.Close)
+	latency, err := mirrorLatency(docker.RegistryHost{Host: strings.TrimPrefix(server.URL, "http://"), Scheme: "http", Path: "/v2"})
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, latency, 20*time.Millisecond)
+
+	server.Close()
+	_, err = mirrorLatency(docker.RegistryHost{Host: strings.TrimPrefix(server.URL, "http://"), Scheme: "http", Path: "/v2"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// fetchImageConfig resolves ref and fetches the image configuration for the platform
+// selected by matcher. Only the manifests and the config blob are fetched, the image
+// layers are never downloaded.
+func fetchImageConfig(ctx context.Context, resolver remotes.Resolver, ref string, matcher platforms.MatchComparer) ([]byte, error) {
+	name, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve %q", ref)
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get fetcher for %q", name)
+	}
+
+	for {
+		switch {
+		case images.IsIndexType(desc.MediaType):
+			var index ocispec.Index
+			if err := fetchJSON(ctx, fetcher, desc, &index); err != nil {
+				return nil, err
+			}
+			desc, err = selectManifest(index.Manifests, matcher)
+			if err != nil {
+				return nil, errors.Wrapf(err, "select manifest from %q", name)
+			}
+		case images.IsManifestType(desc.MediaType):
+			var manifest ocispec.Manifest
+			if err := fetchJSON(ctx, fetcher, desc, &manifest); err != nil {
+				return nil, err
+			}
+			return fetchBlob(ctx, fetcher, manifest.Config)
+		default:
+			return nil, errors.Errorf("unsupported media type %q for %q", desc.MediaType, name)
+		}
+	}
+}
+
+// selectManifest returns the manifest from an index that best matches the platform.
+// Manifests without a platform are considered a match for any platform.
+func selectManifest(manifests []ocispec.Descriptor, matcher platforms.MatchComparer) (ocispec.Descriptor, error) {
+	var matched []ocispec.Descriptor
+	for _, manifest := range manifests {
+		if manifest.Platform == nil || matcher.Match(*manifest.Platform) {
+			matched = append(matched, manifest)
+		}
+	}
+	if len(matched) == 0 {
+		return ocispec.Descriptor{}, errors.Wrap(errdefs.ErrNotFound, "no manifest matches the platform")
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].Platform == nil {
+			return false
+		}
+		if matched[j].Platform == nil {
+			return true
+		}
+		return matcher.Less(*matched[i].Platform, *matched[j].Platform)
+	})
+	return matched[0], nil
+}
+
+// fetchJSON fetches the blob for desc and unmarshals it into v
+func fetchJSON(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, v interface{}) error {
+	raw, err := fetchBlob(ctx, fetcher, desc)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return errors.Wrapf(err, "failed to unmarshal %s", desc.Digest)
+	}
+	return nil
+}
+
+// fetchBlob fetches the blob for desc and verifies it against the descriptor's digest
+func fetchBlob(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) ([]byte, error) {
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid digest %q", desc.Digest)
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch %s", desc.Digest)
+	}
+	defer rc.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(rc, desc.Size+1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", desc.Digest)
+	}
+	if int64(len(raw)) != desc.Size {
+		return nil, errors.Errorf("size mismatch for %s: expected %d bytes, got %d", desc.Digest, desc.Size, len(raw))
+	}
+	if actual := desc.Digest.Algorithm().FromBytes(raw); actual != desc.Digest {
+		return nil, errors.Errorf("digest mismatch for %s: got %s", desc.Digest, actual)
+	}
+	return raw, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchImageConfig(t *testing.T) {
+	registry := newFakeRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	amd64Manifest, amd64Config := registry.addImage(t, amd64, []byte("amd64 layer"))
+	arm64Manifest, arm64Config := registry.addImage(t, arm64, []byte("arm64 layer"))
+	registry.tag("bottlerocket/single", "latest", amd64Manifest)
+	registry.tag("bottlerocket/multi", "latest", registry.addIndex(t, amd64Manifest, arm64Manifest))
+
+	tests := []struct {
+		name             string
+		ref              string
+		platform         ocispec.Platform
+		expectedConfig   ocispec.Descriptor
+		expectedManifest ocispec.Descriptor
+	}{
+		{
+			"Single platform image",
+			"registry.example.com/bottlerocket/single:latest",
+			amd64,
+			amd64Config,
+			amd64Manifest,
+		},
+		{
+			"Image index",
+			"registry.example.com/bottlerocket/multi:latest",
+			arm64,
+			arm64Config,
+			arm64Manifest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := fetchImageConfig(context.TODO(), registry.resolver(), tc.ref, platforms.Only(tc.platform))
+			assert.NoError(t, err)
+
+			var image ocispec.Image
+			assert.NoError(t, json.Unmarshal(config, &image))
+			assert.Equal(t, tc.platform.Architecture, image.Architecture)
+			assert.True(t, registry.fetched(tc.expectedManifest.Digest))
+			assert.True(t, registry.fetched(tc.expectedConfig.Digest))
+		})
+	}
+
+	// None of the layers should have been fetched
+	for _, manifest := range []ocispec.Descriptor{amd64Manifest, arm64Manifest} {
+		var m ocispec.Manifest
+		assert.NoError(t, json.Unmarshal(registry.blobs[manifest.Digest], &m))
+		for _, layer := range m.Layers {
+			assert.False(t, registry.fetched(layer.Digest))
+		}
+	}
+}
+
+func TestFetchImageConfigNoMatchingPlatform(t *testing.T) {
+	registry := newFakeRegistry(t)
+	amd64Manifest, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "amd64"})
+	registry.tag("bottlerocket/multi", "latest", registry.addIndex(t, amd64Manifest))
+
+	_, err := fetchImageConfig(context.TODO(), registry.resolver(), "registry.example.com/bottlerocket/multi:latest",
+		platforms.Only(ocispec.Platform{OS: "linux", Architecture: "arm64"}))
+	assert.Error(t, err)
+}
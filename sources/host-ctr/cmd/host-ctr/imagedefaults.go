@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// The image config label image authors can use to ship default container settings
+const imageDefaultsLabel = "io.bottlerocket.host-ctr.defaults"
+
+// imageDefaults holds the default container labels and mounts shipped with an image, e.g.
+//
+//	{"labels": {"key": "value"}, "mounts": [{"type": "bind", "source": "/x", "destination": "/y", "options": ["rbind", "ro"]}]}
+//
+// The mounts are only applied if they're read-only bind mounts of host paths the operator allows.
+type imageDefaults struct {
+	Labels map[string]string   `json:"labels,omitempty"`
+	Mounts []runtimespec.Mount `json:"mounts,omitempty"`
+}
+
+// containerOptions holds the container labels and mounts requested on the command line
+type containerOptions struct {
+	labels map[string]string
+	mounts []runtimespec.Mount
+	// Whether to apply the defaults embedded in the image
+	useImageDefaults bool
+	// Whether to run images whose config declares a different platform than requested
+	ignorePlatformMismatch bool
+	// Whether to recreate the container from its image when a refresh pulls a new digest
+	swapOnUpdate bool
+	// The entrypoint followed by the cmd the image must run, if set
+	expectedEntrypoint []string
+	// The command that scans the unpacked image before the container is created, if set
+	scanCommand []string
+	// The most severe vulnerabilities the image's embedded vulnerability summary may list, if set
+	maxVulnSeverity vulnSeverity
+	// The cgroup resource constraints of the container
+	resources resourceLimits
+	// Whether the container's process reads from host-ctr's stdin
+	interactive bool
+	// Whether to check the host CPU has the features the image requires
+	checkCPUFeatures bool
+	// Whether to copy the image config labels onto the container
+	inheritImageLabels bool
+	// The host paths mounts from the image defaults may bind, read-only
+	imageMountSources []string
+	// The prefixes every container label key must start with, including labels from the image
+	allowedLabelPrefixes []string
+	// Whether to print the container's OCI runtime spec before creating its task
+	printSpec bool
+	// Whether to print the spec the container would be created with, instead of creating it
+	dryRunSpec bool
+	// How long the container's task gets to exit after SIGTERM before it is killed, zero for the default
+	stopGracePeriod time.Duration
+	// Stops the container when closed, instead of host-ctr's own SIGTERM and SIGINT, if set
+	stopC <-chan struct{}
+}
+
+// parseImageDefaults parses the image defaults from the image config labels. Images without
+// the defaults label have no defaults.
+func parseImageDefaults(configLabels map[string]string) (imageDefaults, error) {
+	var defaults imageDefaults
+	raw, ok := configLabels[imageDefaultsLabel]
+	if !ok {
+		return defaults, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &defaults); err != nil {
+		return defaults, errors.Wrapf(err, "failed to parse %s image label", imageDefaultsLabel)
+	}
+	for _, mount := range defaults.Mounts {
+		if mount.Destination == "" {
+			return defaults, errors.Errorf("mount in %s image label is missing a destination", imageDefaultsLabel)
+		}
+	}
+	return defaults, nil
+}
+
+// fetchImageLabels reads the image's config labels and applies them to the container options.
+// The image defaults are merged in if requested, then the image labels if they're inherited, and
+// the final set of container labels must start with the allowed prefixes.
+func fetchImageLabels(ctx context.Context, img containerd.Image, opts containerOptions) (containerOptions, error) {
+	if !opts.useImageDefaults && !opts.inheritImageLabels && len(opts.allowedLabelPrefixes) == 0 {
+		return opts, nil
+	}
+	spec, err := img.Spec(ctx)
+	if err != nil {
+		return opts, errors.Wrapf(err, "failed to read image config for %s", img.Name())
+	}
+	return applyImageLabels(spec.Config.Labels, opts)
+}
+
+// applyImageLabels applies the image config labels to the container options, as fetchImageLabels
+func applyImageLabels(configLabels map[string]string, opts containerOptions) (containerOptions, error) {
+	if opts.useImageDefaults {
+		defaults, err := parseImageDefaults(configLabels)
+		if err != nil {
+			return opts, err
+		}
+		if err := defaults.checkMounts(opts.imageMountSources); err != nil {
+			return opts, err
+		}
+		opts = defaults.merge(opts)
+	}
+	if opts.inheritImageLabels {
+		opts.labels = mergeImageLabels(configLabels, opts.labels)
+	}
+	if err := checkLabelPrefixes(opts.labels, opts.allowedLabelPrefixes); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// The mount options image defaults may use. Every image mount must be a read-only bind mount.
+var imageMountOptions = map[string]bool{"bind": true, "rbind": true, "ro": true, "nosuid": true, "nodev": true, "noexec": true}
+
+// checkMounts returns an error unless every mount in the image defaults is a read-only bind mount
+// of one of the allowed host paths. Images are pulled from registries, so they can't be trusted
+// to choose what the container sees of the host.
+func (defaults imageDefaults) checkMounts(allowedSources []string) error {
+	for _, mount := range defaults.Mounts {
+		if mount.Type != "bind" {
+			return errors.Errorf("mount of %s in %s image label isn't a bind mount", mount.Destination, imageDefaultsLabel)
+		}
+		readOnly := false
+		for _, option := range mount.Options {
+			if !imageMountOptions[option] {
+				return errors.Errorf("mount of %s in %s image label has disallowed option %q", mount.Destination, imageDefaultsLabel, option)
+			}
+			readOnly = readOnly || option == "ro"
+		}
+		if !readOnly {
+			return errors.Errorf("mount of %s in %s image label isn't read-only", mount.Destination, imageDefaultsLabel)
+		}
+		allowed := false
+		for _, source := range allowedSources {
+			if filepath.IsAbs(mount.Source) && filepath.Clean(mount.Source) == filepath.Clean(source) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return errors.Errorf("mount of %s in %s image label binds %q, which isn't an allowed source", mount.Destination, imageDefaultsLabel, mount.Source)
+		}
+	}
+	return nil
+}
+
+// merge applies the image defaults underneath the container options. Labels from the
+// command line replace image labels with the same key, and mounts from the command line
+// replace image mounts with the same destination.
+func (defaults imageDefaults) merge(opts containerOptions) containerOptions {
+	labels := map[string]string{}
+	for k, v := range defaults.Labels {
+		labels[k] = v
+	}
+	for k, v := range opts.labels {
+		labels[k] = v
+	}
+
+	overridden := map[string]bool{}
+	for _, mount := range opts.mounts {
+		overridden[mount.Destination] = true
+	}
+	var mounts []runtimespec.Mount
+	for _, mount := range defaults.Mounts {
+		if !overridden[mount.Destination] {
+			mounts = append(mounts, mount)
+		}
+	}
+	mounts = append(mounts, opts.mounts...)
+
+	opts.labels = labels
+	opts.mounts = mounts
+	return opts
+}
+
+// mergeImageLabels returns the image config labels merged underneath the container labels, so
+// labels from the command line or the image defaults replace image labels with the same key. The
+// image defaults label itself isn't copied.
+func mergeImageLabels(configLabels map[string]string, labels map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range configLabels {
+		if k != imageDefaultsLabel {
+			merged[k] = v
+		}
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
+}
+
+// convertMounts converts mounts in the format of "source:destination[:option,...]" to bind mounts
+func convertMounts(mounts []string) ([]runtimespec.Mount, error) {
+	var converted []runtimespec.Mount
+	for _, mount := range mounts {
+		parts := strings.SplitN(mount, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid mount %q, expected `source:destination[:options]`", mount)
+		}
+		options := []string{"rbind"}
+		if len(parts) == 3 && parts[2] != "" {
+			options = append(options, strings.Split(parts[2], ",")...)
+		}
+		converted = append(converted, runtimespec.Mount{
+			Type:        "bind",
+			Source:      parts[0],
+			Destination: parts[1],
+			Options:     options,
+		})
+	}
+	return converted, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseImageDefaults(t *testing.T) {
+	tests := []struct {
+		name         string
+		configLabels map[string]string
+		expectedErr  bool
+		expected     imageDefaults
+	}{
+		{
+			"No defaults label",
+			map[string]string{"other": "label"},
+			false,
+			imageDefaults{},
+		},
+		{
+			"Labels and mounts",
+			map[string]string{imageDefaultsLabel: `{"labels": {"a": "1"}, "mounts": [{"type": "bind", "source": "/x", "destination": "/y", "options": ["rbind", "ro"]}]}`},
+			false,
+			imageDefaults{
+				Labels: map[string]string{"a": "1"},
+				Mounts: []runtimespec.Mount{{Type: "bind", Source: "/x", Destination: "/y", Options: []string{"rbind", "ro"}}},
+			},
+		},
+		{
+			"Invalid JSON",
+			map[string]string{imageDefaultsLabel: `{"labels": `},
+			true,
+			imageDefaults{},
+		},
+		{
+			"Mount without destination",
+			map[string]string{imageDefaultsLabel: `{"mounts": [{"source": "/x"}]}`},
+			true,
+			imageDefaults{},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defaults, err := parseImageDefaults(tc.configLabels)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, defaults)
+		})
+	}
+}
+
+func TestImageDefaultsPrecedence(t *testing.T) {
+	defaults := imageDefaults{
+		Labels: map[string]string{"a": "image", "b": "image"},
+		Mounts: []runtimespec.Mount{
+			{Type: "bind", Source: "/image/x", Destination: "/x"},
+			{Type: "bind", Source: "/image/y", Destination: "/y"},
+		},
+	}
+	flags := containerOptions{
+		labels: map[string]string{"b": "flag", "c": "flag"},
+		mounts: []runtimespec.Mount{
+			{Type: "bind", Source: "/flag/y", Destination: "/y"},
+			{Type: "bind", Source: "/flag/z", Destination: "/z"},
+		},
+		useImageDefaults: true,
+	}
+
+	merged := defaults.merge(flags)
+	assert.Equal(t, map[string]string{"a": "image", "b": "flag", "c": "flag"}, merged.labels)
+	assert.Equal(t, []runtimespec.Mount{
+		{Type: "bind", Source: "/image/x", Destination: "/x"},
+		{Type: "bind", Source: "/flag/y", Destination: "/y"},
+		{Type: "bind", Source: "/flag/z", Destination: "/z"},
+	}, merged.mounts)
+	// The image defaults don't modify the flags
+	assert.Equal(t, map[string]string{"b": "flag", "c": "flag"}, flags.labels)
+
+	// Without image defaults the flags are used as is
+	merged = imageDefaults{}.merge(flags)
+	assert.Equal(t, flags.labels, merged.labels)
+	assert.Equal(t, flags.mounts, merged.mounts)
+}
+
+func TestMergeImageLabels(t *testing.T) {
+	configLabels := map[string]string{
+		"org.opencontainers.image.source":  "https://github.com/bottlerocket-os/bottlerocket-admin-container",
+		"org.opencontainers.image.version": "v0.11.0",
+		imageDefaultsLabel:                 `{"labels": {"tier": "image-defaults"}}`,
+	}
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected map[string]string
+	}{
+		{"No container labels", nil, map[string]string{
+			"org.opencontainers.image.source":  "https://github.com/bottlerocket-os/bottlerocket-admin-container",
+			"org.opencontainers.image.version": "v0.11.0",
+		}},
+		{"Conflicting keys", map[string]string{"org.opencontainers.image.version": "pinned", "tier": "admin"}, map[string]string{
+			"org.opencontainers.image.source":  "https://github.com/bottlerocket-os/bottlerocket-admin-container",
+			"org.opencontainers.image.version": "pinned",
+			"tier":                             "admin",
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, mergeImageLabels(configLabels, tc.labels))
+		})
+	}
+
+	// Labels from the image defaults also take precedence over the image config labels
+	opts := imageDefaults{Labels: map[string]string{"org.opencontainers.image.version": "defaults"}}.merge(containerOptions{})
+	assert.Equal(t, "defaults", mergeImageLabels(configLabels, opts.labels)["org.opencontainers.image.version"])
+}
+
+func TestImageDefaultsCheckMounts(t *testing.T) {
+	allowed := []string{"/etc/pki", "/var/lib/shared/"}
+	tests := []struct {
+		name        string
+		mount       runtimespec.Mount
+		expectedErr bool
+	}{
+		{"Read-only bind of an allowed source", runtimespec.Mount{Type: "bind", Source: "/etc/pki", Destination: "/pki", Options: []string{"rbind", "ro"}}, false},
+		{"Allowed source with a trailing slash", runtimespec.Mount{Type: "bind", Source: "/var/lib/shared", Destination: "/shared", Options: []string{"rbind", "ro", "nosuid"}}, false},
+		{"Host root", runtimespec.Mount{Type: "bind", Source: "/", Destination: "/host", Options: []string{"rbind", "ro"}}, true},
+		{"Below an allowed source", runtimespec.Mount{Type: "bind", Source: "/etc/pki/private", Destination: "/pki", Options: []string{"rbind", "ro"}}, true},
+		{"Escaping an allowed source", runtimespec.Mount{Type: "bind", Source: "/etc/pki/..", Destination: "/etc", Options: []string{"rbind", "ro"}}, true},
+		{"Writable", runtimespec.Mount{Type: "bind", Source: "/etc/pki", Destination: "/pki", Options: []string{"rbind"}}, true},
+		{"Read-write option", runtimespec.Mount{Type: "bind", Source: "/etc/pki", Destination: "/pki", Options: []string{"rbind", "ro", "rw"}}, true},
+		{"Propagation", runtimespec.Mount{Type: "bind", Source: "/etc/pki", Destination: "/pki", Options: []string{"rbind", "ro", "rshared"}}, true},
+		{"Not a bind mount", runtimespec.Mount{Type: "proc", Source: "proc", Destination: "/proc", Options: []string{"ro"}}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := imageDefaults{Mounts: []runtimespec.Mount{tc.mount}}.checkMounts(allowed)
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// Without allowed sources, image mounts are rejected altogether
+	_, err := applyImageLabels(map[string]string{
+		imageDefaultsLabel: `{"mounts": [{"type": "bind", "source": "/", "destination": "/host", "options": ["rbind"]}]}`,
+	}, containerOptions{useImageDefaults: true})
+	assert.Error(t, err)
+}
+
+func TestConvertMounts(t *testing.T) {
+	tests := []struct {
+		name        string
+		mounts      []string
+		expectedErr bool
+		expected    []runtimespec.Mount
+	}{
+		{
+			"No mounts",
+			nil,
+			false,
+			nil,
+		},
+		{
+			"Without options",
+			[]string{"/x:/y"},
+			false,
+			[]runtimespec.Mount{{Type: "bind", Source: "/x", Destination: "/y", Options: []string{"rbind"}}},
+		},
+		{
+			"With options",
+			[]string{"/x:/y:ro,rshared"},
+			false,
+			[]runtimespec.Mount{{Type: "bind", Source: "/x", Destination: "/y", Options: []string{"rbind", "ro", "rshared"}}},
+		},
+		{
+			"Missing destination",
+			[]string{"/x"},
+			true,
+			nil,
+		},
+		{
+			"Empty source",
+			[]string{":/y"},
+			true,
+			nil,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mounts, err := convertMounts(tc.mounts)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, mounts)
+		})
+	}
+}
+
+func TestApplyImageLabels(t *testing.T) {
+	configLabels := map[string]string{
+		"io.bottlerocket.tier": "image",
+		"com.example.tracking": "image",
+		imageDefaultsLabel:     `{"labels": {"com.example.defaults": "image-defaults"}}`,
+	}
+	allowed := []string{"io.bottlerocket."}
+	tests := []struct {
+		name        string
+		opts        containerOptions
+		expectedErr bool
+		expected    map[string]string
+	}{
+		{
+			"Image labels aren't used",
+			containerOptions{labels: map[string]string{"io.bottlerocket.name": "admin"}, allowedLabelPrefixes: allowed},
+			false,
+			map[string]string{"io.bottlerocket.name": "admin"},
+		},
+		{
+			"Inherited image label with a disallowed prefix",
+			containerOptions{inheritImageLabels: true, allowedLabelPrefixes: allowed},
+			true,
+			nil,
+		},
+		{
+			"Image defaults label with a disallowed prefix",
+			containerOptions{useImageDefaults: true, allowedLabelPrefixes: allowed},
+			true,
+			nil,
+		},
+		{
+			"Inherited image labels without allowed prefixes",
+			containerOptions{inheritImageLabels: true},
+			false,
+			map[string]string{"io.bottlerocket.tier": "image", "com.example.tracking": "image"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := applyImageLabels(configLabels, tc.opts)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, opts.labels)
+		})
+	}
+}
+
+// specImage is an image with the given config
+type specImage struct {
+	containerd.Image
+	config ocispec.ImageConfig
+}
+
+func (i *specImage) Name() string {
+	return "registry.example.com/bottlerocket/container:latest"
+}
+
+func (i *specImage) Spec(context.Context) (ocispec.Image, error) {
+	return ocispec.Image{Config: i.config}, nil
+}
+
+func TestFetchImageLabelsRejectsInheritedLabel(t *testing.T) {
+	img := &specImage{config: ocispec.ImageConfig{Labels: map[string]string{
+		"io.bottlerocket.tier":     "image",
+		"com.example.unauthorized": "image",
+	}}}
+	opts := containerOptions{
+		labels:               map[string]string{"io.bottlerocket.name": "admin"},
+		inheritImageLabels:   true,
+		allowedLabelPrefixes: []string{"io.bottlerocket."},
+	}
+	_, err := fetchImageLabels(context.TODO(), img, opts)
+	assert.ErrorContains(t, err, "com.example.unauthorized")
+
+	// Without the disallowed label, the image's labels are inherited
+	delete(img.config.Labels, "com.example.unauthorized")
+	merged, err := fetchImageLabels(context.TODO(), img, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"io.bottlerocket.tier": "image", "io.bottlerocket.name": "admin"}, merged.labels)
+}
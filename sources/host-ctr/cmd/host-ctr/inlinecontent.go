@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/remotes"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// inlineContent returns the content of descriptors that is known without fetching it from the
+// registry: the empty JSON blob OCI artifacts use for unused configs and layers, and content
+// embedded in the descriptor's data field
+func inlineContent(desc ocispec.Descriptor) ([]byte, bool) {
+	if desc.Digest == ocispec.DescriptorEmptyJSON.Digest && desc.Size == ocispec.DescriptorEmptyJSON.Size {
+		return ocispec.DescriptorEmptyJSON.Data, true
+	}
+	// Embedded data that doesn't match the descriptor is ignored, as registries ignore it too
+	if desc.Data != nil && int64(len(desc.Data)) == desc.Size && digest.FromBytes(desc.Data) == desc.Digest {
+		return desc.Data, true
+	}
+	return nil, false
+}
+
+// inlineResolver returns fetchers that serve inline content locally instead of
+// requesting it from the registry
+type inlineResolver struct {
+	remotes.Resolver
+}
+
+func (r inlineResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := r.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return inlineFetcher{fetcher}, nil
+}
+
+// inlineFetcher serves inline content, and fetches all other content with the wrapped fetcher
+type inlineFetcher struct {
+	remotes.Fetcher
+}
+
+func (f inlineFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	if data, ok := inlineContent(desc); ok {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return f.Fetcher.Fetch(ctx, desc)
+}
+
+// withInlineContent wraps the resolver set up by opt so that inline content isn't fetched
+func withInlineContent(opt containerd.RemoteOpt) containerd.RemoteOpt {
+	return func(client *containerd.Client, c *containerd.RemoteContext) error {
+		if err := opt(client, c); err != nil {
+			return err
+		}
+		c.Resolver = inlineResolver{c.Resolver}
+		return nil
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInlineContent(t *testing.T) {
+	empty := ocispec.DescriptorEmptyJSON
+	empty.Data = nil
+	embedded := []byte("embedded layer")
+	tests := []struct {
+		name     string
+		desc     ocispec.Descriptor
+		expected []byte
+	}{
+		{"empty descriptor", empty, []byte("{}")},
+		{"embedded data", ocispec.Descriptor{Digest: digest.FromBytes(embedded), Size: int64(len(embedded)), Data: embedded}, embedded},
+		{"mismatched data", ocispec.Descriptor{Digest: digest.FromBytes([]byte("other")), Size: int64(len(embedded)), Data: embedded}, nil},
+		{"no data", ocispec.Descriptor{Digest: digest.FromBytes(embedded), Size: int64(len(embedded))}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data, ok := inlineContent(tc.desc)
+			assert.Equal(t, tc.expected != nil, ok)
+			assert.Equal(t, tc.expected, data)
+		})
+	}
+}
+
+func TestFetchEmptyDescriptor(t *testing.T) {
+	registry := newFakeRegistry(t)
+	empty := ocispec.DescriptorEmptyJSON
+	empty.Data = nil
+	embedded := []byte("embedded layer")
+	embeddedLayer := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(embedded),
+		Size:      int64(len(embedded)),
+		Data:      embedded,
+	}
+	manifest := ocispec.Manifest{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: "application/vnd.example.artifact",
+		Config:       empty,
+		Layers:       []ocispec.Descriptor{empty, embeddedLayer},
+	}
+	manifest.SchemaVersion = 2
+	desc := registry.addJSON(t, ocispec.MediaTypeImageManifest, manifest)
+	registry.tag("bottlerocket/artifact", "latest", desc)
+
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := inlineResolver{registry.resolver()}
+	_, err = fetchToContentStore(context.TODO(), resolver, "registry.example.com/bottlerocket/artifact:latest", platforms.All, store, nil)
+	assert.NoError(t, err)
+
+	// Neither the empty descriptor nor the embedded layer are in the registry
+	assert.True(t, registry.fetched(desc.Digest))
+	assert.False(t, registry.fetched(empty.Digest))
+	assert.False(t, registry.fetched(embeddedLayer.Digest))
+	raw, err := content.ReadBlob(context.TODO(), store, empty)
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(raw))
+	raw, err = content.ReadBlob(context.TODO(), store, embeddedLayer)
+	assert.NoError(t, err)
+	assert.Equal(t, embedded, raw)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Supported inventory document formats
+const (
+	inventorySPDX      = "spdx"
+	inventoryCycloneDX = "cyclonedx"
+)
+
+// inventoryImage describes a pulled image in the inventory
+type inventoryImage struct {
+	Ref    string
+	Digest digest.Digest
+	Layers []ocispec.Descriptor
+}
+
+// spdxDocument is the subset of an SPDX 2.3 JSON document used for the inventory
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string         `json:"name"`
+	SPDXID           string         `json:"SPDXID"`
+	Version          string         `json:"versionInfo,omitempty"`
+	DownloadLocation string         `json:"downloadLocation"`
+	FilesAnalyzed    bool           `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum `json:"checksums"`
+}
+
+type spdxChecksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	Element string `json:"spdxElementId"`
+	Type    string `json:"relationshipType"`
+	Related string `json:"relatedSpdxElement"`
+}
+
+// cycloneDXDocument is the subset of a CycloneDX 1.5 JSON document used for the inventory
+type cycloneDXDocument struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Metadata    cycloneDXMetadata    `json:"metadata"`
+	Components  []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string `json:"timestamp"`
+}
+
+type cycloneDXComponent struct {
+	Type       string               `json:"type"`
+	BOMRef     string               `json:"bom-ref"`
+	Name       string               `json:"name"`
+	Version    string               `json:"version,omitempty"`
+	MimeType   string               `json:"mime-type,omitempty"`
+	Hashes     []cycloneDXHash      `json:"hashes"`
+	Components []cycloneDXComponent `json:"components,omitempty"`
+}
+
+type cycloneDXHash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+// checkInventoryFormat returns an error if the inventory format isn't supported
+func checkInventoryFormat(format string) error {
+	switch format {
+	case inventorySPDX, inventoryCycloneDX:
+		return nil
+	default:
+		return fmt.Errorf("invalid inventory format %q, expected one of: [spdx, cyclonedx]", format)
+	}
+}
+
+// marshalInventory serializes the inventory of the pulled images in the given format
+func marshalInventory(format string, pulled []inventoryImage, created time.Time) ([]byte, error) {
+	timestamp := created.UTC().Format(time.RFC3339)
+	switch format {
+	case inventorySPDX:
+		doc := spdxDocument{
+			SPDXVersion: "SPDX-2.3",
+			DataLicense: "CC0-1.0",
+			SPDXID:      "SPDXRef-DOCUMENT",
+			Name:        "host-ctr-inventory",
+			// The namespace only needs to be unique to the document
+			DocumentNamespace: fmt.Sprintf("https://spdx.org/spdxdocs/host-ctr-inventory-%d", created.UnixNano()),
+			CreationInfo: spdxCreationInfo{
+				Created:  timestamp,
+				Creators: []string{"Tool: host-ctr"},
+			},
+		}
+		for i, image := range pulled {
+			imageID := fmt.Sprintf("SPDXRef-Image-%d", i)
+			doc.Packages = append(doc.Packages, spdxPackage{
+				Name:             image.Ref,
+				SPDXID:           imageID,
+				Version:          image.Digest.String(),
+				DownloadLocation: "NOASSERTION",
+				Checksums:        spdxChecksums(image.Digest),
+			})
+			doc.Relationships = append(doc.Relationships, spdxRelationship{Element: "SPDXRef-DOCUMENT", Type: "DESCRIBES", Related: imageID})
+			for j, layer := range image.Layers {
+				layerID := fmt.Sprintf("SPDXRef-Image-%d-Layer-%d", i, j)
+				doc.Packages = append(doc.Packages, spdxPackage{
+					Name:             layer.Digest.String(),
+					SPDXID:           layerID,
+					DownloadLocation: "NOASSERTION",
+					Checksums:        spdxChecksums(layer.Digest),
+				})
+				doc.Relationships = append(doc.Relationships, spdxRelationship{Element: imageID, Type: "CONTAINS", Related: layerID})
+			}
+		}
+		return json.MarshalIndent(doc, "", "  ")
+	case inventoryCycloneDX:
+		doc := cycloneDXDocument{
+			BOMFormat:   "CycloneDX",
+			SpecVersion: "1.5",
+			Version:     1,
+			Metadata:    cycloneDXMetadata{Timestamp: timestamp},
+		}
+		for _, image := range pulled {
+			component := cycloneDXComponent{
+				Type:    "container",
+				BOMRef:  image.Ref + "@" + image.Digest.String(),
+				Name:    image.Ref,
+				Version: image.Digest.String(),
+				Hashes:  cycloneDXHashes(image.Digest),
+			}
+			for _, layer := range image.Layers {
+				component.Components = append(component.Components, cycloneDXComponent{
+					Type:     "data",
+					BOMRef:   layer.Digest.String(),
+					Name:     layer.Digest.String(),
+					MimeType: layer.MediaType,
+					Hashes:   cycloneDXHashes(layer.Digest),
+				})
+			}
+			doc.Components = append(doc.Components, component)
+		}
+		return json.MarshalIndent(doc, "", "  ")
+	default:
+		return nil, checkInventoryFormat(format)
+	}
+}
+
+// spdxChecksums returns the SPDX checksums for a digest, only SHA-256 digests have one
+func spdxChecksums(dgst digest.Digest) []spdxChecksum {
+	if dgst.Algorithm() != digest.SHA256 {
+		return []spdxChecksum{}
+	}
+	return []spdxChecksum{{Algorithm: "SHA256", Value: dgst.Encoded()}}
+}
+
+// cycloneDXHashes returns the CycloneDX hashes for a digest, only SHA-256 digests have one
+func cycloneDXHashes(dgst digest.Digest) []cycloneDXHash {
+	if dgst.Algorithm() != digest.SHA256 {
+		return []cycloneDXHash{}
+	}
+	return []cycloneDXHash{{Algorithm: "SHA-256", Content: dgst.Encoded()}}
+}
+
+// writeInventory writes the inventory document for the pulled image to path
+func writeInventory(ctx context.Context, client *containerd.Client, img containerd.Image, matcher platforms.MatchComparer, format string, path string) error {
+	manifest, err := images.Manifest(ctx, client.ContentStore(), img.Target(), matcher)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read manifest for %s", img.Name())
+	}
+	raw, err := marshalInventory(format, []inventoryImage{{
+		Ref:    img.Name(),
+		Digest: img.Target().Digest,
+		Layers: manifest.Layers,
+	}}, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.Wrapf(checkReadOnly(path, err), "failed to create directory for inventory %s", path)
+	}
+	return errors.Wrapf(writeFileAtomic(path, raw, 0o644), "failed to write inventory %s", path)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func testInventoryImage() inventoryImage {
+	return inventoryImage{
+		Ref:    "registry.example.com/bottlerocket/container:latest",
+		Digest: digest.FromString("manifest"),
+		Layers: []ocispec.Descriptor{
+			{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString("layer 1")},
+			{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString("layer 2")},
+		},
+	}
+}
+
+func TestSPDXInventory(t *testing.T) {
+	image := testInventoryImage()
+	raw, err := marshalInventory(inventorySPDX, []inventoryImage{image}, time.Unix(0, 0))
+	assert.NoError(t, err)
+
+	var doc spdxDocument
+	assert.NoError(t, json.Unmarshal(raw, &doc))
+	assert.Equal(t, "SPDX-2.3", doc.SPDXVersion)
+	assert.Equal(t, "1970-01-01T00:00:00Z", doc.CreationInfo.Created)
+	if assert.Len(t, doc.Packages, 3) {
+		assert.Equal(t, image.Ref, doc.Packages[0].Name)
+		assert.Equal(t, image.Digest.String(), doc.Packages[0].Version)
+		assert.Equal(t, []spdxChecksum{{Algorithm: "SHA256", Value: image.Digest.Encoded()}}, doc.Packages[0].Checksums)
+		assert.Equal(t, image.Layers[0].Digest.String(), doc.Packages[1].Name)
+		assert.Equal(t, image.Layers[1].Digest.String(), doc.Packages[2].Name)
+	}
+	assert.Equal(t, []spdxRelationship{
+		{Element: "SPDXRef-DOCUMENT", Type: "DESCRIBES", Related: "SPDXRef-Image-0"},
+		{Element: "SPDXRef-Image-0", Type: "CONTAINS", Related: "SPDXRef-Image-0-Layer-0"},
+		{Element: "SPDXRef-Image-0", Type: "CONTAINS", Related: "SPDXRef-Image-0-Layer-1"},
+	}, doc.Relationships)
+}
+
+func TestCycloneDXInventory(t *testing.T) {
+	image := testInventoryImage()
+	raw, err := marshalInventory(inventoryCycloneDX, []inventoryImage{image}, time.Unix(0, 0))
+	assert.NoError(t, err)
+
+	var doc cycloneDXDocument
+	assert.NoError(t, json.Unmarshal(raw, &doc))
+	assert.Equal(t, "CycloneDX", doc.BOMFormat)
+	if assert.Len(t, doc.Components, 1) {
+		component := doc.Components[0]
+		assert.Equal(t, "container", component.Type)
+		assert.Equal(t, image.Ref, component.Name)
+		assert.Equal(t, []cycloneDXHash{{Algorithm: "SHA-256", Content: image.Digest.Encoded()}}, component.Hashes)
+		if assert.Len(t, component.Components, 2) {
+			assert.Equal(t, image.Layers[0].Digest.String(), component.Components[0].Name)
+			assert.Equal(t, ocispec.MediaTypeImageLayerGzip, component.Components[0].MimeType)
+		}
+	}
+}
+
+func TestInventoryFormat(t *testing.T) {
+	assert.NoError(t, checkInventoryFormat("spdx"))
+	assert.NoError(t, checkInventoryFormat("cyclonedx"))
+	assert.Error(t, checkInventoryFormat("csv"))
+	_, err := marshalInventory("csv", nil, time.Now())
+	assert.Error(t, err)
+}
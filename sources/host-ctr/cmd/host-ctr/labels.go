@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// checkLabelPrefixes returns an error naming the label keys that don't start with one of the
+// allowed prefixes. Any label key is allowed if there are no allowed prefixes.
+func checkLabelPrefixes(labels map[string]string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	var rejected []string
+	for key := range labels {
+		if !hasAllowedPrefix(key, allowed) {
+			rejected = append(rejected, key)
+		}
+	}
+	if len(rejected) == 0 {
+		return nil
+	}
+	sort.Strings(rejected)
+	return fmt.Errorf("label keys %q don't start with an allowed prefix %q", rejected, allowed)
+}
+
+// hasAllowedPrefix returns whether key starts with one of the non-empty allowed prefixes
+func hasAllowedPrefix(key string, allowed []string) bool {
+	for _, prefix := range allowed {
+		if prefix != "" && strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
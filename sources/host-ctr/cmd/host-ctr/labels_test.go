@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLabelPrefixes(t *testing.T) {
+	allowed := []string{"io.cri-containerd.", "io.bottlerocket."}
+	tests := []struct {
+		name        string
+		labels      []string
+		allowed     []string
+		expectedErr string
+	}{
+		{
+			"No allowed prefixes",
+			[]string{"com.example.injected=1"},
+			nil,
+			"",
+		},
+		{
+			"Allowed keys",
+			[]string{"io.cri-containerd.pinned=pinned", "io.bottlerocket.owner=admin"},
+			allowed,
+			"",
+		},
+		{
+			"No labels",
+			nil,
+			allowed,
+			"",
+		},
+		{
+			"Rejected key",
+			[]string{"io.cri-containerd.pinned=pinned", "com.example.injected=1"},
+			allowed,
+			`label keys ["com.example.injected"] don't start with an allowed prefix ["io.cri-containerd." "io.bottlerocket."]`,
+		},
+		{
+			"Rejected keys are sorted",
+			[]string{"zzz=1", "io.bottlerocket=1", "aaa"},
+			allowed,
+			`label keys ["aaa" "io.bottlerocket" "zzz"] don't start with an allowed prefix ["io.cri-containerd." "io.bottlerocket."]`,
+		},
+		{
+			"Empty prefix allows nothing",
+			[]string{"io.bottlerocket.owner=admin"},
+			[]string{""},
+			`label keys ["io.bottlerocket.owner"] don't start with an allowed prefix [""]`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			labels, err := convertLabels(tc.labels, false)
+			assert.NoError(t, err)
+			err = checkLabelPrefixes(labels, tc.allowed)
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
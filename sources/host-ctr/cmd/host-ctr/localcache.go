@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// The local content store blobs are cached in across invocations, set up from the command line
+var localCache content.Store
+
+// openLocalCache opens the local cache in dir, laid out as a local content store with blobs by
+// digest
+func openLocalCache(dir string) (content.Store, error) {
+	store, err := local.NewStore(dir)
+	if err != nil {
+		return nil, errors.Wrapf(checkReadOnly(dir, err), "failed to open local cache %s", dir)
+	}
+	return store, nil
+}
+
+// localCacheResolver returns fetchers that read blobs through the local cache
+type localCacheResolver struct {
+	remotes.Resolver
+	cache content.Store
+}
+
+func (r localCacheResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := r.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return localCacheFetcher{fetcher, r.cache}, nil
+}
+
+// localCacheFetcher serves blobs from the local cache if they're in it, and otherwise fetches
+// them with the wrapped fetcher and writes them back to the cache as they're read
+type localCacheFetcher struct {
+	remotes.Fetcher
+	cache content.Store
+}
+
+func (f localCacheFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	if ra, err := f.cache.ReaderAt(ctx, desc); err == nil {
+		log.G(ctx).WithField("digest", desc.Digest).Debug("serving blob from local cache")
+		return struct {
+			io.Reader
+			io.Closer
+		}{content.NewReader(ra), ra}, nil
+	}
+	rc, err := f.Fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	ref := "host-ctr-cache-" + desc.Digest.String()
+	w, err := f.cache.Writer(ctx, content.WithRef(ref), content.WithDescriptor(desc))
+	if err != nil {
+		// Another invocation may be caching the same blob
+		log.G(ctx).WithError(err).WithField("digest", desc.Digest).Debug("not writing blob to local cache")
+		return rc, nil
+	}
+	return &cacheWriteBack{ReadCloser: rc, ctx: ctx, cache: f.cache, writer: w, ref: ref, desc: desc}, nil
+}
+
+// cacheWriteBack copies the blob read from the wrapped reader to the local cache, committing it
+// once the whole blob is read. The cache verifies the blob's size and digest on commit.
+type cacheWriteBack struct {
+	io.ReadCloser
+	ctx    context.Context
+	cache  content.Store
+	writer content.Writer
+	ref    string
+	desc   ocispec.Descriptor
+}
+
+func (c *cacheWriteBack) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if c.writer == nil {
+		return n, err
+	}
+	if n > 0 {
+		if _, writeErr := c.writer.Write(p[:n]); writeErr != nil {
+			log.G(c.ctx).WithError(writeErr).WithField("digest", c.desc.Digest).Warn("failed to write blob to local cache")
+			c.abort()
+			return n, err
+		}
+	}
+	if err == io.EOF {
+		commitErr := c.writer.Commit(c.ctx, c.desc.Size, c.desc.Digest)
+		if commitErr != nil && !errdefs.IsAlreadyExists(commitErr) {
+			log.G(c.ctx).WithError(commitErr).WithField("digest", c.desc.Digest).Warn("failed to write blob to local cache")
+			c.abort()
+			return n, err
+		}
+		c.writer.Close()
+		c.writer = nil
+	}
+	return n, err
+}
+
+func (c *cacheWriteBack) Close() error {
+	// Blobs that weren't read completely aren't cached
+	if c.writer != nil {
+		c.abort()
+	}
+	return c.ReadCloser.Close()
+}
+
+// abort discards the partially cached blob
+func (c *cacheWriteBack) abort() {
+	c.writer.Close()
+	c.writer = nil
+	if err := c.cache.Abort(c.ctx, c.ref); err != nil && !errdefs.IsNotFound(err) {
+		log.G(c.ctx).WithError(err).WithField("ref", c.ref).Debug("failed to discard partially cached blob")
+	}
+}
+
+// withLocalCache wraps the resolver set up by opt so that blobs are read through cache. Nothing
+// is cached if cache is nil.
+func withLocalCache(opt containerd.RemoteOpt, cache content.Store) containerd.RemoteOpt {
+	return func(client *containerd.Client, c *containerd.RemoteContext) error {
+		if err := opt(client, c); err != nil {
+			return err
+		}
+		if cache != nil {
+			c.Resolver = localCacheResolver{c.Resolver, cache}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalCache(t *testing.T) {
+	ctx := context.Background()
+	registry := newFakeRegistry(t)
+	cached := registry.addBlob(ocispec.MediaTypeImageLayer, []byte("cached layer"))
+	missing := registry.addBlob(ocispec.MediaTypeImageLayer, []byte("missing layer"))
+	partial := registry.addBlob(ocispec.MediaTypeImageLayer, []byte("partially read layer"))
+
+	cache, err := openLocalCache(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, content.WriteBlob(ctx, cache, "cached", bytes.NewReader([]byte("cached layer")), cached))
+	resolver := localCacheResolver{registry.resolver(), cache}
+	fetcher, err := resolver.Fetcher(ctx, "example.com/bottlerocket/admin:latest")
+	require.NoError(t, err)
+
+	fetch := func(desc ocispec.Descriptor, n int64) []byte {
+		rc, err := fetcher.Fetch(ctx, desc)
+		require.NoError(t, err)
+		defer rc.Close()
+		raw, err := io.ReadAll(io.LimitReader(rc, n))
+		require.NoError(t, err)
+		return raw
+	}
+
+	t.Run("Cached blob is served locally", func(t *testing.T) {
+		assert.Equal(t, []byte("cached layer"), fetch(cached, cached.Size))
+		assert.False(t, registry.fetched(cached.Digest))
+	})
+	t.Run("Missing blob is fetched and cached", func(t *testing.T) {
+		assert.Equal(t, []byte("missing layer"), fetch(missing, missing.Size+1))
+		assert.True(t, registry.fetched(missing.Digest))
+		stored, err := content.ReadBlob(ctx, cache, missing)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("missing layer"), stored)
+	})
+	t.Run("Partially read blob isn't cached", func(t *testing.T) {
+		assert.Equal(t, []byte("partially"), fetch(partial, 9))
+		_, err := cache.Info(ctx, partial.Digest)
+		assert.Error(t, err)
+		// The blob can be cached once it's read completely
+		assert.Equal(t, []byte("partially read layer"), fetch(partial, partial.Size+1))
+		_, err = cache.Info(ctx, partial.Digest)
+		assert.NoError(t, err)
+	})
+}
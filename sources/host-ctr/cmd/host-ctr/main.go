@@ -6,27 +6,38 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
+	ecrsdk "github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/aws/aws-sdk-go/service/ecrpublic"
 	"github.com/awslabs/amazon-ecr-containerd-resolver/ecr"
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/cio"
 	"github.com/containerd/containerd/containers"
 	"github.com/containerd/containerd/contrib/seccomp"
+	"github.com/containerd/containerd/events"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes"
 	"github.com/containerd/containerd/remotes/docker"
 	"github.com/containerd/containerd/runtime/v2/runc/options"
 	"github.com/containerd/errdefs"
 	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
 	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -41,6 +52,9 @@ import (
 // Example 4: 777777777777.dkr.ecr-fips.us-west-2.amazonaws.com/my_image:latest
 var ecrRegex = regexp.MustCompile(`(^[a-zA-Z0-9][a-zA-Z0-9-_]*)\.dkr\.ecr(-fips)?\.([a-zA-Z0-9][a-zA-Z0-9-_]*)\.(amazonaws\.com(\.cn)?|cloud\.adc-e\.uk).*`)
 
+// Expecting to match AWS partitions, e.g. `aws`, `aws-cn`, `aws-us-gov` or `aws-iso-b`
+var ecrPartitionRegex = regexp.MustCompile(`^aws(-[a-z]+)*$`)
+
 // A set of currently supported ECR regions which are not yet present in the golang SDK
 var ecrRefPrefixMapping = map[string]string{
 	"ap-southeast-7": "ecr.aws/arn:aws:ecr:ap-southeast-7:",
@@ -61,13 +75,20 @@ var fipsSupportedEcrRegionSet = map[string]bool{
 const (
 	// The maximum size of an	image label.
 	imageLabelMaxSize = 4096
+	// The tag used for image URIs that don't specify a tag or digest
+	defaultImageTag = "latest"
 )
 
+// errMissingImageTag is returned for image URIs without a tag or digest when one is required
+var errMissingImageTag = errors.New("image URI has no tag or digest")
+
 func init() {
 	rand.New(rand.NewSource(time.Now().UnixNano()))
 	// Dispatch logging output instead of writing all levels' messages to
 	// stderr.
 	log.L.Logger.SetOutput(io.Discard)
+	// Redact credentials before any of the hooks below write the entry.
+	log.L.Logger.AddHook(&LogSanitizeHook{})
 	log.L.Logger.AddHook(&LogSplitHook{os.Stdout, []logrus.Level{
 		logrus.WarnLevel, logrus.InfoLevel, logrus.DebugLevel, logrus.TraceLevel}})
 	log.L.Logger.AddHook(&LogSplitHook{os.Stderr, []logrus.Level{
@@ -93,8 +114,126 @@ func App() *cli.App {
 		registryConfig   string
 		cType            string
 		useCachedImage   bool
+		platform         string
+		configOnly       bool
+		requireECRTag    bool
+		acceptLanguage   string
+		accept           string
+		verifyMirror     bool
+		noUnpack         bool
+		aliasConfig      string
+		imageDefaults    bool
+		containerdRoot   string
+		registryDNS      string
+		retryJitterFlag  string
+		fetchReferrers   bool
+		ecrPartition     string
+		imageKeyring     string
+		disableHTTP2     bool
+		keepAlive        time.Duration
+		ignorePlatform   bool
+		contentStore     string
+		contentStoreBase string
+		allowTagMutation bool
+		inventoryFile    string
+		inventoryFormat  string
+		anonFallback     bool
+		checkWhiteouts   bool
+		skewTolerance    time.Duration
+		minTLSVersion    string
+		wildcardFallback bool
+		checkXattrs      bool
+		retryMaxElapsed  time.Duration
+		refreshInterval  time.Duration
+		swapOnUpdate     bool
+		expectEntrypoint string
+		allPlatforms     bool
+		scanCommand      string
+		auditLogPath     string
+		maxManifestSize  int64
+		localCacheDir    string
+		maxVulnSeverity  string
+		maxConnsPerPull  int
+		traceRequests    bool
+		insecureImage    string
+		checkTotalSize   bool
+		digestFallback   string
+		cpuQuota         int64
+		cpuShares        uint64
+		memoryLimit      uint64
+		certIdentity     string
+		certOIDCIssuer   string
+		mirrorStale      string
+		secretARN        string
+		interactive      bool
+		resolveCacheFile string
+		manifestCacheTTL time.Duration
+		cpuFeatures      bool
+		logWarnings      bool
+		minContainerd    string
+		pickFastest      bool
+		inheritLabels    bool
+		showSpec         bool
+		dryRunSpec       bool
+		maxIdleConns     int
+		anonFirst        bool
+		ipFamily         string
+		minHealthy       int
+		emitEvents       bool
+		strictLabels     bool
+		rateLimit        float64
 	)
 
+	// newPullOptions builds the pull options shared by every way of pulling an image from the
+	// flags, so that pulls made by different commands behave the same
+	newPullOptions := func(c *cli.Context) (pullOptions, error) {
+		ecrEndpoints, err := parseECREndpoints(c.StringSlice("ecr-endpoint"))
+		if err != nil {
+			return pullOptions{}, err
+		}
+		jitter, err := parseRetryJitter(retryJitterFlag)
+		if err != nil {
+			return pullOptions{}, err
+		}
+		fallback, err := parseDigestFallback(digestFallback)
+		if err != nil {
+			return pullOptions{}, err
+		}
+		baggage, err := convertBaggage(c.StringSlice("context-baggage"))
+		if err != nil {
+			return pullOptions{}, err
+		}
+		return pullOptions{
+			registryConfigPath: registryConfig,
+			retryJitter:        jitter,
+			retrySubstrings:    c.StringSlice("retry-error-substrings"),
+			retryMaxElapsed:    retryMaxElapsed,
+			useCachedImage:     useCachedImage,
+			platform:           platform,
+			requireECRTag:      requireECRTag,
+			ecrPartition:       ecrPartition,
+			ecrEndpoints:       ecrEndpoints,
+			manifestTypes:      c.StringSlice("allowed-manifest-types"),
+			traceRequests:      traceRequests,
+			acceptLanguage:     acceptLanguage,
+			accept:             accept,
+			verifyMirrorDigest: verifyMirror,
+			mirrorStaleAction:  mirrorStale,
+			allowTagMutation:   allowTagMutation,
+			inventoryFile:      inventoryFile,
+			inventoryFormat:    inventoryFormat,
+			validateWhiteouts:  checkWhiteouts,
+			validateXattrs:     checkXattrs,
+			verifyTotalSize:    checkTotalSize,
+			digestFallback:     fallback,
+			clockSkewTolerance: skewTolerance,
+			baggage:            baggage,
+			imageKeyring:       imageKeyring,
+			certIdentity:       certIdentity,
+			certOIDCIssuer:     certOIDCIssuer,
+		}, nil
+	}
+
 	app := cli.NewApp()
 	app.Name = "host-ctr"
 	app.Usage = "manage host containers"
@@ -115,6 +254,335 @@ func App() *cli.App {
 			Value:       "default",
 			Destination: &namespace,
 		},
+		&cli.StringFlag{
+			Name:        "platform",
+			Usage:       "the platform to pull images for, e.g. `linux/arm64`; defaults to the registry mirror's default platform or the host's platform",
+			Destination: &platform,
+		},
+		&cli.StringFlag{
+			Name:        "accept-language",
+			Usage:       "the `Accept-Language` header to send with registry requests, e.g. `en-US`",
+			Destination: &acceptLanguage,
+		},
+		&cli.StringFlag{
+			Name:        "accept",
+			Usage:       "the `Accept` header to send verbatim when resolving images, e.g. the media type of an OCI artifact manifest; by default every image manifest and index type is accepted",
+			Destination: &accept,
+		},
+		&cli.BoolFlag{
+			Name:        "verify-mirror-digest",
+			Usage:       "rejects registry mirrors that resolve images to a different digest than the upstream registry",
+			Destination: &verifyMirror,
+			Value:       false,
+		},
+		&cli.StringFlag{
+			Name:        "mirror-stale-action",
+			Usage:       "what to do when --verify-mirror-digest finds a mirror serving stale content, one of: [fail, warn]; either way a `mirror_stale` event is logged",
+			Destination: &mirrorStale,
+			Value:       mirrorStaleFail,
+		},
+		&cli.StringFlag{
+			Name:        "min-containerd-version",
+			Usage:       "the oldest containerd version to run against, checked with the snapshotter's availability at startup; empty skips the checks",
+			Destination: &minContainerd,
+			Value:       defaultMinContainerdVersion,
+		},
+		&cli.StringFlag{
+			Name:        "containerd-root",
+			Usage:       "the root directory of containerd, checked for storage problems before pulling images",
+			Value:       defaultContainerdRoot,
+			Destination: &containerdRoot,
+		},
+		&cli.StringFlag{
+			Name:        "registry-dns",
+			Usage:       "the `IP` address of a DNS server to resolve registry hosts with instead of the system resolver",
+			Destination: &registryDNS,
+		},
+		&cli.StringSliceFlag{
+			Name:  "registry-host-ip",
+			Usage: "connects to a registry host at a fixed IP address instead of resolving it, in `host:ip` format",
+		},
+		&cli.DurationFlag{
+			Name:        "registry-keepalive",
+			Usage:       "the `interval` between TCP keep-alive probes on registry connections, negative to disable them",
+			Value:       defaultRegistryKeepAlive,
+			Destination: &keepAlive,
+		},
+		&cli.StringFlag{
+			Name:        "ip-family",
+			Usage:       "the IP family of registry connections, one of: [ipv4, ipv6, auto]; `auto` tries both, preferring the first address the registry host resolves to",
+			Value:       "auto",
+			Destination: &ipFamily,
+		},
+		&cli.StringFlag{
+			Name:        "retry-jitter",
+			Usage:       "how to randomize the delay between image pull retries, one of: [additive, full, equal, none]; `full` spreads out retries across large fleets the most",
+			Value:       string(additiveJitter),
+			Destination: &retryJitterFlag,
+		},
+		&cli.DurationFlag{
+			Name:        "retry-max-elapsed",
+			Usage:       "the total time to spend retrying an image pull, regardless of how many attempts are left; 0 leaves it unbounded",
+			Destination: &retryMaxElapsed,
+		},
+		&cli.StringSliceFlag{
+			Name:  "retry-error-substrings",
+			Usage: "gives pulls that fail with an error or registry response body containing one of these substrings extra retries on top of the default ones",
+		},
+		&cli.StringFlag{
+			Name:        "secret-arn",
+			Usage:       "the ARN of a Secrets Manager secret holding registry credentials keyed like the registry config's `creds`, fetched with the instance role",
+			Destination: &secretARN,
+		},
+		&cli.StringFlag{
+			Name:        "image-keyring",
+			Usage:       "path to a keyring of PEM encoded public keys; pulled and cached images must have a cosign signature by one of the keys. A keyring with CA certificates for keyless signatures requires --cert-identity or --cert-oidc-issuer",
+			Destination: &imageKeyring,
+		},
+		&cli.StringFlag{
+			Name:        "cert-identity",
+			Usage:       "the email or URI identity keyless image signatures must have been issued to; requires --image-keyring with the CAs of the signing certificates and of the timestamp authority that timestamps the signatures",
+			Destination: &certIdentity,
+		},
+		&cli.StringFlag{
+			Name:        "cert-oidc-issuer",
+			Usage:       "the OIDC issuer keyless image signatures must have been issued by; requires --image-keyring",
+			Destination: &certOIDCIssuer,
+		},
+		&cli.BoolFlag{
+			Name:        "disable-http2",
+			Usage:       "limits registry connections to HTTP/1.1, for proxies that mishandle HTTP/2",
+			Destination: &disableHTTP2,
+			Value:       false,
+		},
+		&cli.StringFlag{
+			Name:        "inventory-file",
+			Usage:       "path to write an inventory document of the pulled image and its layers to",
+			Destination: &inventoryFile,
+		},
+		&cli.StringFlag{
+			Name:        "inventory-format",
+			Usage:       "the format of the inventory document, one of: [spdx, cyclonedx]",
+			Value:       inventorySPDX,
+			Destination: &inventoryFormat,
+		},
+		&cli.BoolFlag{
+			Name:        "anonymous-fallback",
+			Usage:       "retries registries anonymously when authenticating with the configured credentials fails",
+			Destination: &anonFallback,
+			Value:       false,
+		},
+		&cli.BoolFlag{
+			Name:        "anonymous-token-first",
+			Usage:       "authorizes with an anonymous token first, authenticating with the configured credentials once the registry challenges the anonymous token with insufficient_scope",
+			Destination: &anonFirst,
+			Value:       false,
+		},
+		&cli.BoolFlag{
+			Name:        "validate-whiteouts",
+			Usage:       "checks that files deleted by an image's layers are absent once the image is unpacked",
+			Destination: &checkWhiteouts,
+			Value:       false,
+		},
+		&cli.BoolFlag{
+			Name:        "validate-xattrs",
+			Usage:       "checks that extended attributes of an image's files, such as file capabilities, are preserved once the image is unpacked",
+			Destination: &checkXattrs,
+			Value:       false,
+		},
+		&cli.BoolFlag{
+			Name:        "verify-total-size",
+			Usage:       "checks that the total size of a pulled image's content matches the sizes its manifest declares",
+			Destination: &checkTotalSize,
+			Value:       false,
+		},
+		&cli.DurationFlag{
+			Name:        "clock-skew-tolerance",
+			Usage:       "how far the system clock may differ from a registry's before authentication failures are blamed on it",
+			Value:       defaultClockSkewTolerance,
+			Destination: &skewTolerance,
+		},
+		&cli.StringFlag{
+			Name:        "min-tls-version",
+			Usage:       "the minimum TLS version of registry connections, one of: [1.0, 1.1, 1.2, 1.3]; mirrors may override it with min_tls_version",
+			Destination: &minTLSVersion,
+		},
+		&cli.StringSliceFlag{
+			Name:  "context-baggage",
+			Usage: "baggage to propagate to registries in the W3C baggage header, in `key=value` format",
+		},
+		&cli.StringSliceFlag{
+			Name:  "ecr-endpoint",
+			Usage: "overrides the ECR API endpoint of a region, in `region=URL` format; the URL may use http to reach ECR through an internal gateway",
+		},
+		&cli.IntFlag{
+			Name:        "min-healthy-mirrors",
+			Usage:       "fails pulls before they start unless at least this many of the registry config's mirrors for the image respond to a health probe; 0 skips the probe",
+			Destination: &minHealthy,
+			Value:       0,
+		},
+		&cli.BoolFlag{
+			Name:        "pick-fastest-mirror",
+			Usage:       "probes the latency of registry mirrors before pulling and tries the fastest first; the upstream registry is still tried last",
+			Destination: &pickFastest,
+			Value:       false,
+		},
+		&cli.BoolFlag{
+			Name:        "wildcard-as-fallback",
+			Usage:       "tries the endpoints of the '*' mirror after those of a more specific mirror, before the upstream registry",
+			Destination: &wildcardFallback,
+			Value:       false,
+		},
+		&cli.StringFlag{
+			Name:        "alias-config",
+			Usage:       "path to a configuration mapping image aliases to image references",
+			Destination: &aliasConfig,
+		},
+		&cli.StringFlag{
+			Name:        "audit-log",
+			Usage:       "path to a file to append a JSON audit event to for each image pull and container create, start, and stop",
+			Destination: &auditLogPath,
+		},
+		&cli.BoolFlag{
+			Name:        "strict-labels",
+			Usage:       "rejects empty container and image labels instead of skipping them",
+			Destination: &strictLabels,
+			Value:       false,
+		},
+		&cli.StringSliceFlag{
+			Name:  "allowed-label-prefixes",
+			Usage: "rejects container and image labels unless their keys start with one of these prefixes, such as io.bottlerocket.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "allowed-manifest-types",
+			Usage: "fails pulls of manifests whose artifact type, or config media type if they have none, isn't one of these, such as application/vnd.oci.image.config.v1+json",
+		},
+		&cli.StringFlag{
+			Name:        "local-cache-dir",
+			Usage:       "reads image blobs from this content-addressed directory, shared across invocations, before fetching them from registries, and writes fetched blobs to it",
+			Destination: &localCacheDir,
+		},
+		&cli.StringFlag{
+			Name:        "manifest-cache-file",
+			Usage:       "records the manifest each image was resolved to in this file, and pulls the recorded manifest when the registry fails with a 5xx error",
+			Destination: &resolveCacheFile,
+		},
+		&cli.DurationFlag{
+			Name:        "manifest-cache-ttl",
+			Usage:       "how long after an image was resolved its recorded manifest may be pulled when the registry fails",
+			Destination: &manifestCacheTTL,
+			Value:       24 * time.Hour,
+		},
+		&cli.StringFlag{
+			Name:        "bootstrap-insecure",
+			Usage:       "pulls only this image, exactly as given to --source, without verifying TLS certificates and over plain HTTP if the registry doesn't speak TLS; for bootstrapping hosts without certificates",
+			Destination: &insecureImage,
+		},
+		&cli.BoolFlag{
+			Name:        "emit-events",
+			Usage:       "publishes pull-started, pull-completed and pull-failed events for image pulls to containerd's event exchange, under the /host-ctr/pull/ topics",
+			Destination: &emitEvents,
+			Value:       false,
+		},
+		&cli.BoolFlag{
+			Name:        "log-registry-warnings",
+			Usage:       "logs the Warning headers of registry responses, such as deprecation notices",
+			Destination: &logWarnings,
+			Value:       false,
+		},
+		&cli.BoolFlag{
+			Name:        "trace-requests",
+			Usage:       "times the DNS lookup, connection, TLS handshake, and first response byte of registry requests, reporting the totals with the pull",
+			Destination: &traceRequests,
+			Value:       false,
+		},
+		&cli.IntFlag{
+			Name:        "max-connections-per-pull",
+			Usage:       "the maximum number of connections a pull opens to each registry endpoint at once, independent of how many layers are fetched at once; 0 for no limit",
+			Destination: &maxConnsPerPull,
+			Value:       0,
+		},
+		&cli.IntFlag{
+			Name:        "max-idle-connections-per-host",
+			Usage:       "the maximum number of idle connections kept open to each registry host for reuse by later requests and pulls; 0 for Go's default of 2",
+			Destination: &maxIdleConns,
+			Value:       0,
+		},
+		&cli.Float64Flag{
+			Name:        "registry-rate-limit",
+			Usage:       "the maximum number of requests per second sent to each registry host, shared by every pull host-ctr runs at once; 0 for no limit",
+			Destination: &rateLimit,
+			Value:       0,
+		},
+		&cli.Int64Flag{
+			Name:        "max-manifest-size",
+			Usage:       "fails pulls of image manifests and indexes larger than this many bytes, counting the bytes received even if registries don't send their size; 0 for no limit",
+			Destination: &maxManifestSize,
+			Value:       0,
+		},
+	}
+
+	app.Before = func(c *cli.Context) error {
+		if (certIdentity != "" || certOIDCIssuer != "") && imageKeyring == "" {
+			return errors.New("--cert-identity and --cert-oidc-issuer require --image-keyring")
+		}
+		if inventoryFile != "" {
+			if err := checkInventoryFormat(inventoryFormat); err != nil {
+				return err
+			}
+		}
+		if err := checkMirrorStaleAction(mirrorStale); err != nil {
+			return err
+		}
+		dialer, err := newRegistryDialer(registryDNS, c.StringSlice("registry-host-ip"), keepAlive, ipFamily)
+		if err != nil {
+			return err
+		}
+		defaultRegistryDialer = dialer
+		registryHTTP2Disabled = disableHTTP2
+		registryAnonymousFallback = anonFallback
+		registryAnonymousFirst = anonFirst
+		registryWildcardFallback = wildcardFallback
+		registryPickFastest = pickFastest
+		registryMinHealthyMirrors = minHealthy
+		emitPullEvents = emitEvents
+		registryMaxManifestSize = maxManifestSize
+		registryMaxConns = maxConnsPerPull
+		registryMaxIdleConns = maxIdleConns
+		if rateLimit < 0 {
+			return errors.New("--registry-rate-limit can't be negative")
+		}
+		registryRateLimit = rateLimit
+		bootstrapInsecureImage = insecureImage
+		registryLogWarnings = logWarnings
+		minContainerdVersion = minContainerd
+		if minContainerdVersion != "" {
+			if _, err := parseContainerdVersion(minContainerdVersion); err != nil {
+				return errors.Wrap(err, "invalid --min-containerd-version")
+			}
+		}
+		if secretARN != "" {
+			if registrySecret, err = newSecretCredentials(secretARN); err != nil {
+				return err
+			}
+		}
+		if resolveCacheFile != "" {
+			registryManifestCache = newManifestCache(resolveCacheFile, manifestCacheTTL)
+		}
+		if localCacheDir != "" {
+			if localCache, err = openLocalCache(localCacheDir); err != nil {
+				return err
+			}
+		}
+		if registryMinTLSVersion, err = parseTLSVersion(minTLSVersion); err != nil {
+			return err
+		}
+		if auditLogPath != "" {
+			if auditLog, err = openAuditLog(auditLogPath); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 
 	// Subcommands
@@ -158,9 +626,208 @@ func App() *cli.App {
 					Destination: &useCachedImage,
 					Value:       false,
 				},
+				&cli.BoolFlag{
+					Name:        "require-ecr-tag",
+					Usage:       "fails instead of defaulting to the `latest` tag when an ECR image URI has no tag or digest",
+					Destination: &requireECRTag,
+					Value:       false,
+				},
+				&cli.StringFlag{
+					Name:        "digest-fallback",
+					Usage:       "pulls the image by this digest, such as sha256:..., if its tag no longer resolves",
+					Destination: &digestFallback,
+				},
+				&cli.BoolFlag{
+					Name:        "allow-tag-mutation",
+					Usage:       "pulls image tags that now point at a different image than the one already pulled; set to false to refuse them",
+					Destination: &allowTagMutation,
+					Value:       true,
+				},
+				&cli.StringFlag{
+					Name:        "ecr-partition",
+					Usage:       "the AWS `partition` of the ECR repository, when it differs from the partition of the region in the image URI",
+					Destination: &ecrPartition,
+				},
+				&cli.StringSliceFlag{
+					Name:  "label",
+					Usage: "label to add to the container in `key=value` format",
+				},
+				&cli.StringSliceFlag{
+					Name:  "mount",
+					Usage: "bind mount to add to the container in `source:destination[:options]` format",
+				},
+				&cli.BoolFlag{
+					Name:        "inherit-image-labels",
+					Usage:       "copies the image config labels onto the container; labels from --label replace image labels with the same key",
+					Destination: &inheritLabels,
+					Value:       false,
+				},
+				&cli.BoolFlag{
+					Name:        "image-defaults",
+					Usage:       "applies the default labels and mounts embedded in the image, with --label and --mount taking precedence",
+					Destination: &imageDefaults,
+					Value:       false,
+				},
+				&cli.StringSliceFlag{
+					Name:  "image-default-mount-sources",
+					Usage: "the host paths the mounts from --image-defaults may bind; image mounts must be read-only bind mounts of one of these paths, and any other image mount is rejected",
+				},
+				&cli.BoolFlag{
+					Name:        "ignore-platform-mismatch",
+					Usage:       "runs the image even if its config declares a different platform than the one pulled",
+					Destination: &ignorePlatform,
+					Value:       false,
+				},
+				&cli.DurationFlag{
+					Name:        "refresh-interval",
+					Usage:       "pulls the image again every `interval` while the container runs, to keep mutable tags current; 0 disables refreshes. Can't be combined with --allow-tag-mutation=false",
+					Destination: &refreshInterval,
+				},
+				&cli.BoolFlag{
+					Name:        "swap-on-update",
+					Usage:       "recreates the container when --refresh-interval pulls a new image, rolling back to the previous image if the new container fails to start",
+					Destination: &swapOnUpdate,
+					Value:       false,
+				},
+				&cli.BoolFlag{
+					Name:        "check-cpu-features",
+					Usage:       "refuses to run images whose manifest declares CPU features the host CPU doesn't have",
+					Destination: &cpuFeatures,
+					Value:       false,
+				},
+				&cli.BoolFlag{
+					Name:        "print-spec",
+					Usage:       "prints the container's OCI runtime spec as JSON before creating its task",
+					Destination: &showSpec,
+					Value:       false,
+				},
+				&cli.BoolFlag{
+					Name:        "dry-run-spec",
+					Usage:       "prints the OCI runtime spec the container would be created with as JSON, without creating the container",
+					Destination: &dryRunSpec,
+					Value:       false,
+				},
+				&cli.BoolFlag{
+					Name:        "interactive",
+					Aliases:     []string{"stdin"},
+					Usage:       "attaches host-ctr's stdin to the container's process, so operators can provide input to it. On by default; --interactive=false gives the process no stdin, so its reads from stdin see end of file, while its output still goes to host-ctr's stdout and stderr",
+					Destination: &interactive,
+					Value:       true,
+				},
+				&cli.StringFlag{
+					Name:        "expect-entrypoint",
+					Usage:       "refuses to run the image unless its entrypoint followed by its cmd is this JSON array, such as [\"/usr/bin/start\"]",
+					Destination: &expectEntrypoint,
+				},
+				&cli.BoolFlag{
+					Name:        "all-platforms",
+					Usage:       "runs a container for each platform of the image, with the architecture appended to the container ID, such as id-arm64",
+					Destination: &allPlatforms,
+					Value:       false,
+				},
+				&cli.StringSliceFlag{
+					Name:  "shutdown-order",
+					Usage: "with --all-platforms, the containers' `platform[=grace-period]` in dependency order, such as linux/amd64=30s; on SIGTERM they are stopped in reverse order, each once the previous one exited, after any unlisted platforms",
+				},
+				&cli.StringFlag{
+					Name:        "scan-cmd",
+					Usage:       "scans the image before creating the container by running this command with the path of a read-only mount of the image as its last argument; the container isn't started if it exits with a non-zero status",
+					Destination: &scanCommand,
+				},
+				&cli.StringFlag{
+					Name:        "max-vuln-severity",
+					Usage:       "refuses to run images whose embedded vulnerability summary lists vulnerabilities more severe than this, one of: [low, medium, high, critical]",
+					Destination: &maxVulnSeverity,
+				},
+				&cli.Int64Flag{
+					Name:        "cpu-quota",
+					Usage:       "microseconds of CPU time the container may use every 100ms, such as 50000 for half a CPU; 0 for no limit",
+					Destination: &cpuQuota,
+					Value:       0,
+				},
+				&cli.Uint64Flag{
+					Name:        "cpu-shares",
+					Usage:       "the container's CPU weight relative to other processes; 0 for the default",
+					Destination: &cpuShares,
+					Value:       0,
+				},
+				&cli.Uint64Flag{
+					Name:        "memory-limit",
+					Usage:       "bytes of memory the container may use; 0 for no limit",
+					Destination: &memoryLimit,
+					Value:       0,
+				},
 			},
-			Action: func(_ *cli.Context) error {
-				return runCtr(containerdSocket, namespace, containerID, source, superpowered, registryConfig, containerType(cType), useCachedImage)
+			Action: func(c *cli.Context) error {
+				// Refreshes pull the tag wherever it moved, which is what --allow-tag-mutation=false refuses
+				if refreshInterval > 0 && !allowTagMutation {
+					return errors.New("--refresh-interval can't be combined with --allow-tag-mutation=false")
+				}
+				source, err := resolveImageAlias(aliasConfig, source)
+				if err != nil {
+					return err
+				}
+				labels, err := convertLabels(c.StringSlice("label"), strictLabels)
+				if err != nil {
+					return err
+				}
+				if err := checkLabelPrefixes(labels, c.StringSlice("allowed-label-prefixes")); err != nil {
+					return err
+				}
+				mounts, err := convertMounts(c.StringSlice("mount"))
+				if err != nil {
+					return err
+				}
+				expectedEntrypoint, err := parseExpectedEntrypoint(expectEntrypoint)
+				if err != nil {
+					return err
+				}
+				maxSeverity, err := parseVulnSeverity(maxVulnSeverity)
+				if err != nil {
+					return err
+				}
+				resources := resourceLimits{cpuQuota: cpuQuota, cpuShares: cpuShares, memoryLimit: memoryLimit}
+				if err := resources.validate(); err != nil {
+					return err
+				}
+				ctrOpts := containerOptions{
+					labels:                 labels,
+					mounts:                 mounts,
+					useImageDefaults:       imageDefaults,
+					ignorePlatformMismatch: ignorePlatform,
+					swapOnUpdate:           swapOnUpdate,
+					expectedEntrypoint:     expectedEntrypoint,
+					scanCommand:            parseScanCommand(scanCommand),
+					maxVulnSeverity:        maxSeverity,
+					resources:              resources,
+					interactive:            interactive,
+					checkCPUFeatures:       cpuFeatures,
+					inheritImageLabels:     inheritLabels,
+					imageMountSources:      c.StringSlice("image-default-mount-sources"),
+					allowedLabelPrefixes:   c.StringSlice("allowed-label-prefixes"),
+					printSpec:              showSpec || dryRunSpec,
+					dryRunSpec:             dryRunSpec,
+				}
+				pullOpts, err := newPullOptions(c)
+				if err != nil {
+					return err
+				}
+				pullOpts.refreshInterval = refreshInterval
+				checkStorage(c.Context, containerdRoot)
+				shutdownOrder, err := parseShutdownOrder(c.StringSlice("shutdown-order"))
+				if err != nil {
+					return err
+				}
+				if allPlatforms {
+					if platform != "" {
+						return errors.New("--all-platforms can't be combined with --platform")
+					}
+					return runCtrAllPlatforms(containerdSocket, namespace, containerID, source, superpowered, containerType(cType), ctrOpts, pullOpts, shutdownOrder)
+				}
+				if len(shutdownOrder) != 0 {
+					return errors.New("--shutdown-order requires --all-platforms")
+				}
+				return runCtr(containerdSocket, namespace, containerID, source, superpowered, containerType(cType), ctrOpts, pullOpts)
 			},
 		},
 		{
@@ -189,14 +856,134 @@ func App() *cli.App {
 					Name:  "label",
 					Usage: "label to add to the pulled image in `key=value` format",
 				},
+				&cli.BoolFlag{
+					Name:        "require-ecr-tag",
+					Usage:       "fails instead of defaulting to the `latest` tag when an ECR image URI has no tag or digest",
+					Destination: &requireECRTag,
+					Value:       false,
+				},
+				&cli.StringFlag{
+					Name:        "digest-fallback",
+					Usage:       "pulls the image by this digest, such as sha256:..., if its tag no longer resolves",
+					Destination: &digestFallback,
+				},
+				&cli.BoolFlag{
+					Name:        "allow-tag-mutation",
+					Usage:       "pulls image tags that now point at a different image than the one already pulled; set to false to refuse them",
+					Destination: &allowTagMutation,
+					Value:       true,
+				},
+				&cli.StringFlag{
+					Name:        "ecr-partition",
+					Usage:       "the AWS `partition` of the ECR repository, when it differs from the partition of the region in the image URI",
+					Destination: &ecrPartition,
+				},
+				&cli.BoolFlag{
+					Name:        "no-unpack",
+					Usage:       "downloads the image content without unpacking it into the snapshotter",
+					Destination: &noUnpack,
+					Value:       false,
+				},
+				&cli.BoolFlag{
+					Name:        "fetch-referrers",
+					Usage:       "also fetches the artifacts referring to the image, such as SBOMs and attestations, into the content store",
+					Destination: &fetchReferrers,
+					Value:       false,
+				},
+				&cli.StringFlag{
+					Name:        "content-store",
+					Usage:       "pulls the image content into a standalone content store in `local:/path` format, without containerd",
+					Destination: &contentStore,
+				},
+				&cli.StringFlag{
+					Name:        "content-store-base",
+					Usage:       "`path` to a read-only content store to layer the content store over; content already there isn't pulled again",
+					Destination: &contentStoreBase,
+				},
+				&cli.BoolFlag{
+					Name:        "config-only",
+					Usage:       "fetches and prints the image configuration without pulling the image layers",
+					Destination: &configOnly,
+					Value:       false,
+				},
 			},
 			Action: func(c *cli.Context) error {
-				labels := c.StringSlice("label")
-				labelsMap, err := convertLabels(labels)
+				source, err := resolveImageAlias(aliasConfig, source)
+				if err != nil {
+					return err
+				}
+				labels, err := convertLabels(c.StringSlice("label"), strictLabels)
+				if err != nil {
+					return err
+				}
+				if err := checkLabelPrefixes(labels, c.StringSlice("allowed-label-prefixes")); err != nil {
+					return err
+				}
+				pullOpts, err := newPullOptions(c)
 				if err != nil {
 					return err
 				}
-				return pullImageOnly(containerdSocket, namespace, source, registryConfig, useCachedImage, labelsMap)
+				if configOnly || contentStore != "" {
+					// Labels are added to the image in containerd's image store, which these pulls don't use
+					if len(labels) != 0 {
+						return errors.New("--label can't be combined with --config-only or --content-store")
+					}
+				}
+				if configOnly {
+					return pullImageConfig(source, pullOpts)
+				}
+				if contentStore != "" {
+					storePath, err := parseContentStore(contentStore)
+					if err != nil {
+						return err
+					}
+					return pullImageToContentStore(source, storePath, contentStoreBase, pullOpts)
+				}
+				pullOpts.labels = labels
+				pullOpts.noUnpack = noUnpack
+				pullOpts.fetchReferrers = fetchReferrers
+				checkStorage(c.Context, containerdRoot)
+				return pullImageOnly(containerdSocket, namespace, source, pullOpts)
+			},
+		},
+		{
+			Name:        "pin",
+			Usage:       "print the digest of the specified image for each of its platforms",
+			Description: "resolve the specified image and print a JSON object mapping each platform to the digest of its manifest, to pin every platform of a multi-platform image",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "source",
+					Usage:       "the image source",
+					Destination: &source,
+					Required:    true,
+				},
+				&cli.StringFlag{
+					Name:        "registry-config",
+					Usage:       "path to image registry configuration",
+					Destination: &registryConfig,
+				},
+				&cli.BoolFlag{
+					Name:        "require-ecr-tag",
+					Usage:       "fails instead of defaulting to the `latest` tag when an ECR image URI has no tag or digest",
+					Destination: &requireECRTag,
+					Value:       false,
+				},
+				&cli.StringFlag{
+					Name:        "ecr-partition",
+					Usage:       "the AWS `partition` of the ECR repository, when it differs from the partition of the region in the image URI",
+					Destination: &ecrPartition,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				source, err := resolveImageAlias(aliasConfig, source)
+				if err != nil {
+					return err
+				}
+				pullOpts, err := newPullOptions(c)
+				if err != nil {
+					return err
+				}
+				return pinImage(source, pullOpts)
 			},
 		},
 		{
@@ -261,6 +1048,74 @@ func (ct containerType) Prefix() string {
 	return ""
 }
 
+// pullOptions contains the settings used to fetch an image
+type pullOptions struct {
+	// Path to the image registry configuration
+	registryConfigPath string
+	// Skip the pull if the image already exists in the image store
+	useCachedImage bool
+	// Labels to add to the pulled image
+	labels map[string]string
+	// Platform to pull; if empty, the registry mirror's default platform or the host's platform is used
+	platform string
+	// Fail for ECR image URIs without a tag or digest instead of defaulting to the `latest` tag
+	requireECRTag bool
+	// Value of the `Accept-Language` header sent with registry requests
+	acceptLanguage string
+	// Value of the `Accept` header sent when resolving images, instead of the image media types
+	accept string
+	// Check that registry mirrors resolve the image to the same digest as the upstream registry
+	verifyMirrorDigest bool
+	// Whether to fail or only warn when a registry mirror serves stale content
+	mirrorStaleAction string
+	// Only download the image content, without unpacking it into the snapshotter
+	noUnpack bool
+	// How to randomize the delay between pull retries
+	retryJitter retryJitter
+	// The total time to spend retrying a pull, zero for no bound beyond the attempt count
+	retryMaxElapsed time.Duration
+	// Substrings of errors that are retried even if their registry response status isn't
+	retrySubstrings []string
+	// Fetch the artifacts referring to the image through the OCI referrers API
+	fetchReferrers bool
+	// The AWS partition to use for ECR images instead of the one inferred from the region
+	ecrPartition string
+	// ECR API endpoint overrides by region
+	ecrEndpoints map[string]string
+	// The types of artifact pulled manifests may describe, any if empty
+	manifestTypes []string
+	// Path to the keyring pulled images must be signed with
+	imageKeyring string
+	// The identity and OIDC issuer keyless signatures must have been issued to, any if empty
+	certIdentity   string
+	certOIDCIssuer string
+	// Pull tags that were already pulled even if they now point at a different image
+	allowTagMutation bool
+	// Path to write an inventory document of the pulled image to, in inventoryFormat
+	inventoryFile   string
+	inventoryFormat string
+	// Check that the snapshotter applied the whiteouts in the image's layers when unpacking
+	validateWhiteouts bool
+	// Check that the extended attributes in the image's layers were preserved when unpacking
+	validateXattrs bool
+	// Check that the image's content adds up to the sizes its manifest declares
+	verifyTotalSize bool
+	// How far the clock may differ from a registry's before authentication failures are blamed on it
+	clockSkewTolerance time.Duration
+	// Value of the W3C `baggage` header sent with registry requests
+	baggage string
+	// Records the registry endpoints a pull attempt tries, to report why each failed
+	endpointAttempts *endpointAttempts
+	// The digest to pull if the image's tag no longer resolves, if set
+	digestFallback digest.Digest
+	// Trace the timing of registry requests, and report it with the pull
+	traceRequests bool
+	// Aggregates the timing of a pull attempt's registry requests when they're traced
+	requestTimings *requestTimings
+	// How often to pull the image again while its container runs, zero to never refresh it
+	refreshInterval time.Duration
+}
+
 // SliceContains returns true if a slice contains a string
 func SliceContains(s []string, v string) bool {
 	for _, n := range s {
@@ -271,7 +1126,7 @@ func SliceContains(s []string, v string) bool {
 	return false
 }
 
-func runCtr(containerdSocket string, namespace string, containerID string, source string, superpowered bool, registryConfigPath string, cType containerType, useCachedImage bool) error {
+func runCtr(containerdSocket string, namespace string, containerID string, source string, superpowered bool, cType containerType, ctrOpts containerOptions, pullOpts pullOptions) error {
 	// Check if the containerType provided is valid
 	if !cType.IsValid() {
 		return errors.New("Invalid container type")
@@ -286,17 +1141,28 @@ func runCtr(containerdSocket string, namespace string, containerID string, sourc
 	defer cancel()
 	ctx = namespaces.WithNamespace(ctx, namespace)
 
-	go func(ctx context.Context, cancel context.CancelFunc) {
-		// Set up channel on which to send signal notifications.
-		// We must use a buffered channel or risk missing the signal
-		// if we're not ready to receive when the signal is sent.
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-		for sigrecv := range c {
-			log.G(ctx).Info("received signal: ", sigrecv)
-			cancel()
-		}
-	}(ctx, cancel)
+	if ctrOpts.stopC != nil {
+		// The caller stops the container, such as to stop several containers in order
+		go func(ctx context.Context, cancel context.CancelFunc) {
+			select {
+			case <-ctrOpts.stopC:
+				cancel()
+			case <-ctx.Done():
+			}
+		}(ctx, cancel)
+	} else {
+		go func(ctx context.Context, cancel context.CancelFunc) {
+			// Set up channel on which to send signal notifications.
+			// We must use a buffered channel or risk missing the signal
+			// if we're not ready to receive when the signal is sent.
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+			for sigrecv := range c {
+				log.G(ctx).Info("received signal: ", sigrecv)
+				cancel()
+			}
+		}(ctx, cancel)
+	}
 
 	client, err := newContainerdClient(ctx, containerdSocket, namespace)
 	if err != nil {
@@ -307,15 +1173,14 @@ func runCtr(containerdSocket string, namespace string, containerID string, sourc
 	// Check if the image source is an ECR image. If it is, then we need to handle it with the ECR resolver.
 	isECRImage := ecrRegex.MatchString(source)
 	var img containerd.Image
-	emptyLabels := make(map[string]string)
 
 	if isECRImage {
-		img, err = fetchECRImage(ctx, source, client, registryConfigPath, useCachedImage, emptyLabels)
+		img, err = fetchECRImage(ctx, source, client, pullOpts)
 		if err != nil {
 			return err
 		}
 	} else {
-		img, err = fetchImage(ctx, source, client, registryConfigPath, useCachedImage, emptyLabels)
+		img, err = fetchImage(ctx, source, client, pullOpts)
 		if err != nil {
 			log.G(ctx).WithField("ref", source).Error(err)
 			return err
@@ -336,41 +1201,59 @@ func runCtr(containerdSocket string, namespace string, containerID string, sourc
 		}
 	}
 
-	// If the container doesn't already exist, create it
-	if container == nil {
-		// Set the destination name for the container persistent storage location
-		persistentDir := cType.PersistentDir()
-
-		specOpts := []oci.SpecOpts{
-			oci.WithImageConfig(img),
-			oci.WithHostNamespace(runtimespec.NetworkNamespace),
-			oci.WithHostHostsFile,
-			oci.WithHostResolvconf,
-			// Unmask `/sys/firmware` to provide extra insight into the hardware of the
-			// underlying host, such as the number of CPU sockets on aarch64 variants
-			withUnmaskedPaths([]string{"/sys/firmware"}),
-			// Pass proxy environment variables to this container
-			withProxyEnv(),
-			// Add a default set of mounts regardless of the container type
-			withDefaultMounts(containerName, persistentDir),
-			// Mount the container's rootfs with an SELinux label that makes it writable
-			withMountLabel("system_u:object_r:secret_t:s0"),
-		}
-
-		// Select the set of specOpts based on the container type
-		switch {
-		case superpowered:
-			specOpts = append(specOpts, withSuperpowered())
-		case cType == bootstrap:
-			specOpts = append(specOpts, withBootstrap())
-		default:
-			specOpts = append(specOpts, withDefault())
-		}
-
-		ctrOpts := containerd.WithNewSpec(specOpts...)
+	// Set the destination name for the container persistent storage location
+	persistentDir := cType.PersistentDir()
+	// prepareContainer checks img and assembles the container options and the spec options the
+	// container is created from it with
+	prepareContainer := func(img containerd.Image) (containerOptions, []oci.SpecOpts, error) {
+		ctrOpts := ctrOpts
+		if err := verifyImagePlatform(ctx, img, pullOpts, ctrOpts.ignorePlatformMismatch); err != nil {
+			log.G(ctx).WithError(err).WithField("img", img.Name()).Error("image platform check failed")
+			return ctrOpts, nil, err
+		}
+		if len(ctrOpts.expectedEntrypoint) != 0 {
+			if err := verifyImageEntrypoint(ctx, img, ctrOpts.expectedEntrypoint); err != nil {
+				log.G(ctx).WithError(err).WithField("img", img.Name()).Error("image entrypoint check failed")
+				return ctrOpts, nil, err
+			}
+		}
+		if ctrOpts.checkCPUFeatures {
+			if err := verifyImageCPUFeatures(ctx, img); err != nil {
+				log.G(ctx).WithError(err).WithField("img", img.Name()).Error("image CPU feature check failed")
+				return ctrOpts, nil, err
+			}
+		}
+		if ctrOpts.maxVulnSeverity != 0 {
+			if err := verifyImageVulnerabilities(ctx, img, ctrOpts.maxVulnSeverity); err != nil {
+				log.G(ctx).WithError(err).WithField("img", img.Name()).Error("image vulnerability check failed")
+				return ctrOpts, nil, err
+			}
+		}
+		if len(ctrOpts.scanCommand) != 0 {
+			if err := scanImage(ctx, client, img, ctrOpts.scanCommand); err != nil {
+				log.G(ctx).WithError(err).WithField("img", img.Name()).Error("image scan failed")
+				return ctrOpts, nil, err
+			}
+		}
+
+		ctrOpts, err := fetchImageLabels(ctx, img, ctrOpts)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("img", img.Name()).Error("failed to apply image labels")
+			return ctrOpts, nil, err
+		}
+
+		specOpts := append([]oci.SpecOpts{oci.WithImageConfig(img)}, containerSpecOpts(containerName, persistentDir, superpowered, cType, ctrOpts)...)
+		return ctrOpts, specOpts, nil
+	}
+	// newContainer creates the container from img
+	newContainer := func(img containerd.Image) (containerd.Container, error) {
+		ctrOpts, specOpts, err := prepareContainer(img)
+		if err != nil {
+			return nil, err
+		}
 
 		// Create the container.
-		container, err = client.NewContainer(
+		container, err := client.NewContainer(
 			ctx,
 			containerID,
 			containerd.WithImage(img),
@@ -378,15 +1261,43 @@ func runCtr(containerdSocket string, namespace string, containerID string, sourc
 			containerd.WithRuntime("io.containerd.runc.v2", &options.Options{
 				Root: "/run/host-containerd/runc",
 			}),
-			ctrOpts,
+			containerd.WithContainerLabels(ctrOpts.labels),
+			containerd.WithNewSpec(specOpts...),
 		)
 		if err != nil {
 			log.G(ctx).WithError(err).WithField("img", img.Name).Error("failed to create container")
+			return nil, err
+		}
+		return container, nil
+	}
+
+	// Print the spec the container would be created with, without creating it
+	if ctrOpts.dryRunSpec {
+		_, specOpts, err := prepareContainer(img)
+		if err != nil {
+			return err
+		}
+		spec, err := oci.GenerateSpec(ctx, client, &containers.Container{ID: containerID}, specOpts...)
+		if err != nil {
+			log.G(ctx).WithError(err).Error("failed to generate container spec")
+			return err
+		}
+		return printSpec(os.Stdout, spec)
+	}
+
+	// If the container doesn't already exist, create it
+	if container == nil {
+		container, err = newContainer(img)
+		auditLog.recordImage(auditCreate, containerID, img.Name(), img, err)
+		if err != nil {
 			return err
 		}
 	}
 	defer func() {
-		// Clean up the container as program wraps up.
+		// Clean up the container as program wraps up. There's none left if swapping images failed.
+		if container == nil {
+			return
+		}
 		cleanup, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		err := container.Delete(cleanup, containerd.WithSnapshotCleanup)
@@ -396,7 +1307,7 @@ func runCtr(containerdSocket string, namespace string, containerID string, sourc
 	}()
 
 	// Check if the container task already exists. If it does, try to manage it.
-	task, err := container.Task(ctx, cio.NewAttach(cio.WithStdio))
+	task, err := container.Task(ctx, cio.NewAttach(taskStreams(ctrOpts.interactive)))
 	if err != nil {
 		if errdefs.IsNotFound(err) {
 			log.G(ctx).WithField("container-id", containerID).Info("container task does not exist, proceeding to create it")
@@ -408,8 +1319,18 @@ func runCtr(containerdSocket string, namespace string, containerID string, sourc
 	// If the container doesn't already exist, create it
 	taskAlreadyRunning := false
 	if task == nil {
+		if ctrOpts.printSpec {
+			spec, err := container.Spec(ctx)
+			if err != nil {
+				log.G(ctx).WithError(err).Error("failed to read container spec")
+				return err
+			}
+			if err := printSpec(os.Stdout, spec); err != nil {
+				return err
+			}
+		}
 		// Create the container task
-		task, err = container.NewTask(ctx, cio.NewCreator(cio.WithStdio))
+		task, err = container.NewTask(ctx, cio.NewCreator(taskStreams(ctrOpts.interactive)))
 		if err != nil {
 			log.G(ctx).WithError(err).Error("failed to create container task")
 			return err
@@ -432,7 +1353,7 @@ func runCtr(containerdSocket string, namespace string, containerID string, sourc
 			}
 			log.G(ctx).Info("killed existing container task to replace it with a new task")
 			// Recreate the container task
-			task, err = container.NewTask(ctx, cio.NewCreator(cio.WithStdio))
+			task, err = container.NewTask(ctx, cio.NewCreator(taskStreams(ctrOpts.interactive)))
 			if err != nil {
 				log.G(ctx).WithError(err).Error("failed to create container task")
 				return err
@@ -444,6 +1365,9 @@ func runCtr(containerdSocket string, namespace string, containerID string, sourc
 	}
 	defer func() {
 		// Clean up the container's task as program wraps up.
+		if task == nil {
+			return
+		}
 		cleanup, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		_, err := task.Delete(cleanup)
@@ -460,12 +1384,25 @@ func runCtr(containerdSocket string, namespace string, containerID string, sourc
 	}
 	if !taskAlreadyRunning {
 		// Execute the target container's task.
-		if err := task.Start(ctx); err != nil {
+		err := task.Start(ctx)
+		auditLog.recordImage(auditStart, containerID, img.Name(), img, err)
+		if err != nil {
 			log.G(ctx).WithError(err).Error("failed to start container task")
 			return err
 		}
 		log.G(ctx).Info("successfully started container task")
 	}
+	notifyState(ctx, "READY=1")
+
+	// Refreshed images are sent on updates when the container should be swapped to them
+	var updates chan imageUpdate
+	if pullOpts.refreshInterval > 0 {
+		if ctrOpts.swapOnUpdate {
+			updates = make(chan imageUpdate)
+		}
+		stopRefresh := startImageRefresh(ctx, source, img, client, pullOpts.refreshInterval, pullOpts, updates)
+		defer stopRefresh()
+	}
 
 	// Block until an OS signal (e.g. SIGTERM, SIGINT) is received or the
 	// container task finishes and exits on its own.
@@ -476,60 +1413,171 @@ func runCtr(containerdSocket string, namespace string, containerID string, sourc
 	ctrCtx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	select {
-	case <-ctx.Done():
-		// SIGTERM the container task and get its exit status
-		if err := task.Kill(ctrCtx, syscall.SIGTERM); err != nil {
-			log.G(ctrCtx).WithError(err).Error("failed to send SIGTERM to container")
+	// recreate creates the container from img and starts its task, after the previous
+	// container was removed for a swap
+	recreate := func(ctx context.Context, img containerd.Image) error {
+		var err error
+		container, err = newContainer(img)
+		auditLog.recordImage(auditCreate, containerID, img.Name(), img, err)
+		if err != nil {
 			return err
 		}
-		// Wait for 20 seconds and see check if container task exited
-		const gracePeriod = 20 * time.Second
-		timeout := time.NewTimer(gracePeriod)
+		task, exitStatusC, err = startTask(ctx, container, ctrOpts.interactive)
+		auditLog.recordImage(auditStart, containerID, img.Name(), img, err)
+		return err
+	}
 
+wait:
+	for {
 		select {
+		case <-ctx.Done():
+			status, err = stopTask(ctrCtx, task, exitStatusC, ctrOpts.stopGracePeriod)
+			if err != nil {
+				auditLog.recordImage(auditStop, containerID, img.Name(), img, err)
+				return err
+			}
+			break wait
 		case status = <-exitStatusC:
-			// Container task was able to exit on its own, stop the timer.
-			if !timeout.Stop() {
-				<-timeout.C
+			// Container task exited on its own
+			break wait
+		case update := <-updates:
+			updated := update.img
+			steps := swapSteps{
+				stopOld: func(ctx context.Context) error {
+					_, err := stopTask(ctx, task, exitStatusC, ctrOpts.stopGracePeriod)
+					auditLog.recordImage(auditStop, containerID, img.Name(), img, err)
+					if err != nil {
+						return err
+					}
+					return removeContainer(ctx, &container, &task)
+				},
+				startNew: func(ctx context.Context) error {
+					return recreate(ctx, updated)
+				},
+				cleanUpNew: func(ctx context.Context) error {
+					return removeContainer(ctx, &container, &task)
+				},
+				restartOld: func(ctx context.Context) error {
+					return recreate(ctx, img)
+				},
 			}
-		case <-timeout.C:
-			// Container task still hasn't exited, SIGKILL the container task or
-			// timeout and bail.
-
-			const sigkillTimeout = 45 * time.Second
-			killCtx, cancel := context.WithTimeout(ctrCtx, sigkillTimeout)
-
-			err := task.Kill(killCtx, syscall.SIGKILL)
-			cancel()
+			// The tag already points at the refreshed image, so the previous image's content is
+			// only kept for a rollback by the lease
+			release, err := leaseImage(ctrCtx, client, img)
 			if err != nil {
-				log.G(ctrCtx).WithError(err).Error("failed to SIGKILL container process, timed out")
-				return err
+				log.G(ctrCtx).WithError(err).WithField("img", img.Name()).Error("failed to lease the running image, not swapping the container")
+				update.swapped <- err
+				continue
 			}
-
-			status = <-exitStatusC
+			err = swapContainer(ctrCtx, steps)
+			release()
+			update.swapped <- err
+			if err != nil {
+				log.G(ctrCtx).WithError(err).WithField("img", updated.Name()).Error("failed to swap container to the refreshed image")
+				if task == nil {
+					return err
+				}
+				continue
+			}
+			log.G(ctrCtx).WithField("img", updated.Name()).WithField("digest", updated.Target().Digest).Info("swapped container to the refreshed image")
+			img = updated
 		}
+	}
+	code, _, err := status.Result()
+	if err != nil {
+		log.G(ctrCtx).WithError(err).Error("failed to get container task exit status")
+		auditLog.recordImage(auditStop, containerID, img.Name(), img, err)
+		return err
+	}
+
+	log.G(ctrCtx).WithField("code", code).Info("container task exited")
+
+	// Return error if container exists with non-zero status
+	if code != 0 {
+		err = fmt.Errorf("Container %s exited with non-zero status", containerID)
+	}
+	auditLog.recordImage(auditStop, containerID, img.Name(), img, err)
+	return err
+}
+
+// stopTask sends SIGTERM to the container task and waits for it to exit, sending SIGKILL if
+// it doesn't exit within the grace period
+func stopTask(ctx context.Context, task containerd.Task, exitStatusC <-chan containerd.ExitStatus, gracePeriod time.Duration) (containerd.ExitStatus, error) {
+	var status containerd.ExitStatus
+	// SIGTERM the container task and get its exit status
+	if err := task.Kill(ctx, syscall.SIGTERM); err != nil {
+		log.G(ctx).WithError(err).Error("failed to send SIGTERM to container")
+		return status, err
+	}
+	// Wait for the grace period and see if the container task exited
+	if gracePeriod == 0 {
+		gracePeriod = defaultStopGracePeriod
+	}
+	timeout := time.NewTimer(gracePeriod)
+
+	select {
 	case status = <-exitStatusC:
-		// Container task exited on its own
+		// Container task was able to exit on its own, stop the timer.
+		if !timeout.Stop() {
+			<-timeout.C
+		}
+	case <-timeout.C:
+		// Container task still hasn't exited, SIGKILL the container task or
+		// timeout and bail.
+
+		const sigkillTimeout = 45 * time.Second
+		killCtx, cancel := context.WithTimeout(ctx, sigkillTimeout)
+
+		err := task.Kill(killCtx, syscall.SIGKILL)
+		cancel()
+		if err != nil {
+			log.G(ctx).WithError(err).Error("failed to SIGKILL container process, timed out")
+			return status, err
+		}
+
+		status = <-exitStatusC
+	}
+	return status, nil
+}
+
+// startTask creates and starts the container's task, returning the channel its exit status is sent on
+func startTask(ctx context.Context, container containerd.Container, interactive bool) (containerd.Task, <-chan containerd.ExitStatus, error) {
+	task, err := container.NewTask(ctx, cio.NewCreator(taskStreams(interactive)))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create container task")
+	}
+	exitStatusC, err := task.Wait(context.TODO())
+	if err == nil {
+		err = task.Start(ctx)
 	}
-	code, _, err := status.Result()
 	if err != nil {
-		log.G(ctrCtx).WithError(err).Error("failed to get container task exit status")
-		return err
+		if _, deleteErr := task.Delete(ctx, containerd.WithProcessKill); deleteErr != nil {
+			log.G(ctx).WithError(deleteErr).Error("failed to delete container task")
+		}
+		return nil, nil, errors.Wrap(err, "failed to start container task")
 	}
+	return task, exitStatusC, nil
+}
 
-	log.G(ctrCtx).WithField("code", code).Info("container task exited")
-
-	// Return error if container exists with non-zero status
-	if code != 0 {
-		return fmt.Errorf("Container %s exited with non-zero status", containerID)
+// removeContainer deletes the container and its task, if they exist
+func removeContainer(ctx context.Context, container *containerd.Container, task *containerd.Task) error {
+	if *task != nil {
+		if _, err := (*task).Delete(ctx, containerd.WithProcessKill); err != nil {
+			return err
+		}
+		*task = nil
+	}
+	if *container != nil {
+		if err := (*container).Delete(ctx, containerd.WithSnapshotCleanup); err != nil {
+			return err
+		}
+		*container = nil
 	}
-
 	return nil
 }
 
 // pullImageOnly pulls the specified container image
-func pullImageOnly(containerdSocket string, namespace string, source string, registryConfigPath string, useCachedImage bool, labels map[string]string) error {
+func pullImageOnly(containerdSocket string, namespace string, source string, pullOpts pullOptions) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	ctx = namespaces.WithNamespace(ctx, namespace)
@@ -543,21 +1591,90 @@ func pullImageOnly(containerdSocket string, namespace string, source string, reg
 	// Check if the image source is an ECR image. If it is, then we need to handle it with the ECR resolver.
 	isECRImage := ecrRegex.MatchString(source)
 	if isECRImage {
-		_, err = fetchECRImage(ctx, source, client, registryConfigPath, useCachedImage, labels)
+		_, err = fetchECRImage(ctx, source, client, pullOpts)
 		if err != nil {
 			return err
 		}
 	} else {
-		_, err = fetchImage(ctx, source, client, registryConfigPath, useCachedImage, labels)
+		_, err = fetchImage(ctx, source, client, pullOpts)
 		if err != nil {
 			log.G(ctx).WithField("ref", source).Error(err)
 			return err
 		}
 	}
+	notifyState(ctx, "READY=1")
+
+	return nil
+}
+
+// pullImageConfig fetches the specified image's configuration and prints it to stdout.
+// The image layers aren't pulled and nothing is added to the containerd image store.
+func pullImageConfig(source string, pullOpts pullOptions) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ref, resolver, matcher, err := remoteResolver(ctx, source, pullOpts)
+	if err != nil {
+		return err
+	}
+	verifier, err := remoteImageVerifier(ctx, source, pullOpts)
+	if err != nil {
+		return err
+	}
 
+	var config []byte
+	err = retryRemotePull(ctx, ref, pullOpts, func() error {
+		if verifier != nil {
+			name, desc, err := resolver.Resolve(ctx, ref)
+			if err != nil {
+				return errors.Wrapf(err, "failed to resolve %q", ref)
+			}
+			if err := verifier.Verify(ctx, name, desc); err != nil {
+				return err
+			}
+		}
+		config, err = fetchImageConfig(ctx, resolver, ref, matcher)
+		return err
+	})
+	if err != nil {
+		log.G(ctx).WithField("ref", ref).Error(err)
+		return err
+	}
+	fmt.Println(string(config))
 	return nil
 }
 
+// remoteResolver sets up the resolver and platform matcher for pulling source without containerd,
+// returning the reference to resolve, which differs from source for ECR images
+func remoteResolver(ctx context.Context, source string, pullOpts pullOptions) (string, remotes.Resolver, platforms.MatchComparer, error) {
+	ref := source
+	if ecrRegex.MatchString(source) {
+		ecrRef, err := parseECRSource(ctx, source, pullOpts)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		ref = ecrRef.Canonical()
+	}
+
+	registryConfig, err := loadRegistryConfig(ctx, pullOpts.registryConfigPath)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	matcher, err := platformMatcher(registryConfig, ref, pullOpts.platform)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	// Fall back to the same resolver containerd uses if the dynamic resolver doesn't set one
+	remoteCtx := &containerd.RemoteContext{
+		Resolver: docker.NewResolver(docker.ResolverOptions{}),
+	}
+	if err := withManifestSizeLimit(withLocalCache(withInlineContent(withManifestCache(withDynamicResolver(ctx, ref, registryConfig, pullOpts), registryManifestCache)), localCache), registryMaxManifestSize)(nil, remoteCtx); err != nil {
+		return "", nil, nil, err
+	}
+	return ref, remoteCtx.Resolver, matcher, nil
+}
+
 // cleanUp checks if the specified container exists and attempts to clean it up
 func cleanUp(containerdSocket string, namespace string, containerID string) error {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -617,9 +1734,32 @@ type parsedECR struct {
 	Fips     bool
 }
 
+// trimECRPort removes an explicit port from the registry host of an ECR image URI, which some
+// load balancer and proxy setups use. ECR images are pulled through the ECR API regardless of
+// the port, so only its validity is checked.
+func trimECRPort(input string) (string, error) {
+	host, repoPath, hasRepoPath := strings.Cut(input, "/")
+	hostname, port, hasPort := strings.Cut(host, ":")
+	if !hasPort {
+		return input, nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q in image URI: %s", port, input)
+	}
+	if !hasRepoPath {
+		return hostname, nil
+	}
+	return hostname + "/" + repoPath, nil
+}
+
 // parseImageURIAsECR mimics the parsing in ecr.ParseImageURI but only returns metadata pertaining
-// to the parsed URI.
-func parseImageURIAsECR(input string) (*parsedECR, error) {
+// to the parsed URI. If the URI has no tag or digest, the `latest` tag is added to the repository
+// path unless requireTag is set, in which case an error is returned.
+func parseImageURIAsECR(input string, requireTag bool) (*parsedECR, error) {
+	input, err := trimECRPort(input)
+	if err != nil {
+		return nil, err
+	}
 	matches := ecrRegex.FindStringSubmatch(input)
 
 	if len(matches) < 3 {
@@ -644,6 +1784,12 @@ func parseImageURIAsECR(input string) (*parsedECR, error) {
 		strings.HasSuffix(fullRepoPath, "@"):
 		return nil, errors.New("incomplete reference provided")
 	}
+	if !hasTagOrDigest(fullRepoPath) {
+		if requireTag {
+			return nil, errors.Wrapf(errMissingImageTag, "invalid image URI: %s", input)
+		}
+		fullRepoPath += ":" + defaultImageTag
+	}
 
 	isFips := matches[2] == "-fips"
 	region := matches[3]
@@ -656,6 +1802,15 @@ func parseImageURIAsECR(input string) (*parsedECR, error) {
 	}, nil
 }
 
+// hasTagOrDigest returns true if the repository path ends with a tag or digest
+func hasTagOrDigest(repoPath string) bool {
+	if strings.Contains(repoPath, "@") {
+		return true
+	}
+	// Only the last path component may hold a tag, since the registry host was already stripped
+	return strings.Contains(repoPath[strings.LastIndex(repoPath, "/")+1:], ":")
+}
+
 // Metadata for specially-treated ECR URIs
 type specialRegions struct {
 	// region => domain mappings
@@ -664,12 +1819,18 @@ type specialRegions struct {
 	FipsSupportedEcrRegions map[string]bool
 }
 
+// The special regions used when parsing ECR image URIs
+var defaultSpecialRegions = specialRegions{
+	EcrRefPrefixMappings:    ecrRefPrefixMapping,
+	FipsSupportedEcrRegions: fipsSupportedEcrRegionSet,
+}
+
 // parseImageURISpecialRegions mimics the parsing in ecr.ParseImageURI but
 // constructs the canonical ECR references while skipping certain checks.
 // We only do this for special regions that are not yet supported by the aws-go-sdk and for ECR FIPS endpoints.
 // Referenced source: https://github.com/awslabs/amazon-ecr-containerd-resolver/blob/a5058cf091f4fc573813a032db37a9820952f1f9/ecr/ref.go#L70-L71
-func parseImageURISpecialRegions(input string, specialRegions specialRegions) (ecr.ECRSpec, error) {
-	parsedECR, err := parseImageURIAsECR(input)
+func parseImageURISpecialRegions(input string, specialRegions specialRegions, requireTag bool) (ecr.ECRSpec, error) {
+	parsedECR, err := parseImageURIAsECR(input, requireTag)
 	if err != nil {
 		return ecr.ECRSpec{}, err
 	}
@@ -701,17 +1862,29 @@ func parseImageURISpecialRegions(input string, specialRegions specialRegions) (e
 // special regions that are not yet supported. If it fails for any reason,
 // attempt to parse again using parseImageURISpecialRegions in this package.
 // This uses a special region reference to build the ECR image references.
-// If both fail, an error is returned.
-func fetchECRRef(ctx context.Context, input string, specialRegions specialRegions) (ecr.ECRSpec, error) {
+// If both fail, an error is returned. References without a tag or digest
+// default to the `latest` tag, unless requireTag is set.
+func fetchECRRef(ctx context.Context, input string, specialRegions specialRegions, requireTag bool) (ecr.ECRSpec, error) {
+	// The ECR resolver's parser doesn't accept ports
+	input, err := trimECRPort(input)
+	if err != nil {
+		return ecr.ECRSpec{}, err
+	}
 	var spec ecr.ECRSpec
-	spec, err := ecr.ParseImageURI(input)
+	spec, err = ecr.ParseImageURI(input)
 	if err == nil {
+		if spec.Object == "" {
+			if requireTag {
+				return ecr.ECRSpec{}, errors.Wrapf(errMissingImageTag, "invalid image URI: %s", input)
+			}
+			spec.Object = defaultImageTag
+		}
 		return spec, nil
 	}
 	log.G(ctx).WithError(err).WithField("source", input).Warn("failed to parse ECR reference")
 
 	// The parsing might fail if the AWS region is special, parse again with special handling:
-	spec, err = parseImageURISpecialRegions(input, specialRegions)
+	spec, err = parseImageURISpecialRegions(input, specialRegions, requireTag)
 	if err == nil {
 		return spec, nil
 	}
@@ -723,13 +1896,38 @@ func fetchECRRef(ctx context.Context, input string, specialRegions specialRegion
 
 }
 
-// fetchECRImage does some additional conversions before resolving the image reference and fetches the image.
-func fetchECRImage(ctx context.Context, source string, client *containerd.Client, registryConfigPath string, fetchCachedImageIfExist bool, labels map[string]string) (containerd.Image, error) {
-	specialRegions := specialRegions{
-		EcrRefPrefixMappings:    ecrRefPrefixMapping,
-		FipsSupportedEcrRegions: fipsSupportedEcrRegionSet,
+// withECRPartition replaces the AWS partition inferred from the region in the ECR reference,
+// for repositories in a different partition than their region implies
+func withECRPartition(spec ecr.ECRSpec, partition string) (ecr.ECRSpec, error) {
+	if partition == "" || partition == spec.Partition() {
+		return spec, nil
 	}
-	ecrRef, err := fetchECRRef(ctx, source, specialRegions)
+	if !ecrPartitionRegex.MatchString(partition) {
+		return ecr.ECRSpec{}, fmt.Errorf("invalid AWS partition: %s", partition)
+	}
+	parsed, err := arn.Parse(spec.ARN())
+	if err != nil {
+		return ecr.ECRSpec{}, err
+	}
+	parsed.Partition = partition
+	return ecr.ParseRef(reference.Spec{
+		Locator: "ecr.aws/" + parsed.String(),
+		Object:  spec.Object,
+	}.String())
+}
+
+// parseECRSource parses the ECR reference for source, applying the partition override if there is one
+func parseECRSource(ctx context.Context, source string, pullOpts pullOptions) (ecr.ECRSpec, error) {
+	spec, err := fetchECRRef(ctx, source, defaultSpecialRegions, pullOpts.requireECRTag)
+	if err != nil {
+		return ecr.ECRSpec{}, err
+	}
+	return withECRPartition(spec, pullOpts.ecrPartition)
+}
+
+// fetchECRImage does some additional conversions before resolving the image reference and fetches the image.
+func fetchECRImage(ctx context.Context, source string, client *containerd.Client, pullOpts pullOptions) (containerd.Image, error) {
+	ecrRef, err := parseECRSource(ctx, source, pullOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -740,8 +1938,9 @@ func fetchECRImage(ctx context.Context, source string, client *containerd.Client
 		WithField("source", source).
 		Debug("parsed ECR reference from URI")
 
-	img, err := fetchImage(ctx, ref, client, registryConfigPath, fetchCachedImageIfExist, labels)
+	img, err := fetchImage(ctx, ref, client, pullOpts)
 	if err != nil {
+		err = mapECRError(err, ecrRef)
 		log.G(ctx).WithField("ref", ref).Error(err)
 		return nil, err
 	}
@@ -772,6 +1971,37 @@ func fetchECRImage(ctx context.Context, source string, client *containerd.Client
 	return img, nil
 }
 
+// ecrRepositoryNotFoundError is returned when the ECR repository for an image doesn't exist
+type ecrRepositoryNotFoundError struct {
+	Account    string
+	Region     string
+	Repository string
+	err        error
+}
+
+func (e *ecrRepositoryNotFoundError) Error() string {
+	return fmt.Sprintf("ECR repository %q does not exist in account %s in region %s, check that the image URI is correct and that the repository has been created",
+		e.Repository, e.Account, e.Region)
+}
+
+func (e *ecrRepositoryNotFoundError) Unwrap() error {
+	return e.err
+}
+
+// mapECRError converts known ECR API errors returned while pulling the image for spec into typed errors
+func mapECRError(err error, spec ecr.ECRSpec) error {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == ecrsdk.ErrCodeRepositoryNotFoundException {
+		return &ecrRepositoryNotFoundError{
+			Account:    spec.Registry(),
+			Region:     spec.Region(),
+			Repository: spec.Repository,
+			err:        err,
+		}
+	}
+	return err
+}
+
 // newContainerdClient creates a new containerd client connected to the specified containerd socket.
 func newContainerdClient(ctx context.Context, containerdSocket string, namespace string) (*containerd.Client, error) {
 	client, err := containerd.New(containerdSocket, containerd.WithDefaultNamespace(namespace))
@@ -783,10 +2013,52 @@ func newContainerdClient(ctx context.Context, containerdSocket string, namespace
 			Error("failed to connect to containerd")
 		return nil, err
 	}
+	if minContainerdVersion != "" {
+		if err := verifyContainerd(ctx, client); err != nil {
+			log.G(ctx).WithError(err).WithField("socket", containerdSocket).Error("incompatible containerd")
+			client.Close()
+			return nil, err
+		}
+	}
 
 	return client, nil
 }
 
+// containerSpecOpts returns the spec options for a container of the given type, other than the
+// image config the container's spec starts from
+func containerSpecOpts(containerName string, persistentDir string, superpowered bool, cType containerType, ctrOpts containerOptions) []oci.SpecOpts {
+	specOpts := []oci.SpecOpts{
+		oci.WithHostNamespace(runtimespec.NetworkNamespace),
+		oci.WithHostHostsFile,
+		oci.WithHostResolvconf,
+		// Unmask `/sys/firmware` to provide extra insight into the hardware of the
+		// underlying host, such as the number of CPU sockets on aarch64 variants
+		withUnmaskedPaths([]string{"/sys/firmware"}),
+		// Pass proxy environment variables to this container
+		withProxyEnv(),
+		// Add a default set of mounts regardless of the container type
+		withDefaultMounts(containerName, persistentDir),
+		// Mount the container's rootfs with an SELinux label that makes it writable
+		withMountLabel("system_u:object_r:secret_t:s0"),
+	}
+
+	// Select the set of specOpts based on the container type
+	switch {
+	case superpowered:
+		specOpts = append(specOpts, withSuperpowered())
+	case cType == bootstrap:
+		specOpts = append(specOpts, withBootstrap())
+	default:
+		specOpts = append(specOpts, withDefault())
+	}
+
+	// Add the requested mounts last so they aren't shadowed by the default mounts
+	if len(ctrOpts.mounts) != 0 {
+		specOpts = append(specOpts, withMounts(ctrOpts.mounts))
+	}
+	return append(specOpts, withResourceLimits(ctrOpts.resources))
+}
+
 // withSuperpowered adds container options to grant administrative privileges
 func withSuperpowered() oci.SpecOpts {
 	return oci.Compose(
@@ -1073,9 +2345,19 @@ func withProxyEnv() oci.SpecOpts {
 }
 
 // fetchImage returns a `containerd.Image` given an image source.
-func fetchImage(ctx context.Context, source string, client *containerd.Client, registryConfigPath string, useCachedImage bool, labels map[string]string) (containerd.Image, error) {
+func fetchImage(ctx context.Context, source string, client *containerd.Client, pullOpts pullOptions) (containerd.Image, error) {
+	// Images in the store are opened for the platform they're pulled for, rather than the host's,
+	// so that each container of --all-platforms gets its own platform's config and snapshot
+	registryConfig, err := loadRegistryConfig(ctx, pullOpts.registryConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := platformMatcher(registryConfig, source, pullOpts.platform)
+	if err != nil {
+		return nil, err
+	}
 	// Check the containerd image store to see if image exists
-	img, err := client.GetImage(ctx, source)
+	img, err := getImage(ctx, client, source, matcher)
 	if err != nil {
 		if errdefs.IsNotFound(err) {
 			log.G(ctx).WithField("ref", source).Info("Image does not exist, proceeding to pull image from source.")
@@ -1084,63 +2366,179 @@ func fetchImage(ctx context.Context, source string, client *containerd.Client, r
 			return nil, err
 		}
 	}
-	if img != nil && useCachedImage {
+	if img != nil && pullOpts.useCachedImage {
 		log.G(ctx).WithField("ref", source).Info("Image exists, fetching cached image from image store")
-		return img, err
+		// The cached image may have been pulled without verification, or before its signature changed
+		if pullOpts.imageKeyring != "" {
+			if err := verifyImage(ctx, client, img, registryConfig, pullOpts); err != nil {
+				return nil, err
+			}
+		}
+		return img, nil
+	}
+	if img != nil && !pullOpts.allowTagMutation {
+		_, resolver, _, err := remoteResolver(ctx, source, pullOpts)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkTagMutation(ctx, source, img.Target(), resolver, false); err != nil {
+			log.G(ctx).WithError(err).WithField("ref", source).Error("refusing to pull mutated image tag")
+			return nil, err
+		}
 	}
-	return pullImage(ctx, source, client, registryConfigPath, labels)
+	pullRef := source
+	if pullOpts.digestFallback != "" {
+		_, resolver, _, err := remoteResolver(ctx, source, pullOpts)
+		if err != nil {
+			return nil, err
+		}
+		if pullRef, err = selectPullRef(ctx, resolver, source, pullOpts.digestFallback); err != nil {
+			log.G(ctx).WithError(err).WithField("ref", source).Error("failed to resolve image")
+			return nil, err
+		}
+	}
+	var publisher events.Publisher
+	if emitPullEvents {
+		publisher = client.EventService()
+	}
+	publishPullEvent(ctx, publisher, pullStartedTopic, &pullEvent{Ref: source})
+	img, err = pullImage(ctx, pullRef, client, pullOpts)
+	if err == nil && pullRef != source {
+		// Name the image pulled by digest after its tag, as if the tag had resolved
+		if err = tagImage(ctx, pullRef, source, client); err == nil {
+			img, err = getImage(ctx, client, source, matcher)
+		}
+	}
+	var pulled digest.Digest
+	if err == nil {
+		pulled = img.Target().Digest
+	}
+	publishPullResult(ctx, publisher, source, pulled, err)
+	auditLog.recordImage(auditPull, "", source, img, err)
+	return img, err
+}
+
+// getImage returns the image named ref in containerd's image store, for the platforms matched
+func getImage(ctx context.Context, client *containerd.Client, ref string, matcher platforms.MatchComparer) (containerd.Image, error) {
+	i, err := client.ImageService().Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return containerd.NewImageWithPlatform(client, i, matcher), nil
 }
 
 // pullImage pulls an image from the specified source.
-func pullImage(ctx context.Context, source string, client *containerd.Client, registryConfigPath string, labels map[string]string) (containerd.Image, error) {
+func pullImage(ctx context.Context, source string, client *containerd.Client, pullOpts pullOptions) (containerd.Image, error) {
 	// Handle registry config
-	var registryConfig *RegistryConfig
-	if registryConfigPath != "" {
-		var err error
-		registryConfig, err = NewRegistryConfig(registryConfigPath)
-		if err != nil {
-			log.G(ctx).
-				WithError(err).
-				WithField("registry-config", registryConfigPath).
-				Error("failed to read registry config")
+	registryConfig, err := loadRegistryConfig(ctx, pullOpts.registryConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Select the platform to pull
+	matcher, err := platformMatcher(registryConfig, source, pullOpts.platform)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail fast during widespread mirror outages. Private ECR images are pulled with the ECR
+	// resolver, which doesn't use mirrors.
+	if registryMinHealthyMirrors > 0 && registryConfig != nil && !strings.HasPrefix(source, "ecr.aws/") {
+		if err := checkMirrorQuorum(ctx, registryHosts(registryConfig, nil, source), source, registryMinHealthyMirrors, probeMirrorHealth); err != nil {
+			log.G(ctx).WithError(err).WithField("ref", source).Error("too few registry mirrors are healthy")
 			return nil, err
 		}
 	}
 
-	// Pull the image
-	// Retry with exponential backoff when failures occur, maximum retry duration will not exceed 31 seconds
-	const maxRetryAttempts = 5
-	const intervalMultiplier = 2
-	const maxRetryInterval = 30 * time.Second
-	const jitterPeakAmplitude = 4000
-	const jitterLowerBound = 2000
-	var retryInterval = 1 * time.Second
+	// Pull the image, retrying with exponential backoff when failures occur
+	var retryInterval = initialRetryInterval
+	var rng = rand.New(rand.NewSource(time.Now().UnixNano()))
 	var retryAttempts = 0
+	var budget = retryBudget{maxAttempts: maxRetryAttempts, maxElapsed: pullOpts.retryMaxElapsed}
+	var retryLimit = budget.maxAttempts
+	var pullStart = time.Now()
 	var img containerd.Image
 	for {
 		var err error
 
+		if retryAttempts == 0 {
+			notifyState(ctx, "STATUS=Pulling "+source)
+		} else {
+			notifyState(ctx, fmt.Sprintf("STATUS=Pulling %s (retry %d of %d)", source, retryAttempts, retryLimit))
+		}
+
+		// Count the content reused from the content store for each attempt
+		stats := &pullStats{}
+		pullOpts.endpointAttempts = newEndpointAttempts()
+		if pullOpts.traceRequests {
+			pullOpts.requestTimings = &requestTimings{}
+		}
 		//nolint:staticcheck // We will re-evaluate the deprecated WithSchema1Conversion
-		pullOpts := []containerd.RemoteOpt{
-			withDynamicResolver(ctx, source, registryConfig),
+		remoteOpts := []containerd.RemoteOpt{
+			withManifestSizeLimit(withLocalCache(withInlineContent(withManifestCache(withDynamicResolver(ctx, source, registryConfig, pullOpts), registryManifestCache)), localCache), registryMaxManifestSize),
 			containerd.WithSchema1Conversion,
+			containerd.WithPlatformMatcher(matcher),
+			containerd.WithImageHandler(stats.handler(client.ContentStore())),
+			containerd.WithImageHandlerWrapper(withAllowedManifestTypes(client.ContentStore(), pullOpts.manifestTypes)),
 		}
 
-		if len(labels) != 0 {
-			pullOpts = append(pullOpts, containerd.WithPullLabels(labels))
+		if len(pullOpts.labels) != 0 {
+			remoteOpts = append(remoteOpts, containerd.WithPullLabels(pullOpts.labels))
 		}
 
-		img, err = client.Pull(ctx, source, pullOpts...)
+		// Mirrors can't be verified for private ECR images since they are pulled with the ECR resolver
+		if pullOpts.verifyMirrorDigest && registryConfig != nil && !strings.HasPrefix(source, "ecr.aws/") {
+			err = verifyMirrorDigest(ctx, registryHosts(registryConfig, nil, source), source, registryHeaders(pullOpts))
+			var mismatch *mirrorDigestMismatchError
+			if errors.As(err, &mismatch) {
+				if pullOpts.mirrorStaleAction != mirrorStaleWarn {
+					log.G(ctx).WithError(err).WithField("ref", source).Error("registry mirror failed verification")
+					return nil, err
+				}
+				err = nil
+			}
+		}
+		if err == nil {
+			img, err = client.Pull(ctx, source, remoteOpts...)
+			// Pulling the same manifest again won't change its type
+			var typeErr *manifestTypeError
+			if errors.As(err, &typeErr) {
+				log.G(ctx).WithError(err).WithField("ref", source).Error("refusing to pull disallowed artifact")
+				return nil, err
+			}
+		}
 
 		if err == nil {
-			log.G(ctx).WithField("img", img.Name()).Info("pulled image successfully")
+			downloaded, reused := stats.bytes()
+			entry := log.G(ctx)
+			if pullOpts.requestTimings != nil {
+				entry = entry.WithFields(pullOpts.requestTimings.fields())
+			}
+			entry.
+				WithField("img", img.Name()).
+				WithField("bytes_downloaded", downloaded).
+				WithField("bytes_reused", reused).
+				Info("pulled image successfully")
 			break
 		}
-		if retryAttempts >= maxRetryAttempts {
-			return nil, errors.Wrap(err, "retries exhausted")
+		// Retrying is pointless while TLS handshakes stall on entropy, so wait for it first
+		if reason := entropyDiagnostic(err, crngReady); reason != "" {
+			log.G(ctx).WithError(err).Warn(reason)
+			waitForEntropy(ctx)
+		}
+		measureSkew := func() (time.Duration, error) {
+			return registryClockSkew(ctx, configuredRegistryHosts(registryConfig, source), source)
 		}
-		// Add a random jitter between 2 - 6 seconds to the retry interval
-		retryIntervalWithJitter := retryInterval + time.Duration(rand.Int31n(jitterPeakAmplitude))*time.Millisecond + jitterLowerBound*time.Millisecond
+		if reason := clockSkewDiagnostic(err, measureSkew, pullOpts.clockSkewTolerance); reason != "" {
+			log.G(ctx).WithError(err).Warn(reason)
+		}
+		attemptBudget := pullRetryBudget(budget, err, pullOpts.retrySubstrings)
+		retryLimit = attemptBudget.maxAttempts
+		if reason := attemptBudget.exhausted(retryAttempts, time.Since(pullStart)); reason != "" {
+			return nil, errors.Wrap(pullOpts.endpointAttempts.wrap(err), reason)
+		}
+		// Add a random jitter to the retry interval
+		retryIntervalWithJitter := budget.clamp(retryDelay(retryInterval, pullOpts.retryJitter, rng), time.Since(pullStart))
 		log.G(ctx).WithError(err).Warnf("failed to pull image. waiting %s before retrying...", retryIntervalWithJitter)
 		timer := time.NewTimer(retryIntervalWithJitter)
 		select {
@@ -1155,12 +2553,99 @@ func pullImage(ctx context.Context, source string, client *containerd.Client, re
 		}
 	}
 
+	requested := pullOpts.platform
+	if requested == "" {
+		requested = platforms.DefaultString()
+	}
+	if err := checkSinglePlatformImage(ctx, client.ContentStore(), img.Name(), img.Target(), matcher, requested); err != nil {
+		return nil, err
+	}
+
+	if pullOpts.verifyTotalSize {
+		if err := verifyTotalSize(ctx, client.ContentStore(), img.Name(), img.Target(), matcher); err != nil {
+			log.G(ctx).WithError(err).WithField("img", img.Name()).Error("image size verification failed")
+			return nil, err
+		}
+	}
+
+	if pullOpts.imageKeyring != "" {
+		if err := verifyImage(ctx, client, img, registryConfig, pullOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := unpackImage(ctx, img, pullOpts); err != nil {
+		return nil, err
+	}
+
+	if pullOpts.validateWhiteouts && !pullOpts.noUnpack {
+		if err := validateWhiteouts(ctx, client, img, matcher); err != nil {
+			return nil, err
+		}
+	}
+
+	if pullOpts.validateXattrs && !pullOpts.noUnpack {
+		if err := validateXattrs(ctx, client, img, matcher); err != nil {
+			return nil, err
+		}
+	}
+
+	if pullOpts.inventoryFile != "" {
+		if err := writeInventory(ctx, client, img, matcher, pullOpts.inventoryFormat, pullOpts.inventoryFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if pullOpts.fetchReferrers {
+		// Private ECR images are pulled with the ECR resolver, which doesn't support the referrers API
+		if strings.HasPrefix(source, "ecr.aws/") {
+			log.G(ctx).WithField("ref", source).Warn("fetching referrers isn't supported for private ECR images")
+		} else if err := pullReferrers(ctx, client, img, registryConfig, pullOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	return img, nil
+}
+
+// unpackImage unpacks the pulled image into the default snapshotter, unless unpacking is disabled
+func unpackImage(ctx context.Context, img containerd.Image, pullOpts pullOptions) error {
+	if pullOpts.noUnpack {
+		log.G(ctx).WithField("img", img.Name()).Info("skipping image unpack")
+		return nil
+	}
 	log.G(ctx).WithField("img", img.Name()).Info("unpacking image...")
 	if err := img.Unpack(ctx, containerd.DefaultSnapshotter); err != nil {
-		return nil, errors.Wrap(err, "failed to unpack image")
+		return errors.Wrap(err, "failed to unpack image")
 	}
+	return nil
+}
 
-	return img, nil
+// loadRegistryConfig reads the registry config at the given path, if a path is provided, and adds
+// the registry credentials stored in Secrets Manager to it
+func loadRegistryConfig(ctx context.Context, registryConfigPath string) (*RegistryConfig, error) {
+	if registryConfigPath == "" && registrySecret == nil {
+		return nil, nil
+	}
+	registryConfig := &RegistryConfig{}
+	if registryConfigPath != "" {
+		var err error
+		registryConfig, err = NewRegistryConfig(registryConfigPath)
+		if err != nil {
+			log.G(ctx).
+				WithError(err).
+				WithField("registry-config", registryConfigPath).
+				Error("failed to read registry config")
+			return nil, err
+		}
+	}
+	if registrySecret != nil {
+		if err := registrySecret.addTo(ctx, registryConfig); err != nil {
+			log.G(ctx).WithError(err).Error("failed to load registry credentials from Secrets Manager")
+			return nil, err
+		}
+	}
+	return registryConfig, nil
 }
 
 // tagImage adds a tag to the image in containerd's metadata storage.
@@ -1196,13 +2681,28 @@ func tagImage(ctx context.Context, imageName string, newImageName string, client
 }
 
 // withDynamicResolver provides an initialized resolver for use with ref.
-func withDynamicResolver(ctx context.Context, ref string, registryConfig *RegistryConfig) containerd.RemoteOpt {
+func withDynamicResolver(ctx context.Context, ref string, registryConfig *RegistryConfig, pullOpts pullOptions) containerd.RemoteOpt {
+	headers := registryHeaders(pullOpts)
 	defaultResolver := func(_ *containerd.Client, _ *containerd.RemoteContext) error { return nil }
-	if registryConfig != nil {
+	if registryConfig != nil || len(headers) != 0 || customTransport() || pullOpts.requestTimings != nil || registryLogWarnings || registryRateLimit > 0 {
 		defaultResolver = func(_ *containerd.Client, c *containerd.RemoteContext) error {
-			resolver := docker.NewResolver(docker.ResolverOptions{
-				Hosts: registryHosts(registryConfig, nil),
-			})
+			resolverOpts := docker.ResolverOptions{
+				Headers: headers,
+			}
+			if registryConfig != nil {
+				resolverOpts.Hosts = withV2Probe(registryHosts(registryConfig, nil, ref), ref, headers)
+				if registryPickFastest {
+					resolverOpts.Hosts = withFastestMirrorFirst(resolverOpts.Hosts, mirrorLatency)
+				}
+			} else if customTransport() {
+				resolverOpts.Hosts = docker.ConfigureDefaultRegistries(docker.WithClient(&http.Client{Transport: registryTransports.transport("", 0)}))
+			} else if pullOpts.requestTimings != nil || registryLogWarnings || registryRateLimit > 0 {
+				resolverOpts.Hosts = docker.ConfigureDefaultRegistries()
+			}
+			if resolverOpts.Hosts != nil {
+				resolverOpts.Hosts = wrapRegistryHosts(resolverOpts.Hosts, pullOpts)
+			}
+			resolver := docker.NewResolver(resolverOpts)
 			c.Resolver = resolver
 			return nil
 		}
@@ -1215,7 +2715,7 @@ func withDynamicResolver(ctx context.Context, ref string, registryConfig *Regist
 	case strings.HasPrefix(ref, "ecr.aws/"):
 		return func(_ *containerd.Client, c *containerd.RemoteContext) error {
 			// Create the Amazon ECR resolver
-			resolver, err := ecr.NewResolver()
+			resolver, err := newECRResolver(pullOpts.ecrEndpoints)
 			if err != nil {
 				return errors.Wrap(err, "Failed to create ECR resolver")
 			}
@@ -1223,6 +2723,9 @@ func withDynamicResolver(ctx context.Context, ref string, registryConfig *Regist
 			c.Resolver = resolver
 			return nil
 		}
+	// The bootstrap image is pulled without TLS verification, ignoring the registry configuration
+	case isBootstrapInsecure(ref):
+		return withBootstrapInsecureResolver(ctx, ref, pullOpts)
 	// For Amazon ECR Public registries, we should try and fetch credentials before resolving the image reference
 	case strings.HasPrefix(ref, "public.ecr.aws/"):
 		// ... not if the user has specified their own registry credentials for 'public.ecr.aws'; In that case we use the default resolver.
@@ -1263,12 +2766,16 @@ func withDynamicResolver(ctx context.Context, ref string, registryConfig *Regist
 			return tokens[0], tokens[1], nil
 		})
 		authorizer := docker.NewDockerAuthorizer(authOpt)
-		resolverOpt := docker.ResolverOptions{
-			Hosts: registryHosts(registryConfig, &authorizer),
-		}
 
 		return func(_ *containerd.Client, c *containerd.RemoteContext) error {
-			resolver := docker.NewResolver(resolverOpt)
+			hosts := withV2Probe(registryHosts(registryConfig, &authorizer, ref), ref, headers)
+			if registryConfig != nil && registryPickFastest {
+				hosts = withFastestMirrorFirst(hosts, mirrorLatency)
+			}
+			resolver := docker.NewResolver(docker.ResolverOptions{
+				Hosts:   wrapRegistryHosts(hosts, pullOpts),
+				Headers: headers,
+			})
 			log.G(ctx).WithField("ref", ref).Info("pulling from ECR Public")
 			c.Resolver = resolver
 			return nil
@@ -1279,6 +2786,40 @@ func withDynamicResolver(ctx context.Context, ref string, registryConfig *Regist
 	}
 }
 
+// wrapRegistryHosts applies the rate limit, endpoint attempt recording, request tracing and
+// warning logging that every pull's registry hosts get, whichever resolver the pull uses
+func wrapRegistryHosts(hosts docker.RegistryHosts, pullOpts pullOptions) docker.RegistryHosts {
+	if registryRateLimit > 0 {
+		hosts = withRateLimit(hosts, registryRateLimiters, registryRateLimit)
+	}
+	if pullOpts.endpointAttempts != nil {
+		hosts = pullOpts.endpointAttempts.wrapHosts(hosts)
+	}
+	if pullOpts.requestTimings != nil {
+		hosts = pullOpts.requestTimings.wrapHosts(hosts)
+	}
+	if registryLogWarnings {
+		hosts = warningHosts(hosts)
+	}
+	return hosts
+}
+
+// registryHeaders returns the additional HTTP headers to send with registry requests
+func registryHeaders(pullOpts pullOptions) http.Header {
+	headers := http.Header{}
+	if pullOpts.acceptLanguage != "" {
+		headers.Set("Accept-Language", pullOpts.acceptLanguage)
+	}
+	// The resolver only sends the Accept header when resolving, blobs are fetched as usual
+	if pullOpts.accept != "" {
+		headers.Set("Accept", pullOpts.accept)
+	}
+	if pullOpts.baggage != "" {
+		headers.Set("baggage", pullOpts.baggage)
+	}
+	return headers
+}
+
 // withUnmaskedPaths sets an alternate list of masked paths, less the paths provided
 func withUnmaskedPaths(unmaskPaths []string) oci.SpecOpts {
 	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *runtimespec.Spec) error {
@@ -1297,9 +2838,24 @@ func withUnmaskedPaths(unmaskPaths []string) oci.SpecOpts {
 	}
 }
 
+// convertBaggage converts baggage in the format of "key=value" to the value of a W3C `baggage`
+// header, see https://www.w3.org/TR/baggage/. Values are percent-encoded.
+func convertBaggage(baggage []string) (string, error) {
+	var members []string
+	for _, member := range baggage {
+		key, value, ok := strings.Cut(member, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t,;=\"") {
+			return "", fmt.Errorf("invalid context baggage %q, expected `key=value`", member)
+		}
+		members = append(members, key+"="+url.PathEscape(value))
+	}
+	return strings.Join(members, ","), nil
+}
+
 // Convert label to map[string]string for containerd.WithPullLabels.
-// Label are in the format of "key=value".
-func convertLabels(labels []string) (map[string]string, error) {
+// Label are in the format of "key=value". Empty labels are skipped, or rejected if strict.
+func convertLabels(labels []string, strict bool) (map[string]string, error) {
 	labelsMap := make(map[string]string)
 	// a slice of labels is empty if no labels are provided. Then we should return an empty map.
 	if len(labels) == 0 {
@@ -1312,6 +2868,14 @@ func convertLabels(labels []string) (map[string]string, error) {
 			return labelsMap, fmt.Errorf("label key and value length (%d bytes) greater than maximum size (%d bytes)", labelLen, imageLabelMaxSize)
 		}
 
+		// An empty label would set a label with an empty key, which containerd may reject
+		if label == "" {
+			if strict {
+				return labelsMap, errors.New("label is empty, expected `key=value`")
+			}
+			continue
+		}
+
 		if strings.Contains(label, "=") {
 			labelKeyValue := strings.Split(label, "=")
 			labelsMap[labelKeyValue[0]] = labelKeyValue[1]
@@ -2,9 +2,33 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	ecrsdk "github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/awslabs/amazon-ecr-containerd-resolver/ecr"
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/reference"
 	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -141,7 +165,7 @@ func TestRegistryHosts(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			f := registryHosts(&tc.config, nil)
+			f := registryHosts(&tc.config, nil, "")
 			result, err := f(tc.host)
 			assert.NoError(t, err)
 			assert.Equal(t, tc.expected, result)
@@ -157,11 +181,757 @@ func TestBadRegistryHosts(t *testing.T) {
 				Endpoints: []string{"$#%#$$#%#$"},
 			},
 		},
-	}, nil)
+	}, nil, "")
 	_, err := f("docker.io")
 	assert.Error(t, err)
 }
 
+// Test RegistryHosts with mirrors keyed by repository path prefix
+func TestRegistryHostsPathPrefix(t *testing.T) {
+	config := RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"*": {
+				Endpoints: []string{"wildcard.example.com"},
+			},
+			"docker.io": {
+				Endpoints: []string{"docker-mirror.example.com"},
+			},
+			"docker.io/library/*": {
+				Endpoints: []string{"public-cache.example.com"},
+			},
+			"docker.io/library/special": {
+				Endpoints: []string{"special-cache.example.com"},
+			},
+			"docker.io/myorg": {
+				Endpoints: []string{"private-cache.example.com"},
+			},
+		},
+	}
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			"Prefix with wildcard suffix",
+			"docker.io/library/busybox:latest",
+			"public-cache.example.com",
+		},
+		{
+			"Most specific prefix wins",
+			"docker.io/library/special:latest",
+			"special-cache.example.com",
+		},
+		{
+			"Most specific prefix wins for nested repositories",
+			"docker.io/library/special/nested:latest",
+			"special-cache.example.com",
+		},
+		{
+			"Prefix without wildcard suffix",
+			"docker.io/myorg/app:v1.0.0",
+			"private-cache.example.com",
+		},
+		{
+			"Prefix only matches whole path components",
+			"docker.io/myorganization/app:latest",
+			"docker-mirror.example.com",
+		},
+		{
+			"Host mirror when no prefix matches",
+			"docker.io/other/app:latest",
+			"docker-mirror.example.com",
+		},
+		{
+			"* mirror when neither prefix nor host matches",
+			"weird.io/library/busybox:latest",
+			"wildcard.example.com",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := reference.Parse(tc.ref)
+			assert.NoError(t, err)
+			result, err := registryHosts(&config, nil, tc.ref)(spec.Hostname())
+			assert.NoError(t, err)
+			// The mirror endpoint is tried first, followed by the upstream registry
+			assert.Len(t, result, 2)
+			assert.Equal(t, tc.expected, result[0].Host)
+		})
+	}
+}
+
+func TestRegistryHostsRegex(t *testing.T) {
+	config := RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"*": {
+				Endpoints: []string{"wildcard.example.com"},
+			},
+			"registry.internal": {
+				Endpoints: []string{"exact-mirror.example.com"},
+			},
+			`re:^.*\.internal$`: {
+				Endpoints: []string{"internal-mirror.example.com"},
+			},
+			`re:^build[0-9]+\.`: {
+				Endpoints: []string{"build-mirror.example.com"},
+			},
+			"team.internal/app/*": {
+				Endpoints: []string{"app-cache.example.com"},
+			},
+		},
+	}
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{
+			"Regex hit",
+			"images.internal/team/app:latest",
+			"internal-mirror.example.com",
+		},
+		{
+			"Regex miss falls back to the * mirror",
+			"images.internal.example.com/team/app:latest",
+			"wildcard.example.com",
+		},
+		{
+			"Exact host wins over regex",
+			"registry.internal/team/app:latest",
+			"exact-mirror.example.com",
+		},
+		{
+			"Path prefix wins over regex",
+			"team.internal/app/server:latest",
+			"app-cache.example.com",
+		},
+		{
+			"First regex in lexical order wins",
+			"build1.internal/team/app:latest",
+			"internal-mirror.example.com",
+		},
+		{
+			"Other regex hit",
+			"build7.example.com/team/app:latest",
+			"build-mirror.example.com",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := reference.Parse(tc.ref)
+			assert.NoError(t, err)
+			result, err := registryHosts(&config, nil, tc.ref)(spec.Hostname())
+			assert.NoError(t, err)
+			assert.Len(t, result, 2)
+			assert.Equal(t, tc.expected, result[0].Host)
+		})
+	}
+}
+
+func TestInvalidMirrorRegex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.toml")
+	assert.NoError(t, os.WriteFile(path, []byte("[mirrors.\"re:^(unclosed\"]\nendpoints = [\"mirror.example.com\"]\n"), 0o644))
+	_, err := NewRegistryConfig(path)
+	assert.ErrorContains(t, err, "invalid mirror regular expression")
+}
+
+func TestRegistryCredentialPathPrefix(t *testing.T) {
+	config := RegistryConfig{
+		Credentials: map[string]Credential{
+			"registry.example.com":                {Username: "host", Password: "secret"},
+			"registry.example.com/team-a/*":       {Username: "team-a", Password: "secret"},
+			"registry.example.com/team-a/special": {Username: "team-a-special", Password: "secret"},
+			"registry.example.com/team-b":         {Username: "team-b", Password: "secret"},
+		},
+	}
+	tests := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{"Prefix with wildcard suffix", "registry.example.com/team-a/app:latest", "team-a"},
+		{"Overlapping prefixes, most specific wins", "registry.example.com/team-a/special:latest", "team-a-special"},
+		{"Overlapping prefixes, nested repository", "registry.example.com/team-a/special/nested:latest", "team-a-special"},
+		{"Disjoint prefix", "registry.example.com/team-b/app:latest", "team-b"},
+		{"Prefix only matches whole path components", "registry.example.com/team-bc/app:latest", "host"},
+		{"Host credential when no prefix matches", "registry.example.com/other/app:latest", "host"},
+	}
+
+	// A registry that only accepts basic authentication and records the user
+	var users []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		users = append(users, user)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	config.Mirrors = map[string]Mirror{"*": {Endpoints: []string{server.URL}}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := reference.Parse(tc.ref)
+			assert.NoError(t, err)
+			credential, ok := config.credential("registry.example.com", spec.Locator)
+			assert.True(t, ok)
+			assert.Equal(t, tc.expected, credential.Username)
+
+			// The registry sees the scoped credential
+			users = nil
+			registries, err := registryHosts(&config, nil, tc.ref)(spec.Hostname())
+			assert.NoError(t, err)
+			resolver := docker.NewResolver(docker.ResolverOptions{
+				Hosts: func(string) ([]docker.RegistryHost, error) { return registries[:1], nil },
+			})
+			_, _, err = resolver.Resolve(context.TODO(), tc.ref)
+			assert.Error(t, err)
+			assert.NotEmpty(t, users)
+			for _, user := range users {
+				assert.Equal(t, tc.expected, user)
+			}
+		})
+	}
+
+	_, ok := config.credential("other.example.com", "other.example.com/team-a/app")
+	assert.False(t, ok)
+}
+
+func TestVerifyMirrorDigest(t *testing.T) {
+	platform := platforms.DefaultSpec()
+	upstream := newFakeRegistry(t)
+	upstreamManifest, _ := upstream.addImage(t, platform, []byte("upstream layer"))
+	upstream.tag("bottlerocket/container", "latest", upstreamManifest)
+
+	goodMirror := newFakeRegistry(t)
+	goodMirror.tag("bottlerocket/container", "latest", goodMirror.addJSON(t, ocispec.MediaTypeImageManifest, json.RawMessage(upstream.blobs[upstreamManifest.Digest])))
+
+	badMirror := newFakeRegistry(t)
+	badManifest, _ := badMirror.addImage(t, platform, []byte("some other layer"))
+	badMirror.tag("bottlerocket/container", "latest", badManifest)
+
+	emptyMirror := newFakeRegistry(t)
+
+	ref := "registry.example.com/bottlerocket/container:latest"
+	hostsFor := func(registries ...*fakeRegistry) docker.RegistryHosts {
+		return func(string) ([]docker.RegistryHost, error) {
+			var hosts []docker.RegistryHost
+			for _, r := range registries {
+				hosts = append(hosts, r.registryHost())
+			}
+			return hosts, nil
+		}
+	}
+
+	t.Run("Mirror matches upstream", func(t *testing.T) {
+		assert.NoError(t, verifyMirrorDigest(context.TODO(), hostsFor(goodMirror, upstream), ref, nil))
+	})
+	t.Run("Mirror without the image is skipped", func(t *testing.T) {
+		assert.NoError(t, verifyMirrorDigest(context.TODO(), hostsFor(emptyMirror, goodMirror, upstream), ref, nil))
+	})
+	t.Run("No mirrors", func(t *testing.T) {
+		assert.NoError(t, verifyMirrorDigest(context.TODO(), hostsFor(upstream), ref, nil))
+	})
+	t.Run("Mirror mismatches upstream", func(t *testing.T) {
+		err := verifyMirrorDigest(context.TODO(), hostsFor(goodMirror, badMirror, upstream), ref, nil)
+		var mismatch *mirrorDigestMismatchError
+		assert.ErrorAs(t, err, &mismatch)
+		assert.Equal(t, badMirror.registryHost().Host, mismatch.Mirror)
+		assert.Equal(t, badManifest.Digest, mismatch.MirrorDigest)
+		assert.Equal(t, upstreamManifest.Digest, mismatch.UpstreamDigest)
+	})
+	t.Run("Upstream can't be resolved", func(t *testing.T) {
+		err := verifyMirrorDigest(context.TODO(), hostsFor(goodMirror, emptyMirror), ref, nil)
+		assert.Error(t, err)
+		var mismatch *mirrorDigestMismatchError
+		assert.False(t, errors.As(err, &mismatch))
+	})
+}
+
+func TestVerifyMirrorDigestReportsStaleMirror(t *testing.T) {
+	platform := platforms.DefaultSpec()
+	upstream := newFakeRegistry(t)
+	oldManifest, _ := upstream.addImage(t, platform, []byte("v1 layer"))
+	currentManifest, _ := upstream.addImage(t, platform, []byte("v2 layer"))
+	upstream.tag("bottlerocket/container", "latest", currentManifest)
+
+	// The mirror still has the tag cached from before it moved upstream
+	staleMirror := newFakeRegistry(t)
+	staleMirror.tag("bottlerocket/container", "latest", staleMirror.addJSON(t, ocispec.MediaTypeImageManifest, json.RawMessage(upstream.blobs[oldManifest.Digest])))
+
+	hook := test.NewLocal(log.L.Logger)
+	defer hook.Reset()
+
+	ref := "registry.example.com/bottlerocket/container:latest"
+	hosts := func(string) ([]docker.RegistryHost, error) {
+		return []docker.RegistryHost{staleMirror.registryHost(), upstream.registryHost()}, nil
+	}
+	err := verifyMirrorDigest(context.TODO(), hosts, ref, nil)
+	var mismatch *mirrorDigestMismatchError
+	assert.ErrorAs(t, err, &mismatch)
+
+	var events []*logrus.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["event"] == mirrorStaleEvent {
+			events = append(events, entry)
+		}
+	}
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, logrus.WarnLevel, events[0].Level)
+		assert.Equal(t, ref, events[0].Data["ref"])
+		assert.Equal(t, staleMirror.registryHost().Host, events[0].Data["mirror"])
+		assert.Equal(t, oldManifest.Digest, events[0].Data["stale-digest"])
+		assert.Equal(t, currentManifest.Digest, events[0].Data["current-digest"])
+	}
+}
+
+func TestCheckMirrorStaleAction(t *testing.T) {
+	assert.NoError(t, checkMirrorStaleAction("fail"))
+	assert.NoError(t, checkMirrorStaleAction("warn"))
+	assert.Error(t, checkMirrorStaleAction("ignore"))
+}
+
+func TestIsLoopbackEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		expected bool
+	}{
+		{"localhost", true},
+		{"LOCALHOST", true},
+		{"localhost.localdomain", true},
+		{"127.0.0.1", true},
+		{"127.0.0.2", true},
+		{"127.255.255.254", true},
+		{"::1", true},
+		{"[::1]", true},
+		{"localhost:5000", false},
+		{"127.0.0.1:5000", false},
+		{"[::1]:5000", false},
+		{"128.0.0.1", false},
+		{"::2", false},
+		{"localhost.example.com", false},
+		{"registry.localdomain", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.endpoint, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isLoopbackEndpoint(tc.endpoint))
+		})
+	}
+}
+
+func TestDisableHTTP2(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	defer func(disabled bool) { registryHTTP2Disabled = disabled }(registryHTTP2Disabled)
+	registryHTTP2Disabled = true
+
+	transport := newTransport()
+	assert.False(t, transport.ForceAttemptHTTP2)
+	assert.NotNil(t, transport.TLSNextProto)
+	assert.Empty(t, transport.TLSNextProto)
+
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		proto, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1", string(proto))
+	}
+
+	// Every registry host uses the HTTP/1.1 transport rather than the default client
+	assert.True(t, customTransport())
+	registries, err := registryHosts(&RegistryConfig{}, nil, "")("docker.io")
+	assert.NoError(t, err)
+	for _, registry := range registries {
+		assert.NotNil(t, registry.Client)
+	}
+}
+
+func TestMinTLSVersion(t *testing.T) {
+	defer func(version uint16) { registryMinTLSVersion = version }(registryMinTLSVersion)
+	version, err := parseTLSVersion("1.3")
+	assert.NoError(t, err)
+	registryMinTLSVersion = version
+	_, err = parseTLSVersion("1.4")
+	assert.Error(t, err)
+
+	config := &RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"legacy.example.com": {Endpoints: []string{"https://legacy-mirror.example.com"}, MinTLSVersion: "1.2"},
+			"bad.example.com":    {Endpoints: []string{"https://bad-mirror.example.com"}, MinTLSVersion: "TLSv1.2"},
+		},
+	}
+	minVersions := func(host string) map[string]uint16 {
+		registries, err := registryHosts(config, nil, "")(host)
+		assert.NoError(t, err)
+		versions := map[string]uint16{}
+		for _, registry := range registries {
+			versions[registry.Host] = registry.Client.Transport.(*http.Transport).TLSClientConfig.MinVersion
+		}
+		return versions
+	}
+	// The configured minimum applies to every registry host
+	assert.Equal(t, map[string]uint16{"registry-1.docker.io": tls.VersionTLS13}, minVersions("docker.io"))
+	// The mirror's override only applies to its endpoints
+	assert.Equal(t, map[string]uint16{
+		"legacy-mirror.example.com": tls.VersionTLS12,
+		"legacy.example.com":        tls.VersionTLS13,
+	}, minVersions("legacy.example.com"))
+
+	_, err = registryHosts(config, nil, "")("bad.example.com")
+	assert.Error(t, err)
+}
+
+func TestWildcardAsFallback(t *testing.T) {
+	defer func(fallback bool) { registryWildcardFallback = fallback }(registryWildcardFallback)
+	config := &RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"docker.io":         {Endpoints: []string{"https://docker-mirror.example.com"}, MinTLSVersion: "1.3"},
+			"docker.io/library": {Endpoints: []string{"https://library-mirror.example.com"}},
+			"*":                 {Endpoints: []string{"https://any-mirror.example.com", "https://docker-mirror.example.com"}},
+		},
+	}
+	tests := []struct {
+		name     string
+		fallback bool
+		host     string
+		ref      string
+		expected []string
+	}{
+		{"Host mirror", false, "docker.io", "", []string{"docker-mirror.example.com", "registry-1.docker.io"}},
+		{"Host mirror with fallback", true, "docker.io", "", []string{"docker-mirror.example.com", "any-mirror.example.com", "registry-1.docker.io"}},
+		{"Prefix mirror with fallback", true, "docker.io", "docker.io/library/alpine:latest", []string{"library-mirror.example.com", "any-mirror.example.com", "docker-mirror.example.com", "registry-1.docker.io"}},
+		{"Wildcard mirror with fallback", true, "registry.example.com", "", []string{"any-mirror.example.com", "docker-mirror.example.com", "registry.example.com"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			registryWildcardFallback = tc.fallback
+			registries, err := registryHosts(config, nil, tc.ref)(tc.host)
+			assert.NoError(t, err)
+			var hosts []string
+			for _, registry := range registries {
+				hosts = append(hosts, registry.Host)
+			}
+			assert.Equal(t, tc.expected, hosts)
+		})
+	}
+
+	// The wildcard mirror's endpoints don't take on the settings of the more specific mirror
+	registryWildcardFallback = true
+	registries, err := registryHosts(config, nil, "")("docker.io")
+	assert.NoError(t, err)
+	assert.NotNil(t, registries[0].Client)
+	assert.Nil(t, registries[1].Client)
+}
+
+func TestV2Probe(t *testing.T) {
+	// A static mirror without the distribution API
+	static := httptest.NewServer(http.NotFoundHandler())
+	defer static.Close()
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "amd64"})
+	registry.tag("bottlerocket/container", "latest", manifest)
+
+	config := &RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"*": {Endpoints: []string{static.URL, registry.URL}},
+		},
+	}
+	ref := "registry.example.com/bottlerocket/container:latest"
+	hosts := withV2Probe(registryHosts(config, nil, ref), ref, nil)
+	registries, err := hosts("registry.example.com")
+	assert.NoError(t, err)
+	var hostnames []string
+	for _, r := range registries {
+		hostnames = append(hostnames, r.Host)
+	}
+	assert.Equal(t, []string{registry.registryHost().Host, "registry.example.com"}, hostnames)
+
+	_, desc, err := docker.NewResolver(docker.ResolverOptions{Hosts: hosts}).Resolve(context.TODO(), ref)
+	assert.NoError(t, err)
+	assert.Equal(t, manifest.Digest, desc.Digest)
+}
+
+func TestV2ProbeCached(t *testing.T) {
+	// Mirrors that never respond are probed at once, and each mirror only once
+	var probes atomic.Int32
+	blackhole := func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		<-r.Context().Done()
+	}
+	first := httptest.NewServer(http.HandlerFunc(blackhole))
+	defer first.Close()
+	second := httptest.NewServer(http.HandlerFunc(blackhole))
+	defer second.Close()
+	config := &RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"*": {Endpoints: []string{first.URL, second.URL}},
+		},
+	}
+	ref := "registry.example.com/bottlerocket/container:latest"
+	hosts := withV2Probe(registryHosts(config, nil, ref), ref, nil)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		registries, err := hosts("registry.example.com")
+		assert.NoError(t, err)
+		// Mirrors that don't respond are left to the resolver
+		assert.Len(t, registries, 3)
+	}
+	assert.Less(t, time.Since(start), 2*v2ProbeTimeout)
+	assert.Equal(t, int32(2), probes.Load())
+}
+
+func TestV2ProbeRepositoryScope(t *testing.T) {
+	tests := []struct {
+		name             string
+		repository       string
+		authorizedStatus int
+		expected         bool
+		authorized       bool
+	}{
+		{"scoped token", "bottlerocket/container", http.StatusOK, true, true},
+		{"no API behind authorization", "bottlerocket/container", http.StatusNotFound, false, true},
+		{"unknown repository", "", http.StatusOK, true, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			authorized := false
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/token" {
+					// The token server only issues tokens scoped to a repository
+					if r.URL.Query().Get("scope") != "repository:bottlerocket/container:pull" {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					fmt.Fprint(w, `{"token":"scoped"}`)
+					return
+				}
+				if r.Header.Get("Authorization") != "Bearer scoped" {
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, server.URL))
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				authorized = true
+				w.WriteHeader(tc.authorizedStatus)
+			}))
+			defer server.Close()
+
+			config := &RegistryConfig{
+				Mirrors: map[string]Mirror{
+					"*": {Endpoints: []string{server.URL}},
+				},
+			}
+			registries, err := registryHosts(config, nil, "")("registry.example.com")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, hasV2API(registries[0], tc.repository, nil))
+			assert.Equal(t, tc.authorized, authorized)
+		})
+	}
+}
+
+func TestUnreachableMirrorEndpoints(t *testing.T) {
+	// Mirror endpoints that refuse connections
+	var unreachable []string
+	for i := 0; i < 2; i++ {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		unreachable = append(unreachable, server.URL)
+	}
+	registry := newFakeRegistry(t)
+	layer := []byte("layer")
+	manifest, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "amd64"}, layer)
+	registry.tag("bottlerocket/container", "latest", manifest)
+
+	config := &RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"*": {Endpoints: append(unreachable, registry.URL)},
+		},
+	}
+	ref := "registry.example.com/bottlerocket/container:latest"
+	resolver := docker.NewResolver(docker.ResolverOptions{Hosts: registryHosts(config, nil, ref)})
+	_, desc, err := resolver.Resolve(context.TODO(), ref)
+	assert.NoError(t, err)
+	assert.Equal(t, manifest.Digest, desc.Digest)
+
+	// Content is fetched from the reachable endpoint within the same attempt as well
+	fetcher, err := resolver.Fetcher(context.TODO(), ref)
+	assert.NoError(t, err)
+	rc, err := fetcher.Fetch(context.TODO(), ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(layer),
+		Size:      int64(len(layer)),
+	})
+	assert.NoError(t, err)
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.Equal(t, layer, content)
+}
+
+func TestRegistryHostsLoopback(t *testing.T) {
+	config := RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"docker.io": {
+				Endpoints: []string{"::1", "127.0.0.2", "localhost.localdomain"},
+			},
+		},
+	}
+	registries, err := registryHosts(&config, nil, "")("docker.io")
+	assert.NoError(t, err)
+	var hosts []string
+	for _, registry := range registries {
+		hosts = append(hosts, registry.Scheme+"://"+registry.Host)
+	}
+	assert.Equal(t, []string{
+		"http://[::1]",
+		"http://127.0.0.2",
+		"http://localhost.localdomain",
+		"https://registry-1.docker.io",
+	}, hosts)
+}
+
+func TestInferredSchemeWarning(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		scheme   string
+		warn     bool
+	}{
+		{"https for public hostname", "registry.example.com", "https", false},
+		{"https for public hostname with port", "registry.example.com:5000", "https", false},
+		{"https for public IP", "198.158.0.1", "https", false},
+		{"https for private IPv4", "10.0.0.5", "https", true},
+		{"https for private IPv4 with port", "192.168.1.10:5000", "https", true},
+		{"https for private IPv6", "[fd00::1]:5000", "https", true},
+		{"https for link-local IPv4", "169.254.0.10", "https", true},
+		{"https for loopback", "127.0.0.2", "https", false},
+		{"http for localhost", "localhost", "http", false},
+		{"http for loopback IPv4", "127.0.0.1", "http", false},
+		{"http for loopback IPv6", "::1", "http", false},
+		{"http for local hostname", "registry.internal", "http", false},
+		{"http for single label hostname", "registry:5000", "http", false},
+		{"http for private IP", "10.0.0.5", "http", false},
+		{"http for public hostname", "registry.example.com", "http", true},
+		{"http for public hostname with port", "registry.example.com:5000", "http", true},
+		{"http for public IP", "198.158.0.1", "http", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reason := inferredSchemeWarning(tc.endpoint, tc.scheme)
+			if tc.warn {
+				assert.NotEmpty(t, reason)
+			} else {
+				assert.Empty(t, reason)
+			}
+		})
+	}
+}
+
+func TestPlatformMatcher(t *testing.T) {
+	config := RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"arm.example.com": {
+				Endpoints:       []string{"mirror.example.com"},
+				DefaultPlatform: "linux/arm64",
+			},
+			"docker.io": {
+				Endpoints: []string{"mirror.example.com"},
+			},
+		},
+	}
+	tests := []struct {
+		name        string
+		config      *RegistryConfig
+		ref         string
+		platform    string
+		expectedErr bool
+		expected    string
+	}{
+		{
+			"Mirror default platform",
+			&config,
+			"arm.example.com/my_image:latest",
+			"",
+			false,
+			"linux/arm64",
+		},
+		{
+			"Requested platform overrides mirror default platform",
+			&config,
+			"arm.example.com/my_image:latest",
+			"linux/amd64",
+			false,
+			"linux/amd64",
+		},
+		{
+			"Mirror without default platform",
+			&config,
+			"docker.io/library/busybox:latest",
+			"",
+			false,
+			platforms.DefaultString(),
+		},
+		{
+			"Unconfigured registry falls back to * mirror default platform",
+			&RegistryConfig{
+				Mirrors: map[string]Mirror{
+					"*": {DefaultPlatform: "linux/arm64"},
+				},
+			},
+			"weird.io/my_image:latest",
+			"",
+			false,
+			"linux/arm64",
+		},
+		{
+			"No registry config",
+			nil,
+			"arm.example.com/my_image:latest",
+			"",
+			false,
+			platforms.DefaultString(),
+		},
+		{
+			"Invalid platform",
+			&config,
+			"arm.example.com/my_image:latest",
+			"not/a/valid/platform",
+			true,
+			"",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			matcher, err := platformMatcher(tc.config, tc.ref, tc.platform)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, matcher.Match(platforms.MustParse(tc.expected)))
+			assert.False(t, matcher.Match(platforms.MustParse("windows/s390x")))
+		})
+	}
+}
+
 func TestParseImageURIAsECR(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -213,6 +983,40 @@ func TestParseImageURIAsECR(t *testing.T) {
 				Fips:     true,
 			},
 		},
+		{
+			"Parse explicit HTTPS port",
+			"777777777777.dkr.ecr.us-west-2.amazonaws.com:443/my_image:latest",
+			false,
+			&parsedECR{
+				Account:  "777777777777",
+				Region:   "us-west-2",
+				RepoPath: "my_image:latest",
+				Fips:     false,
+			},
+		},
+		{
+			"Parse other ports",
+			"777777777777.dkr.ecr-fips.us-west-2.amazonaws.com:8443/bottlerocket/container:1.2.3",
+			false,
+			&parsedECR{
+				Account:  "777777777777",
+				Region:   "us-west-2",
+				RepoPath: "bottlerocket/container:1.2.3",
+				Fips:     true,
+			},
+		},
+		{
+			"Fail for invalid port",
+			"777777777777.dkr.ecr.us-west-2.amazonaws.com:https/my_image:latest",
+			true,
+			nil,
+		},
+		{
+			"Fail for out of range port",
+			"777777777777.dkr.ecr.us-west-2.amazonaws.com:70000/my_image:latest",
+			true,
+			nil,
+		},
 		{
 			"Fail for no region",
 			"111111111111.dkr.ecr..amazonaws.com/bottlerocket/container:1.2.3",
@@ -229,7 +1033,7 @@ func TestParseImageURIAsECR(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			result, err := parseImageURIAsECR(tc.ecrImgURI)
+			result, err := parseImageURIAsECR(tc.ecrImgURI, false)
 			if tc.expectedErr {
 				// handle error cases
 				if err == nil {
@@ -243,6 +1047,224 @@ func TestParseImageURIAsECR(t *testing.T) {
 	}
 }
 
+func TestParseImageURIAsECRTagless(t *testing.T) {
+	tests := []struct {
+		name             string
+		ecrImgURI        string
+		requireTag       bool
+		expectedErr      bool
+		expectedRepoPath string
+	}{
+		{
+			"Tagless URI defaults to latest",
+			"777777777777.dkr.ecr.us-west-2.amazonaws.com/my_image",
+			false,
+			false,
+			"my_image:latest",
+		},
+		{
+			"Tagless nested repository defaults to latest",
+			"777777777777.dkr.ecr.us-west-2.amazonaws.com/bottlerocket/my_image",
+			false,
+			false,
+			"bottlerocket/my_image:latest",
+		},
+		{
+			"Tagged URI is unchanged",
+			"777777777777.dkr.ecr.us-west-2.amazonaws.com/bottlerocket/my_image:v1.0.0",
+			true,
+			false,
+			"bottlerocket/my_image:v1.0.0",
+		},
+		{
+			"Digest URI is unchanged",
+			"777777777777.dkr.ecr.us-west-2.amazonaws.com/my_image@sha256:3b4f5f7b1b1e1c1a1b0c8e0f5d8f7e6c5b4a39281706f5e4d3c2b1a098765432",
+			true,
+			false,
+			"my_image@sha256:3b4f5f7b1b1e1c1a1b0c8e0f5d8f7e6c5b4a39281706f5e4d3c2b1a098765432",
+		},
+		{
+			"Tagless URI fails when a tag is required",
+			"777777777777.dkr.ecr.us-west-2.amazonaws.com/my_image",
+			true,
+			true,
+			"",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := parseImageURIAsECR(tc.ecrImgURI, tc.requireTag)
+			if tc.expectedErr {
+				assert.ErrorIs(t, err, errMissingImageTag)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedRepoPath, result.RepoPath)
+		})
+	}
+}
+
+func TestFetchECRRefPort(t *testing.T) {
+	specialRegions := specialRegions{
+		FipsSupportedEcrRegions: map[string]bool{"us-west-2": true},
+		EcrRefPrefixMappings: map[string]string{
+			"eu-isoe-west-1": "ecr.aws/arn:aws-iso-e:ecr:eu-isoe-west-1:",
+		},
+	}
+	tests := []struct {
+		ecrImgURI   string
+		expectedErr bool
+		expectedRef string
+	}{
+		{"777777777777.dkr.ecr.us-west-2.amazonaws.com:443/bottlerocket/container:1.2.3", false, "ecr.aws/arn:aws:ecr:us-west-2:777777777777:repository/bottlerocket/container:1.2.3"},
+		{"777777777777.dkr.ecr.us-west-2.amazonaws.com:8443/bottlerocket/container", false, "ecr.aws/arn:aws:ecr:us-west-2:777777777777:repository/bottlerocket/container:latest"},
+		{"777777777777.dkr.ecr-fips.us-west-2.amazonaws.com:443/bottlerocket/container:1.2.3", false, "ecr.aws/arn:aws:ecr-fips:us-west-2:777777777777:repository/bottlerocket/container:1.2.3"},
+		{"777777777777.dkr.ecr.eu-isoe-west-1.cloud.adc-e.uk:443/bottlerocket/container:1.2.3", false, "ecr.aws/arn:aws-iso-e:ecr:eu-isoe-west-1:777777777777:repository/bottlerocket/container:1.2.3"},
+		{"777777777777.dkr.ecr.us-west-2.amazonaws.com:0/bottlerocket/container:1.2.3", true, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.ecrImgURI, func(t *testing.T) {
+			result, err := fetchECRRef(context.TODO(), tc.ecrImgURI, specialRegions, false)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedRef, result.Canonical())
+		})
+	}
+}
+
+func TestFetchECRRefTagless(t *testing.T) {
+	specialRegions := specialRegions{
+		FipsSupportedEcrRegions: map[string]bool{"us-west-2": true},
+		EcrRefPrefixMappings: map[string]string{
+			"eu-isoe-west-1": "ecr.aws/arn:aws-iso-e:ecr:eu-isoe-west-1:",
+		},
+	}
+	tests := []struct {
+		name        string
+		ecrImgURI   string
+		requireTag  bool
+		expectedErr bool
+		expectedRef string
+	}{
+		{
+			"Typical region defaults to latest",
+			"111111111111.dkr.ecr.us-west-2.amazonaws.com/bottlerocket/container",
+			false,
+			false,
+			"ecr.aws/arn:aws:ecr:us-west-2:111111111111:repository/bottlerocket/container:latest",
+		},
+		{
+			"Special region defaults to latest",
+			"111111111111.dkr.ecr.eu-isoe-west-1.cloud.adc-e.uk/bottlerocket/container",
+			false,
+			false,
+			"ecr.aws/arn:aws-iso-e:ecr:eu-isoe-west-1:111111111111:repository/bottlerocket/container:latest",
+		},
+		{
+			"FIPS region defaults to latest",
+			"111111111111.dkr.ecr-fips.us-west-2.amazonaws.com/bottlerocket/container",
+			false,
+			false,
+			"ecr.aws/arn:aws:ecr-fips:us-west-2:111111111111:repository/bottlerocket/container:latest",
+		},
+		{
+			"Typical region fails when a tag is required",
+			"111111111111.dkr.ecr.us-west-2.amazonaws.com/bottlerocket/container",
+			true,
+			true,
+			"",
+		},
+		{
+			"Special region fails when a tag is required",
+			"111111111111.dkr.ecr.eu-isoe-west-1.cloud.adc-e.uk/bottlerocket/container",
+			true,
+			true,
+			"",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := fetchECRRef(context.TODO(), tc.ecrImgURI, specialRegions, tc.requireTag)
+			if tc.expectedErr {
+				assert.ErrorIs(t, err, errMissingImageTag)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedRef, result.Canonical())
+		})
+	}
+}
+
+func TestWithECRPartition(t *testing.T) {
+	tests := []struct {
+		name        string
+		ecrImgURI   string
+		partition   string
+		expectedErr bool
+		expectedRef string
+	}{
+		{
+			"No override",
+			"111111111111.dkr.ecr.us-west-2.amazonaws.com/bottlerocket/container:v1",
+			"",
+			false,
+			"ecr.aws/arn:aws:ecr:us-west-2:111111111111:repository/bottlerocket/container:v1",
+		},
+		{
+			"Same partition",
+			"111111111111.dkr.ecr.us-west-2.amazonaws.com/bottlerocket/container:v1",
+			"aws",
+			false,
+			"ecr.aws/arn:aws:ecr:us-west-2:111111111111:repository/bottlerocket/container:v1",
+		},
+		{
+			"Different partition",
+			"111111111111.dkr.ecr.us-west-2.amazonaws.com/bottlerocket/container:v1",
+			"aws-us-gov",
+			false,
+			"ecr.aws/arn:aws-us-gov:ecr:us-west-2:111111111111:repository/bottlerocket/container:v1",
+		},
+		{
+			"Different partition with digest",
+			"111111111111.dkr.ecr.us-west-2.amazonaws.com/bottlerocket/container@sha256:1ed3a1f8b0a3b0e1e1fa2f79ba0f2d36a1d3dcf2b3f4b0c6ffae7b2b7d5e7c3a",
+			"aws-cn",
+			false,
+			"ecr.aws/arn:aws-cn:ecr:us-west-2:111111111111:repository/bottlerocket/container@sha256:1ed3a1f8b0a3b0e1e1fa2f79ba0f2d36a1d3dcf2b3f4b0c6ffae7b2b7d5e7c3a",
+		},
+		{
+			"FIPS endpoint keeps the service",
+			"111111111111.dkr.ecr-fips.us-west-2.amazonaws.com/bottlerocket/container:v1",
+			"aws-us-gov",
+			false,
+			"ecr.aws/arn:aws-us-gov:ecr-fips:us-west-2:111111111111:repository/bottlerocket/container:v1",
+		},
+		{
+			"Invalid partition",
+			"111111111111.dkr.ecr.us-west-2.amazonaws.com/bottlerocket/container:v1",
+			"gcp",
+			true,
+			"",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := parseECRSource(context.TODO(), tc.ecrImgURI, pullOptions{ecrPartition: tc.partition})
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedRef, result.Canonical())
+		})
+	}
+}
+
 func TestFetchECRRef(t *testing.T) {
 	specialRegions := specialRegions{
 		FipsSupportedEcrRegions: map[string]bool{
@@ -323,7 +1345,7 @@ func TestFetchECRRef(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			result, err := fetchECRRef(context.TODO(), tc.ecrImgURI, specialRegions)
+			result, err := fetchECRRef(context.TODO(), tc.ecrImgURI, specialRegions, false)
 			if tc.expectedErr {
 				// handle error cases
 				if err == nil {
@@ -337,6 +1359,137 @@ func TestFetchECRRef(t *testing.T) {
 	}
 }
 
+func TestMapECRError(t *testing.T) {
+	spec, err := ecr.ParseImageURI("111111111111.dkr.ecr.us-west-2.amazonaws.com/bottlerocket/container:1.2.3")
+	assert.NoError(t, err)
+
+	t.Run("Repository not found", func(t *testing.T) {
+		apiErr := awserr.New(ecrsdk.ErrCodeRepositoryNotFoundException, "The repository does not exist", nil)
+		// Mimic the wrapping done by containerd and the retry loop
+		pullErr := errors.Wrap(fmt.Errorf("failed to resolve reference %q: %w", spec.Canonical(), apiErr), "retries exhausted")
+
+		mapped := mapECRError(pullErr, spec)
+		var notFound *ecrRepositoryNotFoundError
+		assert.ErrorAs(t, mapped, &notFound)
+		assert.Equal(t, "111111111111", notFound.Account)
+		assert.Equal(t, "us-west-2", notFound.Region)
+		assert.Equal(t, "bottlerocket/container", notFound.Repository)
+		assert.Contains(t, mapped.Error(), "111111111111")
+		assert.Contains(t, mapped.Error(), "us-west-2")
+		assert.Contains(t, mapped.Error(), `"bottlerocket/container"`)
+		assert.ErrorIs(t, mapped, apiErr)
+	})
+
+	t.Run("Other errors are unchanged", func(t *testing.T) {
+		pullErr := errors.Wrap(awserr.New(ecrsdk.ErrCodeImageNotFoundException, "The image does not exist", nil), "retries exhausted")
+		assert.Equal(t, pullErr, mapECRError(pullErr, spec))
+	})
+}
+
+func TestRegistryHeaders(t *testing.T) {
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, platforms.DefaultSpec())
+	registry.tag("bottlerocket/container", "latest", manifest)
+	ref := "registry.example.com/bottlerocket/container:latest"
+
+	remoteCtx := &containerd.RemoteContext{}
+	baggage, err := convertBaggage([]string{"tenant=team a", "trace.id=4bf92f3577b34da6"})
+	assert.NoError(t, err)
+	opt := withDynamicResolver(context.TODO(), ref, registry.mirrorConfig(), pullOptions{acceptLanguage: "en-US", baggage: baggage})
+	assert.NoError(t, opt(nil, remoteCtx))
+	_, err = fetchImageConfig(context.TODO(), remoteCtx.Resolver, ref, platforms.Default())
+	assert.NoError(t, err)
+
+	requests := registry.received()
+	assert.NotEmpty(t, requests)
+	for _, request := range requests {
+		assert.Equal(t, "en-US", request.Header.Get("Accept-Language"), "%s %s", request.Method, request.Path)
+		assert.Equal(t, "tenant=team%20a,trace.id=4bf92f3577b34da6", request.Header.Get("baggage"), "%s %s", request.Method, request.Path)
+	}
+}
+
+func TestRegistryAcceptHeader(t *testing.T) {
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, platforms.DefaultSpec())
+	registry.tag("bottlerocket/container", "latest", manifest)
+	ref := "registry.example.com/bottlerocket/container:latest"
+
+	accept := "application/vnd.oci.image.manifest.v1+json; artifactType=application/vnd.cncf.helm.config.v1+json"
+	remoteCtx := &containerd.RemoteContext{}
+	opt := withDynamicResolver(context.TODO(), ref, registry.mirrorConfig(), pullOptions{accept: accept})
+	assert.NoError(t, opt(nil, remoteCtx))
+	_, err := fetchImageConfig(context.TODO(), remoteCtx.Resolver, ref, platforms.Default())
+	assert.NoError(t, err)
+
+	var resolved bool
+	for _, request := range registry.received() {
+		if strings.HasSuffix(request.Path, "/manifests/latest") {
+			resolved = true
+			assert.Equal(t, []string{accept}, request.Header.Values("Accept"), "%s %s", request.Method, request.Path)
+		} else if strings.Contains(request.Path, "/blobs/") {
+			assert.NotEqual(t, accept, request.Header.Get("Accept"), "%s %s", request.Method, request.Path)
+		}
+	}
+	assert.True(t, resolved)
+}
+
+func TestConvertBaggage(t *testing.T) {
+	tests := []struct {
+		name     string
+		baggage  []string
+		expected string
+		fails    bool
+	}{
+		{"None", nil, "", false},
+		{"Single", []string{"tenant=bottlerocket"}, "tenant=bottlerocket", false},
+		{"Multiple in order", []string{"z=1", "a=2"}, "z=1,a=2", false},
+		{"Encoded value", []string{"path=a,b c"}, "path=a%2Cb%20c", false},
+		{"Empty value", []string{"flag="}, "flag=", false},
+		{"Missing value", []string{"tenant"}, "", true},
+		{"Missing key", []string{"=value"}, "", true},
+		{"Invalid key", []string{"a,b=value"}, "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			baggage, err := convertBaggage(tc.baggage)
+			if tc.fails {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, baggage)
+		})
+	}
+}
+
+// fakeImage records the snapshotters an image is unpacked into
+type fakeImage struct {
+	containerd.Image
+	unpacked []string
+}
+
+func (i *fakeImage) Name() string {
+	return "registry.example.com/bottlerocket/container:latest"
+}
+
+func (i *fakeImage) Unpack(_ context.Context, snapshotter string, _ ...containerd.UnpackOpt) error {
+	i.unpacked = append(i.unpacked, snapshotter)
+	return nil
+}
+
+func TestUnpackImage(t *testing.T) {
+	t.Run("Unpack", func(t *testing.T) {
+		img := &fakeImage{}
+		assert.NoError(t, unpackImage(context.TODO(), img, pullOptions{}))
+		assert.Equal(t, []string{containerd.DefaultSnapshotter}, img.unpacked)
+	})
+	t.Run("No unpack", func(t *testing.T) {
+		img := &fakeImage{}
+		assert.NoError(t, unpackImage(context.TODO(), img, pullOptions{noUnpack: true}))
+		assert.Empty(t, img.unpacked)
+	})
+}
+
 func TestConvertLabel(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -364,7 +1517,15 @@ func TestConvertLabel(t *testing.T) {
 			"Empty labels",
 			[]string{""},
 			false,
-			map[string]string{"": ""},
+			map[string]string{},
+		},
+		{
+			"Empty label among labels",
+			[]string{"io.cri-containerd.pinned=pinned", ""},
+			false,
+			map[string]string{
+				"io.cri-containerd.pinned": "pinned",
+			},
 		},
 		{
 			"Valid multiple labels",
@@ -397,7 +1558,7 @@ func TestConvertLabel(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			result, err := convertLabels(tc.labels)
+			result, err := convertLabels(tc.labels, false)
 			if tc.expectedErr {
 				// handle error cases
 				if err == nil {
@@ -410,3 +1571,36 @@ func TestConvertLabel(t *testing.T) {
 		})
 	}
 }
+
+func TestConvertLabelStrict(t *testing.T) {
+	_, err := convertLabels([]string{"io.cri-containerd.pinned=pinned", ""}, true)
+	assert.Error(t, err)
+
+	result, err := convertLabels([]string{"io.cri-containerd.pinned=pinned", "io.cri-containerd.test="}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"io.cri-containerd.pinned": "pinned", "io.cri-containerd.test": ""}, result)
+}
+
+func TestWrapRegistryHosts(t *testing.T) {
+	defer func(limit float64, warnings bool) { registryRateLimit, registryLogWarnings = limit, warnings }(registryRateLimit, registryLogWarnings)
+	registryRateLimit, registryLogWarnings = 10, true
+
+	hosts := func(host string) ([]docker.RegistryHost, error) {
+		return []docker.RegistryHost{{Host: host}}, nil
+	}
+	pullOpts := pullOptions{endpointAttempts: newEndpointAttempts(), requestTimings: &requestTimings{}}
+	registries, err := wrapRegistryHosts(hosts, pullOpts)("public.ecr.aws")
+	assert.NoError(t, err)
+	assert.Len(t, registries, 1)
+
+	// Every wrapper wraps the transport of the one before it
+	warning, ok := registries[0].Client.Transport.(*warningTransport)
+	assert.True(t, ok)
+	tracing, ok := warning.RoundTripper.(*tracingTransport)
+	assert.True(t, ok)
+	recording, ok := tracing.RoundTripper.(*recordingTransport)
+	assert.True(t, ok)
+	limited, ok := recording.RoundTripper.(*rateLimitedTransport)
+	assert.True(t, ok)
+	assert.Equal(t, http.DefaultTransport, limited.RoundTripper)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/remotes"
+	remoteerrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/containerd/log"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// The cache of resolved manifests used when the registry fails, set up from the command line
+var registryManifestCache *manifestCache
+
+// manifestCacheEntry is a manifest descriptor an image reference was resolved to
+type manifestCacheEntry struct {
+	Name     string             `json:"name"`
+	Target   ocispec.Descriptor `json:"target"`
+	Resolved time.Time          `json:"resolved"`
+}
+
+// manifestCache records the manifest each image reference was last resolved to in a file, so
+// pulls can fall back to it when the registry fails with a server error. Only the descriptor is
+// cached, the manifest and the image content are read from the content store as usual.
+type manifestCache struct {
+	path string
+	// How long a resolved manifest may be used for after it was resolved
+	ttl time.Duration
+	now func() time.Time
+
+	mu sync.Mutex
+}
+
+// newManifestCache sets up the manifest cache in the file at path
+func newManifestCache(path string, ttl time.Duration) *manifestCache {
+	return &manifestCache{path: path, ttl: ttl, now: time.Now}
+}
+
+// load reads the cached manifests, keyed by image reference. A missing cache file is empty.
+func (m *manifestCache) load() (map[string]manifestCacheEntry, error) {
+	entries := map[string]manifestCacheEntry{}
+	raw, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read manifest cache %s", m.path)
+	}
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse manifest cache %s", m.path)
+	}
+	return entries, nil
+}
+
+// lock takes an exclusive lock shared with other host-ctr processes using the same cache. The
+// lock is on a file next to the cache, since writes replace the cache file. The returned function
+// releases the lock.
+func (m *manifestCache) lock() (func(), error) {
+	f, err := os.OpenFile(m.path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open manifest cache lock %s.lock", m.path)
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "failed to lock manifest cache %s", m.path)
+	}
+	// Closing the file releases the lock
+	return func() { f.Close() }, nil
+}
+
+// store records the manifest ref was resolved to
+func (m *manifestCache) store(ref string, name string, target ocispec.Descriptor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	unlock, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := m.load()
+	if err != nil {
+		return err
+	}
+	entries[ref] = manifestCacheEntry{Name: name, Target: target, Resolved: m.now()}
+	raw, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.path, raw, 0o600)
+}
+
+// lookup returns the manifest ref was last resolved to, if it was resolved within the TTL
+func (m *manifestCache) lookup(ref string) (manifestCacheEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries, err := m.load()
+	if err != nil {
+		log.L.WithError(err).Warn("failed to read manifest cache")
+		return manifestCacheEntry{}, false
+	}
+	entry, ok := entries[ref]
+	if !ok || m.now().Sub(entry.Resolved) > m.ttl {
+		return manifestCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// isServerError returns whether err is a registry response with a 5xx status
+func isServerError(err error) bool {
+	var status remoteerrors.ErrUnexpectedStatus
+	return errors.As(err, &status) && status.StatusCode >= http.StatusInternalServerError
+}
+
+// manifestCacheResolver records resolved manifests in the cache, and resolves references to the
+// cached manifest when the registry fails with a server error
+type manifestCacheResolver struct {
+	remotes.Resolver
+	cache *manifestCache
+}
+
+func (r manifestCacheResolver) Resolve(ctx context.Context, ref string) (string, ocispec.Descriptor, error) {
+	name, desc, err := r.Resolver.Resolve(ctx, ref)
+	if err == nil {
+		if err := r.cache.store(ref, name, desc); err != nil {
+			log.G(ctx).WithError(err).WithField("ref", ref).Warn("failed to record resolved manifest in manifest cache")
+		}
+		return name, desc, nil
+	}
+	if !isServerError(err) {
+		return name, desc, err
+	}
+	entry, ok := r.cache.lookup(ref)
+	if !ok {
+		return name, desc, err
+	}
+	log.G(ctx).WithError(err).
+		WithField("ref", ref).
+		WithField("digest", entry.Target.Digest).
+		WithField("resolved", entry.Resolved).
+		Warn("registry failed, using cached manifest")
+	return entry.Name, entry.Target, nil
+}
+
+// withManifestCache wraps the resolver set up by opt so that references resolve to the cached
+// manifest when the registry fails. Nothing is cached if cache is nil.
+func withManifestCache(opt containerd.RemoteOpt, cache *manifestCache) containerd.RemoteOpt {
+	return func(client *containerd.Client, c *containerd.RemoteContext) error {
+		if err := opt(client, c); err != nil {
+			return err
+		}
+		if cache != nil {
+			c.Resolver = manifestCacheResolver{c.Resolver, cache}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestCacheResolver(t *testing.T) {
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, platforms.DefaultSpec())
+	registry.tag("bottlerocket/container", "latest", manifest)
+
+	// The registry fails with a server error while it's down
+	var down atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		registry.serveHTTP(w, req)
+	}))
+	t.Cleanup(server.Close)
+	hosts := func(string) ([]docker.RegistryHost, error) {
+		return []docker.RegistryHost{{
+			Host:         strings.TrimPrefix(server.URL, "http://"),
+			Scheme:       "http",
+			Path:         "/v2",
+			Capabilities: docker.HostCapabilityResolve | docker.HostCapabilityPull,
+		}}, nil
+	}
+
+	const ref = "registry.example.com/bottlerocket/container:latest"
+	tests := []struct {
+		name        string
+		age         time.Duration
+		ref         string
+		expectCache bool
+	}{
+		{"Fresh cache is used", time.Hour, ref, true},
+		{"Expired cache isn't used", 25 * time.Hour, ref, false},
+		{"Uncached reference", time.Hour, "registry.example.com/bottlerocket/container:other", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			down.Store(false)
+			now := time.Unix(1700000000, 0)
+			path := filepath.Join(t.TempDir(), "manifests.json")
+			cache := newManifestCache(path, 24*time.Hour)
+			cache.now = func() time.Time { return now }
+			resolver := manifestCacheResolver{docker.NewResolver(docker.ResolverOptions{Hosts: hosts}), cache}
+			_, _, err := resolver.Resolve(context.TODO(), ref)
+			assert.NoError(t, err)
+
+			// The cache is read back from its file, as it would be after a reboot
+			down.Store(true)
+			cache = newManifestCache(path, 24*time.Hour)
+			cache.now = func() time.Time { return now.Add(tc.age) }
+			resolver = manifestCacheResolver{docker.NewResolver(docker.ResolverOptions{Hosts: hosts}), cache}
+			_, desc, err := resolver.Resolve(context.TODO(), tc.ref)
+			if !tc.expectCache {
+				assert.True(t, isServerError(err), "expected the registry's error, got %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, manifest.Digest, desc.Digest)
+		})
+	}
+}
+
+func TestManifestCacheNotUsedForClientErrors(t *testing.T) {
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, platforms.DefaultSpec())
+	registry.tag("bottlerocket/container", "latest", manifest)
+
+	const ref = "registry.example.com/bottlerocket/container:latest"
+	cache := newManifestCache(filepath.Join(t.TempDir(), "manifests.json"), 24*time.Hour)
+	resolver := manifestCacheResolver{registry.resolver(), cache}
+	_, _, err := resolver.Resolve(context.TODO(), ref)
+	assert.NoError(t, err)
+
+	// The tag was deleted, which the cache must not hide
+	registry.mu.Lock()
+	delete(registry.tags, "bottlerocket/container:latest")
+	registry.mu.Unlock()
+	_, _, err = resolver.Resolve(context.TODO(), ref)
+	assert.Error(t, err)
+}
+
+func TestManifestCacheConcurrentStores(t *testing.T) {
+	// Each cache stands in for a host-ctr process sharing the cache file, so only the file lock
+	// keeps their stores from overwriting each other's entries
+	path := filepath.Join(t.TempDir(), "manifests.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cache := newManifestCache(path, time.Hour)
+			for j := 0; j < 10; j++ {
+				ref := fmt.Sprintf("registry.example.com/bottlerocket/container:%d-%d", i, j)
+				target := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString(ref)}
+				assert.NoError(t, cache.store(ref, ref, target))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	entries, err := newManifestCache(path, time.Hour).load()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 200)
+}
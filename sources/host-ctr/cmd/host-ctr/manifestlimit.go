@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The maximum size of manifests and indexes in bytes, set up from the command line. There's no
+// limit if it isn't positive.
+var registryMaxManifestSize int64
+
+// manifestTooLargeError is returned when a manifest or index is larger than the size cap
+type manifestTooLargeError struct {
+	Digest digest.Digest
+	Max    int64
+}
+
+func (e *manifestTooLargeError) Error() string {
+	return fmt.Sprintf("manifest %s is larger than the maximum manifest size of %d bytes", e.Digest, e.Max)
+}
+
+// manifestLimitResolver returns fetchers that enforce the size cap on manifests and indexes
+type manifestLimitResolver struct {
+	remotes.Resolver
+	max int64
+}
+
+func (r manifestLimitResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := r.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return manifestLimitFetcher{fetcher, r.max}, nil
+}
+
+// manifestLimitFetcher fails fetches of manifests and indexes larger than max bytes. Registries
+// may send manifests with chunked transfer encoding and no Content-Length, or with a size that
+// doesn't match the descriptor, so the bytes actually streamed are counted.
+type manifestLimitFetcher struct {
+	remotes.Fetcher
+	max int64
+}
+
+func (f manifestLimitFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	if !images.IsManifestType(desc.MediaType) && !images.IsIndexType(desc.MediaType) {
+		return f.Fetcher.Fetch(ctx, desc)
+	}
+	if desc.Size > f.max {
+		return nil, &manifestTooLargeError{Digest: desc.Digest, Max: f.max}
+	}
+	rc, err := f.Fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	return &sizeLimitedReader{ReadCloser: rc, remaining: f.max, err: &manifestTooLargeError{Digest: desc.Digest, Max: f.max}}, nil
+}
+
+// sizeLimitedReader returns err once more than remaining bytes are read
+type sizeLimitedReader struct {
+	io.ReadCloser
+	remaining int64
+	err       error
+}
+
+func (r *sizeLimitedReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, r.err
+	}
+	// Read at most one byte past the limit, so exceeding it is detected without reading
+	// the rest of the response
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return 0, r.err
+	}
+	return n, err
+}
+
+// withManifestSizeLimit wraps the resolver set up by opt so that manifests larger than max bytes
+// are rejected. There's no limit if max isn't positive.
+func withManifestSizeLimit(opt containerd.RemoteOpt, max int64) containerd.RemoteOpt {
+	return func(client *containerd.Client, c *containerd.RemoteContext) error {
+		if err := opt(client, c); err != nil {
+			return err
+		}
+		if max > 0 {
+			c.Resolver = manifestLimitResolver{c.Resolver, max}
+		}
+		return nil
+	}
+}
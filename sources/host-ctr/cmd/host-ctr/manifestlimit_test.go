@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newChunkedRegistry starts a registry that serves content for every manifest and blob with
+// chunked transfer encoding and no Content-Length
+func newChunkedRegistry(t *testing.T, content []byte, requests *atomic.Int32) docker.RegistryHost {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		for rest := content; len(rest) > 0; {
+			n := min(256, len(rest))
+			w.Write(rest[:n])
+			w.(http.Flusher).Flush()
+			rest = rest[n:]
+		}
+	}))
+	t.Cleanup(server.Close)
+	return docker.RegistryHost{
+		Client:       server.Client(),
+		Host:         strings.TrimPrefix(server.URL, "http://"),
+		Scheme:       "http",
+		Path:         "/v2",
+		Capabilities: docker.HostCapabilityResolve | docker.HostCapabilityPull,
+	}
+}
+
+func TestManifestSizeLimit(t *testing.T) {
+	const max = 1024
+	small := bytes.Repeat([]byte("s"), 600)
+	large := bytes.Repeat([]byte("l"), 8*max)
+	tests := []struct {
+		name        string
+		content     []byte
+		desc        ocispec.Descriptor
+		expectedErr bool
+		requests    int32
+	}{
+		{
+			"Chunked manifest within the limit",
+			small,
+			ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(small), Size: int64(len(small))},
+			false,
+			1,
+		},
+		{
+			"Chunked oversized manifest with a smaller descriptor size",
+			large,
+			ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(large), Size: 512},
+			true,
+			1,
+		},
+		{
+			"Chunked oversized index",
+			large,
+			ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex, Digest: digest.FromBytes(large), Size: 512},
+			true,
+			1,
+		},
+		{
+			"Oversized descriptor isn't fetched",
+			large,
+			ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(large), Size: int64(len(large))},
+			true,
+			0,
+		},
+		{
+			"Layers aren't limited",
+			large,
+			ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(large), Size: int64(len(large))},
+			false,
+			1,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests atomic.Int32
+			host := newChunkedRegistry(t, tc.content, &requests)
+			resolver := manifestLimitResolver{
+				Resolver: docker.NewResolver(docker.ResolverOptions{
+					Hosts: func(string) ([]docker.RegistryHost, error) { return []docker.RegistryHost{host}, nil },
+				}),
+				max: max,
+			}
+			ctx := context.Background()
+			fetcher, err := resolver.Fetcher(ctx, "example.com/bottlerocket/admin:latest")
+			require.NoError(t, err)
+
+			var raw []byte
+			rc, err := fetcher.Fetch(ctx, tc.desc)
+			if err == nil {
+				raw, err = io.ReadAll(rc)
+				rc.Close()
+			}
+			if tc.expectedErr {
+				var tooLarge *manifestTooLargeError
+				assert.True(t, errors.As(err, &tooLarge), "expected a manifest too large error, got %v", err)
+				assert.LessOrEqual(t, len(raw), max)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.content, raw)
+			}
+			assert.Equal(t, tc.requests, requests.Load())
+		})
+	}
+}
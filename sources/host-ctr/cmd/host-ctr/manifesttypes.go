@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// manifestTypeError is returned when a pulled manifest's type isn't one of the allowed types
+type manifestTypeError struct {
+	Digest  digest.Digest
+	Type    string
+	Allowed []string
+}
+
+func (e *manifestTypeError) Error() string {
+	return fmt.Sprintf("manifest %s has type %q, which isn't one of the allowed manifest types %q", e.Digest, e.Type, e.Allowed)
+}
+
+// manifestType returns the type of artifact a manifest describes: its artifact type if it has
+// one, and otherwise its config's media type, which is the image config media type for images
+func manifestType(manifest ocispec.Manifest) string {
+	if manifest.ArtifactType != "" {
+		return manifest.ArtifactType
+	}
+	return manifest.Config.MediaType
+}
+
+// checkManifestType returns an error if the manifest with descriptor desc doesn't describe one of
+// the allowed types of artifact
+func checkManifestType(raw []byte, desc ocispec.Descriptor, allowed []string) error {
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return errors.Wrapf(err, "failed to unmarshal manifest %s", desc.Digest)
+	}
+	if typ := manifestType(manifest); !slices.Contains(allowed, typ) {
+		return &manifestTypeError{Digest: desc.Digest, Type: typ, Allowed: allowed}
+	}
+	return nil
+}
+
+// withAllowedManifestTypes returns an image handler wrapper that checks the type of each manifest
+// once it's fetched into store, so that none of the content of disallowed artifacts is fetched.
+// All manifests are allowed if there are no allowed types.
+func withAllowedManifestTypes(store content.Store, allowed []string) func(images.Handler) images.Handler {
+	return func(handler images.Handler) images.Handler {
+		if len(allowed) == 0 {
+			return handler
+		}
+		return images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+			children, err := handler.Handle(ctx, desc)
+			if err != nil || !images.IsManifestType(desc.MediaType) {
+				return children, err
+			}
+			raw, err := content.ReadBlob(ctx, store, desc)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read manifest %s", desc.Digest)
+			}
+			if err := checkManifestType(raw, desc, allowed); err != nil {
+				return nil, err
+			}
+			return children, nil
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestType(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest ocispec.Manifest
+		expected string
+	}{
+		{
+			"Image",
+			ocispec.Manifest{Config: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig}},
+			ocispec.MediaTypeImageConfig,
+		},
+		{
+			"Helm chart",
+			ocispec.Manifest{Config: ocispec.Descriptor{MediaType: "application/vnd.cncf.helm.config.v1+json"}},
+			"application/vnd.cncf.helm.config.v1+json",
+		},
+		{
+			"Artifact type",
+			ocispec.Manifest{ArtifactType: "application/vnd.example.sbom", Config: ocispec.DescriptorEmptyJSON},
+			"application/vnd.example.sbom",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, manifestType(tc.manifest))
+		})
+	}
+}
+
+func TestAllowedManifestTypes(t *testing.T) {
+	registry := newFakeRegistry(t)
+	imageManifest, _ := registry.addImage(t, platforms.DefaultSpec(), []byte("image layer"))
+	registry.tag("bottlerocket/admin", "latest", imageManifest)
+
+	chartConfig := registry.addBlob("application/vnd.cncf.helm.config.v1+json", []byte(`{"name":"chart"}`))
+	chartLayer := registry.addBlob("application/vnd.cncf.helm.chart.content.v1.tar+gzip", []byte("chart content"))
+	chart := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    chartConfig,
+		Layers:    []ocispec.Descriptor{chartLayer},
+	}
+	chart.SchemaVersion = 2
+	chartManifest := registry.addJSON(t, ocispec.MediaTypeImageManifest, chart)
+	registry.tag("bottlerocket/chart", "latest", chartManifest)
+
+	allowed := []string{ocispec.MediaTypeImageConfig, "application/vnd.docker.container.image.v1+json"}
+	tests := []struct {
+		name        string
+		ref         string
+		allowed     []string
+		expectedErr bool
+	}{
+		{"Allowed image manifest", "example.com/bottlerocket/admin:latest", allowed, false},
+		{"Rejected artifact manifest", "example.com/bottlerocket/chart:latest", allowed, true},
+		{"No allowed types", "example.com/bottlerocket/chart:latest", nil, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			store, err := local.NewStore(t.TempDir())
+			require.NoError(t, err)
+			resolver := registry.resolver()
+			name, desc, err := resolver.Resolve(ctx, tc.ref)
+			require.NoError(t, err)
+			fetcher, err := resolver.Fetcher(ctx, name)
+			require.NoError(t, err)
+
+			handler := images.Handlers(remotes.FetchHandler(store, fetcher), images.ChildrenHandler(store))
+			err = images.Dispatch(ctx, withAllowedManifestTypes(store, tc.allowed)(handler), nil, desc)
+
+			var typeErr *manifestTypeError
+			if tc.expectedErr {
+				require.True(t, errors.As(err, &typeErr), "expected a manifest type error, got %v", err)
+				assert.Equal(t, "application/vnd.cncf.helm.config.v1+json", typeErr.Type)
+				assert.Equal(t, chartManifest.Digest, typeErr.Digest)
+				_, err := store.Info(ctx, chartLayer.Digest)
+				assert.Error(t, err, "the rejected artifact's content shouldn't be fetched")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
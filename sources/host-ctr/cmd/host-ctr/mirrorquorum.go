@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+)
+
+// The number of registry mirrors that must pass the health probe before a pull starts, set up
+// from the command line. Zero skips the probe.
+var registryMinHealthyMirrors int
+
+// mirrorQuorumError is returned when fewer registry mirrors are healthy than required
+type mirrorQuorumError struct {
+	Ref      string
+	Healthy  int
+	Mirrors  int
+	Required int
+}
+
+func (e *mirrorQuorumError) Error() string {
+	return fmt.Sprintf("only %d of %d registry mirrors for %s are healthy, at least %d required", e.Healthy, e.Mirrors, e.Ref, e.Required)
+}
+
+// probeMirrorHealth sends a request to the registry's base `/v2/` API. The registry is healthy if
+// it responds without a server error, since the probe isn't authorized.
+func probeMirrorHealth(registry docker.RegistryHost) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorLatencyTimeout)
+	defer cancel()
+	client := registry.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s%s/", registry.Scheme, registry.Host, registry.Path), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// checkMirrorQuorum probes the registry mirrors for ref at once, and fails unless at least min of
+// them are healthy. The upstream registry, the last of the hosts, isn't a mirror.
+func checkMirrorQuorum(ctx context.Context, hosts docker.RegistryHosts, ref string, min int, probe func(docker.RegistryHost) error) error {
+	spec, err := reference.Parse(ref)
+	if err != nil {
+		return err
+	}
+	registries, err := hosts(spec.Hostname())
+	if err != nil {
+		return err
+	}
+	var mirrors []docker.RegistryHost
+	if len(registries) > 1 {
+		mirrors = registries[:len(registries)-1]
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		healthy int
+	)
+	for _, mirror := range mirrors {
+		wg.Add(1)
+		go func(mirror docker.RegistryHost) {
+			defer wg.Done()
+			if err := probe(mirror); err != nil {
+				log.G(ctx).WithError(err).WithField("host", mirror.Host).Warn("registry mirror failed the health probe")
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			healthy++
+		}(mirror)
+	}
+	wg.Wait()
+	if healthy < min {
+		return &mirrorQuorumError{Ref: ref, Healthy: healthy, Mirrors: len(mirrors), Required: min}
+	}
+	log.G(ctx).WithField("ref", ref).WithField("healthy", healthy).Debug("enough registry mirrors are healthy")
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckMirrorQuorum(t *testing.T) {
+	hosts := func(host string) ([]docker.RegistryHost, error) {
+		return []docker.RegistryHost{
+			{Host: "mirror-a.example.com"},
+			{Host: "mirror-b.example.com"},
+			{Host: "mirror-c.example.com"},
+			{Host: host},
+		}, nil
+	}
+	tests := []struct {
+		name      string
+		unhealthy []string
+		min       int
+		healthy   int
+	}{
+		{"All mirrors healthy", nil, 3, 3},
+		{"Threshold met", []string{"mirror-b.example.com"}, 2, 2},
+		{"Threshold not met", []string{"mirror-a.example.com", "mirror-c.example.com"}, 2, 1},
+		{"No mirror healthy", []string{"mirror-a.example.com", "mirror-b.example.com", "mirror-c.example.com"}, 1, 0},
+		{"More required than configured", nil, 4, 3},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			probe := func(registry docker.RegistryHost) error {
+				assert.NotEqual(t, "registry.example.com", registry.Host, "the upstream registry isn't a mirror")
+				for _, host := range tc.unhealthy {
+					if registry.Host == host {
+						return errors.New("connection refused")
+					}
+				}
+				return nil
+			}
+			err := checkMirrorQuorum(context.TODO(), hosts, "registry.example.com/bottlerocket/container:latest", tc.min, probe)
+			if tc.healthy >= tc.min {
+				assert.NoError(t, err)
+				return
+			}
+			var quorumErr *mirrorQuorumError
+			if assert.ErrorAs(t, err, &quorumErr) {
+				assert.Equal(t, tc.healthy, quorumErr.Healthy)
+				assert.Equal(t, 3, quorumErr.Mirrors)
+				assert.Equal(t, tc.min, quorumErr.Required)
+			}
+		})
+	}
+}
+
+func TestProbeMirrorHealth(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		healthy bool
+	}{
+		{"OK", http.StatusOK, true},
+		{"Unauthorized", http.StatusUnauthorized, true},
+		{"Service unavailable", http.StatusServiceUnavailable, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/v2/", r.URL.Path)
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+			err := probeMirrorHealth(docker.RegistryHost{Host: strings.TrimPrefix(server.URL, "http://"), Scheme: "http", Path: "/v2"})
+			assert.Equal(t, tc.healthy, err == nil, "unexpected probe result %v", err)
+		})
+	}
+}
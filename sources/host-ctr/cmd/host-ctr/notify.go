@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+// The time allowed to send a state update to systemd, so a stuck notify socket can't hold up pulls
+const sdNotifyTimeout = time.Second
+
+// Whether a failure to notify systemd was already logged as a warning
+var sdNotifyWarned atomic.Bool
+
+// sdNotify sends a state update such as `READY=1` or `STATUS=...` to systemd. It does nothing
+// unless host-ctr runs as a `Type=notify` unit, which is when systemd sets NOTIFY_SOCKET.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Abstract sockets are passed with a leading `@`
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Writes to datagram sockets block while the receiver's queue is full
+	if err := conn.SetWriteDeadline(time.Now().Add(sdNotifyTimeout)); err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// notifyState sends a state update to systemd, only logging failures since the state
+// updates are informational for systemd and never affect the container. Only the first
+// failure is logged as a warning, so a broken socket doesn't flood the log.
+func notifyState(ctx context.Context, state string) {
+	err := sdNotify(state)
+	if err == nil {
+		return
+	}
+	entry := log.G(ctx).WithError(err).WithField("state", state)
+	if sdNotifyWarned.CompareAndSwap(false, true) {
+		entry.Warn("failed to notify systemd")
+		return
+	}
+	entry.Debug("failed to notify systemd")
+}
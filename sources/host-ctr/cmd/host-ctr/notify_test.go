@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSdNotify(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	assert.NoError(t, sdNotify("READY=1"))
+
+	buf := make([]byte, 64)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+}
+
+func TestSdNotifyUnset(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	assert.NoError(t, sdNotify("READY=1"))
+}
+
+func TestSdNotifyMissingSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	assert.Error(t, sdNotify("READY=1"))
+}
+
+func TestSdNotifyStuckSocket(t *testing.T) {
+	// A receiver that never reads, so its queue fills up and writes block
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	var failed bool
+	for i := 0; i < 100 && !failed; i++ {
+		start := time.Now()
+		failed = sdNotify("STATUS=Pulling") != nil
+		assert.Less(t, time.Since(start), sdNotifyTimeout+time.Second)
+	}
+	assert.True(t, failed, "expected notifying to time out once the queue is full")
+}
+
+func TestNotifyStateWarnsOnce(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	sdNotifyWarned.Store(false)
+	hook := test.NewLocal(log.L.Logger)
+	defer hook.Reset()
+
+	for i := 0; i < 3; i++ {
+		notifyState(context.TODO(), "STATUS=Pulling")
+	}
+	var warnings int
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel {
+			warnings++
+		}
+	}
+	assert.Equal(t, 1, warnings)
+}
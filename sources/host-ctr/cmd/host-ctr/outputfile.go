@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// readOnlyError is returned when a file host-ctr writes is on a read-only filesystem
+type readOnlyError struct {
+	Path string
+}
+
+func (e *readOnlyError) Error() string {
+	return fmt.Sprintf("can't write %s because its filesystem is read-only", e.Path)
+}
+
+// checkReadOnly returns a readOnlyError instead of err if writing the file at path failed
+// because its filesystem is read-only, which otherwise surfaces as a generic permission error
+func checkReadOnly(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, unix.EROFS) || (errors.Is(err, fs.ErrPermission) && isReadOnlyFilesystem(filepath.Dir(path))) {
+		return &readOnlyError{Path: path}
+	}
+	return err
+}
+
+// isReadOnlyFilesystem returns whether dir, or the closest of its parents that exists, is on a
+// filesystem mounted read-only
+func isReadOnlyFilesystem(dir string) bool {
+	for {
+		var stat unix.Statfs_t
+		err := unix.Statfs(dir, &stat)
+		if err == nil {
+			return stat.Flags&unix.ST_RDONLY != 0
+		}
+		parent := filepath.Dir(dir)
+		if !errors.Is(err, unix.ENOENT) || parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// writeFileAtomic writes data to the file at path through a temporary file in the same directory
+// that's renamed into place, so readers see either the previous file or the complete new one.
+// Every file host-ctr replaces must be written this way; the audit log is only ever appended to.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return checkReadOnly(path, err)
+	}
+	// Nothing is left to remove once the temporary file is renamed
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
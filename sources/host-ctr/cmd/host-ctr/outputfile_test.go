@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// readOnlyMount mounts a read-only tmpfs in a temporary directory, which is unmounted when the
+// test completes, and skips the test if mounting isn't permitted
+func readOnlyMount(t *testing.T) string {
+	dir := t.TempDir()
+	if err := unix.Mount("tmpfs", dir, "tmpfs", unix.MS_RDONLY, ""); err != nil {
+		t.Skipf("can't mount a read-only tmpfs: %v", err)
+	}
+	t.Cleanup(func() { unix.Unmount(dir, 0) })
+	return dir
+}
+
+func TestCheckReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	other := errors.New("disk full")
+	tests := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{"No error", nil, nil},
+		{"Read-only filesystem", &fs.PathError{Op: "open", Path: path, Err: unix.EROFS}, &readOnlyError{Path: path}},
+		{"Permission denied on a writable filesystem", &fs.PathError{Op: "open", Path: path, Err: unix.EACCES}, &fs.PathError{Op: "open", Path: path, Err: unix.EACCES}},
+		{"Other error", other, other},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, checkReadOnly(path, tc.err))
+		})
+	}
+}
+
+func TestReadOnlyOutputFiles(t *testing.T) {
+	dir := readOnlyMount(t)
+	assert.True(t, isReadOnlyFilesystem(dir))
+	assert.True(t, isReadOnlyFilesystem(filepath.Join(dir, "missing", "dir")))
+
+	t.Run("Audit log", func(t *testing.T) {
+		path := filepath.Join(dir, "audit.log")
+		_, err := openAuditLog(path)
+		var readOnly *readOnlyError
+		require.True(t, errors.As(err, &readOnly), "expected a read-only error, got %v", err)
+		assert.Equal(t, path, readOnly.Path)
+		assert.Contains(t, err.Error(), "read-only")
+	})
+	t.Run("Trust state file", func(t *testing.T) {
+		path := filepath.Join(dir, "state", "tofu.json")
+		err := newTOFUStore(path).save(map[string]string{"registry.example.com": "fingerprint"})
+		var readOnly *readOnlyError
+		require.True(t, errors.As(err, &readOnly), "expected a read-only error, got %v", err)
+		assert.Equal(t, path, readOnly.Path)
+	})
+	t.Run("Permission denied", func(t *testing.T) {
+		path := filepath.Join(dir, "inventory.json")
+		err := checkReadOnly(path, &fs.PathError{Op: "open", Path: path, Err: unix.EACCES})
+		var readOnly *readOnlyError
+		assert.True(t, errors.As(err, &readOnly), "expected a read-only error, got %v", err)
+	})
+}
+
+func TestWritableOutputFile(t *testing.T) {
+	dir := t.TempDir()
+	assert.False(t, isReadOnlyFilesystem(dir))
+	path := filepath.Join(dir, "audit.log")
+	_, err := openAuditLog(path)
+	assert.NoError(t, err)
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "inventory.json")
+	require.NoError(t, writeFileAtomic(path, []byte("first"), 0o640))
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), raw)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0o640), info.Mode().Perm())
+
+	require.NoError(t, writeFileAtomic(path, []byte("second"), 0o640))
+	raw, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), raw)
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWriteFileAtomicConcurrentReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	versions := [][]byte{
+		bytes.Repeat([]byte("a"), 1<<20),
+		bytes.Repeat([]byte("b"), 1<<19),
+	}
+	require.NoError(t, writeFileAtomic(path, versions[0], 0o644))
+
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if err := writeFileAtomic(path, versions[i%2], 0o644); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		if !bytes.Equal(raw, versions[0]) && !bytes.Equal(raw, versions[1]) {
+			t.Fatalf("read a partial file of %d bytes", len(raw))
+		}
+	}
+	select {
+	case err := <-errs:
+		t.Fatal(err)
+	default:
+	}
+}
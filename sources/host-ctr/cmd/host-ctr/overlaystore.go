@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/errdefs"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// overlayStore is a content store layered over a read-only base content store. Content in the base,
+// e.g. images seeded into a golden image, is read from the base, while new content is written to
+// the writable upper content store.
+type overlayStore struct {
+	// The writable content store, which also tracks ingests
+	content.Store
+	// The read-only content store
+	base content.Store
+}
+
+// newOverlayStore returns a content store that reads from upper and base, and writes to upper
+func newOverlayStore(base content.Store, upper content.Store) *overlayStore {
+	return &overlayStore{Store: upper, base: base}
+}
+
+// Info returns the content's info from the upper content store, or from the base if it's only there
+func (s *overlayStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	info, err := s.Store.Info(ctx, dgst)
+	if errdefs.IsNotFound(err) {
+		return s.base.Info(ctx, dgst)
+	}
+	return info, err
+}
+
+// ReaderAt reads the content from the upper content store, or from the base if it's only there
+func (s *overlayStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	ra, err := s.Store.ReaderAt(ctx, desc)
+	if errdefs.IsNotFound(err) {
+		return s.base.ReaderAt(ctx, desc)
+	}
+	return ra, err
+}
+
+// Walk walks the content of both content stores, visiting content in both only once
+func (s *overlayStore) Walk(ctx context.Context, fn content.WalkFunc, filters ...string) error {
+	seen := map[digest.Digest]bool{}
+	err := s.Store.Walk(ctx, func(info content.Info) error {
+		seen[info.Digest] = true
+		return fn(info)
+	}, filters...)
+	if err != nil {
+		return err
+	}
+	return s.base.Walk(ctx, func(info content.Info) error {
+		if seen[info.Digest] {
+			return nil
+		}
+		return fn(info)
+	}, filters...)
+}
+
+// Update updates the content's info in the upper content store. Content only in the base can't be
+// updated.
+func (s *overlayStore) Update(ctx context.Context, info content.Info, fieldpaths ...string) (content.Info, error) {
+	if err := s.checkWritable(ctx, info.Digest); err != nil {
+		return content.Info{}, err
+	}
+	return s.Store.Update(ctx, info, fieldpaths...)
+}
+
+// Delete deletes the content from the upper content store. Content only in the base can't be
+// deleted.
+func (s *overlayStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	if err := s.checkWritable(ctx, dgst); err != nil {
+		return err
+	}
+	return s.Store.Delete(ctx, dgst)
+}
+
+// Writer writes new content to the upper content store. Content already in the base isn't written
+// again.
+func (s *overlayStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	var wOpts content.WriterOpts
+	for _, opt := range opts {
+		if err := opt(&wOpts); err != nil {
+			return nil, err
+		}
+	}
+	if wOpts.Desc.Digest != "" {
+		if _, err := s.base.Info(ctx, wOpts.Desc.Digest); err == nil {
+			return nil, errors.Wrapf(errdefs.ErrAlreadyExists, "content %v is in the base content store", wOpts.Desc.Digest)
+		}
+	}
+	return s.Store.Writer(ctx, opts...)
+}
+
+// checkWritable returns an error if the content is only in the read-only base content store
+func (s *overlayStore) checkWritable(ctx context.Context, dgst digest.Digest) error {
+	_, err := s.Store.Info(ctx, dgst)
+	if !errdefs.IsNotFound(err) {
+		return err
+	}
+	if _, err := s.base.Info(ctx, dgst); err == nil {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "content %v is in the read-only base content store", dgst)
+	}
+	return err
+}
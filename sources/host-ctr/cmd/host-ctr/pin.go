@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// pinImage resolves the specified image and prints the digest of the manifest for each of its
+// platforms as a JSON object, so every platform of a multi-platform image can be pinned.
+func pinImage(source string, pullOpts pullOptions) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ref, resolver, _, err := remoteResolver(ctx, source, pullOpts)
+	if err != nil {
+		return err
+	}
+	verifier, err := remoteImageVerifier(ctx, source, pullOpts)
+	if err != nil {
+		return err
+	}
+
+	var pins map[string]digest.Digest
+	err = retryRemotePull(ctx, ref, pullOpts, func() error {
+		if verifier != nil {
+			name, desc, err := resolver.Resolve(ctx, ref)
+			if err != nil {
+				return errors.Wrapf(err, "failed to resolve %q", ref)
+			}
+			if err := verifier.Verify(ctx, name, desc); err != nil {
+				return err
+			}
+		}
+		pins, err = resolvePlatformDigests(ctx, resolver, ref)
+		return err
+	})
+	if err != nil {
+		log.G(ctx).WithField("ref", ref).Error(err)
+		return err
+	}
+	out, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal pinned digests")
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// resolvePlatformDigests resolves ref and returns the digest of the manifest for each platform.
+// Manifests in an index without a platform, such as attestations, aren't included. The platform of
+// a single-platform image is read from its config.
+func resolvePlatformDigests(ctx context.Context, resolver remotes.Resolver, ref string) (map[string]digest.Digest, error) {
+	name, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve %q", ref)
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get fetcher for %q", name)
+	}
+
+	pins := map[string]digest.Digest{}
+	switch {
+	case images.IsIndexType(desc.MediaType):
+		var index ocispec.Index
+		if err := fetchJSON(ctx, fetcher, desc, &index); err != nil {
+			return nil, err
+		}
+		for _, manifest := range index.Manifests {
+			if manifest.Platform == nil {
+				continue
+			}
+			platform := platforms.Format(platforms.Normalize(*manifest.Platform))
+			// Keep the first manifest for a platform, which containerd would pull too
+			if _, ok := pins[platform]; !ok {
+				pins[platform] = manifest.Digest
+			}
+		}
+	case images.IsManifestType(desc.MediaType):
+		var manifest ocispec.Manifest
+		if err := fetchJSON(ctx, fetcher, desc, &manifest); err != nil {
+			return nil, err
+		}
+		var config ocispec.Image
+		if err := fetchJSON(ctx, fetcher, manifest.Config, &config); err != nil {
+			return nil, err
+		}
+		pins[platforms.Format(platforms.Normalize(config.Platform))] = desc.Digest
+	default:
+		return nil, errors.Errorf("unsupported media type %q for %q", desc.MediaType, name)
+	}
+	if len(pins) == 0 {
+		return nil, errors.Errorf("no platform manifests found for %q", name)
+	}
+	return pins, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolvePlatformDigests(t *testing.T) {
+	registry := newFakeRegistry(t)
+	amd64, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("amd64 layer"))
+	arm64, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, []byte("arm64 layer"))
+	attestation, _ := registry.addImage(t, ocispec.Platform{OS: "unknown", Architecture: "unknown"})
+	attestation.Platform = nil
+	index := registry.addIndex(t, amd64, arm64, attestation)
+	registry.tag("bottlerocket/container", "latest", index)
+	registry.tag("bottlerocket/container", "amd64", amd64)
+
+	pins, err := resolvePlatformDigests(context.TODO(), registry.resolver(), "registry.example.com/bottlerocket/container:latest")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]digest.Digest{
+		"linux/amd64": amd64.Digest,
+		"linux/arm64": arm64.Digest,
+	}, pins)
+
+	// The platform of single-platform images comes from the image config
+	pins, err = resolvePlatformDigests(context.TODO(), registry.resolver(), "registry.example.com/bottlerocket/container:amd64")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]digest.Digest{"linux/amd64": amd64.Digest}, pins)
+
+	_, err = resolvePlatformDigests(context.TODO(), registry.resolver(), "registry.example.com/bottlerocket/container:missing")
+	assert.Error(t, err)
+}
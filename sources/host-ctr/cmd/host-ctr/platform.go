@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// platformMismatchError is returned when an image's config declares a platform other than the one
+// requested, which usually means the image was published with the wrong platform
+type platformMismatchError struct {
+	Image     string
+	Platform  ocispec.Platform
+	Requested string
+}
+
+func (e *platformMismatchError) Error() string {
+	return fmt.Sprintf("image %s is for platform %s, which doesn't match platform %s; use --ignore-platform-mismatch to run it anyway",
+		e.Image, platforms.Format(e.Platform), e.Requested)
+}
+
+// checkImagePlatform checks the platform declared by the image config against the matcher for
+// the requested platform. Mismatches are only logged if ignoreMismatch is set.
+func checkImagePlatform(ctx context.Context, image string, platform ocispec.Platform, matcher platforms.MatchComparer, requested string, ignoreMismatch bool) error {
+	// Images that don't declare a platform can't be checked
+	if platform.OS == "" && platform.Architecture == "" {
+		return nil
+	}
+	if matcher.Match(platforms.Normalize(platform)) {
+		return nil
+	}
+	err := &platformMismatchError{Image: image, Platform: platform, Requested: requested}
+	if ignoreMismatch {
+		log.G(ctx).WithError(err).Warn("ignoring image platform mismatch")
+		return nil
+	}
+	return err
+}
+
+// verifyImagePlatform checks that the image config's platform matches the platform the image was
+// pulled for, which is the `--platform` flag, the registry mirror's default platform, or the host's
+func verifyImagePlatform(ctx context.Context, img containerd.Image, pullOpts pullOptions, ignoreMismatch bool) error {
+	spec, err := img.Spec(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read image config for %s", img.Name())
+	}
+	registryConfig, err := loadRegistryConfig(ctx, pullOpts.registryConfigPath)
+	if err != nil {
+		return err
+	}
+	matcher, err := platformMatcher(registryConfig, img.Name(), pullOpts.platform)
+	if err != nil {
+		return err
+	}
+	requested := pullOpts.platform
+	if requested == "" {
+		requested = platforms.DefaultString()
+	}
+	return checkImagePlatform(ctx, img.Name(), spec.Platform, matcher, requested, ignoreMismatch)
+}
+
+// singlePlatformMismatchError is returned when a single-platform image, a manifest rather than an
+// index, is pulled for a platform it isn't for
+type singlePlatformMismatchError struct {
+	Image     string
+	Platform  ocispec.Platform
+	Requested string
+}
+
+func (e *singlePlatformMismatchError) Error() string {
+	return fmt.Sprintf("image %s is a single-platform image for %s and has no image for the requested platform %s",
+		e.Image, platforms.Format(e.Platform), e.Requested)
+}
+
+// checkSinglePlatformImage checks that a pulled single-platform image matches the requested
+// platform. Containerd pulls such images regardless of the platform and only fails to find the
+// platform's manifest when unpacking, so the mismatch is reported with the image's actual platform
+// instead. Image indexes are left to containerd's platform matching.
+func checkSinglePlatformImage(ctx context.Context, provider content.Provider, image string, target ocispec.Descriptor, matcher platforms.MatchComparer, requested string) error {
+	if !images.IsManifestType(target.MediaType) {
+		return nil
+	}
+	raw, err := content.ReadBlob(ctx, provider, target)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read manifest for %s", image)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return errors.Wrapf(err, "failed to parse manifest for %s", image)
+	}
+	raw, err = content.ReadBlob(ctx, provider, manifest.Config)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read image config for %s", image)
+	}
+	var config ocispec.Image
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return errors.Wrapf(err, "failed to parse image config for %s", image)
+	}
+	// Images that don't declare a platform can't be checked
+	if config.OS == "" && config.Architecture == "" {
+		return nil
+	}
+	if matcher.Match(platforms.Normalize(config.Platform)) {
+		return nil
+	}
+	return &singlePlatformMismatchError{Image: image, Platform: config.Platform, Requested: requested}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckImagePlatform(t *testing.T) {
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	tests := []struct {
+		name           string
+		platform       ocispec.Platform
+		requested      ocispec.Platform
+		ignoreMismatch bool
+		expectedErr    bool
+	}{
+		{"Match", amd64, amd64, false, false},
+		{"Match with variant", ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, arm64, false, false},
+		{"Mismatch", arm64, amd64, false, true},
+		{"Mismatch ignored", arm64, amd64, true, false},
+		{"OS mismatch", ocispec.Platform{OS: "windows", Architecture: "amd64"}, amd64, false, true},
+		{"No platform in config", ocispec.Platform{}, amd64, false, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkImagePlatform(context.TODO(), "registry.example.com/bottlerocket/container:latest",
+				tc.platform, platforms.Only(tc.requested), platforms.Format(tc.requested), tc.ignoreMismatch)
+			if tc.expectedErr {
+				var mismatch *platformMismatchError
+				assert.ErrorAs(t, err, &mismatch)
+				assert.Equal(t, tc.platform, mismatch.Platform)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckSinglePlatformImage(t *testing.T) {
+	registry := newFakeRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	arm64Manifest, _ := registry.addImage(t, arm64, []byte("arm64 layer"))
+	registry.tag("bottlerocket/single", "latest", arm64Manifest)
+	amd64Manifest, _ := registry.addImage(t, amd64, []byte("amd64 layer"))
+	registry.tag("bottlerocket/index", "latest", registry.addIndex(t, amd64Manifest, arm64Manifest))
+
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		ref         string
+		requested   ocispec.Platform
+		expectedErr bool
+	}{
+		{"Single-platform match", "registry.example.com/bottlerocket/single:latest", arm64, false},
+		{"Single-platform mismatch", "registry.example.com/bottlerocket/single:latest", amd64, true},
+		{"Index", "registry.example.com/bottlerocket/index:latest", amd64, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			matcher := platforms.Only(tc.requested)
+			desc, err := fetchToContentStore(context.TODO(), registry.resolver(), tc.ref, matcher, store, nil)
+			assert.NoError(t, err)
+			err = checkSinglePlatformImage(context.TODO(), store, tc.ref, desc, matcher, platforms.Format(tc.requested))
+			if tc.expectedErr {
+				var mismatch *singlePlatformMismatchError
+				assert.ErrorAs(t, err, &mismatch)
+				assert.EqualError(t, err, "image registry.example.com/bottlerocket/single:latest is a single-platform image for linux/arm64 and has no image for the requested platform linux/amd64")
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/containerd/containerd/oci"
+	"github.com/pkg/errors"
+)
+
+// printSpec writes the container's OCI runtime spec to w as indented JSON, so the settings the
+// container runs with can be checked
+func printSpec(w io.Writer, spec *oci.Spec) error {
+	raw, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize container spec")
+	}
+	_, err = w.Write(append(raw, '\n'))
+	return err
+}
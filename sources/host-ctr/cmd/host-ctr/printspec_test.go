@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintSpec(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "default")
+	ctrOpts := containerOptions{
+		mounts:    []runtimespec.Mount{{Source: "/var/log", Destination: "/host/var/log", Type: "bind", Options: []string{"rbind", "ro"}}},
+		resources: resourceLimits{cpuQuota: 50000, memoryLimit: 256 << 20},
+	}
+	spec, err := oci.GenerateSpec(ctx, nil, &containers.Container{ID: "host-containers-admin"}, containerSpecOpts("admin", host.PersistentDir(), false, host, ctrOpts)...)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, printSpec(&out, spec))
+	var printed runtimespec.Spec
+	require.NoError(t, json.Unmarshal(out.Bytes(), &printed))
+
+	assert.Equal(t, "system_u:system_r:control_t:s0-s0:c0.c1023", printed.Process.SelinuxLabel)
+	assert.Equal(t, "system_u:object_r:secret_t:s0", printed.Linux.MountLabel)
+	assert.NotNil(t, printed.Linux.Seccomp, "expected the default seccomp profile")
+	assert.Equal(t, int64(50000), *printed.Linux.Resources.CPU.Quota)
+	assert.Equal(t, int64(256<<20), *printed.Linux.Resources.Memory.Limit)
+	// Requested mounts are made private like the default mounts
+	assert.Contains(t, printed.Mounts, runtimespec.Mount{Source: "/var/log", Destination: "/host/var/log", Type: "bind", Options: []string{"rbind", "ro", "rprivate"}})
+	assert.Contains(t, printed.Linux.Namespaces, runtimespec.LinuxNamespace{Type: runtimespec.PIDNamespace})
+	assert.NotContains(t, printed.Linux.Namespaces, runtimespec.LinuxNamespace{Type: runtimespec.NetworkNamespace})
+}
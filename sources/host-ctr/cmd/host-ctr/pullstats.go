@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// pullStats counts the bytes of an image's content that a pull downloads and the bytes it reuses
+// from the content store, to measure how effective caching content is
+type pullStats struct {
+	mu         sync.Mutex
+	downloaded int64
+	reused     int64
+}
+
+// handler returns an image handler that counts each descriptor as reused if its content is
+// already in the store, and as downloaded otherwise. It has to run ahead of the fetch handler.
+func (s *pullStats) handler(store content.Store) images.Handler {
+	return images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		_, err := store.Info(ctx, desc.Digest)
+		if err != nil && !errdefs.IsNotFound(err) {
+			return nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err == nil {
+			s.reused += desc.Size
+		} else {
+			s.downloaded += desc.Size
+		}
+		return nil, nil
+	})
+}
+
+// bytes returns the bytes downloaded and the bytes reused
+func (s *pullStats) bytes() (int64, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.downloaded, s.reused
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPullStats(t *testing.T) {
+	ctx := context.TODO()
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := func(data string) ocispec.Descriptor {
+		return ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    digest.FromString(data),
+			Size:      int64(len(data)),
+		}
+	}
+	// The base layer was pulled before, with another image
+	cached := layer("base layer")
+	assert.NoError(t, content.WriteBlob(ctx, store, "cached", strings.NewReader("base layer"), cached))
+	fresh := layer("application layer")
+
+	stats := &pullStats{}
+	_, err = images.Handlers(stats.handler(store))(ctx, cached)
+	assert.NoError(t, err)
+	_, err = images.Handlers(stats.handler(store))(ctx, fresh)
+	assert.NoError(t, err)
+
+	downloaded, reused := stats.bytes()
+	assert.Equal(t, fresh.Size, downloaded)
+	assert.Equal(t, cached.Size, reused)
+}
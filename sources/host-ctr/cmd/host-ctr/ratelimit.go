@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"golang.org/x/time/rate"
+)
+
+// The rate, in requests per second, at which requests are sent to each registry host, set up from
+// the command line. Zero leaves requests unlimited.
+var registryRateLimit float64
+
+// The limiters of requests to each registry host, shared by every pull host-ctr makes so that pulls
+// running at once, as with --all-platforms, share each host's rate instead of multiplying it
+var registryRateLimiters = &rateLimiters{}
+
+// rateLimiters hands out one request rate limiter per registry host
+type rateLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// limiter returns the limiter of requests to host, which allows the given number of requests
+// per second
+func (r *rateLimiters) limiter(host string, limit float64) *rate.Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if l, ok := r.limiters[host]; ok {
+		l.SetLimit(rate.Limit(limit))
+		return l
+	}
+	if r.limiters == nil {
+		r.limiters = map[string]*rate.Limiter{}
+	}
+	l := rate.NewLimiter(rate.Limit(limit), 1)
+	r.limiters[host] = l
+	return l
+}
+
+// withRateLimit wraps the clients of the registry hosts so that requests to each host wait for
+// the host's limiter
+func withRateLimit(hosts docker.RegistryHosts, limiters *rateLimiters, limit float64) docker.RegistryHosts {
+	return func(host string) ([]docker.RegistryHost, error) {
+		registries, err := hosts(host)
+		if err != nil {
+			return nil, err
+		}
+		for i := range registries {
+			client := http.Client{}
+			if registries[i].Client != nil {
+				client = *registries[i].Client
+			}
+			transport := client.Transport
+			if transport == nil {
+				transport = http.DefaultTransport
+			}
+			client.Transport = &rateLimitedTransport{RoundTripper: transport, limiter: limiters.limiter(registries[i].Host, limit)}
+			registries[i].Client = &client
+		}
+		return registries, nil
+	}
+}
+
+// rateLimitedTransport waits for its limiter before each request
+type rateLimitedTransport struct {
+	http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitersShareHost(t *testing.T) {
+	limiters := &rateLimiters{}
+	first := limiters.limiter("mirror.example.com", 10)
+	assert.Same(t, first, limiters.limiter("mirror.example.com", 10))
+	assert.NotSame(t, first, limiters.limiter("other.example.com", 10))
+}
+
+func TestRateLimitConcurrentPulls(t *testing.T) {
+	registry := newFakeRegistry(t)
+	platform := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	manifest, _ := registry.addImage(t, platform, []byte("layer"))
+	registry.tag("bottlerocket/container", "latest", manifest)
+
+	const limit = 50
+	limiters := &rateLimiters{}
+	hosts := func(string) ([]docker.RegistryHost, error) {
+		return []docker.RegistryHost{registry.registryHost()}, nil
+	}
+
+	// Each pull gets its own resolver, as the containers of --all-platforms do, so only the
+	// shared limiters keep them to the host's rate
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resolver := docker.NewResolver(docker.ResolverOptions{Hosts: withRateLimit(hosts, limiters, limit)})
+			_, err := fetchImageConfig(context.TODO(), resolver, "registry.example.com/bottlerocket/container:latest", platforms.Only(platform))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	requests := len(registry.received())
+	assert.Greater(t, requests, 4)
+	minimum := time.Duration(requests-1) * time.Second / limit
+	assert.GreaterOrEqual(t, elapsed, minimum, "%d requests took %s", requests, elapsed)
+}
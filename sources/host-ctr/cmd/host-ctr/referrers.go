@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// The prefix of the content labels that keep an image's referrers from being garbage collected
+const referrerGCLabelPrefix = "containerd.io/gc.ref.content.referrer."
+
+// listReferrers lists the artifacts referring to the image manifest with digest dgst, such as SBOMs
+// and attestations, through the OCI referrers API. The hosts are tried in order, and an empty list
+// is returned if none of them support the referrers API.
+func listReferrers(ctx context.Context, hosts docker.RegistryHosts, ref string, dgst digest.Digest) ([]ocispec.Descriptor, error) {
+	spec, err := reference.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	registries, err := hosts(spec.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	repository := strings.TrimPrefix(spec.Locator, spec.Hostname()+"/")
+
+	var lastErr error
+	for _, host := range registries {
+		if !host.Capabilities.Has(docker.HostCapabilityResolve) {
+			continue
+		}
+		index, err := getReferrers(ctx, host, repository, dgst)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("host", host.Host).Debug("failed to list referrers")
+			lastErr = err
+			continue
+		}
+		if index != nil {
+			return index.Manifests, nil
+		}
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, nil
+}
+
+// getReferrers requests the referrers index from a single registry host. A nil index is returned
+// if the host doesn't support the referrers API.
+func getReferrers(ctx context.Context, host docker.RegistryHost, repository string, dgst digest.Digest) (*ocispec.Index, error) {
+	url := fmt.Sprintf("%s://%s%s/%s/referrers/%s", host.Scheme, host.Host, host.Path, repository, dgst)
+	client := host.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	do := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", ocispec.MediaTypeImageIndex)
+		if host.Authorizer != nil {
+			if err := host.Authorizer.Authorize(ctx, req); err != nil {
+				return nil, err
+			}
+		}
+		return client.Do(req)
+	}
+	resp, err := do()
+	if err != nil {
+		return nil, err
+	}
+	// Authenticate with the challenge from the registry and try again
+	if resp.StatusCode == http.StatusUnauthorized && host.Authorizer != nil {
+		resp.Body.Close()
+		if err := host.Authorizer.AddResponses(ctx, []*http.Response{resp}); err != nil {
+			return nil, err
+		}
+		if resp, err = do(); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, errors.Errorf("unexpected status listing referrers from %s: %s", host.Host, resp.Status)
+	}
+	var index ocispec.Index
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&index); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode referrers from %s", host.Host)
+	}
+	return &index, nil
+}
+
+// fetchReferrers fetches the referrer manifests and their content into the content store
+func fetchReferrers(ctx context.Context, resolver remotes.Resolver, ref string, referrers []ocispec.Descriptor, store content.Ingester) error {
+	fetcher, err := resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get fetcher for %q", ref)
+	}
+	fetch := func(desc ocispec.Descriptor) ([]byte, error) {
+		raw, err := fetchBlob(ctx, fetcher, desc)
+		if err != nil {
+			return nil, err
+		}
+		if err := content.WriteBlob(ctx, store, remotes.MakeRefKey(ctx, desc), bytes.NewReader(raw), desc); err != nil {
+			return nil, errors.Wrapf(err, "failed to store %s", desc.Digest)
+		}
+		return raw, nil
+	}
+	for _, referrer := range referrers {
+		raw, err := fetch(referrer)
+		if err != nil {
+			return err
+		}
+		if !images.IsManifestType(referrer.MediaType) {
+			continue
+		}
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(raw, &manifest); err != nil {
+			return errors.Wrapf(err, "failed to unmarshal %s", referrer.Digest)
+		}
+		for _, desc := range append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...) {
+			if _, err := fetch(desc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pullReferrers fetches the artifacts referring to the pulled image into the content store, and
+// labels the image's target so that they're garbage collected along with the image
+func pullReferrers(ctx context.Context, client *containerd.Client, img containerd.Image, registryConfig *RegistryConfig, pullOpts pullOptions) error {
+	hosts := configuredRegistryHosts(registryConfig, img.Name())
+	target := img.Target()
+	referrers, err := listReferrers(ctx, hosts, img.Name(), target.Digest)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list referrers for %s", img.Name())
+	}
+	if len(referrers) == 0 {
+		log.G(ctx).WithField("img", img.Name()).Info("no referrers found for image")
+		return nil
+	}
+
+	resolver := docker.NewResolver(docker.ResolverOptions{Hosts: hosts, Headers: registryHeaders(pullOpts)})
+	store := client.ContentStore()
+	if err := fetchReferrers(ctx, resolver, img.Name(), referrers, store); err != nil {
+		return err
+	}
+
+	info := content.Info{Digest: target.Digest, Labels: map[string]string{}}
+	var fieldpaths []string
+	for i, referrer := range referrers {
+		key := fmt.Sprintf("%s%d", referrerGCLabelPrefix, i)
+		info.Labels[key] = referrer.Digest.String()
+		fieldpaths = append(fieldpaths, "labels."+key)
+	}
+	if _, err := store.Update(ctx, info, fieldpaths...); err != nil {
+		return errors.Wrapf(err, "failed to label %s with its referrers", target.Digest)
+	}
+	log.G(ctx).WithField("img", img.Name()).WithField("referrers", len(referrers)).Info("fetched image referrers")
+	return nil
+}
+
+// configuredRegistryHosts returns the registry hosts for ref from the registry config, if there is one
+func configuredRegistryHosts(registryConfig *RegistryConfig, ref string) docker.RegistryHosts {
+	if registryConfig == nil {
+		registryConfig = &RegistryConfig{}
+	}
+	return registryHosts(registryConfig, nil, ref)
+}
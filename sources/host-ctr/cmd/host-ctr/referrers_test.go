@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/remotes/docker"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListAndFetchReferrers(t *testing.T) {
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("layer"))
+	registry.tag("bottlerocket/container", "latest", manifest)
+
+	sbom := registry.addBlob("application/spdx+json", []byte(`{"spdxVersion": "SPDX-2.3"}`))
+	empty := registry.addBlob(ocispec.MediaTypeEmptyJSON, []byte("{}"))
+	artifact := ocispec.Manifest{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: "application/spdx+json",
+		Config:       empty,
+		Layers:       []ocispec.Descriptor{sbom},
+		Subject:      &manifest,
+	}
+	artifact.SchemaVersion = 2
+	referrer := registry.addJSON(t, ocispec.MediaTypeImageManifest, artifact)
+	referrer.ArtifactType = artifact.ArtifactType
+	registry.addReferrer(manifest.Digest, referrer)
+
+	ctx := context.TODO()
+	ref := "registry.example.com/bottlerocket/container:latest"
+	hosts := registryHosts(registry.mirrorConfig(), nil, ref)
+	referrers, err := listReferrers(ctx, hosts, ref, manifest.Digest)
+	assert.NoError(t, err)
+	assert.Equal(t, []ocispec.Descriptor{referrer}, referrers)
+
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, fetchReferrers(ctx, registry.resolver(), ref, referrers, store))
+	for _, desc := range []ocispec.Descriptor{referrer, empty, sbom} {
+		raw, err := content.ReadBlob(ctx, store, desc)
+		assert.NoError(t, err)
+		assert.Equal(t, desc.Size, int64(len(raw)))
+	}
+}
+
+func TestListReferrersNone(t *testing.T) {
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "amd64"})
+
+	ref := "registry.example.com/bottlerocket/container:latest"
+	referrers, err := listReferrers(context.TODO(), registryHosts(registry.mirrorConfig(), nil, ref), ref, manifest.Digest)
+	assert.NoError(t, err)
+	assert.Empty(t, referrers)
+}
+
+func TestListReferrersUnsupported(t *testing.T) {
+	// Registries without the referrers API respond with 404
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	hosts := func(string) ([]docker.RegistryHost, error) {
+		return []docker.RegistryHost{{
+			Host:         strings.TrimPrefix(server.URL, "http://"),
+			Scheme:       "http",
+			Path:         "/v2",
+			Capabilities: docker.HostCapabilityResolve | docker.HostCapabilityPull,
+		}}, nil
+	}
+	referrers, err := listReferrers(context.TODO(), hosts, "registry.example.com/bottlerocket/container:latest", "sha256:0000000000000000000000000000000000000000000000000000000000000000")
+	assert.NoError(t, err)
+	assert.Nil(t, referrers)
+}
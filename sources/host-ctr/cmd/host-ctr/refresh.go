@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/log"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// refreshImage pulls the image again on every tick until ctx is done, to keep mutable tags
+// current and caches warm on long-lived hosts. The pull is skipped while the image still
+// resolves to the current digest. Failures are logged and retried on the next tick.
+func refreshImage(ctx context.Context, source string, current digest.Digest, ticks <-chan time.Time, resolve func(context.Context) (digest.Digest, error), pull func(context.Context) (digest.Digest, error)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+		}
+		latest, err := resolve(ctx)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("ref", source).Warn("failed to resolve image to refresh it")
+			continue
+		}
+		if latest == current {
+			log.G(ctx).WithField("ref", source).WithField("digest", current).Debug("image is unchanged, skipping refresh")
+			continue
+		}
+		pulled, err := pull(ctx)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("ref", source).Warn("failed to refresh image")
+			continue
+		}
+		log.G(ctx).
+			WithField("ref", source).
+			WithField("previous", current).
+			WithField("digest", pulled).
+			Info("refreshed image to a new digest")
+		current = pulled
+	}
+}
+
+// imageUpdate is a refreshed image to swap the container to. The outcome of the swap is sent on
+// swapped, which is buffered so that it never blocks.
+type imageUpdate struct {
+	img     containerd.Image
+	swapped chan error
+}
+
+// startImageRefresh refreshes the image pulled from source every interval in the background,
+// until ctx is done. Refreshed images are sent on updated, unless it's nil, and only become
+// current once they are swapped in, so a failed swap is retried on the next tick. The returned
+// function stops the refreshes.
+func startImageRefresh(ctx context.Context, source string, img containerd.Image, client *containerd.Client, interval time.Duration, pullOpts pullOptions, updated chan<- imageUpdate) func() {
+	// Always pull, the refresh already skips images that didn't change
+	pullOpts.useCachedImage = false
+	resolve := func(ctx context.Context) (digest.Digest, error) {
+		ref, resolver, _, err := remoteResolver(ctx, source, pullOpts)
+		if err != nil {
+			return "", err
+		}
+		_, desc, err := resolver.Resolve(ctx, ref)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to resolve %q", ref)
+		}
+		return desc.Digest, nil
+	}
+	pull := func(ctx context.Context) (digest.Digest, error) {
+		var (
+			img containerd.Image
+			err error
+		)
+		if ecrRegex.MatchString(source) {
+			img, err = fetchECRImage(ctx, source, client, pullOpts)
+		} else {
+			img, err = fetchImage(ctx, source, client, pullOpts)
+		}
+		if err != nil {
+			return "", err
+		}
+		if updated != nil {
+			swapped := make(chan error, 1)
+			select {
+			case updated <- imageUpdate{img: img, swapped: swapped}:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			select {
+			case err := <-swapped:
+				if err != nil {
+					return "", errors.Wrap(err, "failed to swap container to the refreshed image")
+				}
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+		return img.Target().Digest, nil
+	}
+
+	ticker := time.NewTicker(interval)
+	go refreshImage(ctx, source, img.Target().Digest, ticker.C, resolve, pull)
+	return ticker.Stop
+}
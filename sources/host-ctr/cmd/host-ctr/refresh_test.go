@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock ticks every interval as time is advanced by the test
+type fakeClock struct {
+	interval time.Duration
+	now      time.Duration
+	next     time.Duration
+	ticks    chan time.Time
+}
+
+func newFakeClock(interval time.Duration) *fakeClock {
+	return &fakeClock{interval: interval, next: interval, ticks: make(chan time.Time)}
+}
+
+// advance moves the clock forward, ticking for every interval that passes
+func (c *fakeClock) advance(d time.Duration) {
+	c.now += d
+	for c.now >= c.next {
+		c.ticks <- time.Unix(0, 0).Add(c.next)
+		c.next += c.interval
+	}
+}
+
+func TestRefreshImage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first := digest.FromString("first")
+	second := digest.FromString("second")
+
+	clock := newFakeClock(6 * time.Hour)
+	latest := make(chan digest.Digest, 1)
+	resolved := make(chan struct{}, 1)
+	pulled := make(chan struct{}, 1)
+	resolve := func(context.Context) (digest.Digest, error) {
+		resolved <- struct{}{}
+		return <-latest, nil
+	}
+	pull := func(context.Context) (digest.Digest, error) {
+		pulled <- struct{}{}
+		return second, nil
+	}
+	go refreshImage(ctx, "registry.example.com/bottlerocket/container:latest", first, clock.ticks, resolve, pull)
+
+	waitFor := func(c chan struct{}) bool {
+		select {
+		case <-c:
+			return true
+		case <-time.After(5 * time.Second):
+			return false
+		}
+	}
+
+	// Nothing happens before the interval passes
+	clock.advance(5 * time.Hour)
+	assert.Empty(t, resolved)
+
+	// The tag still points at the same image, so it isn't pulled
+	latest <- first
+	clock.advance(time.Hour)
+	assert.True(t, waitFor(resolved))
+
+	// The tag moved, so the new image is pulled
+	latest <- second
+	clock.advance(6 * time.Hour)
+	assert.True(t, waitFor(resolved))
+	assert.True(t, waitFor(pulled))
+
+	// The pulled image is the current one from now on
+	latest <- second
+	clock.advance(6 * time.Hour)
+	assert.True(t, waitFor(resolved))
+	// Wait for the next tick to be received, so the previous refresh is done
+	latest <- second
+	clock.advance(6 * time.Hour)
+	assert.True(t, waitFor(resolved))
+	assert.Empty(t, pulled)
+}
+
+func TestRefreshImageRetriesFailedSwap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first := digest.FromString("first")
+	second := digest.FromString("second")
+
+	clock := newFakeClock(time.Hour)
+	resolve := func(context.Context) (digest.Digest, error) {
+		return second, nil
+	}
+	swaps := make(chan error, 2)
+	swaps <- errors.New("failed to start the new container")
+	swaps <- nil
+	pulled := make(chan struct{}, 3)
+	pull := func(context.Context) (digest.Digest, error) {
+		pulled <- struct{}{}
+		if err := <-swaps; err != nil {
+			return "", err
+		}
+		return second, nil
+	}
+	done := make(chan struct{})
+	go func() {
+		refreshImage(ctx, "registry.example.com/bottlerocket/container:latest", first, clock.ticks, resolve, pull)
+		close(done)
+	}()
+
+	// The swap to the new image fails, so it's still pulled and swapped on the next tick
+	clock.advance(time.Hour)
+	clock.advance(time.Hour)
+	// Once the swap succeeded, the image is current and isn't pulled again
+	clock.advance(time.Hour)
+	cancel()
+	<-done
+	assert.Len(t, pulled, 2)
+}
+
+func TestRefreshRequiresTagMutation(t *testing.T) {
+	err := App().Run([]string{"host-ctr", "run", "--source", "registry.example.com/bottlerocket/container:latest", "--container-id", "container", "--refresh-interval", "1h", "--allow-tag-mutation=false"})
+	assert.EqualError(t, err, "--refresh-interval can't be combined with --allow-tag-mutation=false")
+}
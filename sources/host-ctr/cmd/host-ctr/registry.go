@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/containerd/containerd/pkg/cri/server"
+	"github.com/containerd/containerd/reference"
 	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/pelletier/go-toml"
 	"github.com/pkg/errors"
 	runtime "k8s.io/cri-api/pkg/apis/runtime/v1"
@@ -18,6 +28,19 @@ import (
 // Mirror contains the config related to the registry mirror
 type Mirror struct {
 	Endpoints []string `toml:"endpoints,omitempty"`
+	// DefaultPlatform is the platform to pull for images from this registry
+	// when no platform is explicitly requested
+	DefaultPlatform string `toml:"default_platform,omitempty"`
+	// TrustOnFirstUse records the certificate first presented by each of the mirror's
+	// endpoints and rejects the endpoint if its certificate changes. For lab registries only.
+	TrustOnFirstUse bool `toml:"trust_on_first_use,omitempty"`
+	// MinTLSVersion overrides the minimum TLS version for the mirror's endpoints, e.g. to
+	// allow TLS 1.2 for a legacy mirror while other registries require TLS 1.3
+	MinTLSVersion string `toml:"min_tls_version,omitempty"`
+	// SRVDiscovery replaces the endpoints with the ones in the SRV records at SRVName,
+	// e.g. `_https._tcp.mirror.internal`, so the mirror pool can change without config changes
+	SRVDiscovery bool   `toml:"srv_discovery,omitempty"`
+	SRVName      string `toml:"srv_name,omitempty"`
 }
 
 // Credential contains a registry credential
@@ -32,6 +55,8 @@ type Credential struct {
 type RegistryConfig struct {
 	Mirrors     map[string]Mirror     `toml:"mirrors,omitempty"`
 	Credentials map[string]Credential `toml:"creds,omitempty"`
+	// TrustStateFile is where certificates trusted on first use are recorded
+	TrustStateFile string `toml:"trust_state_file,omitempty"`
 }
 
 // NewRegistryConfig unmarshalls a registry configuration file and sets up a RegistryConfig
@@ -42,41 +67,202 @@ func NewRegistryConfig(registryConfigFile string) (*RegistryConfig, error) {
 	}
 
 	config := RegistryConfig{}
-	return &config, toml.Unmarshal(raw, &config)
+	if err := toml.Unmarshal(raw, &config); err != nil {
+		return &config, err
+	}
+	for key := range config.Mirrors {
+		if pattern, ok := strings.CutPrefix(key, mirrorRegexPrefix); ok {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return &config, errors.Wrapf(err, "invalid mirror regular expression %q", key)
+			}
+		}
+	}
+	return &config, nil
+}
+
+// The prefix of mirror keys that are regular expressions matched against the registry host,
+// e.g. `re:^.*\.internal$`
+const mirrorRegexPrefix = "re:"
+
+// mirror returns the mirror to use for the given registry host and image repository.
+// Mirrors keyed by a repository path prefix (e.g. `docker.io/library` or `docker.io/library/*`)
+// take precedence over mirrors keyed by the registry host, with the most specific prefix winning.
+// Mirrors keyed by a regular expression matching the registry host are used if no mirror is keyed
+// by the host itself, and the `*` mirror is used if no other mirror matches. The repository may
+// be empty, in which case only the registry host is matched.
+func (registryConfig *RegistryConfig) mirror(host string, repository string) Mirror {
+	return registryConfig.Mirrors[registryConfig.mirrorKey(host, repository)]
+}
+
+// mirrorKey returns the key of the mirror to use for the given registry host and image repository,
+// as matched by mirror
+func (registryConfig *RegistryConfig) mirrorKey(host string, repository string) string {
+	if repository != "" {
+		var (
+			bestKey     string
+			bestPrefix  string
+			foundPrefix bool
+		)
+		for key := range registryConfig.Mirrors {
+			prefix := strings.TrimSuffix(key, "/*")
+			if !strings.Contains(prefix, "/") || strings.HasPrefix(key, mirrorRegexPrefix) {
+				continue
+			}
+			if repository != prefix && !strings.HasPrefix(repository, prefix+"/") {
+				continue
+			}
+			if len(prefix) > len(bestPrefix) {
+				bestKey, bestPrefix, foundPrefix = key, prefix, true
+			}
+		}
+		if foundPrefix {
+			return bestKey
+		}
+	}
+	if _, ok := registryConfig.Mirrors[host]; ok {
+		return host
+	}
+	if key, ok := registryConfig.mirrorRegexKey(host); ok {
+		return key
+	}
+	return "*"
+}
+
+// mirrorRegexKey returns the key of the first mirror, in lexical order of the keys, that is a
+// regular expression matching host. Invalid regular expressions never match.
+func (registryConfig *RegistryConfig) mirrorRegexKey(host string) (string, bool) {
+	var keys []string
+	for key := range registryConfig.Mirrors {
+		if strings.HasPrefix(key, mirrorRegexPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		re, err := regexp.Compile(strings.TrimPrefix(key, mirrorRegexPrefix))
+		if err == nil && re.MatchString(host) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// credential returns the credential to use for the given registry host and image repository.
+// Like mirrors, credentials keyed by a repository path prefix (e.g. `registry.example.com/team-a`
+// or `registry.example.com/team-a/*`) take precedence over credentials keyed by the registry host,
+// with the most specific prefix winning. The repository may be empty, in which case only the
+// registry host is matched.
+func (registryConfig *RegistryConfig) credential(host string, repository string) (Credential, bool) {
+	if repository != "" {
+		var bestKey, bestPrefix string
+		for key := range registryConfig.Credentials {
+			prefix := strings.TrimSuffix(key, "/*")
+			if !strings.Contains(prefix, "/") {
+				continue
+			}
+			if repository != prefix && !strings.HasPrefix(repository, prefix+"/") {
+				continue
+			}
+			if len(prefix) > len(bestPrefix) {
+				bestKey, bestPrefix = key, prefix
+			}
+		}
+		if bestKey != "" {
+			return registryConfig.Credentials[bestKey], true
+		}
+	}
+	credential, ok := registryConfig.Credentials[host]
+	return credential, ok
+}
+
+// mirrors returns the mirrors whose endpoints to use for the given registry host and image
+// repository, in order. With wildcard fallback, the `*` mirror follows the matching mirror.
+func (registryConfig *RegistryConfig) mirrors(host string, repository string) []Mirror {
+	key := registryConfig.mirrorKey(host, repository)
+	mirrors := []Mirror{registryConfig.Mirrors[key]}
+	if wildcard, ok := registryConfig.Mirrors["*"]; ok && registryWildcardFallback && key != "*" {
+		mirrors = append(mirrors, wildcard)
+	}
+	return mirrors
+}
+
+// Whether the `*` mirror's endpoints follow the endpoints of more specific mirrors, set up from
+// the command line
+var registryWildcardFallback bool
+
+// platformMatcher returns the platform matcher used to select the image to pull for ref.
+// An explicitly requested platform takes precedence over the default platform configured
+// for the registry's mirror, which in turn takes precedence over the host's platform.
+func platformMatcher(registryConfig *RegistryConfig, ref string, platform string) (platforms.MatchComparer, error) {
+	if platform == "" && registryConfig != nil {
+		if spec, err := reference.Parse(ref); err == nil {
+			platform = registryConfig.mirror(spec.Hostname(), spec.Locator).DefaultPlatform
+		}
+	}
+	if platform == "" {
+		return platforms.Default(), nil
+	}
+	p, err := platforms.Parse(platform)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse platform %q", platform)
+	}
+	return platforms.Only(p), nil
 }
 
 // registryHosts returns the registry hosts to be used by the resolver.
 // Heavily borrowed from containerd CRI plugin's implementation.
 // See https://github.com/containerd/containerd/blob/1407cab509ff0d96baa4f0eb6ff9980270e6e620/pkg/cri/server/image_pull.go#L332-L405
 // authorizerOverride lets the caller override the generated authorizer with a custom authorizer
+// ref is the image being pulled, used to match mirrors keyed by repository path prefix; it may be empty
 // FIXME Replace this once there's a public containerd client interface that supports registry mirrors
-func registryHosts(registryConfig *RegistryConfig, authorizerOverride *docker.Authorizer) docker.RegistryHosts {
+func registryHosts(registryConfig *RegistryConfig, authorizerOverride *docker.Authorizer, ref string) docker.RegistryHosts {
+	var repository string
+	if spec, err := reference.Parse(ref); err == nil {
+		repository = spec.Locator
+	}
+	lookups := &srvLookups{}
 	return func(host string) ([]docker.RegistryHost, error) {
 		var (
 			registries []docker.RegistryHost
 			endpoints  []string
-			authConfig runtime.AuthConfig
+			// The mirror each endpoint belongs to, nil for the upstream registry
+			endpointMirrors []*Mirror
+			authConfig      runtime.AuthConfig
 		)
-		// Set up endpoints for the registry
-		if _, ok := registryConfig.Mirrors[host]; ok {
-			endpoints = registryConfig.Mirrors[host].Endpoints
-		} else {
-			endpoints = registryConfig.Mirrors["*"].Endpoints
+		// Set up endpoints for the registry. Within a pull attempt, the resolver tries each endpoint in
+		// order and moves on to the next one when it fails to connect.
+		mirrors := registryConfig.mirrors(host, repository)
+		for i := range mirrors {
+			for _, endpoint := range mirrors[i].discoveredEndpoints(lookups) {
+				if SliceContains(endpoints, endpoint) {
+					continue
+				}
+				endpoints = append(endpoints, endpoint)
+				endpointMirrors = append(endpointMirrors, &mirrors[i])
+			}
 		}
 		defaultHost, err := docker.DefaultHost(host)
 		if err != nil {
 			return nil, errors.Wrap(err, "get default host")
 		}
 		endpoints = append(endpoints, defaultHost)
+		endpointMirrors = append(endpointMirrors, nil)
 
-		for _, endpoint := range endpoints {
+		for i, endpoint := range endpoints {
 			// Prefix the endpoint with an appropriate URL scheme if the endpoint does not have one.
 			if !strings.Contains(endpoint, "://") {
-				if endpoint == "localhost" || endpoint == "127.0.0.1" || endpoint == "::1" {
-					endpoint = "http://" + endpoint
-				} else {
-					endpoint = "https://" + endpoint
+				scheme := "https"
+				if isLoopbackEndpoint(endpoint) {
+					scheme = "http"
+				}
+				if reason := inferredSchemeWarning(endpoint, scheme); reason != "" {
+					log.L.WithField("endpoint", endpoint).WithField("scheme", scheme).Warn(reason)
 				}
+				// Bare IPv6 addresses need brackets to be parsed as a URL host
+				if ip := net.ParseIP(endpoint); ip != nil && ip.To4() == nil {
+					endpoint = "[" + endpoint + "]"
+				}
+				endpoint = scheme + "://" + endpoint
 			}
 			url, err := url.Parse(endpoint)
 			if err != nil {
@@ -85,53 +271,428 @@ func registryHosts(registryConfig *RegistryConfig, authorizerOverride *docker.Au
 			if url.Path == "" {
 				url.Path = "/v2"
 			}
+			// Trust on first use and the mirror's TLS version only ever apply to the mirror's own
+			// endpoints, not the upstream registry
+			mirror := endpointMirrors[i]
+			var mirrorTLSVersion uint16
+			if mirror != nil {
+				if mirrorTLSVersion, err = parseTLSVersion(mirror.MinTLSVersion); err != nil {
+					return nil, errors.Wrapf(err, "parse minimum TLS version of the mirror for %q", host)
+				}
+			}
+			var client *http.Client
+			if mirror != nil && mirror.TrustOnFirstUse && url.Scheme == "https" {
+				client = newTOFUStore(registryConfig.TrustStateFile).client(url.Host, mirrorTLSVersion)
+			} else if customTransport() || mirrorTLSVersion != 0 {
+				client = &http.Client{Transport: registryTransports.transport(url.Host, mirrorTLSVersion)}
+			}
+			// Tokens are requested with the endpoint's own client, so they're sent over connections
+			// held to the same TLS requirements as the endpoint's
+			authClient := client
+			if authClient == nil {
+				authClient = &http.Client{Transport: registryTransports.transport(url.Host, 0)}
+			}
 			var authorizer docker.Authorizer
 			if authorizerOverride == nil {
 				// Set up auth for pulling from registry
 				var authOpts []docker.AuthorizerOpt
-				if _, ok := registryConfig.Credentials[defaultHost]; ok {
+				if credential, ok := registryConfig.credential(defaultHost, repository); ok {
 					// Convert registry credentials config to runtime auth config, so it can be parsed by `ParseAuth`
-					authConfig.Username = registryConfig.Credentials[defaultHost].Username
-					authConfig.Password = registryConfig.Credentials[defaultHost].Password
-					authConfig.Auth = registryConfig.Credentials[defaultHost].Auth
-					authConfig.IdentityToken = registryConfig.Credentials[defaultHost].IdentityToken
-					authOpts = append(authOpts, docker.WithAuthClient(&http.Client{
-						Transport: newTransport(),
-					}))
+					authConfig.Username = credential.Username
+					authConfig.Password = credential.Password
+					authConfig.Auth = credential.Auth
+					authConfig.IdentityToken = credential.IdentityToken
+					authOpts = append(authOpts, docker.WithAuthClient(authClient))
 					authOpts = append(authOpts, docker.WithAuthCreds(func(host string) (string, string, error) {
 						return server.ParseAuth(&authConfig, host)
 					}))
 				}
 				authorizer = docker.NewDockerAuthorizer(authOpts...)
+				if registryAnonymousFallback && len(authOpts) != 0 {
+					authorizer = newAnonymousFallbackAuthorizer(authorizer, authClient)
+				}
+				if registryAnonymousFirst && len(authOpts) != 0 {
+					authorizer = newAnonymousFirstAuthorizer(authorizer, authClient)
+				}
 			} else {
 				authorizer = *authorizerOverride
 			}
-			registries = append(registries, docker.RegistryHost{
+			registryHost := docker.RegistryHost{
 				Authorizer:   authorizer,
 				Host:         url.Host,
 				Scheme:       url.Scheme,
 				Path:         url.Path,
 				Capabilities: docker.HostCapabilityResolve | docker.HostCapabilityPull,
-			})
+			}
+			registryHost.Client = client
+			registries = append(registries, registryHost)
 		}
 		return registries, nil
 	}
 }
 
+// The time allowed for a registry mirror to respond to the `/v2` API probe. Mirrors are probed
+// before the pull starts, so a mirror that doesn't respond holds up the pull for this long.
+const v2ProbeTimeout = 2 * time.Second
+
+// withV2Probe wraps hosts to drop registry mirrors that respond to the base `/v2/` API with
+// 404, since they can't serve the distribution protocol and would only delay falling back
+// to the next endpoint. The upstream registry, the last of the hosts, is never dropped. The
+// probes are sent with the headers of the pull's other registry requests.
+//
+// The mirrors are probed at once, and each mirror is only probed the first time hosts returns
+// it, since hosts is called for every request the resolver makes. The type of the upstream
+// registry is detected from the pull's own responses instead. Pulls without a registry config
+// don't use withV2Probe, so their registry type isn't detected.
+func withV2Probe(hosts docker.RegistryHosts, ref string, headers http.Header) docker.RegistryHosts {
+	var repository string
+	if spec, err := reference.Parse(ref); err == nil {
+		repository = strings.TrimPrefix(spec.Locator, spec.Hostname()+"/")
+	}
+	probes := &v2Probes{}
+	hosts = withUpstreamTypeDetection(hosts, &registryTypes{})
+	return func(host string) ([]docker.RegistryHost, error) {
+		registries, err := hosts(host)
+		if err != nil || len(registries) < 2 {
+			return registries, err
+		}
+		mirrors := registries[:len(registries)-1]
+		hasAPI := make([]bool, len(mirrors))
+		var wg sync.WaitGroup
+		for i := range mirrors {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				hasAPI[i] = probes.hasV2API(mirrors[i], repository, headers)
+			}()
+		}
+		wg.Wait()
+		var probed []docker.RegistryHost
+		for i, registry := range mirrors {
+			if !hasAPI[i] {
+				log.L.WithField("host", registry.Host).Warn("registry mirror doesn't serve the /v2 API, skipping it")
+				continue
+			}
+			probed = append(probed, registry)
+		}
+		return append(probed, registries[len(registries)-1]), nil
+	}
+}
+
+// v2Probes holds the results of the `/v2` API probes of each registry endpoint
+type v2Probes struct {
+	mu     sync.Mutex
+	probes map[string]*v2Probe
+}
+
+// v2Probe is the probe of one registry endpoint, sent once
+type v2Probe struct {
+	once   sync.Once
+	hasAPI bool
+}
+
+// hasV2API probes the registry as the package's hasV2API the first time it's called for the
+// registry's endpoint, and returns the same result after that
+func (p *v2Probes) hasV2API(registry docker.RegistryHost, repository string, headers http.Header) bool {
+	key := fmt.Sprintf("%s://%s%s", registry.Scheme, registry.Host, registry.Path)
+	p.mu.Lock()
+	if p.probes == nil {
+		p.probes = map[string]*v2Probe{}
+	}
+	probe, ok := p.probes[key]
+	if !ok {
+		probe = &v2Probe{}
+		p.probes[key] = probe
+	}
+	p.mu.Unlock()
+	probe.once.Do(func() {
+		probe.hasAPI = hasV2API(registry, repository, headers)
+	})
+	return probe.hasAPI
+}
+
+// hasV2API returns false if the registry responds to the base `/v2/` API with 404. Any other
+// response, including failures to connect, is left to the resolver to handle. The registry
+// implementation detected from the response is logged.
+//
+// Some registries only serve the base API to clients with a token scoped to a repository, so
+// when the registry asks for authorization the probe is retried with a token for pulling from
+// repository. The authorizer keeps the token for the pull.
+func hasV2API(registry docker.RegistryHost, repository string, headers http.Header) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), v2ProbeTimeout)
+	defer cancel()
+	client := registry.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	probe := func(ctx context.Context) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s%s/", registry.Scheme, registry.Host, registry.Path), nil)
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range headers {
+			req.Header[key] = values
+		}
+		if registry.Authorizer != nil {
+			if err := registry.Authorizer.Authorize(ctx, req); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return resp, nil
+	}
+	resp, err := probe(ctx)
+	if err != nil {
+		return true
+	}
+	registryType := detectRegistryType(resp.Header)
+	if resp.StatusCode == http.StatusUnauthorized && registry.Authorizer != nil && repository != "" {
+		scopedCtx := docker.WithScope(ctx, fmt.Sprintf("repository:%s:pull", repository))
+		if err := registry.Authorizer.AddResponses(scopedCtx, []*http.Response{resp}); err != nil {
+			log.L.WithField("host", registry.Host).WithError(err).Debug("failed to authorize the /v2 API probe")
+		} else if scoped, err := probe(scopedCtx); err != nil {
+			log.L.WithField("host", registry.Host).WithError(err).Debug("failed to probe the /v2 API with a repository scoped token")
+		} else {
+			resp = scoped
+			// Registries are mostly recognized by their authentication challenge
+			if registryType == registryTypeUnknown {
+				registryType = detectRegistryType(resp.Header)
+			}
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return false
+	}
+	log.L.WithField("host", registry.Host).WithField("type", registryType).Info("detected registry type")
+	return true
+}
+
+// mirrorDigestMismatchError is returned when a registry mirror resolves an image to a
+// different digest than the upstream registry
+type mirrorDigestMismatchError struct {
+	Ref            string
+	Mirror         string
+	MirrorDigest   digest.Digest
+	UpstreamDigest digest.Digest
+}
+
+func (e *mirrorDigestMismatchError) Error() string {
+	return fmt.Sprintf("registry mirror %s resolved %s to %s, but the upstream registry resolved it to %s",
+		e.Mirror, e.Ref, e.MirrorDigest, e.UpstreamDigest)
+}
+
+// What to do when a registry mirror serves stale content: fail the pull, or only report the stale
+// mirror and pull anyway
+const (
+	mirrorStaleFail = "fail"
+	mirrorStaleWarn = "warn"
+)
+
+// The event reported for each registry mirror that resolves an image to a stale digest
+const mirrorStaleEvent = "mirror_stale"
+
+// checkMirrorStaleAction checks that action is a valid action for stale registry mirrors
+func checkMirrorStaleAction(action string) error {
+	switch action {
+	case mirrorStaleFail, mirrorStaleWarn:
+		return nil
+	default:
+		return fmt.Errorf("invalid mirror stale action %q, expected one of: [fail, warn]", action)
+	}
+}
+
+// reportStaleMirror reports the mirror's stale digest and the current upstream digest as a
+// `mirror_stale` event, so operators can flush the mirror's cache
+func reportStaleMirror(ctx context.Context, mismatch *mirrorDigestMismatchError) {
+	log.G(ctx).WithFields(log.Fields{
+		"event":          mirrorStaleEvent,
+		"ref":            mismatch.Ref,
+		"mirror":         mismatch.Mirror,
+		"stale-digest":   mismatch.MirrorDigest,
+		"current-digest": mismatch.UpstreamDigest,
+	}).Warn("registry mirror served stale content")
+}
+
+// verifyMirrorDigest resolves ref through each of the registry hosts and checks that every mirror
+// resolves the same digest as the upstream registry, which is the last of the hosts. Mirrors that
+// can't resolve ref are skipped, since the pull falls back to the next host for them anyway. Every
+// stale mirror is reported, and the first one is returned as the error.
+func verifyMirrorDigest(ctx context.Context, hosts docker.RegistryHosts, ref string, headers http.Header) error {
+	spec, err := reference.Parse(ref)
+	if err != nil {
+		return err
+	}
+	// Content pulled by digest is verified against the digest regardless of where it comes from
+	if spec.Digest() != "" {
+		return nil
+	}
+	registries, err := hosts(spec.Hostname())
+	if err != nil {
+		return err
+	}
+	if len(registries) < 2 {
+		return nil
+	}
+
+	resolve := func(host docker.RegistryHost) (digest.Digest, error) {
+		resolver := docker.NewResolver(docker.ResolverOptions{
+			Hosts: func(string) ([]docker.RegistryHost, error) {
+				return []docker.RegistryHost{host}, nil
+			},
+			Headers: headers,
+		})
+		_, desc, err := resolver.Resolve(ctx, ref)
+		return desc.Digest, err
+	}
+
+	upstream := registries[len(registries)-1]
+	upstreamDigest, err := resolve(upstream)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve %s from upstream registry %s to verify mirrors", ref, upstream.Host)
+	}
+	var stale *mirrorDigestMismatchError
+	for _, mirror := range registries[:len(registries)-1] {
+		mirrorDigest, err := resolve(mirror)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("mirror", mirror.Host).Warn("failed to resolve image from registry mirror, skipping verification")
+			continue
+		}
+		if mirrorDigest != upstreamDigest {
+			mismatch := &mirrorDigestMismatchError{
+				Ref:            ref,
+				Mirror:         mirror.Host,
+				MirrorDigest:   mirrorDigest,
+				UpstreamDigest: upstreamDigest,
+			}
+			reportStaleMirror(ctx, mismatch)
+			if stale == nil {
+				stale = mismatch
+			}
+			continue
+		}
+		log.G(ctx).WithField("mirror", mirror.Host).WithField("digest", mirrorDigest).Debug("verified registry mirror digest")
+	}
+	if stale != nil {
+		return stale
+	}
+	return nil
+}
+
+// inferredSchemeWarning returns the reason an inferred URL scheme looks like a configuration mistake
+// for an endpoint without an explicit scheme, or an empty string if the inferred scheme is expected.
+// Plain HTTP to a public host and HTTPS to a private IP address usually mean a scheme prefix is missing.
+func inferredSchemeWarning(endpoint string, scheme string) string {
+	host := endpoint
+	if h, _, err := net.SplitHostPort(endpoint); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+
+	switch scheme {
+	case "http":
+		if ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() {
+			return "inferred http for a public IP address, add an `https://` prefix to the endpoint if the registry serves TLS"
+		}
+		if ip == nil && strings.Contains(host, ".") && !isLocalHostname(host) {
+			return "inferred http for a public hostname, add an `https://` prefix to the endpoint if the registry serves TLS"
+		}
+	case "https":
+		if ip != nil && (ip.IsPrivate() || ip.IsLinkLocalUnicast()) {
+			return "inferred https for a private IP address, add an `http://` prefix to the endpoint if the registry doesn't serve TLS"
+		}
+	}
+	return ""
+}
+
+// isLoopbackEndpoint returns true if the endpoint is a loopback address or hostname without a port.
+// Endpoints with a port keep defaulting to https.
+func isLoopbackEndpoint(endpoint string) bool {
+	host := strings.TrimSuffix(strings.TrimPrefix(endpoint, "["), "]")
+	switch strings.ToLower(strings.TrimSuffix(host, ".")) {
+	case "localhost", "localhost.localdomain":
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLocalHostname returns true if the hostname is only resolvable on the local network
+func isLocalHostname(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if !strings.Contains(host, ".") {
+		return true
+	}
+	for _, suffix := range []string{".localhost", ".localdomain", ".local", ".internal", ".lan", ".home.arpa"} {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
 // newTransport is borrowed from containerd CRI plugin
 // See https://github.com/containerd/containerd/blob/1407cab509ff0d96baa4f0eb6ff9980270e6e620/pkg/cri/server/image_pull.go#L466-L481
 // FIXME Replace this once containerd creates a library that shares this code with ctr
 func newTransport() *http.Transport {
-	return &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:       30 * time.Second,
-			KeepAlive:     30 * time.Second,
-			FallbackDelay: 300 * time.Millisecond,
-		}).DialContext,
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           defaultRegistryDialer.DialContext,
 		MaxIdleConns:          10,
+		MaxIdleConnsPerHost:   registryMaxIdleConns,
 		IdleConnTimeout:       30 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 5 * time.Second,
 	}
+	if registryMaxIdleConns > transport.MaxIdleConns {
+		transport.MaxIdleConns = registryMaxIdleConns
+	}
+	if registryMinTLSVersion != 0 {
+		transport.TLSClientConfig = &tls.Config{MinVersion: registryMinTLSVersion}
+	}
+	if registryMaxConns > 0 {
+		transport.DialContext = newConnLimiter(registryMaxConns).dialContext(transport.DialContext)
+	}
+	if registryHTTP2Disabled {
+		// A non-nil, empty TLSNextProto keeps the transport from negotiating HTTP/2
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// Whether registry connections are limited to HTTP/1.1, set up from the command line
+var registryHTTP2Disabled bool
+
+// The minimum TLS version of registry connections, set up from the command line. Zero leaves
+// the minimum at Go's default.
+var registryMinTLSVersion uint16
+
+// TLS versions by the names accepted in flags and the registry config
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion parses a TLS version such as `1.3`, returning zero for an empty version
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("invalid TLS version %q, must be one of: [1.0, 1.1, 1.2, 1.3]", version)
+	}
+	return v, nil
+}
+
+// customTransport returns whether registry connections need the transport from newTransport
+// instead of the default HTTP client's transport
+func customTransport() bool {
+	return defaultRegistryDialer.configured() || registryHTTP2Disabled || registryMinTLSVersion != 0 || registryMaxConns > 0 || registryMaxIdleConns > 0
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+)
+
+// registryType is the registry implementation behind a registry host, as far as it can be told
+// from its responses. Implementations differ in details such as cross-repository blob mounts and
+// chunked uploads.
+type registryType string
+
+const (
+	registryTypeUnknown      registryType = "unknown"
+	registryTypeECR          registryType = "ecr"
+	registryTypeECRPublic    registryType = "ecr-public"
+	registryTypeGoogle       registryType = "google"
+	registryTypeHarbor       registryType = "harbor"
+	registryTypeDockerHub    registryType = "docker-hub"
+	registryTypeQuay         registryType = "quay"
+	registryTypeArtifactory  registryType = "artifactory"
+	registryTypeNexus        registryType = "nexus"
+	registryTypeDistribution registryType = "distribution"
+)
+
+// detectRegistryType detects the registry implementation from the headers of a response to the
+// base `/v2/` API. Registries that only identify as implementing the distribution API are
+// reported as `distribution`.
+func detectRegistryType(header http.Header) registryType {
+	if header.Get("X-Artifactory-Id") != "" {
+		return registryTypeArtifactory
+	}
+	if strings.HasPrefix(header.Get("Server"), "Nexus/") {
+		return registryTypeNexus
+	}
+	challenge := strings.ToLower(header.Get("WWW-Authenticate"))
+	switch {
+	case strings.Contains(challenge, `service="ecr.amazonaws.com"`), strings.Contains(challenge, ".dkr.ecr."):
+		return registryTypeECR
+	case strings.Contains(challenge, "public.ecr.aws"):
+		return registryTypeECRPublic
+	case strings.Contains(challenge, "gcr.io/"), strings.Contains(challenge, ".pkg.dev/"):
+		return registryTypeGoogle
+	case strings.Contains(challenge, `service="harbor-registry"`):
+		return registryTypeHarbor
+	case strings.Contains(challenge, "auth.docker.io"):
+		return registryTypeDockerHub
+	case strings.Contains(challenge, "quay.io"):
+		return registryTypeQuay
+	}
+	if header.Get("Docker-Distribution-Api-Version") != "" {
+		return registryTypeDistribution
+	}
+	return registryTypeUnknown
+}
+
+// registryTypes holds the registry type detected for each registry host
+type registryTypes struct {
+	mu    sync.Mutex
+	types map[string]registryType
+}
+
+// detect detects the registry type of host from the headers of one of its responses, and logs it
+// the first time it's recognized. Responses that don't identify the registry are ignored.
+func (r *registryTypes) detect(host string, header http.Header) {
+	detected := detectRegistryType(header)
+	if detected == registryTypeUnknown {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.types[host]; ok {
+		return
+	}
+	if r.types == nil {
+		r.types = map[string]registryType{}
+	}
+	r.types[host] = detected
+	log.L.WithField("host", host).WithField("type", detected).Info("detected registry type")
+}
+
+// get returns the registry type detected for host
+func (r *registryTypes) get(host string) registryType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if detected, ok := r.types[host]; ok {
+		return detected
+	}
+	return registryTypeUnknown
+}
+
+// withUpstreamTypeDetection wraps hosts so that the type of the upstream registry, the last of
+// the hosts, is detected from the responses to the pull's own requests. Mirrors are classified by
+// the `/v2` API probe instead, but the upstream registry is never probed.
+func withUpstreamTypeDetection(hosts docker.RegistryHosts, types *registryTypes) docker.RegistryHosts {
+	return func(host string) ([]docker.RegistryHost, error) {
+		registries, err := hosts(host)
+		if err != nil || len(registries) == 0 {
+			return registries, err
+		}
+		upstream := &registries[len(registries)-1]
+		client := http.Client{}
+		if upstream.Client != nil {
+			client = *upstream.Client
+		}
+		transport := client.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		client.Transport = &registryTypeTransport{RoundTripper: transport, host: upstream.Host, types: types}
+		upstream.Client = &client
+		return registries, nil
+	}
+}
+
+// registryTypeTransport detects the registry type from each response until it's recognized
+type registryTypeTransport struct {
+	http.RoundTripper
+	host  string
+	types *registryTypes
+}
+
+func (t *registryTypeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err == nil {
+		t.types.detect(t.host, resp.Header)
+	}
+	return resp, err
+}
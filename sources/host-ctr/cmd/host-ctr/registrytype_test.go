@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectRegistryType(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   map[string]string
+		expected registryType
+	}{
+		{
+			"ECR",
+			map[string]string{"WWW-Authenticate": `Basic realm="https://777777777777.dkr.ecr.us-west-2.amazonaws.com/",service="ecr.amazonaws.com"`},
+			registryTypeECR,
+		},
+		{
+			"ECR Public",
+			map[string]string{"WWW-Authenticate": `Bearer realm="https://public.ecr.aws/token/",service="public.ecr.aws",scope="aws"`},
+			registryTypeECRPublic,
+		},
+		{
+			"GCR",
+			map[string]string{"WWW-Authenticate": `Bearer realm="https://gcr.io/v2/token",service="gcr.io"`},
+			registryTypeGoogle,
+		},
+		{
+			"Artifact Registry",
+			map[string]string{"WWW-Authenticate": `Bearer realm="https://us-docker.pkg.dev/v2/token"`},
+			registryTypeGoogle,
+		},
+		{
+			"Harbor",
+			map[string]string{"WWW-Authenticate": `Bearer realm="https://harbor.example.com/service/token",service="harbor-registry"`},
+			registryTypeHarbor,
+		},
+		{
+			"Docker Hub",
+			map[string]string{"WWW-Authenticate": `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`},
+			registryTypeDockerHub,
+		},
+		{
+			"Quay",
+			map[string]string{"WWW-Authenticate": `Bearer realm="https://quay.io/v2/auth",service="quay.io"`},
+			registryTypeQuay,
+		},
+		{
+			"Artifactory",
+			map[string]string{"X-Artifactory-Id": "a1b2c3", "Docker-Distribution-Api-Version": "registry/2.0"},
+			registryTypeArtifactory,
+		},
+		{
+			"Nexus",
+			map[string]string{"Server": "Nexus/3.61.0-02 (OSS)", "Docker-Distribution-Api-Version": "registry/2.0"},
+			registryTypeNexus,
+		},
+		{
+			"Distribution",
+			map[string]string{"Docker-Distribution-Api-Version": "registry/2.0"},
+			registryTypeDistribution,
+		},
+		{
+			"Unknown",
+			map[string]string{"Server": "nginx"},
+			registryTypeUnknown,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			for key, value := range tc.header {
+				header.Set(key, value)
+			}
+			assert.Equal(t, tc.expected, detectRegistryType(header))
+		})
+	}
+}
+
+func TestUpstreamTypeDetection(t *testing.T) {
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "amd64"})
+	registry.tag("bottlerocket/container", "latest", manifest)
+	// A distribution registry without any mirrors
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+		registry.serveHTTP(w, req)
+	}))
+	t.Cleanup(server.Close)
+	upstream := docker.RegistryHost{
+		Host:         strings.TrimPrefix(server.URL, "http://"),
+		Scheme:       "http",
+		Path:         "/v2",
+		Capabilities: docker.HostCapabilityResolve | docker.HostCapabilityPull,
+	}
+	types := &registryTypes{}
+	hosts := withUpstreamTypeDetection(func(string) ([]docker.RegistryHost, error) {
+		return []docker.RegistryHost{upstream}, nil
+	}, types)
+
+	assert.Equal(t, registryTypeUnknown, types.get(upstream.Host))
+	_, _, err := docker.NewResolver(docker.ResolverOptions{Hosts: hosts}).Resolve(context.TODO(), "registry.example.com/bottlerocket/container:latest")
+	assert.NoError(t, err)
+	assert.Equal(t, registryTypeDistribution, types.get(upstream.Host))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+)
+
+// requestTiming is how long the phases of a registry request took. Requests that reuse a
+// connection spend no time on DNS, connecting, or the TLS handshake.
+type requestTiming struct {
+	DNS       time.Duration
+	Connect   time.Duration
+	TLS       time.Duration
+	FirstByte time.Duration
+}
+
+// requestTimings aggregates the timings of the registry requests of a pull attempt, to tell
+// whether slow pulls are bound by DNS, connecting, TLS, or the registry's response time
+type requestTimings struct {
+	mu       sync.Mutex
+	requests int
+	total    requestTiming
+}
+
+// add adds the timing of a request to the totals
+func (t *requestTimings) add(timing requestTiming) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests++
+	t.total.DNS += timing.DNS
+	t.total.Connect += timing.Connect
+	t.total.TLS += timing.TLS
+	t.total.FirstByte += timing.FirstByte
+}
+
+// totals returns the number of requests and their total timing
+func (t *requestTimings) totals() (int, requestTiming) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.requests, t.total
+}
+
+// fields returns the totals as log fields
+func (t *requestTimings) fields() log.Fields {
+	requests, total := t.totals()
+	return log.Fields{
+		"requests":        requests,
+		"dns_time":        total.DNS.String(),
+		"connect_time":    total.Connect.String(),
+		"tls_time":        total.TLS.String(),
+		"first_byte_time": total.FirstByte.String(),
+	}
+}
+
+// wrapHosts wraps the clients of the registry hosts to trace each request
+func (t *requestTimings) wrapHosts(hosts docker.RegistryHosts) docker.RegistryHosts {
+	return func(host string) ([]docker.RegistryHost, error) {
+		registries, err := hosts(host)
+		if err != nil {
+			return nil, err
+		}
+		for i := range registries {
+			client := http.Client{}
+			if registries[i].Client != nil {
+				client = *registries[i].Client
+			}
+			transport := client.Transport
+			if transport == nil {
+				transport = http.DefaultTransport
+			}
+			client.Transport = &tracingTransport{RoundTripper: transport, timings: t}
+			registries[i].Client = &client
+		}
+		return registries, nil
+	}
+}
+
+// tracingTransport times the phases of requests with httptrace
+type tracingTransport struct {
+	http.RoundTripper
+	timings *requestTimings
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace, timing := newRequestTrace(time.Now)
+	resp, err := t.RoundTripper.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	result := timing()
+	t.timings.add(result)
+	log.G(req.Context()).
+		WithField("url", req.URL.Redacted()).
+		WithField("dns_time", result.DNS.String()).
+		WithField("connect_time", result.Connect.String()).
+		WithField("tls_time", result.TLS.String()).
+		WithField("first_byte_time", result.FirstByte.String()).
+		Debug("registry request timing")
+	return resp, err
+}
+
+// newRequestTrace returns hooks that time the phases of a request started when it's called, and
+// a function returning the timing once the request's response headers are received
+func newRequestTrace(now func() time.Time) (*httptrace.ClientTrace, func() requestTiming) {
+	var (
+		mu                               sync.Mutex
+		timing                           requestTiming
+		dnsStart, connectStart, tlsStart time.Time
+	)
+	start := now()
+	since := func(t time.Time) time.Duration {
+		if t.IsZero() {
+			return 0
+		}
+		return now().Sub(t)
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			dnsStart = now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			timing.DNS = since(dnsStart)
+		},
+		// Connections to several addresses may be attempted, the time until the first succeeds counts
+		ConnectStart: func(string, string) {
+			mu.Lock()
+			defer mu.Unlock()
+			if connectStart.IsZero() {
+				connectStart = now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil && timing.Connect == 0 {
+				timing.Connect = since(connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			tlsStart = now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			timing.TLS = since(tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			timing.FirstByte = since(start)
+		},
+	}
+	return trace, func() requestTiming {
+		mu.Lock()
+		defer mu.Unlock()
+		return timing
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestTraceHooks(t *testing.T) {
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now := func() time.Time { return clock }
+	advance := func(d time.Duration) { clock = clock.Add(d) }
+
+	trace, timing := newRequestTrace(now)
+	trace.DNSStart(httptrace.DNSStartInfo{Host: "registry.example.com"})
+	advance(10 * time.Millisecond)
+	trace.DNSDone(httptrace.DNSDoneInfo{})
+	// A failed connection attempt is followed by a successful one
+	trace.ConnectStart("tcp", "[2001:db8::1]:443")
+	trace.ConnectStart("tcp", "192.0.2.1:443")
+	advance(20 * time.Millisecond)
+	trace.ConnectDone("tcp", "[2001:db8::1]:443", assert.AnError)
+	advance(5 * time.Millisecond)
+	trace.ConnectDone("tcp", "192.0.2.1:443", nil)
+	trace.TLSHandshakeStart()
+	advance(30 * time.Millisecond)
+	trace.TLSHandshakeDone(tls.ConnectionState{}, nil)
+	advance(40 * time.Millisecond)
+	trace.GotFirstResponseByte()
+
+	assert.Equal(t, requestTiming{
+		DNS:       10 * time.Millisecond,
+		Connect:   25 * time.Millisecond,
+		TLS:       30 * time.Millisecond,
+		FirstByte: 105 * time.Millisecond,
+	}, timing())
+}
+
+func TestTracingTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	timings := &requestTimings{}
+	client := &http.Client{Transport: &tracingTransport{RoundTripper: server.Client().Transport, timings: timings}}
+	get := func() {
+		resp, err := client.Get(server.URL + "/v2/")
+		require.NoError(t, err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	get()
+	requests, first := timings.totals()
+	assert.Equal(t, 1, requests)
+	assert.Greater(t, first.Connect, time.Duration(0))
+	assert.Greater(t, first.TLS, time.Duration(0))
+	assert.Greater(t, first.FirstByte, time.Duration(0))
+
+	// The second request reuses the connection, so only its time to first byte adds up
+	get()
+	requests, total := timings.totals()
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, first.Connect, total.Connect)
+	assert.Equal(t, first.TLS, total.TLS)
+	assert.Greater(t, total.FirstByte, first.FirstByte)
+
+	fields := timings.fields()
+	assert.Equal(t, 2, fields["requests"])
+	assert.Equal(t, total.TLS.String(), fields["tls_time"])
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/oci"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// The CFS period the CPU quota is a share of, the kernel's default
+const cpuPeriod uint64 = 100000
+
+// resourceLimits are the cgroup resource constraints of a host container, zero values are unlimited
+type resourceLimits struct {
+	// Microseconds of CPU time the container may use every 100ms period, e.g. 50000 for half a CPU
+	cpuQuota int64
+	// The container's CPU weight relative to other cgroups
+	cpuShares uint64
+	// Bytes of memory the container may use
+	memoryLimit uint64
+}
+
+// validate checks that the limits are within what the kernel accepts
+func (l resourceLimits) validate() error {
+	// The kernel rejects CPU quotas below 1ms
+	if l.cpuQuota != 0 && l.cpuQuota < 1000 {
+		return fmt.Errorf("invalid CPU quota %d, must be at least 1000 microseconds", l.cpuQuota)
+	}
+	if l.cpuShares != 0 && (l.cpuShares < 2 || l.cpuShares > 262144) {
+		return fmt.Errorf("invalid CPU shares %d, must be between 2 and 262144", l.cpuShares)
+	}
+	return nil
+}
+
+// withResourceLimits sets the limits in the linux resources section of the spec
+func withResourceLimits(limits resourceLimits) oci.SpecOpts {
+	return func(ctx context.Context, client oci.Client, c *containers.Container, s *runtimespec.Spec) error {
+		var opts []oci.SpecOpts
+		if limits.cpuQuota != 0 {
+			opts = append(opts, oci.WithCPUCFS(limits.cpuQuota, cpuPeriod))
+		}
+		if limits.cpuShares != 0 {
+			opts = append(opts, oci.WithCPUShares(limits.cpuShares))
+		}
+		if limits.memoryLimit != 0 {
+			opts = append(opts, oci.WithMemoryLimit(limits.memoryLimit))
+		}
+		return oci.Compose(opts...)(ctx, client, c, s)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithResourceLimits(t *testing.T) {
+	quota := int64(50000)
+	period := cpuPeriod
+	shares := uint64(512)
+	memory := int64(256 << 20)
+	tests := []struct {
+		name     string
+		limits   resourceLimits
+		expected *runtimespec.LinuxResources
+	}{
+		{
+			"No limits",
+			resourceLimits{},
+			nil,
+		},
+		{
+			"CPU quota",
+			resourceLimits{cpuQuota: quota},
+			&runtimespec.LinuxResources{CPU: &runtimespec.LinuxCPU{Quota: &quota, Period: &period}},
+		},
+		{
+			"CPU shares",
+			resourceLimits{cpuShares: shares},
+			&runtimespec.LinuxResources{CPU: &runtimespec.LinuxCPU{Shares: &shares}},
+		},
+		{
+			"Memory limit",
+			resourceLimits{memoryLimit: uint64(memory)},
+			&runtimespec.LinuxResources{Memory: &runtimespec.LinuxMemory{Limit: &memory}},
+		},
+		{
+			"All limits",
+			resourceLimits{cpuQuota: quota, cpuShares: shares, memoryLimit: uint64(memory)},
+			&runtimespec.LinuxResources{
+				CPU:    &runtimespec.LinuxCPU{Quota: &quota, Period: &period, Shares: &shares},
+				Memory: &runtimespec.LinuxMemory{Limit: &memory},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := runtimespec.Spec{Linux: &runtimespec.Linux{}}
+			require.NoError(t, withResourceLimits(tc.limits)(context.Background(), nil, nil, &spec))
+			assert.Equal(t, tc.expected, spec.Linux.Resources)
+		})
+	}
+}
+
+func TestResourceLimitsValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		limits      resourceLimits
+		expectedErr bool
+	}{
+		{"No limits", resourceLimits{}, false},
+		{"Valid limits", resourceLimits{cpuQuota: 50000, cpuShares: 512, memoryLimit: 1 << 30}, false},
+		{"CPU quota too small", resourceLimits{cpuQuota: 999}, true},
+		{"Negative CPU quota", resourceLimits{cpuQuota: -1}, true},
+		{"CPU shares too small", resourceLimits{cpuShares: 1}, true},
+		{"CPU shares too large", resourceLimits{cpuShares: 262145}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.limits.validate()
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	remoteerrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// retryJitter selects how the delay between pull retries is randomized
+type retryJitter string
+
+const (
+	// Adds 2 - 6 seconds to the retry interval
+	additiveJitter retryJitter = "additive"
+	// Waits a random duration up to the retry interval, which spreads out retries the most
+	fullJitter retryJitter = "full"
+	// Waits at least half of the retry interval, plus a random duration up to the other half
+	equalJitter retryJitter = "equal"
+	// Waits exactly the retry interval
+	noJitter retryJitter = "none"
+)
+
+// Pulls are retried with exponential backoff, so the retry interval never exceeds 31 seconds
+const (
+	maxRetryAttempts     = 5
+	initialRetryInterval = 1 * time.Second
+	intervalMultiplier   = 2
+	maxRetryInterval     = 30 * time.Second
+)
+
+// The extra retries allowed for pulls that fail with an error matching --retry-error-substrings
+// or consistent with clock skew
+const substringRetryAttempts = 5
+
+const (
+	// The bounds of the random duration added to the retry interval by additive jitter
+	additiveJitterLowerBound = 2 * time.Second
+	additiveJitterUpperBound = 6 * time.Second
+)
+
+// parseRetryJitter parses the retry jitter strategy, defaulting to additive jitter
+func parseRetryJitter(jitter string) (retryJitter, error) {
+	switch retryJitter(jitter) {
+	case "":
+		return additiveJitter, nil
+	case additiveJitter, fullJitter, equalJitter, noJitter:
+		return retryJitter(jitter), nil
+	default:
+		return "", fmt.Errorf("invalid retry jitter %q, expected one of: [additive, full, equal, none]", jitter)
+	}
+}
+
+// retryDelay returns how long to wait before retrying after the given retry interval
+func retryDelay(interval time.Duration, jitter retryJitter, rng *rand.Rand) time.Duration {
+	switch jitter {
+	case fullJitter:
+		return time.Duration(rng.Int63n(int64(interval) + 1))
+	case equalJitter:
+		half := interval / 2
+		return half + time.Duration(rng.Int63n(int64(interval-half)+1))
+	case noJitter:
+		return interval
+	default:
+		return interval + additiveJitterLowerBound + time.Duration(rng.Int63n(int64(additiveJitterUpperBound-additiveJitterLowerBound)))
+	}
+}
+
+// retryBudget bounds how long image pulls are retried for, by attempt count and
+// by the total time spent, whichever runs out first
+type retryBudget struct {
+	maxAttempts int
+	// Zero leaves the time spent retrying unbounded
+	maxElapsed time.Duration
+}
+
+// exhausted returns why retrying must stop after the given number of retries
+// and the time elapsed since the first attempt, or "" if another retry is allowed
+func (b retryBudget) exhausted(attempts int, elapsed time.Duration) string {
+	if attempts >= b.maxAttempts {
+		return "retries exhausted"
+	}
+	if b.maxElapsed > 0 && elapsed >= b.maxElapsed {
+		return fmt.Sprintf("retry time budget of %s exhausted after %d retries", b.maxElapsed, attempts)
+	}
+	return ""
+}
+
+// clamp shortens the delay before the next retry so it doesn't wait past the time budget
+func (b retryBudget) clamp(delay time.Duration, elapsed time.Duration) time.Duration {
+	if b.maxElapsed > 0 && elapsed+delay > b.maxElapsed {
+		return b.maxElapsed - elapsed
+	}
+	return delay
+}
+
+// The error containerd's fetcher returns for a registry response with an unexpected status, which
+// includes the registry's error message if it sent one
+var fetchStatusPattern = regexp.MustCompile(`unexpected status code \S+: (\d{3})`)
+
+// pullErrorStatus returns the status of the registry response a pull failed with, if any, and the
+// response body when containerd kept it
+func pullErrorStatus(err error) (int, []byte, bool) {
+	var status remoteerrors.ErrUnexpectedStatus
+	if errors.As(err, &status) {
+		return status.StatusCode, status.Body, true
+	}
+	if match := fetchStatusPattern.FindStringSubmatch(err.Error()); match != nil {
+		code, _ := strconv.Atoi(match[1])
+		return code, nil, true
+	}
+	return 0, nil, false
+}
+
+// matchesRetrySubstring returns whether a failed pull's error, or the registry response body it
+// carries, contains one of the substrings
+func matchesRetrySubstring(err error, substrings []string) bool {
+	_, body, _ := pullErrorStatus(err)
+	for _, substring := range substrings {
+		if strings.Contains(err.Error(), substring) || bytes.Contains(body, []byte(substring)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pullRetryBudget returns the retry budget for a failed pull. Every failure is retried within the
+// default budget. Errors matching one of the substrings, for upstreams that report transient
+// conditions with unusual errors, and authentication failures consistent with clock skew, which
+// go away once time is synchronized at early boot, get extra attempts on top of it.
+func pullRetryBudget(budget retryBudget, err error, substrings []string) retryBudget {
+	if matchesRetrySubstring(err, substrings) || isClockSkewError(err) {
+		budget.maxAttempts += substringRetryAttempts
+	}
+	return budget
+}
+
+// retryRemotePull runs attempt, a pull of source that doesn't go through containerd, and retries
+// it with the same backoff and retry budget as image pulls
+func retryRemotePull(ctx context.Context, source string, pullOpts pullOptions, attempt func() error) error {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	budget := retryBudget{maxAttempts: maxRetryAttempts, maxElapsed: pullOpts.retryMaxElapsed}
+	retryInterval := initialRetryInterval
+	pullStart := time.Now()
+	for retryAttempts := 0; ; retryAttempts++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		if signatureRejected(err) {
+			return err
+		}
+		if reason := entropyDiagnostic(err, crngReady); reason != "" {
+			log.G(ctx).WithError(err).Warn(reason)
+			waitForEntropy(ctx)
+		}
+		if reason := pullRetryBudget(budget, err, pullOpts.retrySubstrings).exhausted(retryAttempts, time.Since(pullStart)); reason != "" {
+			return errors.Wrap(err, reason)
+		}
+		delay := budget.clamp(retryDelay(retryInterval, pullOpts.retryJitter, rng), time.Since(pullStart))
+		log.G(ctx).WithError(err).WithField("ref", source).Warnf("failed to pull image. waiting %s before retrying...", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+			retryInterval = min(retryInterval*intervalMultiplier, maxRetryInterval)
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrap(err, "context ended while retrying")
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/remotes/docker"
+	remoteerrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/containerd/platforms"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRetryJitter(t *testing.T) {
+	for _, jitter := range []string{"additive", "full", "equal", "none"} {
+		parsed, err := parseRetryJitter(jitter)
+		assert.NoError(t, err)
+		assert.Equal(t, retryJitter(jitter), parsed)
+	}
+	parsed, err := parseRetryJitter("")
+	assert.NoError(t, err)
+	assert.Equal(t, additiveJitter, parsed)
+	_, err = parseRetryJitter("partial")
+	assert.Error(t, err)
+}
+
+func TestRetryDelayBounds(t *testing.T) {
+	tests := []struct {
+		jitter retryJitter
+		min    time.Duration
+		max    time.Duration
+	}{
+		{additiveJitter, 10*time.Second + 2*time.Second, 10*time.Second + 6*time.Second},
+		{fullJitter, 0, 10 * time.Second},
+		{equalJitter, 5 * time.Second, 10 * time.Second},
+		{noJitter, 10 * time.Second, 10 * time.Second},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.jitter), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			for i := 0; i < 1000; i++ {
+				delay := retryDelay(10*time.Second, tc.jitter, rng)
+				assert.GreaterOrEqual(t, delay, tc.min)
+				assert.LessOrEqual(t, delay, tc.max)
+			}
+		})
+	}
+}
+
+func TestRetryDelaySeeded(t *testing.T) {
+	for _, jitter := range []retryJitter{additiveJitter, fullJitter, equalJitter} {
+		first := retryDelay(10*time.Second, jitter, rand.New(rand.NewSource(42)))
+		second := retryDelay(10*time.Second, jitter, rand.New(rand.NewSource(42)))
+		assert.Equal(t, first, second)
+	}
+}
+
+func TestRetryBudgetAttemptCap(t *testing.T) {
+	tests := []struct {
+		name      string
+		budget    retryBudget
+		attempts  int
+		elapsed   time.Duration
+		exhausted bool
+	}{
+		{"unbounded time, attempts left", retryBudget{5, 0}, 4, time.Hour, false},
+		{"unbounded time, attempts used", retryBudget{5, 0}, 5, time.Second, true},
+		{"time left, attempts used", retryBudget{5, time.Minute}, 5, time.Second, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reason := tc.budget.exhausted(tc.attempts, tc.elapsed)
+			if !tc.exhausted {
+				assert.Empty(t, reason)
+				return
+			}
+			assert.Equal(t, "retries exhausted", reason)
+		})
+	}
+}
+
+func TestRetryBudgetTimeBudget(t *testing.T) {
+	budget := retryBudget{maxAttempts: 5, maxElapsed: 10 * time.Second}
+	assert.Empty(t, budget.exhausted(1, 9*time.Second))
+	assert.Contains(t, budget.exhausted(1, 10*time.Second), "retry time budget of 10s exhausted")
+	assert.Contains(t, budget.exhausted(2, time.Minute), "after 2 retries")
+
+	// The delay before the last retry is cut short so it happens within the budget
+	assert.Equal(t, 4*time.Second, budget.clamp(4*time.Second, 5*time.Second))
+	assert.Equal(t, 2*time.Second, budget.clamp(4*time.Second, 8*time.Second))
+	assert.Equal(t, 30*time.Second, retryBudget{maxAttempts: 5}.clamp(30*time.Second, time.Hour))
+
+	// Simulate the retry loop, which ends on the time budget before using up the attempts
+	var elapsed time.Duration
+	attempts := 0
+	for budget.exhausted(attempts, elapsed) == "" {
+		elapsed += budget.clamp(4*time.Second, elapsed)
+		attempts++
+	}
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 10*time.Second, elapsed)
+}
+
+func TestPullRetryBudget(t *testing.T) {
+	status := func(code int, body string) error {
+		return fmt.Errorf("failed to resolve reference: %w", remoteerrors.ErrUnexpectedStatus{
+			Status:     fmt.Sprintf("%d %s", code, http.StatusText(code)),
+			StatusCode: code,
+			Body:       []byte(body),
+		})
+	}
+	budget := retryBudget{maxAttempts: 5}
+	substrings := []string{"QUOTA_EXCEEDED", "try again later"}
+	tests := []struct {
+		name        string
+		err         error
+		maxAttempts int
+	}{
+		{"Network error", errors.New("dial tcp 192.0.2.1:443: connect: connection refused"), 5},
+		{"Server error", status(http.StatusBadGateway, ""), 5},
+		{"Client error", status(http.StatusForbidden, `{"errors":[{"code":"DENIED"}]}`), 5},
+		{"Client error with matching body", status(http.StatusForbidden, `{"errors":[{"code":"QUOTA_EXCEEDED"}]}`), 5 + substringRetryAttempts},
+		{"Client error with matching message", errors.Wrap(status(http.StatusBadRequest, ""), "registry said try again later"), 5 + substringRetryAttempts},
+		{"Network error with matching message", errors.New("read: connection reset, try again later"), 5 + substringRetryAttempts},
+		{"Expired token", status(http.StatusUnauthorized, `{"errors":[{"code":"UNAUTHORIZED","message":"token is expired"}]}`), 5 + substringRetryAttempts},
+		{"Certificate not yet valid", errors.New("tls: failed to verify certificate: x509: certificate has expired or is not yet valid"), 5 + substringRetryAttempts},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.maxAttempts, pullRetryBudget(budget, tc.err, substrings).maxAttempts)
+		})
+	}
+}
+
+func TestMatchesRetrySubstringFromRegistry(t *testing.T) {
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, platforms.DefaultSpec())
+	registry.tag("bottlerocket/container", "latest", manifest)
+
+	// The registry rejects blob fetches with its own error message
+	var message string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.Contains(req.URL.Path, "/blobs/") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `{"errors": [{"code": "DENIED", "message": %q}]}`, message)
+			return
+		}
+		registry.serveHTTP(w, req)
+	}))
+	t.Cleanup(server.Close)
+	resolver := docker.NewResolver(docker.ResolverOptions{
+		Hosts: func(string) ([]docker.RegistryHost, error) {
+			return []docker.RegistryHost{{
+				Host:         strings.TrimPrefix(server.URL, "http://"),
+				Scheme:       "http",
+				Path:         "/v2",
+				Capabilities: docker.HostCapabilityResolve | docker.HostCapabilityPull,
+			}}, nil
+		},
+	})
+	substrings := []string{"upstream overloaded"}
+
+	message = "upstream overloaded, retry shortly"
+	_, err := fetchImageConfig(context.TODO(), resolver, "registry.example.com/bottlerocket/container:latest", platforms.Default())
+	assert.Error(t, err)
+	assert.True(t, matchesRetrySubstring(err, substrings), "expected the registry's message to match: %v", err)
+
+	message = "access denied"
+	_, err = fetchImageConfig(context.TODO(), resolver, "registry.example.com/bottlerocket/container:latest", platforms.Default())
+	assert.Error(t, err)
+	assert.False(t, matchesRetrySubstring(err, substrings), "expected the registry's message not to match: %v", err)
+}
+
+func TestRetryRemotePull(t *testing.T) {
+	pullOpts := pullOptions{retryJitter: noJitter, retryMaxElapsed: 10 * time.Millisecond}
+
+	// Failures are retried until the pull succeeds
+	attempts := 0
+	err := retryRemotePull(context.TODO(), "registry.example.com/bottlerocket/container:latest", pullOpts, func() error {
+		attempts++
+		if attempts < 2 {
+			return errors.New("connection reset by peer")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	// Images that fail signature verification aren't pulled again
+	attempts = 0
+	err = retryRemotePull(context.TODO(), "registry.example.com/bottlerocket/container:latest", pullOpts, func() error {
+		attempts++
+		return errors.Wrap(errImageUnsigned, "registry.example.com/bottlerocket/container:latest")
+	})
+	assert.ErrorIs(t, err, errImageUnsigned)
+	assert.Equal(t, 1, attempts)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// scanFailedError is returned when the image scanner rejects an image
+type scanFailedError struct {
+	Image    string
+	ExitCode int
+	Output   string
+}
+
+func (e *scanFailedError) Error() string {
+	return fmt.Sprintf("image scanner rejected %s with exit code %d: %s", e.Image, e.ExitCode, e.Output)
+}
+
+// parseScanCommand splits the scanner command into its arguments on whitespace
+func parseScanCommand(command string) []string {
+	return strings.Fields(command)
+}
+
+// runScanner runs the scanner command with the path the image is mounted at as its last
+// argument. The scanner rejects the image by exiting with a non-zero status.
+func runScanner(ctx context.Context, image string, scanCommand []string, root string) error {
+	cmd := exec.CommandContext(ctx, scanCommand[0], append(scanCommand[1:], root)...)
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &scanFailedError{Image: image, ExitCode: exitErr.ExitCode(), Output: strings.TrimSpace(string(output))}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to run image scanner %s", scanCommand[0])
+	}
+	log.G(ctx).WithField("img", image).WithField("output", strings.TrimSpace(string(output))).Info("image scanner accepted image")
+	return nil
+}
+
+// scanImage runs the scanner command against a read-only mount of the unpacked image
+func scanImage(ctx context.Context, client *containerd.Client, img containerd.Image, scanCommand []string) error {
+	return withUnpackedImage(ctx, client, img, func(root string) error {
+		return runScanner(ctx, img.Name(), scanCommand, root)
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScanCommand(t *testing.T) {
+	assert.Empty(t, parseScanCommand(""))
+	assert.Equal(t, []string{"/usr/bin/clamscan", "-r", "--infected"}, parseScanCommand(" /usr/bin/clamscan  -r --infected "))
+}
+
+func TestRunScanner(t *testing.T) {
+	image := "registry.example.com/bottlerocket/container:latest"
+	// The scanner rejects images containing an `infected` file
+	scanner := []string{"/bin/sh", "-c", `if [ -e "$0/infected" ]; then echo "found infected"; exit 3; fi`}
+
+	clean := t.TempDir()
+	assert.NoError(t, runScanner(context.TODO(), image, scanner, clean))
+
+	infected := t.TempDir()
+	if err := os.WriteFile(filepath.Join(infected, "infected"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := runScanner(context.TODO(), image, scanner, infected)
+	var failed *scanFailedError
+	assert.ErrorAs(t, err, &failed)
+	assert.Equal(t, 3, failed.ExitCode)
+	assert.Equal(t, "found infected", failed.Output)
+
+	// Scanners that can't run block the container as well
+	assert.Error(t, runScanner(context.TODO(), image, []string{filepath.Join(clean, "missing")}, clean))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// How long registry credentials fetched from Secrets Manager are used before they are fetched
+// again, so rotated credentials are picked up without fetching the secret for every pull
+const secretCredentialsTTL = 15 * time.Minute
+
+// The registry credentials stored in Secrets Manager, set up from the command line
+var registrySecret *secretCredentials
+
+// secretsManagerAPI is the part of the Secrets Manager API used to fetch registry credentials
+type secretsManagerAPI interface {
+	GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// secretCredentials fetches registry credentials from a Secrets Manager secret and caches them.
+// The secret is a JSON object of credentials keyed like the `creds` of the registry config,
+// e.g. `{"registry.example.com": {"username": "...", "password": "..."}}`.
+type secretCredentials struct {
+	arn    string
+	client secretsManagerAPI
+	now    func() time.Time
+
+	mu          sync.Mutex
+	credentials map[string]Credential
+	fetched     time.Time
+}
+
+// newSecretCredentials sets up fetching registry credentials from the secret with the given ARN,
+// with the instance role's credentials, in the secret's region
+func newSecretCredentials(secretARN string) (*secretCredentials, error) {
+	parsed, err := arn.Parse(secretARN)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid secret ARN %q", secretARN)
+	}
+	if parsed.Service != secretsmanager.EndpointsID {
+		return nil, errors.Errorf("invalid secret ARN %q, expected a Secrets Manager secret", secretARN)
+	}
+	session, err := session.NewSession(aws.NewConfig().WithRegion(parsed.Region))
+	if err != nil {
+		return nil, err
+	}
+	return &secretCredentials{
+		arn:    secretARN,
+		client: secretsmanager.New(session),
+		now:    time.Now,
+	}, nil
+}
+
+// get returns the registry credentials in the secret, fetching it if the cached credentials are
+// missing or older than secretCredentialsTTL
+func (s *secretCredentials) get(ctx context.Context) (map[string]Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.credentials != nil && s.now().Sub(s.fetched) < secretCredentialsTTL {
+		return s.credentials, nil
+	}
+	output, err := s.client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(s.arn)})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch registry credentials from secret %s", s.arn)
+	}
+	if output.SecretString == nil {
+		return nil, errors.Errorf("secret %s has no string value", s.arn)
+	}
+	credentials := map[string]Credential{}
+	if err := json.Unmarshal([]byte(aws.StringValue(output.SecretString)), &credentials); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse registry credentials in secret %s", s.arn)
+	}
+	log.G(ctx).WithField("secret", s.arn).WithField("registries", len(credentials)).Debug("fetched registry credentials from Secrets Manager")
+	s.credentials, s.fetched = credentials, s.now()
+	return credentials, nil
+}
+
+// addTo adds the registry credentials in the secret to the registry config. Credentials in the
+// registry config itself take precedence over the ones in the secret.
+func (s *secretCredentials) addTo(ctx context.Context, registryConfig *RegistryConfig) error {
+	credentials, err := s.get(ctx)
+	if err != nil {
+		return err
+	}
+	if registryConfig.Credentials == nil {
+		registryConfig.Credentials = map[string]Credential{}
+	}
+	for key, credential := range credentials {
+		if _, ok := registryConfig.Credentials[key]; !ok {
+			registryConfig.Credentials[key] = credential
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSecretARN = "arn:aws:secretsmanager:us-west-2:111122223333:secret:registry-creds-AbCdEf"
+
+// stubSecretsManager serves a fixed secret value and counts the requests for it
+type stubSecretsManager struct {
+	value    *string
+	err      error
+	requests int
+}
+
+func (s *stubSecretsManager) GetSecretValueWithContext(_ aws.Context, input *secretsmanager.GetSecretValueInput, _ ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	s.requests++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &secretsmanager.GetSecretValueOutput{ARN: input.SecretId, SecretString: s.value}, nil
+}
+
+func TestSecretCredentialsCached(t *testing.T) {
+	stub := &stubSecretsManager{value: aws.String(`{"registry.example.com": {"username": "builder", "password": "hunter2"}}`)}
+	now := time.Unix(1700000000, 0)
+	secret := &secretCredentials{arn: testSecretARN, client: stub, now: func() time.Time { return now }}
+
+	expected := map[string]Credential{"registry.example.com": {Username: "builder", Password: "hunter2"}}
+	credentials, err := secret.get(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, credentials)
+
+	now = now.Add(secretCredentialsTTL - time.Second)
+	_, err = secret.get(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stub.requests, "expected the cached credentials to be used")
+
+	now = now.Add(time.Second)
+	stub.value = aws.String(`{"registry.example.com": {"username": "builder", "password": "rotated"}}`)
+	credentials, err = secret.get(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stub.requests, "expected the secret to be fetched again once the cache expires")
+	assert.Equal(t, "rotated", credentials["registry.example.com"].Password)
+}
+
+func TestSecretCredentialsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		stub *stubSecretsManager
+	}{
+		{"Request fails", &stubSecretsManager{err: errors.New("AccessDeniedException")}},
+		{"Binary secret", &stubSecretsManager{}},
+		{"Not JSON", &stubSecretsManager{value: aws.String("builder:hunter2")}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			secret := &secretCredentials{arn: testSecretARN, client: tc.stub, now: time.Now}
+			_, err := secret.get(context.TODO())
+			assert.Error(t, err)
+			// Failures aren't cached
+			_, err = secret.get(context.TODO())
+			assert.Error(t, err)
+			assert.Equal(t, 2, tc.stub.requests)
+		})
+	}
+}
+
+func TestSecretCredentialsAddTo(t *testing.T) {
+	stub := &stubSecretsManager{value: aws.String(`{
+		"registry.example.com": {"username": "builder", "password": "from-secret"},
+		"registry.example.com/team-a": {"identitytoken": "token"},
+		"other.example.com": {"auth": "YnVpbGRlcjpodW50ZXIy"}
+	}`)}
+	secret := &secretCredentials{arn: testSecretARN, client: stub, now: time.Now}
+	config := &RegistryConfig{Credentials: map[string]Credential{
+		"other.example.com": {Username: "local", Password: "from-config"},
+	}}
+	assert.NoError(t, secret.addTo(context.TODO(), config))
+
+	credential, ok := config.credential("registry.example.com", "registry.example.com/team-b/app")
+	assert.True(t, ok)
+	assert.Equal(t, "from-secret", credential.Password)
+	credential, ok = config.credential("registry.example.com", "registry.example.com/team-a/app")
+	assert.True(t, ok)
+	assert.Equal(t, "token", credential.IdentityToken)
+	credential, ok = config.credential("other.example.com", "other.example.com/app")
+	assert.True(t, ok)
+	assert.Equal(t, "from-config", credential.Password, "expected the registry config's credential to take precedence")
+
+	assert.NoError(t, secret.addTo(context.TODO(), &RegistryConfig{}))
+}
+
+func TestNewSecretCredentialsInvalidARN(t *testing.T) {
+	for _, secretARN := range []string{"registry-creds", "arn:aws:ssm:us-west-2:111122223333:parameter/registry-creds"} {
+		_, err := newSecretCredentials(secretARN)
+		assert.Error(t, err, secretARN)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Image signatures are stored in the format cosign uses: a manifest tagged `sha256-<hex>.sig` in
+// the image's repository, after the digest of the signed image manifest. Each layer of the
+// manifest is a signature. The layer's blob is the signed payload, a "simple signing" JSON
+// document naming the signed manifest digest, and the layer's annotations hold the base64
+// encoded signature over the payload and, for keyless signatures, the signing certificate and an
+// RFC 3161 timestamp over the signature.
+const (
+	signatureTagSuffix             = ".sig"
+	signaturePayloadMediaType      = "application/vnd.dev.cosign.simplesigning.v1+json"
+	signatureAnnotation            = "dev.cosignproject.cosign/signature"
+	signatureCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	signatureChainAnnotation       = "dev.sigstore.cosign/chain"
+)
+
+// The type of simple signing payloads for container images
+const simpleSigningType = "cosign container image signature"
+
+// simpleSigningPayload is the payload of a cosign image signature
+type simpleSigningPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// signsManifest returns whether the signature payload is for the image manifest with the digest
+func signsManifest(payload []byte, dgst digest.Digest) bool {
+	var p simpleSigningPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return false
+	}
+	return p.Critical.Type == simpleSigningType && p.Critical.Image.DockerManifestDigest == dgst
+}
+
+// signatureTag returns the reference of the signatures for the image manifest with the digest
+func signatureTag(ref string, dgst digest.Digest) (string, error) {
+	spec, err := reference.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%s-%s%s", spec.Locator, dgst.Algorithm(), dgst.Encoded(), signatureTagSuffix), nil
+}
+
+// The certificate extensions holding the OIDC issuer of a keyless signing certificate. The
+// legacy extension holds the raw issuer, the current one holds it as a DER encoded string.
+var (
+	oidIssuerLegacy = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuer       = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+var (
+	errImageUnsigned          = errors.New("image has no signatures")
+	errImageSignatureBad      = errors.New("image has no signature from a trusted key")
+	errImageSignatureIdentity = errors.New("image has no signature from the expected identity")
+)
+
+// signatureRejected returns whether err is an image failing signature verification, which
+// pulling the image again doesn't change
+func signatureRejected(err error) bool {
+	return errors.Is(err, errImageUnsigned) || errors.Is(err, errImageSignatureBad) || errors.Is(err, errImageSignatureIdentity)
+}
+
+// imageSignature is a signature over a payload naming an image's manifest digest, with the
+// signing certificate, the certificates chaining it to a CA and the timestamp response proving
+// when the signature was made if it is a keyless signature
+type imageSignature struct {
+	payload       []byte
+	signature     []byte
+	certificate   *x509.Certificate
+	intermediates []*x509.Certificate
+	timestamp     []byte
+}
+
+// signatureIdentity is the identity keyless signatures must have been issued to. Empty fields
+// match any value.
+type signatureIdentity struct {
+	// The subject alternative name, an email address or URI, of the signing certificate
+	subject string
+	// The OIDC issuer that authenticated the subject
+	issuer string
+}
+
+// required returns whether signatures must be keyless signatures issued to the identity
+func (i signatureIdentity) required() bool {
+	return i.subject != "" || i.issuer != ""
+}
+
+// matches returns whether the signing certificate was issued to the identity
+func (i signatureIdentity) matches(cert *x509.Certificate) bool {
+	if i.subject != "" && !slices.Contains(certificateSubjects(cert), i.subject) {
+		return false
+	}
+	return i.issuer == "" || certificateIssuer(cert) == i.issuer
+}
+
+// certificateSubjects returns the email and URI subject alternative names of cert
+func certificateSubjects(cert *x509.Certificate) []string {
+	subjects := slices.Clone(cert.EmailAddresses)
+	for _, uri := range cert.URIs {
+		subjects = append(subjects, uri.String())
+	}
+	return subjects
+}
+
+// certificateIssuer returns the OIDC issuer recorded in a keyless signing certificate
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidIssuer):
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		case ext.Id.Equal(oidIssuerLegacy):
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
+
+// imageVerifier verifies a pulled image before it is used
+type imageVerifier interface {
+	Verify(ctx context.Context, ref string, target ocispec.Descriptor) error
+}
+
+// keyring holds the public keys trusted to sign images, and the CA certificates trusted to issue
+// certificates for keyless signatures and for the timestamp authorities that timestamp them
+type keyring struct {
+	keys  []crypto.PublicKey
+	roots *x509.CertPool
+}
+
+// loadKeyring reads the PEM encoded ed25519 or ECDSA public keys and CA certificates in the
+// keyring file
+func loadKeyring(path string) (*keyring, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read keyring %s", path)
+	}
+	k := &keyring{}
+	var roots int
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse certificate in keyring %s", path)
+			}
+			if k.roots == nil {
+				k.roots = x509.NewCertPool()
+			}
+			k.roots.AddCert(cert)
+			roots++
+			continue
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse key in keyring %s", path)
+		}
+		switch key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey:
+			k.keys = append(k.keys, key)
+		default:
+			return nil, fmt.Errorf("unsupported key type %T in keyring %s", key, path)
+		}
+	}
+	if len(k.keys) == 0 && roots == 0 {
+		return nil, fmt.Errorf("no public keys in keyring %s", path)
+	}
+	return k, nil
+}
+
+// verify returns whether the signature over its payload is from one of the keys in the keyring,
+// or for keyless signatures, from the key of a certificate issued by one of the keyring's CAs and
+// timestamped while the certificate was valid
+func (k *keyring) verify(signature imageSignature) bool {
+	if signature.certificate != nil {
+		// Without a trusted timestamp, the key of any certificate ever issued for the identity,
+		// including expired and leaked ones, could sign images
+		if signature.timestamp == nil {
+			return false
+		}
+		signedAt, err := k.timestampTime(signature.timestamp, signature.signature)
+		if err != nil {
+			return false
+		}
+		return k.trustsCertificate(signature.certificate, signature.intermediates, signedAt) && verifyWithKey(signature.certificate.PublicKey, signature.payload, signature.signature)
+	}
+	for _, key := range k.keys {
+		if verifyWithKey(key, signature.payload, signature.signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustsCertificate returns whether the keyless signing certificate chained to a CA in the keyring
+// and was valid at the time the signature was made
+func (k *keyring) trustsCertificate(cert *x509.Certificate, intermediates []*x509.Certificate, signedAt time.Time) bool {
+	if k.roots == nil {
+		return false
+	}
+	pool := x509.NewCertPool()
+	for _, intermediate := range intermediates {
+		pool.AddCert(intermediate)
+	}
+	// Keyless signing certificates are short-lived and expire soon after the image is signed,
+	// so the chain is verified as of the time the signature was timestamped
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         k.roots,
+		Intermediates: pool,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	return err == nil
+}
+
+// verifyWithKey returns whether signature is a valid signature over payload by key
+func verifyWithKey(key crypto.PublicKey, payload []byte, signature []byte) bool {
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, signature)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	}
+	return false
+}
+
+// keyringVerifier verifies images against the signatures stored for them in their repository
+type keyringVerifier struct {
+	keyring *keyring
+	// The identity keyless signatures must have been issued to, if any
+	identity signatureIdentity
+	// signatures fetches the signatures attached to the image manifest
+	signatures func(ctx context.Context, ref string, target ocispec.Descriptor) ([]imageSignature, error)
+}
+
+// newKeyringVerifier sets up a verifier for the keyring at path, which fetches signatures with
+// the given resolver
+func newKeyringVerifier(path string, identity signatureIdentity, resolver remotes.Resolver) (*keyringVerifier, error) {
+	k, err := loadKeyring(path)
+	if err != nil {
+		return nil, err
+	}
+	// Any certificate chaining to the CAs would be trusted otherwise, whoever it was issued to
+	if k.roots != nil && !identity.required() {
+		return nil, errors.Errorf("image keyring %s holds CA certificates, which require --cert-identity or --cert-oidc-issuer", path)
+	}
+	return &keyringVerifier{
+		keyring:  k,
+		identity: identity,
+		signatures: func(ctx context.Context, ref string, target ocispec.Descriptor) ([]imageSignature, error) {
+			return fetchSignatures(ctx, resolver, ref, target)
+		},
+	}, nil
+}
+
+// Verify checks that the image manifest is signed by a key in the keyring. If an identity is
+// required, the image must have a keyless signature issued to the identity. Keyless signatures
+// are never accepted without a required identity.
+func (v *keyringVerifier) Verify(ctx context.Context, ref string, target ocispec.Descriptor) error {
+	signatures, err := v.signatures(ctx, ref, target)
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch signatures for %s", ref)
+	}
+	if len(signatures) == 0 {
+		return errors.Wrap(errImageUnsigned, ref)
+	}
+	var unexpectedIdentity bool
+	for _, signature := range signatures {
+		if !v.keyring.verify(signature) || !signsManifest(signature.payload, target.Digest) {
+			continue
+		}
+		keyless := signature.certificate != nil
+		if keyless != v.identity.required() || (keyless && !v.identity.matches(signature.certificate)) {
+			unexpectedIdentity = true
+			continue
+		}
+		log.G(ctx).WithField("ref", ref).WithField("digest", target.Digest).Info("verified image signature")
+		return nil
+	}
+	if unexpectedIdentity {
+		return errors.Wrap(errImageSignatureIdentity, ref)
+	}
+	return errors.Wrap(errImageSignatureBad, ref)
+}
+
+// fetchSignatures fetches the signatures stored for the image manifest in its repository
+func fetchSignatures(ctx context.Context, resolver remotes.Resolver, ref string, target ocispec.Descriptor) ([]imageSignature, error) {
+	sigRef, err := signatureTag(ref, target.Digest)
+	if err != nil {
+		return nil, err
+	}
+	name, desc, err := resolver.Resolve(ctx, sigRef)
+	if errdefs.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var manifest ocispec.Manifest
+	if err := fetchJSON(ctx, fetcher, desc, &manifest); err != nil {
+		return nil, err
+	}
+	var signatures []imageSignature
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != signaturePayloadMediaType {
+			continue
+		}
+		signature, err := base64.StdEncoding.DecodeString(layer.Annotations[signatureAnnotation])
+		if err != nil || len(signature) == 0 {
+			log.G(ctx).WithField("digest", layer.Digest).Warn("ignoring malformed image signature")
+			continue
+		}
+		payload, err := fetchBlob(ctx, fetcher, layer)
+		if err != nil {
+			return nil, err
+		}
+		sig := imageSignature{payload: payload, signature: signature}
+		if certPEM, ok := layer.Annotations[signatureCertificateAnnotation]; ok {
+			certs, err := parseCertificates([]byte(certPEM))
+			if err != nil || len(certs) != 1 {
+				log.G(ctx).WithError(err).WithField("digest", layer.Digest).Warn("ignoring image signature with malformed certificate")
+				continue
+			}
+			sig.certificate = certs[0]
+			if sig.intermediates, err = parseCertificates([]byte(layer.Annotations[signatureChainAnnotation])); err != nil {
+				log.G(ctx).WithError(err).WithField("digest", layer.Digest).Warn("ignoring image signature with malformed certificate chain")
+				continue
+			}
+			if annotation, ok := layer.Annotations[signatureTimestampAnnotation]; ok {
+				if sig.timestamp, err = parseSignatureTimestamp(annotation); err != nil {
+					log.G(ctx).WithError(err).WithField("digest", layer.Digest).Warn("ignoring image signature with malformed timestamp")
+					continue
+				}
+			}
+		}
+		signatures = append(signatures, sig)
+	}
+	return signatures, nil
+}
+
+// parseCertificates parses the PEM encoded certificates
+func parseCertificates(raw []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// newImageVerifier sets up the verifier for images pulled from source, or returns nil if
+// signature verification isn't enabled
+func newImageVerifier(registryConfig *RegistryConfig, source string, pullOpts pullOptions) (imageVerifier, error) {
+	if pullOpts.imageKeyring == "" {
+		return nil, nil
+	}
+	// Private ECR images are pulled with the ECR resolver, which can't fetch their signatures
+	if strings.HasPrefix(source, "ecr.aws/") || ecrRegex.MatchString(source) {
+		return nil, fmt.Errorf("signature verification isn't supported for private ECR image %s", source)
+	}
+	resolver := docker.NewResolver(docker.ResolverOptions{Hosts: configuredRegistryHosts(registryConfig, source), Headers: registryHeaders(pullOpts)})
+	identity := signatureIdentity{subject: pullOpts.certIdentity, issuer: pullOpts.certOIDCIssuer}
+	return newKeyringVerifier(pullOpts.imageKeyring, identity, resolver)
+}
+
+// remoteImageVerifier sets up the verifier for images pulled from source without containerd, or
+// returns nil if signature verification isn't enabled
+func remoteImageVerifier(ctx context.Context, source string, pullOpts pullOptions) (imageVerifier, error) {
+	if pullOpts.imageKeyring == "" {
+		return nil, nil
+	}
+	registryConfig, err := loadRegistryConfig(ctx, pullOpts.registryConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return newImageVerifier(registryConfig, source, pullOpts)
+}
+
+// verifyImage verifies the pulled image against the keyring, removing the image from the image
+// store if it fails verification
+func verifyImage(ctx context.Context, client *containerd.Client, img containerd.Image, registryConfig *RegistryConfig, pullOpts pullOptions) error {
+	verifier, err := newImageVerifier(registryConfig, img.Name(), pullOpts)
+	if err != nil {
+		return err
+	}
+	if err := verifier.Verify(ctx, img.Name(), img.Target()); err != nil {
+		log.G(ctx).WithError(err).WithField("img", img.Name()).Error("image failed signature verification")
+		if err := client.ImageService().Delete(ctx, img.Name()); err != nil {
+			log.G(ctx).WithError(err).WithField("img", img.Name()).Error("failed to remove unverified image")
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestKeyring writes the public keys to a keyring file and returns its path
+func writeTestKeyring(t *testing.T, keys ...crypto.PublicKey) string {
+	var raw []byte
+	for _, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw = append(raw, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	path := filepath.Join(t.TempDir(), "keyring.pem")
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// cosignPayload returns the simple signing payload cosign signs for the image manifest
+func cosignPayload(repository string, dgst digest.Digest) []byte {
+	return []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, repository, dgst))
+}
+
+func TestKeyringVerifier(t *testing.T) {
+	trustedPub, trusted, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, untrusted, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedECDSA, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := loadKeyring(writeTestKeyring(t, trustedPub, &trustedECDSA.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: "sha256:1ed3a1f8b0a3b0e1e1fa2f79ba0f2d36a1d3dcf2b3f4b0c6ffae7b2b7d5e7c3a"}
+	payload := cosignPayload("registry.example.com/bottlerocket/container", target.Digest)
+	payloadDigest := sha256.Sum256(payload)
+	ecdsaSignature, err := ecdsa.SignASN1(rand.Reader, trustedECDSA, payloadDigest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := cosignPayload("registry.example.com/bottlerocket/container", "sha256:0000000000000000000000000000000000000000000000000000000000000000")
+	signed := func(signature []byte) imageSignature {
+		return imageSignature{payload: payload, signature: signature}
+	}
+
+	tests := []struct {
+		name        string
+		signatures  []imageSignature
+		expectedErr error
+	}{
+		{"Signed", []imageSignature{signed(ed25519.Sign(trusted, payload))}, nil},
+		{"Signed with ECDSA", []imageSignature{signed(ecdsaSignature)}, nil},
+		{"Signed by one of several keys", []imageSignature{signed(ed25519.Sign(untrusted, payload)), signed(ed25519.Sign(trusted, payload))}, nil},
+		{"Unsigned", nil, errImageUnsigned},
+		{"Wrong key", []imageSignature{signed(ed25519.Sign(untrusted, payload))}, errImageSignatureBad},
+		{"Signature over a different digest", []imageSignature{{payload: other, signature: ed25519.Sign(trusted, other)}}, errImageSignatureBad},
+		{"Signature over a bare digest", []imageSignature{{payload: []byte(target.Digest), signature: ed25519.Sign(trusted, []byte(target.Digest))}}, errImageSignatureBad},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			verifier := &keyringVerifier{
+				keyring: k,
+				signatures: func(context.Context, string, ocispec.Descriptor) ([]imageSignature, error) {
+					return tc.signatures, nil
+				},
+			}
+			err := verifier.Verify(context.TODO(), "registry.example.com/bottlerocket/container:latest", target)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestLoadKeyringErrors(t *testing.T) {
+	_, err := loadKeyring(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+
+	_, err = loadKeyring(writeTestKeyring(t))
+	assert.Error(t, err)
+}
+
+func TestFetchSignatures(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "amd64"})
+	registry.tag("bottlerocket/container", "latest", manifest)
+	ref := "registry.example.com/bottlerocket/container:latest"
+	verifier, err := newKeyringVerifier(writeTestKeyring(t, pub), signatureIdentity{}, registry.resolver())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ErrorIs(t, verifier.Verify(context.TODO(), ref, manifest), errImageUnsigned)
+
+	// Store the signature the way `cosign sign --key` does, as a manifest tagged after the
+	// image's digest whose layer is the signed payload
+	payload := cosignPayload("registry.example.com/bottlerocket/container", manifest.Digest)
+	layer := registry.addBlob(signaturePayloadMediaType, payload)
+	layer.Annotations = map[string]string{
+		signatureAnnotation: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, payload)),
+	}
+	artifact := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    registry.addBlob(ocispec.MediaTypeImageConfig, []byte("{}")),
+		Layers:    []ocispec.Descriptor{layer},
+	}
+	artifact.SchemaVersion = 2
+	registry.tag("bottlerocket/container", "sha256-"+manifest.Digest.Encoded()+".sig", registry.addJSON(t, ocispec.MediaTypeImageManifest, artifact))
+	assert.NoError(t, verifier.Verify(context.TODO(), ref, manifest))
+}
+
+func TestSignatureTag(t *testing.T) {
+	tag, err := signatureTag("registry.example.com/bottlerocket/container:latest", "sha256:1ed3a1f8b0a3b0e1e1fa2f79ba0f2d36a1d3dcf2b3f4b0c6ffae7b2b7d5e7c3a")
+	assert.NoError(t, err)
+	assert.Equal(t, "registry.example.com/bottlerocket/container:sha256-1ed3a1f8b0a3b0e1e1fa2f79ba0f2d36a1d3dcf2b3f4b0c6ffae7b2b7d5e7c3a.sig", tag)
+}
+
+// testSigningCA issues keyless signing certificates for tests
+type testSigningCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestSigningCA(t *testing.T) *testSigningCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test signing CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSigningCA{cert: cert, key: key}
+}
+
+// issue returns a short-lived, already expired signing certificate for the subject and OIDC
+// issuer, and its key
+func (ca *testSigningCA) issue(t *testing.T, subject string, issuer string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerValue, err := asn1.Marshal(issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       time.Now().Add(-30 * time.Minute),
+		NotAfter:        time.Now().Add(-20 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		ExtraExtensions: []pkix.Extension{{Id: oidIssuer, Value: issuerValue}},
+	}
+	if uri, err := url.Parse(subject); err == nil && uri.Scheme != "" {
+		template.URIs = []*url.URL{uri}
+	} else {
+		template.EmailAddresses = []string{subject}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// writeTestCAKeyring writes the CA certificates to a keyring file and returns its path
+func writeTestCAKeyring(t *testing.T, cas ...*testSigningCA) string {
+	var raw []byte
+	for _, ca := range cas {
+		raw = append(raw, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	}
+	path := filepath.Join(t.TempDir(), "keyring.pem")
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKeyringVerifierIdentity(t *testing.T) {
+	ca := newTestSigningCA(t)
+	k, err := loadKeyring(writeTestCAKeyring(t, ca))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: "sha256:1ed3a1f8b0a3b0e1e1fa2f79ba0f2d36a1d3dcf2b3f4b0c6ffae7b2b7d5e7c3a"}
+	payload := cosignPayload("registry.example.com/bottlerocket/container", target.Digest)
+	payloadDigest := sha256.Sum256(payload)
+	tsa := newTestTSA(t, ca)
+	keyless := func(ca *testSigningCA, subject string, issuer string) imageSignature {
+		cert, key := ca.issue(t, subject, issuer)
+		signature, err := ecdsa.SignASN1(rand.Reader, key, payloadDigest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Signed while the short-lived certificate was valid
+		timestamp := tsa.timestamp(t, 0, signature, cert.NotBefore.Add(time.Minute))
+		return imageSignature{payload: payload, signature: signature, certificate: cert, timestamp: timestamp}
+	}
+
+	const (
+		workflow = "https://github.com/bottlerocket-os/bottlerocket/.github/workflows/release.yml@refs/heads/develop"
+		actions  = "https://token.actions.githubusercontent.com"
+	)
+	release := keyless(ca, workflow, actions)
+	untimestamped := keyless(ca, workflow, actions)
+	untimestamped.timestamp = nil
+	expired := keyless(ca, workflow, actions)
+	expired.timestamp = tsa.timestamp(t, 0, expired.signature, expired.certificate.NotAfter.Add(time.Minute))
+	tests := []struct {
+		name        string
+		identity    signatureIdentity
+		signatures  []imageSignature
+		expectedErr error
+	}{
+		{"Matching identity and issuer", signatureIdentity{workflow, actions}, []imageSignature{release}, nil},
+		{"Matching email identity", signatureIdentity{"builder@example.com", ""}, []imageSignature{keyless(ca, "builder@example.com", actions)}, nil},
+		{"Matching issuer only", signatureIdentity{"", actions}, []imageSignature{release}, nil},
+		{"No identity required", signatureIdentity{}, []imageSignature{release}, errImageSignatureIdentity},
+		{"One of several signatures matches", signatureIdentity{workflow, actions}, []imageSignature{keyless(ca, "someone@example.com", actions), release}, nil},
+		{"Unexpected identity", signatureIdentity{workflow, actions}, []imageSignature{keyless(ca, "someone@example.com", actions)}, errImageSignatureIdentity},
+		{"Unexpected issuer", signatureIdentity{workflow, actions}, []imageSignature{keyless(ca, workflow, "https://accounts.example.com")}, errImageSignatureIdentity},
+		{"Certificate from an untrusted CA", signatureIdentity{workflow, actions}, []imageSignature{keyless(newTestSigningCA(t), workflow, actions)}, errImageSignatureBad},
+		{"Signature by a different key", signatureIdentity{workflow, actions}, []imageSignature{{payload: payload, signature: keyless(ca, workflow, actions).signature, certificate: release.certificate, timestamp: release.timestamp}}, errImageSignatureBad},
+		{"Signature without a timestamp", signatureIdentity{workflow, actions}, []imageSignature{untimestamped}, errImageSignatureBad},
+		{"Signature timestamped after the certificate expired", signatureIdentity{workflow, actions}, []imageSignature{expired}, errImageSignatureBad},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			verifier := &keyringVerifier{
+				keyring:  k,
+				identity: tc.identity,
+				signatures: func(context.Context, string, ocispec.Descriptor) ([]imageSignature, error) {
+					return tc.signatures, nil
+				},
+			}
+			err := verifier.Verify(context.TODO(), "registry.example.com/bottlerocket/container:latest", target)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestKeyringVerifierIdentityRequiresKeyless(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := loadKeyring(writeTestKeyring(t, pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: "sha256:1ed3a1f8b0a3b0e1e1fa2f79ba0f2d36a1d3dcf2b3f4b0c6ffae7b2b7d5e7c3a"}
+	verifier := &keyringVerifier{
+		keyring:  k,
+		identity: signatureIdentity{subject: "builder@example.com"},
+		signatures: func(context.Context, string, ocispec.Descriptor) ([]imageSignature, error) {
+			payload := cosignPayload("registry.example.com/bottlerocket/container", target.Digest)
+			return []imageSignature{{payload: payload, signature: ed25519.Sign(priv, payload)}}, nil
+		},
+	}
+	err = verifier.Verify(context.TODO(), "registry.example.com/bottlerocket/container:latest", target)
+	assert.ErrorIs(t, err, errImageSignatureIdentity)
+}
+
+func TestNewKeyringVerifierCARequiresIdentity(t *testing.T) {
+	path := writeTestCAKeyring(t, newTestSigningCA(t))
+	_, err := newKeyringVerifier(path, signatureIdentity{}, nil)
+	assert.ErrorContains(t, err, "--cert-identity")
+	_, err = newKeyringVerifier(path, signatureIdentity{issuer: "https://token.actions.githubusercontent.com"}, nil)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// The time allowed to look up a mirror's endpoints
+const srvLookupTimeout = 5 * time.Second
+
+// srvResolver looks up SRV records, like net.Resolver
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// The resolver for mirror endpoint SRV records, the registry dialer's resolver unless replaced
+var mirrorSRVResolver srvResolver
+
+// LookupSRV looks up SRV records with the dialer's resolver, if it has one
+func (d *registryDialer) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if d.resolver != nil {
+		return d.resolver.LookupSRV(ctx, service, proto, name)
+	}
+	return net.DefaultResolver.LookupSRV(ctx, service, proto, name)
+}
+
+// discoverEndpoints returns the endpoints in the SRV records at name, e.g.
+// `_https._tcp.mirror.internal`, in the order they should be tried. Records under `_http._tcp`
+// are plain HTTP endpoints, all others use HTTPS.
+func discoverEndpoints(ctx context.Context, resolver srvResolver, name string) ([]string, error) {
+	_, records, err := resolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to look up SRV records for %s", name)
+	}
+	scheme := "https"
+	if strings.HasPrefix(name, "_http._tcp.") {
+		scheme = "http"
+	}
+	var endpoints []string
+	for _, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		// A target of "." means the service isn't available at this name
+		if target == "" {
+			continue
+		}
+		endpoints = append(endpoints, fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(target, strconv.Itoa(int(record.Port)))))
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no SRV records for %s", name)
+	}
+	return endpoints, nil
+}
+
+// srvLookups holds the endpoints discovered at each SRV name. The registry hosts are set up for
+// every request a resolver makes, so each resolver looks its mirrors' records up only once.
+type srvLookups struct {
+	mu      sync.Mutex
+	results map[string]srvLookup
+}
+
+// srvLookup is the result of discovering the endpoints at an SRV name
+type srvLookup struct {
+	endpoints []string
+	err       error
+}
+
+// lookup returns the endpoints discovered at name, looking them up the first time only
+func (l *srvLookups) lookup(name string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if result, ok := l.results[name]; ok {
+		return result.endpoints, result.err
+	}
+	resolver := mirrorSRVResolver
+	if resolver == nil {
+		resolver = defaultRegistryDialer
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), srvLookupTimeout)
+	defer cancel()
+	endpoints, err := discoverEndpoints(ctx, resolver, name)
+	if l.results == nil {
+		l.results = map[string]srvLookup{}
+	}
+	l.results[name] = srvLookup{endpoints: endpoints, err: err}
+	if err != nil {
+		log.L.WithError(err).Warn("failed to discover mirror endpoints, using the configured endpoints")
+	}
+	return endpoints, err
+}
+
+// discoveredEndpoints returns the mirror's endpoints, discovering them from its SRV records if
+// enabled. The mirror's static endpoints are used if discovery fails.
+func (m Mirror) discoveredEndpoints(lookups *srvLookups) []string {
+	if !m.SRVDiscovery || m.SRVName == "" {
+		return m.Endpoints
+	}
+	endpoints, err := lookups.lookup(m.SRVName)
+	if err != nil {
+		return m.Endpoints
+	}
+	return endpoints
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubSRVResolver returns fixed SRV records by name
+type stubSRVResolver map[string][]*net.SRV
+
+func (r stubSRVResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	records, ok := r[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, records, nil
+}
+
+var testSRVResolver = stubSRVResolver{
+	"_https._tcp.mirror.internal": {
+		{Target: "mirror-1.internal.", Port: 443, Priority: 10},
+		{Target: "mirror-2.internal.", Port: 5000, Priority: 20},
+	},
+	"_http._tcp.mirror.internal": {
+		{Target: "mirror-3.internal.", Port: 80},
+	},
+	"_https._tcp.unavailable.internal": {
+		{Target: ".", Port: 0},
+	},
+}
+
+func TestDiscoverEndpoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		srvName   string
+		endpoints []string
+		fails     bool
+	}{
+		{"https", "_https._tcp.mirror.internal", []string{"https://mirror-1.internal:443", "https://mirror-2.internal:5000"}, false},
+		{"http", "_http._tcp.mirror.internal", []string{"http://mirror-3.internal:80"}, false},
+		{"unavailable", "_https._tcp.unavailable.internal", nil, true},
+		{"missing", "_https._tcp.missing.internal", nil, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			endpoints, err := discoverEndpoints(context.TODO(), testSRVResolver, tc.srvName)
+			if tc.fails {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.endpoints, endpoints)
+		})
+	}
+}
+
+func TestRegistryHostsSRVDiscovery(t *testing.T) {
+	defer func(resolver srvResolver) { mirrorSRVResolver = resolver }(mirrorSRVResolver)
+	mirrorSRVResolver = testSRVResolver
+
+	tests := []struct {
+		name     string
+		mirror   Mirror
+		expected []string
+	}{
+		{
+			"discovered",
+			Mirror{SRVDiscovery: true, SRVName: "_https._tcp.mirror.internal", Endpoints: []string{"https://static.internal"}},
+			[]string{"mirror-1.internal:443", "mirror-2.internal:5000", "registry-1.docker.io"},
+		},
+		{
+			"fall back to static endpoints",
+			Mirror{SRVDiscovery: true, SRVName: "_https._tcp.missing.internal", Endpoints: []string{"https://static.internal"}},
+			[]string{"static.internal", "registry-1.docker.io"},
+		},
+		{
+			"discovery disabled",
+			Mirror{SRVName: "_https._tcp.mirror.internal", Endpoints: []string{"https://static.internal"}},
+			[]string{"static.internal", "registry-1.docker.io"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &RegistryConfig{Mirrors: map[string]Mirror{"docker.io": tc.mirror}}
+			registries, err := registryHosts(config, nil, "")("docker.io")
+			assert.NoError(t, err)
+			var hosts []string
+			for _, registry := range registries {
+				hosts = append(hosts, registry.Host)
+			}
+			assert.Equal(t, tc.expected, hosts)
+		})
+	}
+}
+
+// countingSRVResolver counts the SRV lookups passed on to its resolver
+type countingSRVResolver struct {
+	srvResolver
+	lookups int
+}
+
+func (r *countingSRVResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.lookups++
+	return r.srvResolver.LookupSRV(ctx, service, proto, name)
+}
+
+func TestRegistryHostsSRVLookupOnce(t *testing.T) {
+	defer func(resolver srvResolver) { mirrorSRVResolver = resolver }(mirrorSRVResolver)
+	resolver := &countingSRVResolver{srvResolver: testSRVResolver}
+	mirrorSRVResolver = resolver
+
+	config := &RegistryConfig{Mirrors: map[string]Mirror{
+		"docker.io": {SRVDiscovery: true, SRVName: "_https._tcp.mirror.internal"},
+		"quay.io":   {SRVDiscovery: true, SRVName: "_https._tcp.missing.internal", Endpoints: []string{"https://static.internal"}},
+	}}
+	hosts := registryHosts(config, nil, "")
+	for i := 0; i < 3; i++ {
+		registries, err := hosts("docker.io")
+		assert.NoError(t, err)
+		assert.Equal(t, "mirror-1.internal:443", registries[0].Host)
+		// Failed lookups aren't repeated either
+		registries, err = hosts("quay.io")
+		assert.NoError(t, err)
+		assert.Equal(t, "static.internal", registries[0].Host)
+	}
+	assert.Equal(t, 2, resolver.lookups)
+}
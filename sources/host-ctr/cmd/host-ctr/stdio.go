@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"github.com/containerd/containerd/cio"
+)
+
+// taskStreams returns the IO streams of the container's task. Its output always goes to
+// host-ctr's stdout and stderr, but only interactive containers read from host-ctr's stdin.
+// Containers are interactive unless --interactive=false is set.
+func taskStreams(interactive bool) cio.Opt {
+	if interactive {
+		return cio.WithStdio
+	}
+	return cio.WithStreams(nil, os.Stdout, os.Stderr)
+}
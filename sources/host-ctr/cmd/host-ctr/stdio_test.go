@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/containerd/containerd/cio"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+func TestTaskStreams(t *testing.T) {
+	tests := []struct {
+		name          string
+		interactive   bool
+		expectedStdin bool
+	}{
+		{"Interactive", true, true},
+		{"Not interactive", false, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var streams cio.Streams
+			taskStreams(tc.interactive)(&streams)
+			if tc.expectedStdin {
+				assert.Equal(t, os.Stdin, streams.Stdin)
+			} else {
+				assert.Nil(t, streams.Stdin)
+			}
+			assert.Equal(t, os.Stdout, streams.Stdout)
+			assert.Equal(t, os.Stderr, streams.Stderr)
+		})
+	}
+}
+
+func TestTaskStreamsNotInteractive(t *testing.T) {
+	// Without --interactive, the task is created without a stdin FIFO, so the process has no stdin
+	creator := cio.NewCreator(taskStreams(false), cio.WithFIFODir(t.TempDir()))
+	io, err := creator("container")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer io.Close()
+	config := io.Config()
+	assert.Empty(t, config.Stdin)
+	assert.NotEmpty(t, config.Stdout)
+	assert.NotEmpty(t, config.Stderr)
+	assert.False(t, config.Terminal)
+}
+
+func TestInteractiveByDefault(t *testing.T) {
+	// Containers read from host-ctr's stdin unless asked not to, as they always did
+	var run *cli.Command
+	for _, command := range App().Commands {
+		if command.Name == "run" {
+			run = command
+		}
+	}
+	if !assert.NotNil(t, run) {
+		return
+	}
+	for _, flag := range run.Flags {
+		if boolFlag, ok := flag.(*cli.BoolFlag); ok && boolFlag.Name == "interactive" {
+			assert.True(t, boolFlag.Value)
+			return
+		}
+	}
+	t.Error("run has no --interactive flag")
+}
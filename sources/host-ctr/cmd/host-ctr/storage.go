@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// The root directory of host-containerd
+	defaultContainerdRoot = "/var/lib/host-containerd"
+	// Warn when less than this percentage of a storage filesystem is available
+	storageLowSpacePercent = 5
+)
+
+// The host-containerd directories that hold image content and snapshots, relative to its root
+var storageDirs = []string{
+	"io.containerd.content.v1.content",
+	"io.containerd.snapshotter.v1.overlayfs",
+}
+
+// checkStorage resolves host-containerd's content and snapshot directories and warns if they're
+// on a filesystem that can't hold pulled images. The checks never fail the command, since
+// host-containerd owns these directories and may be configured differently.
+func checkStorage(ctx context.Context, containerdRoot string) {
+	for _, dir := range storageDirs {
+		path := filepath.Join(containerdRoot, dir)
+		resolved, warnings, err := checkStoragePath(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.G(ctx).WithError(err).WithField("path", path).Warn("failed to check storage path")
+			}
+			continue
+		}
+		entry := log.G(ctx).WithField("path", path)
+		if resolved != path {
+			entry = entry.WithField("resolved", resolved)
+			entry.Debug("storage path is a symlink")
+		}
+		for _, warning := range warnings {
+			entry.Warn(warning)
+		}
+	}
+}
+
+// checkStoragePath follows any symlinks in path and returns the resolved path along with
+// warnings about the filesystem it's on
+func checkStoragePath(path string) (string, []string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", nil, err
+	}
+	if !info.IsDir() {
+		return resolved, []string{"storage path is not a directory"}, nil
+	}
+	var stat unix.Statfs_t
+	if err := unix.Statfs(resolved, &stat); err != nil {
+		return "", nil, err
+	}
+	return resolved, storageWarnings(stat), nil
+}
+
+// storageWarnings returns the reasons a filesystem can't hold pulled images
+func storageWarnings(stat unix.Statfs_t) []string {
+	var warnings []string
+	if stat.Flags&unix.ST_RDONLY != 0 {
+		warnings = append(warnings, "storage path is on a read-only filesystem")
+	}
+	if stat.Blocks > 0 && stat.Bavail*100 < stat.Blocks*storageLowSpacePercent {
+		warnings = append(warnings, fmt.Sprintf("storage path has only %d of %d bytes available",
+			stat.Bavail*uint64(stat.Bsize), stat.Blocks*uint64(stat.Bsize)))
+	}
+	return warnings
+}
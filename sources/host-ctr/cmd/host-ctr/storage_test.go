@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestCheckStoragePathSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "data", "content")
+	assert.NoError(t, os.MkdirAll(target, 0o755))
+	link := filepath.Join(dir, "content")
+	assert.NoError(t, os.Symlink(target, link))
+
+	resolved, _, err := checkStoragePath(link)
+	assert.NoError(t, err)
+	expected, err := filepath.EvalSymlinks(target)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, resolved)
+}
+
+func TestCheckStoragePathErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	// Dangling symlinks can't be resolved
+	link := filepath.Join(dir, "dangling")
+	assert.NoError(t, os.Symlink(filepath.Join(dir, "missing"), link))
+	_, _, err := checkStoragePath(link)
+	assert.True(t, os.IsNotExist(err))
+
+	file := filepath.Join(dir, "file")
+	assert.NoError(t, os.WriteFile(file, nil, 0o644))
+	_, warnings, err := checkStoragePath(file)
+	assert.NoError(t, err)
+	assert.Len(t, warnings, 1)
+}
+
+func TestStorageWarnings(t *testing.T) {
+	tests := []struct {
+		name             string
+		stat             unix.Statfs_t
+		expectedWarnings int
+	}{
+		{"Healthy", unix.Statfs_t{Bsize: 4096, Blocks: 1000, Bavail: 500}, 0},
+		{"Read-only", unix.Statfs_t{Bsize: 4096, Blocks: 1000, Bavail: 500, Flags: unix.ST_RDONLY}, 1},
+		{"Nearly full", unix.Statfs_t{Bsize: 4096, Blocks: 1000, Bavail: 10}, 1},
+		{"Read-only and full", unix.Statfs_t{Bsize: 4096, Blocks: 1000, Flags: unix.ST_RDONLY}, 2},
+		{"No blocks reported", unix.Statfs_t{}, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Len(t, storageWarnings(tc.stat), tc.expectedWarnings)
+		})
+	}
+}
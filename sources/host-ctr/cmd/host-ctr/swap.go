@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// swapSteps are the steps of replacing a running container with one created from a new image
+type swapSteps struct {
+	// Stops and removes the running container
+	stopOld func(ctx context.Context) error
+	// Creates and starts the container from the new image
+	startNew func(ctx context.Context) error
+	// Removes whatever startNew left behind when it failed
+	cleanUpNew func(ctx context.Context) error
+	// Creates and starts the container from the previous image again
+	restartOld func(ctx context.Context) error
+}
+
+// swapContainer stops the running container and starts one from the new image in its place.
+// If the new container fails to start, it's removed and the container is started from the
+// previous image again, so a bad image doesn't leave the host without the container.
+func swapContainer(ctx context.Context, steps swapSteps) error {
+	if err := steps.stopOld(ctx); err != nil {
+		return errors.Wrap(err, "failed to stop the running container")
+	}
+	startErr := steps.startNew(ctx)
+	if startErr == nil {
+		return nil
+	}
+	log.G(ctx).WithError(startErr).Warn("failed to start the container from the new image, rolling back")
+	if err := steps.cleanUpNew(ctx); err != nil {
+		log.G(ctx).WithError(err).Warn("failed to remove the container created from the new image")
+	}
+	if err := steps.restartOld(ctx); err != nil {
+		return errors.Wrapf(err, "failed to roll back to the previous image after the new container failed to start: %v", startErr)
+	}
+	return errors.Wrap(startErr, "rolled back to the previous image")
+}
+
+// leaseImage leases the image's content and its snapshot unpacked into the default snapshotter,
+// so garbage collection keeps them once the image's tag points at a new digest and the container
+// created from the image is removed. The returned func releases the lease.
+func leaseImage(ctx context.Context, client *containerd.Client, img containerd.Image) (func(), error) {
+	diffIDs, err := img.RootFS(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read root filesystem of %s", img.Name())
+	}
+	resources, err := imageLeaseResources(ctx, client.ContentStore(), img.Target(), img.Platform(), identity.ChainID(diffIDs).String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list the content of %s", img.Name())
+	}
+	manager := client.LeasesService()
+	lease, err := manager.Create(ctx, leases.WithRandomID(), leases.WithExpiration(24*time.Hour))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create lease")
+	}
+	release := func() {
+		// The lease is released after the swap whether or not the swap's context is done
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		if err := manager.Delete(ctx, lease); err != nil {
+			log.G(ctx).WithError(err).WithField("lease", lease.ID).Warn("failed to release lease")
+		}
+	}
+	for _, resource := range resources {
+		if err := manager.AddResource(ctx, lease, resource); err != nil {
+			release()
+			return nil, errors.Wrapf(err, "failed to lease %s", resource.ID)
+		}
+	}
+	return release, nil
+}
+
+// imageLeaseResources returns the lease resources for the content of the image at target for
+// the platforms matched, and for its snapshot in the default snapshotter at chainID. Content
+// missing from the store isn't leased.
+func imageLeaseResources(ctx context.Context, provider content.Provider, target ocispec.Descriptor, matcher platforms.MatchComparer, chainID string) ([]leases.Resource, error) {
+	var resources []leases.Resource
+	children := images.LimitManifests(images.FilterPlatforms(images.ChildrenHandler(provider), matcher), matcher, 1)
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		resources = append(resources, leases.Resource{ID: desc.Digest.String(), Type: "content"})
+		descs, err := children(ctx, desc)
+		if errdefs.IsNotFound(err) {
+			return nil, nil
+		}
+		return descs, err
+	})
+	if err := images.Walk(ctx, handler, target); err != nil {
+		return nil, err
+	}
+	resources = append(resources, leases.Resource{ID: chainID, Type: "snapshots/" + containerd.DefaultSnapshotter})
+	return resources, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSwap records the swap steps that ran, failing the steps named in failures
+func fakeSwap(ran *[]string, failures ...string) swapSteps {
+	step := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			*ran = append(*ran, name)
+			if SliceContains(failures, name) {
+				return errors.New(name + " failed")
+			}
+			return nil
+		}
+	}
+	return swapSteps{
+		stopOld:    step("stopOld"),
+		startNew:   step("startNew"),
+		cleanUpNew: step("cleanUpNew"),
+		restartOld: step("restartOld"),
+	}
+}
+
+func TestSwapContainer(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []string
+		expected []string
+		errMsg   string
+	}{
+		{"successful swap", nil, []string{"stopOld", "startNew"}, ""},
+		{"rollback", []string{"startNew"}, []string{"stopOld", "startNew", "cleanUpNew", "restartOld"}, "rolled back to the previous image: startNew failed"},
+		{"rollback without cleanup", []string{"startNew", "cleanUpNew"}, []string{"stopOld", "startNew", "cleanUpNew", "restartOld"}, "rolled back to the previous image: startNew failed"},
+		{"failed rollback", []string{"startNew", "restartOld"}, []string{"stopOld", "startNew", "cleanUpNew", "restartOld"}, "failed to roll back to the previous image after the new container failed to start: startNew failed: restartOld failed"},
+		{"old container keeps running", []string{"stopOld"}, []string{"stopOld"}, "failed to stop the running container: stopOld failed"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var ran []string
+			err := swapContainer(context.TODO(), fakeSwap(&ran, tc.failures...))
+			assert.Equal(t, tc.expected, ran)
+			if tc.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.errMsg)
+		})
+	}
+}
+
+func TestImageLeaseResources(t *testing.T) {
+	registry := newFakeRegistry(t)
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	amd64Manifest, amd64Config := registry.addImage(t, amd64, []byte("amd64 layer"))
+	arm64Manifest, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "arm64"}, []byte("arm64 layer"))
+	index := registry.addIndex(t, amd64Manifest, arm64Manifest)
+	registry.tag("bottlerocket/container", "latest", index)
+
+	// Only the pulled platform's content is in the store
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fetchToContentStore(context.TODO(), registry.resolver(), "registry.example.com/bottlerocket/container:latest", platforms.Only(amd64), store, nil)
+	assert.NoError(t, err)
+
+	resources, err := imageLeaseResources(context.TODO(), store, index, platforms.Only(amd64), "sha256:chain")
+	assert.NoError(t, err)
+	var expected []leases.Resource
+	for _, dgst := range []digest.Digest{index.Digest, amd64Manifest.Digest, amd64Config.Digest, digest.FromBytes([]byte("amd64 layer"))} {
+		expected = append(expected, leases.Resource{ID: dgst.String(), Type: "content"})
+	}
+	expected = append(expected, leases.Resource{ID: "sha256:chain", Type: "snapshots/" + containerd.DefaultSnapshotter})
+	assert.ElementsMatch(t, expected, resources)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/log"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// tagMutationError is returned when a tag that was already pulled now points at a different image
+type tagMutationError struct {
+	Ref       string
+	OldDigest digest.Digest
+	NewDigest digest.Digest
+}
+
+func (e *tagMutationError) Error() string {
+	return fmt.Sprintf("tag %s changed from %s to %s since it was last pulled; use --allow-tag-mutation to pull it anyway",
+		e.Ref, e.OldDigest, e.NewDigest)
+}
+
+// checkTagMutation compares the digest of the locally stored image for ref against the digest
+// the registry resolves ref to. A changed digest is an error unless allowMutation is set, in
+// which case it is only logged. Failures to resolve ref are left for the pull to report.
+func checkTagMutation(ctx context.Context, ref string, local ocispec.Descriptor, resolver remotes.Resolver, allowMutation bool) error {
+	spec, err := reference.Parse(ref)
+	if err != nil {
+		return err
+	}
+	// Digest references can't change
+	if spec.Digest() != "" {
+		return nil
+	}
+	_, remote, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		log.G(ctx).WithError(err).WithField("ref", ref).Debug("failed to resolve image to check for tag mutation")
+		return nil
+	}
+	if remote.Digest == local.Digest {
+		return nil
+	}
+	err = &tagMutationError{Ref: ref, OldDigest: local.Digest, NewDigest: remote.Digest}
+	if allowMutation {
+		log.G(ctx).WithField("old-digest", local.Digest).WithField("new-digest", remote.Digest).WithField("ref", ref).Warn("image tag changed since it was last pulled")
+		return nil
+	}
+	return err
+}
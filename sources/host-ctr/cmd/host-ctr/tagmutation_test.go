@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTagMutation(t *testing.T) {
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, ocispec.Platform{OS: "linux", Architecture: "amd64"})
+	registry.tag("bottlerocket/container", "latest", manifest)
+	ref := "registry.example.com/bottlerocket/container:latest"
+	old := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("old manifest")}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		assert.NoError(t, checkTagMutation(context.TODO(), ref, manifest, registry.resolver(), false))
+	})
+	t.Run("Mutation detected", func(t *testing.T) {
+		err := checkTagMutation(context.TODO(), ref, old, registry.resolver(), false)
+		var mutation *tagMutationError
+		if assert.ErrorAs(t, err, &mutation) {
+			assert.Equal(t, old.Digest, mutation.OldDigest)
+			assert.Equal(t, manifest.Digest, mutation.NewDigest)
+		}
+	})
+	t.Run("Mutation allowed", func(t *testing.T) {
+		assert.NoError(t, checkTagMutation(context.TODO(), ref, old, registry.resolver(), true))
+	})
+	t.Run("Digest reference", func(t *testing.T) {
+		ref := "registry.example.com/bottlerocket/container@" + manifest.Digest.String()
+		assert.NoError(t, checkTagMutation(context.TODO(), ref, old, registry.resolver(), false))
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mozilla.org/pkcs7"
+)
+
+// The annotation on a cosign signature layer holding the RFC 3161 timestamp over the signature,
+// as JSON with the base64 encoded timestamp response
+const signatureTimestampAnnotation = "dev.sigstore.cosign/rfc3161timestamp"
+
+// The content type of RFC 3161 timestamp tokens
+var oidTSTInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+
+// The hash algorithms timestamps may use for the message imprint
+var timestampHashes = map[string]crypto.Hash{
+	"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+	"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+	"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+}
+
+// signatureTimestamp is the value of the timestamp annotation on a cosign signature
+type signatureTimestamp struct {
+	SignedRFC3161Timestamp []byte
+}
+
+// timeStampResp is an RFC 3161 timestamp response
+type timeStampResp struct {
+	Status struct {
+		Status int
+	}
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// tstInfo is the signed content of an RFC 3161 timestamp token. The fields after the time the
+// timestamp was generated at aren't used.
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint struct {
+		HashAlgorithm struct {
+			Algorithm asn1.ObjectIdentifier
+		}
+		HashedMessage []byte
+	}
+	SerialNumber *big.Int
+	GenTime      time.Time `asn1:"generalized"`
+}
+
+// parseSignatureTimestamp returns the timestamp response in the timestamp annotation
+func parseSignatureTimestamp(annotation string) ([]byte, error) {
+	var ts signatureTimestamp
+	if err := json.Unmarshal([]byte(annotation), &ts); err != nil {
+		return nil, err
+	}
+	if len(ts.SignedRFC3161Timestamp) == 0 {
+		return nil, errors.New("empty timestamp")
+	}
+	return ts.SignedRFC3161Timestamp, nil
+}
+
+// timestampTime verifies the RFC 3161 timestamp response over signature, which must be issued by
+// a timestamp authority chaining to one of the keyring's CAs, and returns the time it attests the
+// signature existed at
+func (k *keyring) timestampTime(resp []byte, signature []byte) (time.Time, error) {
+	if k.roots == nil {
+		return time.Time{}, errors.New("no CAs in the keyring")
+	}
+	var tsr timeStampResp
+	if _, err := asn1.Unmarshal(resp, &tsr); err != nil {
+		return time.Time{}, errors.Wrap(err, "malformed timestamp response")
+	}
+	// 0 is granted, 1 is granted with modifications
+	if tsr.Status.Status > 1 || len(tsr.TimeStampToken.FullBytes) == 0 {
+		return time.Time{}, fmt.Errorf("timestamp wasn't granted, status %d", tsr.Status.Status)
+	}
+	token, err := pkcs7.Parse(tsr.TimeStampToken.FullBytes)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "malformed timestamp token")
+	}
+	var contentType asn1.ObjectIdentifier
+	if err := token.UnmarshalSignedAttribute(pkcs7.OIDAttributeContentType, &contentType); err != nil || !contentType.Equal(oidTSTInfo) {
+		return time.Time{}, errors.New("timestamp token doesn't hold a timestamp")
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(token.Content, &info); err != nil {
+		return time.Time{}, errors.Wrap(err, "malformed timestamp")
+	}
+	hash, ok := timestampHashes[info.MessageImprint.HashAlgorithm.Algorithm.String()]
+	if !ok {
+		return time.Time{}, fmt.Errorf("unsupported timestamp hash algorithm %s", info.MessageImprint.HashAlgorithm.Algorithm)
+	}
+	h := hash.New()
+	h.Write(signature)
+	if !bytes.Equal(h.Sum(nil), info.MessageImprint.HashedMessage) {
+		return time.Time{}, errors.New("timestamp isn't over the signature")
+	}
+	tsa := token.GetOnlySigner()
+	if tsa == nil || !slices.Contains(tsa.ExtKeyUsage, x509.ExtKeyUsageTimeStamping) {
+		return time.Time{}, errors.New("timestamp isn't signed by a timestamp authority")
+	}
+	if err := token.VerifyWithChainAtTime(k.roots, info.GenTime); err != nil {
+		return time.Time{}, errors.Wrap(err, "untrusted timestamp")
+	}
+	return info.GenTime, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mozilla.org/pkcs7"
+)
+
+// testTSA is an RFC 3161 timestamp authority for tests, with a certificate issued by a test CA
+type testTSA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestTSA(t *testing.T, ca *testSigningCA) *testTSA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testTSA{cert: cert, key: key}
+}
+
+// timestamp returns a timestamp response with the status, over the signature, generated at the
+// given time
+func (tsa *testTSA) timestamp(t *testing.T, status int, signature []byte, at time.Time) []byte {
+	imprint := sha256.Sum256(signature)
+	info := tstInfo{
+		Version:      1,
+		Policy:       asn1.ObjectIdentifier{1, 2, 3, 4},
+		SerialNumber: big.NewInt(1),
+		GenTime:      at.UTC().Truncate(time.Second),
+	}
+	info.MessageImprint.HashAlgorithm.Algorithm = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	info.MessageImprint.HashedMessage = imprint[:]
+	content, err := asn1.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.GetSignedData().ContentInfo.ContentType = oidTSTInfo
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSigner(tsa.cert, tsa.key, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := sd.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := timeStampResp{TimeStampToken: asn1.RawValue{FullBytes: token}}
+	resp.Status.Status = status
+	raw, err := asn1.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestTimestampTime(t *testing.T) {
+	ca := newTestSigningCA(t)
+	k, err := loadKeyring(writeTestCAKeyring(t, ca))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsa := newTestTSA(t, ca)
+	signature := []byte("signature")
+	signedAt := time.Now().Add(-25 * time.Minute).UTC().Truncate(time.Second)
+
+	// A signing certificate can't issue timestamps
+	notTSA := &testTSA{}
+	notTSA.cert, notTSA.key = ca.issue(t, "builder@example.com", "https://token.actions.githubusercontent.com")
+
+	tests := []struct {
+		name        string
+		resp        []byte
+		expectedErr bool
+	}{
+		{"Valid timestamp", tsa.timestamp(t, 0, signature, signedAt), false},
+		{"Granted with modifications", tsa.timestamp(t, 1, signature, signedAt), false},
+		{"Rejected", tsa.timestamp(t, 2, signature, signedAt), true},
+		{"Over a different signature", tsa.timestamp(t, 0, []byte("other signature"), signedAt), true},
+		{"Untrusted timestamp authority", newTestTSA(t, newTestSigningCA(t)).timestamp(t, 0, signature, signedAt), true},
+		{"Not a timestamp authority", notTSA.timestamp(t, 0, signature, signedAt), true},
+		{"Malformed", []byte("timestamp"), true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			at, err := k.timestampTime(tc.resp, signature)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, signedAt.Equal(at), "expected %s, got %s", signedAt, at)
+		})
+	}
+}
+
+func TestParseSignatureTimestamp(t *testing.T) {
+	resp, err := parseSignatureTimestamp(`{"SignedRFC3161Timestamp":"` + base64.StdEncoding.EncodeToString([]byte("response")) + `"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("response"), resp)
+
+	_, err = parseSignatureTimestamp(`{}`)
+	assert.Error(t, err)
+	_, err = parseSignatureTimestamp(`response`)
+	assert.Error(t, err)
+}
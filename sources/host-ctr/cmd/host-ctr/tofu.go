@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// The default location of the certificate fingerprints recorded for trust on first use
+const defaultTrustStateFile = "/var/lib/host-ctr/tofu.json"
+
+// tofuStore records the certificate fingerprint first seen for each registry host, and
+// rejects connections to the host if it later presents a different certificate.
+// This is meant for lab registries with self-signed certificates, never for production.
+type tofuStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// newTOFUStore sets up a tofuStore backed by the state file at path
+func newTOFUStore(path string) *tofuStore {
+	if path == "" {
+		path = defaultTrustStateFile
+	}
+	return &tofuStore{path: path}
+}
+
+// verify checks the leaf certificate presented by host against the recorded fingerprint for the
+// host, recording the fingerprint if the host hasn't been seen before
+func (s *tofuStore) verify(host string, rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("no certificate presented by %s", host)
+	}
+	sum := sha256.Sum256(rawCerts[0])
+	fingerprint := "sha256:" + hex.EncodeToString(sum[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fingerprints, err := s.load()
+	if err != nil {
+		return err
+	}
+	known, ok := fingerprints[host]
+	if !ok {
+		fingerprints[host] = fingerprint
+		if err := s.save(fingerprints); err != nil {
+			return err
+		}
+		log.L.WithField("host", host).WithField("fingerprint", fingerprint).Warn("trusting registry certificate on first use")
+		return nil
+	}
+	if known != fingerprint {
+		return fmt.Errorf("certificate for %s changed since first use: expected %s, got %s", host, known, fingerprint)
+	}
+	return nil
+}
+
+// load reads the recorded fingerprints, keyed by host
+func (s *tofuStore) load() (map[string]string, error) {
+	fingerprints := map[string]string{}
+	raw, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return fingerprints, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read trust state file %s", s.path)
+	}
+	if err := json.Unmarshal(raw, &fingerprints); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse trust state file %s", s.path)
+	}
+	return fingerprints, nil
+}
+
+// save atomically replaces the recorded fingerprints
+func (s *tofuStore) save(fingerprints map[string]string) error {
+	raw, err := json.Marshal(fingerprints)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return errors.Wrapf(checkReadOnly(s.path, err), "failed to create directory for trust state file %s", s.path)
+	}
+	return errors.Wrapf(writeFileAtomic(s.path, raw, 0o600), "failed to write trust state file %s", s.path)
+}
+
+// pin sets up config to verify host's certificate with trust on first use
+func (s *tofuStore) pin(config *tls.Config, host string) {
+	// The certificate chain isn't verified, it is pinned by VerifyPeerCertificate instead
+	config.InsecureSkipVerify = true //nolint:gosec
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		return s.verify(host, rawCerts)
+	}
+}
+
+// client returns an HTTP client for host that verifies its certificate with trust on first use.
+// Its transport otherwise has the same settings as the pooled transport for host with at least
+// the given TLS version, if it's set.
+func (s *tofuStore) client(host string, minTLSVersion uint16) *http.Client {
+	transport := registryTransports.transport(host, minTLSVersion).Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	s.pin(transport.TLSClientConfig, host)
+	return &http.Client{Transport: transport}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOFUStoreFirstUse(t *testing.T) {
+	store := newTOFUStore(filepath.Join(t.TempDir(), "state", "tofu.json"))
+	assert.NoError(t, store.verify("mirror.example.com", [][]byte{[]byte("cert")}))
+
+	fingerprints, err := store.load()
+	assert.NoError(t, err)
+	assert.Contains(t, fingerprints, "mirror.example.com")
+	assert.True(t, strings.HasPrefix(fingerprints["mirror.example.com"], "sha256:"))
+}
+
+func TestTOFUStoreSubsequentMatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tofu.json")
+	assert.NoError(t, newTOFUStore(path).verify("mirror.example.com", [][]byte{[]byte("cert")}))
+	// A fresh store reads the fingerprint recorded by the first one
+	assert.NoError(t, newTOFUStore(path).verify("mirror.example.com", [][]byte{[]byte("cert"), []byte("intermediate")}))
+}
+
+func TestTOFUStoreChangeDetection(t *testing.T) {
+	store := newTOFUStore(filepath.Join(t.TempDir(), "tofu.json"))
+	assert.NoError(t, store.verify("mirror.example.com", [][]byte{[]byte("cert")}))
+	assert.Error(t, store.verify("mirror.example.com", [][]byte{[]byte("other-cert")}))
+	// Other hosts are tracked separately
+	assert.NoError(t, store.verify("other.example.com", [][]byte{[]byte("other-cert")}))
+	assert.Error(t, store.verify("mirror.example.com", nil))
+}
+
+func TestTOFUStoreClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+	path := filepath.Join(t.TempDir(), "tofu.json")
+
+	resp, err := newTOFUStore(path).client(host, 0).Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	resp, err = newTOFUStore(path).client(host, 0).Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+}
+
+func TestTOFUStoreClientMinTLSVersion(t *testing.T) {
+	// A mirror that only speaks TLS 1.2 with trust on first use and a minimum of TLS 1.3
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+	config := &RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"registry.example.com": {
+				Endpoints:       []string{server.URL},
+				TrustOnFirstUse: true,
+				MinTLSVersion:   "1.3",
+			},
+		},
+		TrustStateFile: filepath.Join(t.TempDir(), "tofu.json"),
+	}
+	registries, err := registryHosts(config, nil, "")("registry.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, host, registries[0].Host)
+	transport := registries[0].Client.Transport.(*http.Transport)
+	assert.Equal(t, uint16(tls.VersionTLS13), transport.TLSClientConfig.MinVersion)
+	_, err = registries[0].Client.Get(server.URL)
+	assert.ErrorContains(t, err, "protocol version")
+}
+
+func TestTOFUMirrorTokenExchange(t *testing.T) {
+	// A mirror with a self-signed certificate that serves its own tokens to authenticated clients
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, _, ok := r.BasicAuth(); !ok || user != "user" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token":"mirror"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer mirror" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:bottlerocket/container:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a")
+		w.Header().Set("Content-Length", "2")
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, "{}")
+		}
+	}))
+	defer server.Close()
+	config := &RegistryConfig{
+		Mirrors: map[string]Mirror{
+			"registry.example.com": {Endpoints: []string{server.URL}, TrustOnFirstUse: true},
+		},
+		Credentials: map[string]Credential{
+			"registry.example.com": {Username: "user", Password: "password"},
+		},
+		TrustStateFile: filepath.Join(t.TempDir(), "tofu.json"),
+	}
+	ref := "registry.example.com/bottlerocket/container:latest"
+	hosts := func(host string) ([]docker.RegistryHost, error) {
+		registries, err := registryHosts(config, nil, ref)(host)
+		if err != nil {
+			return nil, err
+		}
+		return registries[:1], nil
+	}
+
+	// The token is requested over the mirror's pinned connection, which trusts its certificate
+	_, _, err := docker.NewResolver(docker.ResolverOptions{Hosts: hosts}).Resolve(context.TODO(), ref)
+	assert.NoError(t, err)
+
+	// Once pinned, a token server presenting another certificate is rejected
+	store := newTOFUStore(config.TrustStateFile)
+	fingerprints, err := store.load()
+	assert.NoError(t, err)
+	fingerprints[strings.TrimPrefix(server.URL, "https://")] = "sha256:0000"
+	assert.NoError(t, store.save(fingerprints))
+	_, _, err = docker.NewResolver(docker.ResolverOptions{Hosts: hosts}).Resolve(context.TODO(), ref)
+	assert.Error(t, err)
+}
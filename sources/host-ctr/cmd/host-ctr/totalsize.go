@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// totalSizeMismatchError is returned when the content of a pulled image in the content store
+// doesn't add up to the sizes its manifest declares
+type totalSizeMismatchError struct {
+	Image    string
+	Expected int64
+	Actual   int64
+}
+
+func (e *totalSizeMismatchError) Error() string {
+	return fmt.Sprintf("image %s has %d bytes of content in the content store, but its manifest declares %d bytes", e.Image, e.Actual, e.Expected)
+}
+
+// verifyTotalSize checks that the total size of the image's config and layers in the content
+// store is the sum of the sizes of their descriptors in the manifest selected by matcher. The
+// content store already verifies each blob as it's written, this catches truncated content that
+// somehow got past those checks.
+func verifyTotalSize(ctx context.Context, store content.Store, name string, target ocispec.Descriptor, matcher platforms.MatchComparer) error {
+	manifest, err := images.Manifest(ctx, store, target, matcher)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read manifest for %s", name)
+	}
+	var expected, actual int64
+	for _, desc := range append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...) {
+		info, err := store.Info(ctx, desc.Digest)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read content store info for %s", desc.Digest)
+		}
+		expected += desc.Size
+		actual += info.Size
+	}
+	if actual != expected {
+		return &totalSizeMismatchError{Image: name, Expected: expected, Actual: actual}
+	}
+	return nil
+}
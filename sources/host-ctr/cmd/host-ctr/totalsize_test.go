@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/platforms"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// shortReadStore reports the blob with digest short as one byte shorter than it is, as if its
+// transfer was truncated
+type shortReadStore struct {
+	content.Store
+	short digest.Digest
+}
+
+func (s shortReadStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	info, err := s.Store.Info(ctx, dgst)
+	if dgst == s.short {
+		info.Size--
+	}
+	return info, err
+}
+
+func TestVerifyTotalSize(t *testing.T) {
+	ctx := context.Background()
+	store, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+	write := func(mediaType string, raw []byte) ocispec.Descriptor {
+		desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(raw), Size: int64(len(raw))}
+		require.NoError(t, content.WriteBlob(ctx, store, desc.Digest.String(), bytes.NewReader(raw), desc))
+		return desc
+	}
+	config := write(ocispec.MediaTypeImageConfig, []byte(`{"architecture":"amd64","os":"linux"}`))
+	layers := []ocispec.Descriptor{
+		write(ocispec.MediaTypeImageLayer, []byte("first layer")),
+		write(ocispec.MediaTypeImageLayer, []byte("second layer")),
+	}
+	manifest := ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest, Config: config, Layers: layers}
+	manifest.SchemaVersion = 2
+	raw, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	target := write(ocispec.MediaTypeImageManifest, raw)
+	matcher := platforms.Only(platforms.MustParse("linux/amd64"))
+
+	assert.NoError(t, verifyTotalSize(ctx, store, "admin:v1", target, matcher))
+
+	err = verifyTotalSize(ctx, shortReadStore{store, layers[1].Digest}, "admin:v1", target, matcher)
+	var mismatch *totalSizeMismatchError
+	require.True(t, errors.As(err, &mismatch), "expected a size mismatch, got %v", err)
+	expected := config.Size + layers[0].Size + layers[1].Size
+	assert.Equal(t, &totalSizeMismatchError{Image: "admin:v1", Expected: expected, Actual: expected - 1}, mismatch)
+}
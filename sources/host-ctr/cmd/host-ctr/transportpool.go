@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+)
+
+// The maximum number of idle connections kept open to each registry host for later requests and
+// pulls, set up from the command line. Zero leaves it at Go's default.
+var registryMaxIdleConns int
+
+// The transports registry connections are made with, shared by every pull host-ctr makes
+var registryTransports = &transportPool{}
+
+// transportSettings are the settings transports are built with, so that transports are only
+// shared while the settings are unchanged. The minimum TLS version is the host's own if it has
+// one, otherwise the registries' minimum.
+type transportSettings struct {
+	dialer        *registryDialer
+	http2Disabled bool
+	minTLSVersion uint16
+	maxConns      int
+	maxIdleConns  int
+}
+
+// transportKey identifies the transports of a transport pool
+type transportKey struct {
+	host     string
+	settings transportSettings
+}
+
+// transportPool hands out one transport per registry host, so the transport's idle connections
+// are reused by every pull from the host instead of each pull dialing and handshaking again
+type transportPool struct {
+	mu         sync.Mutex
+	transports map[transportKey]*http.Transport
+}
+
+// transport returns the transport for connections to host, with at least the given TLS version
+// if it's set. The transport is created with newTransport the first time host is connected to.
+func (p *transportPool) transport(host string, minTLSVersion uint16) *http.Transport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if minTLSVersion == 0 {
+		minTLSVersion = registryMinTLSVersion
+	}
+	key := transportKey{host: host, settings: transportSettings{
+		dialer:        defaultRegistryDialer,
+		http2Disabled: registryHTTP2Disabled,
+		minTLSVersion: minTLSVersion,
+		maxConns:      registryMaxConns,
+		maxIdleConns:  registryMaxIdleConns,
+	}}
+	if transport, ok := p.transports[key]; ok {
+		return transport
+	}
+	transport := newTransport()
+	if minTLSVersion != 0 {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.MinVersion = minTLSVersion
+	}
+	if p.transports == nil {
+		p.transports = map[transportKey]*http.Transport{}
+	}
+	p.transports[key] = transport
+	return transport
+}
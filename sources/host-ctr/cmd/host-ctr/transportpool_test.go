@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/platforms"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransportPool(t *testing.T) {
+	pool := &transportPool{}
+	transport := pool.transport("registry.example.com", 0)
+	assert.Same(t, transport, pool.transport("registry.example.com", 0))
+	assert.NotSame(t, transport, pool.transport("mirror.example.com", 0))
+
+	// Mirrors with a minimum TLS version get a transport of their own
+	strict := pool.transport("registry.example.com", tlsVersions["1.3"])
+	assert.NotSame(t, transport, strict)
+	assert.Equal(t, tlsVersions["1.3"], strict.TLSClientConfig.MinVersion)
+	assert.Nil(t, transport.TLSClientConfig)
+
+	// A mirror's minimum TLS version that's the registries' minimum shares their transport
+	defer func(version uint16) { registryMinTLSVersion = version }(registryMinTLSVersion)
+	registryMinTLSVersion = tlsVersions["1.2"]
+	transport = pool.transport("registry.example.com", 0)
+	assert.Same(t, transport, pool.transport("registry.example.com", tlsVersions["1.2"]))
+	assert.Equal(t, tlsVersions["1.2"], transport.TLSClientConfig.MinVersion)
+}
+
+func TestTransportReusedAcrossPulls(t *testing.T) {
+	defer func(max int, pool *transportPool) {
+		registryMaxIdleConns, registryTransports = max, pool
+	}(registryMaxIdleConns, registryTransports)
+	registryMaxIdleConns = 4
+	registryTransports = &transportPool{}
+	assert.True(t, customTransport())
+
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, platforms.DefaultSpec())
+	registry.tag("bottlerocket/container", "latest", manifest)
+	var dialed atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(registry.serveHTTP))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			dialed.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	config := &RegistryConfig{Mirrors: map[string]Mirror{"*": {Endpoints: []string{server.URL}}}}
+
+	// Each pull sets up its registry hosts again, as pullImage does
+	for i := 0; i < 2; i++ {
+		resolver := docker.NewResolver(docker.ResolverOptions{Hosts: registryHosts(config, nil, "")})
+		_, desc, err := resolver.Resolve(context.TODO(), "registry.example.com/bottlerocket/container:latest")
+		assert.NoError(t, err)
+		assert.Equal(t, manifest.Digest, desc.Digest)
+	}
+	assert.Equal(t, int32(1), dialed.Load(), "expected the second pull to reuse the first pull's connection")
+}
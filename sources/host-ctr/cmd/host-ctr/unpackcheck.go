@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// readLayers passes the uncompressed tar stream of each of the image's layers to addLayer, in order
+func readLayers(ctx context.Context, store content.Store, img containerd.Image, matcher platforms.MatchComparer, addLayer func(io.Reader) error) error {
+	manifest, err := images.Manifest(ctx, store, img.Target(), matcher)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read manifest for %s", img.Name())
+	}
+	for _, layer := range manifest.Layers {
+		if err := readLayer(ctx, store, layer, addLayer); err != nil {
+			return errors.Wrapf(err, "failed to read layer %s", layer.Digest)
+		}
+	}
+	return nil
+}
+
+// readLayer passes the uncompressed tar stream of a layer in the content store to addLayer
+func readLayer(ctx context.Context, store content.Store, layer ocispec.Descriptor, addLayer func(io.Reader) error) error {
+	ra, err := store.ReaderAt(ctx, layer)
+	if err != nil {
+		return err
+	}
+	defer ra.Close()
+	r, err := compression.DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return addLayer(r)
+}
+
+// The time after which garbage collection removes the snapshot views of unpacked images left
+// behind by a host-ctr process that was killed mid-check
+const unpackedViewLease = time.Hour
+
+// withUnpackedImage mounts a read-only view of the image unpacked into the default snapshotter and
+// calls f with the root of the mount
+func withUnpackedImage(ctx context.Context, client *containerd.Client, img containerd.Image, f func(root string) error) error {
+	diffIDs, err := img.RootFS(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read root filesystem of %s", img.Name())
+	}
+	// The view is only referenced by the lease, so it's garbage collected if it isn't removed
+	ctx, done, err := client.WithLease(ctx, leases.WithRandomID(), leases.WithExpiration(unpackedViewLease))
+	if err != nil {
+		return errors.Wrap(err, "failed to create lease")
+	}
+	defer func() {
+		if err := done(context.WithoutCancel(ctx)); err != nil {
+			log.G(ctx).WithError(err).Warn("failed to release lease")
+		}
+	}()
+	snapshotter := client.SnapshotService(containerd.DefaultSnapshotter)
+	// Views left behind by another host-ctr process don't collide with this one
+	key := fmt.Sprintf("host-ctr-check-%s-%d", img.Target().Digest.Encoded(), os.Getpid())
+	if err := snapshotter.Remove(ctx, key); err != nil && !errdefs.IsNotFound(err) {
+		log.G(ctx).WithError(err).WithField("key", key).Warn("failed to remove stale snapshot view")
+	}
+	mounts, err := snapshotter.View(ctx, key, identity.ChainID(diffIDs).String())
+	if err != nil {
+		return errors.Wrapf(err, "failed to view unpacked image %s", img.Name())
+	}
+	defer func() {
+		if err := snapshotter.Remove(ctx, key); err != nil {
+			log.G(ctx).WithError(err).WithField("key", key).Warn("failed to remove snapshot view")
+		}
+	}()
+	return mount.WithReadonlyTempMount(ctx, mounts, f)
+}
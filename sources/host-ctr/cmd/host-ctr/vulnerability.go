@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// The manifest annotation build pipelines can use to embed the vulnerability scan summary of an
+// image, a JSON object counting the vulnerabilities of each severity such as
+//
+//	{"critical": 0, "high": 1, "medium": 4, "low": 12}
+const vulnSummaryAnnotation = "io.bottlerocket.host-ctr.vulnerabilities"
+
+// vulnSeverity is the severity of a vulnerability; the zero value is no severity
+type vulnSeverity int
+
+const (
+	vulnLow vulnSeverity = iota + 1
+	vulnMedium
+	vulnHigh
+	vulnCritical
+)
+
+// The names of the severities, in the vulnerability summary and on the command line
+var vulnSeverityNames = map[string]vulnSeverity{
+	"low":      vulnLow,
+	"medium":   vulnMedium,
+	"high":     vulnHigh,
+	"critical": vulnCritical,
+}
+
+func (s vulnSeverity) String() string {
+	for name, severity := range vulnSeverityNames {
+		if severity == s {
+			return name
+		}
+	}
+	return "none"
+}
+
+// parseVulnSeverity parses the name of a severity, where empty means no severity
+func parseVulnSeverity(name string) (vulnSeverity, error) {
+	if name == "" {
+		return 0, nil
+	}
+	severity, ok := vulnSeverityNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("invalid vulnerability severity %q, expected one of: [low, medium, high, critical]", name)
+	}
+	return severity, nil
+}
+
+// vulnThresholdError is returned when an image has vulnerabilities more severe than allowed
+type vulnThresholdError struct {
+	Image    string
+	Severity vulnSeverity
+	Count    int
+	Max      vulnSeverity
+}
+
+func (e *vulnThresholdError) Error() string {
+	return fmt.Sprintf("image %s has %d %s vulnerabilities, more severe than the maximum severity %s", e.Image, e.Count, e.Severity, e.Max)
+}
+
+// parseVulnSummary parses the vulnerability summary in the annotations, returning whether there
+// is one. Severities other than the known ones are ignored.
+func parseVulnSummary(annotations map[string]string) (map[vulnSeverity]int, bool, error) {
+	raw, ok := annotations[vulnSummaryAnnotation]
+	if !ok {
+		return nil, false, nil
+	}
+	var counts map[string]int
+	if err := json.Unmarshal([]byte(raw), &counts); err != nil {
+		return nil, false, errors.Wrapf(err, "failed to parse %s annotation", vulnSummaryAnnotation)
+	}
+	summary := map[vulnSeverity]int{}
+	for name, count := range counts {
+		if severity, ok := vulnSeverityNames[strings.ToLower(name)]; ok {
+			summary[severity] += count
+		}
+	}
+	return summary, true, nil
+}
+
+// checkVulnSummary checks that the image has no vulnerabilities more severe than max, reporting
+// the most severe ones otherwise
+func checkVulnSummary(image string, summary map[vulnSeverity]int, max vulnSeverity) error {
+	for severity := vulnCritical; severity > max; severity-- {
+		if count := summary[severity]; count > 0 {
+			return &vulnThresholdError{Image: image, Severity: severity, Count: count, Max: max}
+		}
+	}
+	return nil
+}
+
+// verifyImageVulnerabilities reports the vulnerability summary embedded in the image's manifest,
+// and checks that it has no vulnerabilities more severe than max. Images without a summary are
+// allowed, since there's nothing to check.
+func verifyImageVulnerabilities(ctx context.Context, img containerd.Image, max vulnSeverity) error {
+	manifest, err := images.Manifest(ctx, img.ContentStore(), img.Target(), img.Platform())
+	if err != nil {
+		return errors.Wrapf(err, "failed to read manifest for %s", img.Name())
+	}
+	summary, ok, err := parseVulnSummary(manifest.Annotations)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		log.G(ctx).WithField("img", img.Name()).Warn("image has no vulnerability summary to check")
+		return nil
+	}
+	entry := log.G(ctx).WithField("img", img.Name())
+	for name, severity := range vulnSeverityNames {
+		entry = entry.WithField(name, summary[severity])
+	}
+	entry.Info("image vulnerability summary")
+	return checkVulnSummary(img.Name(), summary, max)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVulnSeverity(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    vulnSeverity
+		expectedErr bool
+	}{
+		{"Unset", "", 0, false},
+		{"Low", "low", vulnLow, false},
+		{"High", "high", vulnHigh, false},
+		{"Upper case", "CRITICAL", vulnCritical, false},
+		{"Invalid", "severe", 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			severity, err := parseVulnSeverity(tc.input)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, severity)
+		})
+	}
+}
+
+func TestParseVulnSummary(t *testing.T) {
+	summary, ok, err := parseVulnSummary(map[string]string{
+		vulnSummaryAnnotation: `{"Critical": 1, "high": 2, "medium": 0, "negligible": 7}`,
+	})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, map[vulnSeverity]int{vulnCritical: 1, vulnHigh: 2, vulnMedium: 0}, summary)
+
+	_, ok, err = parseVulnSummary(map[string]string{"org.opencontainers.image.title": "admin"})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, err = parseVulnSummary(map[string]string{vulnSummaryAnnotation: "lots"})
+	assert.Error(t, err)
+}
+
+func TestCheckVulnSummary(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		max        vulnSeverity
+		expected   *vulnThresholdError
+	}{
+		{
+			"No vulnerabilities",
+			`{"critical": 0, "high": 0, "medium": 0, "low": 0}`,
+			vulnLow,
+			nil,
+		},
+		{
+			"Under the threshold",
+			`{"critical": 0, "high": 3, "medium": 5, "low": 12}`,
+			vulnHigh,
+			nil,
+		},
+		{
+			"Over the threshold",
+			`{"critical": 2, "high": 3, "medium": 5, "low": 12}`,
+			vulnHigh,
+			&vulnThresholdError{Image: "admin:v1", Severity: vulnCritical, Count: 2, Max: vulnHigh},
+		},
+		{
+			"Most severe vulnerabilities are reported",
+			`{"high": 3, "medium": 5}`,
+			vulnLow,
+			&vulnThresholdError{Image: "admin:v1", Severity: vulnHigh, Count: 3, Max: vulnLow},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			summary, _, err := parseVulnSummary(map[string]string{vulnSummaryAnnotation: tc.annotation})
+			require.NoError(t, err)
+			err = checkVulnSummary("admin:v1", summary, tc.max)
+			if tc.expected == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var thresholdErr *vulnThresholdError
+			require.True(t, errors.As(err, &thresholdErr))
+			assert.Equal(t, tc.expected, thresholdErr)
+			assert.EqualError(t, err, "image admin:v1 has "+map[vulnSeverity]string{vulnCritical: "2 critical", vulnHigh: "3 high"}[tc.expected.Severity]+" vulnerabilities, more severe than the maximum severity "+tc.max.String())
+		})
+	}
+}
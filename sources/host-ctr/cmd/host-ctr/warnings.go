@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+)
+
+// Whether Warning headers in registry responses are logged, set up from the command line
+var registryLogWarnings bool
+
+// registryWarning is an RFC 7234 Warning header value, `code agent "text" ["date"]`
+type registryWarning struct {
+	Code  string
+	Agent string
+	Text  string
+}
+
+// parseWarning parses a Warning header value. Values that don't follow RFC 7234 are kept as the
+// warning's text, so no warning is lost.
+func parseWarning(value string) registryWarning {
+	code, rest, ok := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok {
+		return registryWarning{Text: value}
+	}
+	agent, rest, ok := strings.Cut(strings.TrimSpace(rest), " ")
+	if !ok {
+		return registryWarning{Text: value}
+	}
+	quoted, err := strconv.QuotedPrefix(strings.TrimSpace(rest))
+	if err != nil {
+		return registryWarning{Text: value}
+	}
+	text, err := strconv.Unquote(quoted)
+	if err != nil {
+		return registryWarning{Text: value}
+	}
+	return registryWarning{Code: code, Agent: agent, Text: text}
+}
+
+// warningHosts wraps the clients of the registry hosts to log the Warning headers of responses
+func warningHosts(hosts docker.RegistryHosts) docker.RegistryHosts {
+	return func(host string) ([]docker.RegistryHost, error) {
+		registries, err := hosts(host)
+		if err != nil {
+			return nil, err
+		}
+		for i := range registries {
+			client := http.Client{}
+			if registries[i].Client != nil {
+				client = *registries[i].Client
+			}
+			transport := client.Transport
+			if transport == nil {
+				transport = http.DefaultTransport
+			}
+			client.Transport = &warningTransport{transport}
+			registries[i].Client = &client
+		}
+		return registries, nil
+	}
+}
+
+// warningTransport logs the Warning headers of registry responses, such as deprecation notices,
+// so operators notice them
+type warningTransport struct {
+	http.RoundTripper
+}
+
+func (t *warningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	for _, value := range resp.Header.Values("Warning") {
+		warning := parseWarning(value)
+		log.G(req.Context()).
+			WithField("url", req.URL.Redacted()).
+			WithField("code", warning.Code).
+			WithField("agent", warning.Agent).
+			WithField("text", warning.Text).
+			Warn("registry returned a warning")
+	}
+	return resp, nil
+}
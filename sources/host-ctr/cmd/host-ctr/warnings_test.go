@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWarning(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected registryWarning
+	}{
+		{`299 registry.example.com "this repository is deprecated"`, registryWarning{"299", "registry.example.com", "this repository is deprecated"}},
+		{`299 - "quoted \"text\"" "Wed, 21 Oct 2026 07:28:00 GMT"`, registryWarning{"299", "-", `quoted "text"`}},
+		{`this repository is deprecated`, registryWarning{Text: "this repository is deprecated"}},
+		{`299 -`, registryWarning{Text: "299 -"}},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, parseWarning(tc.value), tc.value)
+	}
+}
+
+func TestWarningHostsLogsWarnings(t *testing.T) {
+	registry := newFakeRegistry(t)
+	manifest, _ := registry.addImage(t, platforms.DefaultSpec())
+	registry.tag("bottlerocket/container", "latest", manifest)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Warning", `299 - "bottlerocket/container is deprecated, use bottlerocket/admin"`)
+		registry.serveHTTP(w, req)
+	}))
+	t.Cleanup(server.Close)
+	hosts := func(string) ([]docker.RegistryHost, error) {
+		return []docker.RegistryHost{{
+			Host:         strings.TrimPrefix(server.URL, "http://"),
+			Scheme:       "http",
+			Path:         "/v2",
+			Capabilities: docker.HostCapabilityResolve | docker.HostCapabilityPull,
+		}}, nil
+	}
+
+	hook := test.NewLocal(log.L.Logger)
+	defer hook.Reset()
+
+	resolver := docker.NewResolver(docker.ResolverOptions{Hosts: warningHosts(hosts)})
+	_, _, err := resolver.Resolve(context.TODO(), "registry.example.com/bottlerocket/container:latest")
+	assert.NoError(t, err)
+
+	var warnings []*logrus.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "registry returned a warning" {
+			warnings = append(warnings, entry)
+		}
+	}
+	if assert.NotEmpty(t, warnings) {
+		assert.Equal(t, logrus.WarnLevel, warnings[0].Level)
+		assert.Equal(t, "299", warnings[0].Data["code"])
+		assert.Equal(t, "bottlerocket/container is deprecated, use bottlerocket/admin", warnings[0].Data["text"])
+		assert.Contains(t, warnings[0].Data["url"], "/v2/bottlerocket/container/manifests/latest")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	"github.com/pkg/errors"
+)
+
+// OCI layer whiteout markers, see https://github.com/opencontainers/image-spec/blob/main/layer.md#whiteouts
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// whiteoutError is returned when files deleted by an image's layers are still present in the
+// unpacked image
+type whiteoutError struct {
+	Ref   string
+	Paths []string
+}
+
+func (e *whiteoutError) Error() string {
+	return fmt.Sprintf("snapshotter failed to apply whiteouts for %s, deleted files are present: %s", e.Ref, strings.Join(e.Paths, ", "))
+}
+
+// whiteoutTracker follows the files added and deleted by the layers of an image, in order
+type whiteoutTracker struct {
+	// Files present after the layers applied so far
+	present map[string]bool
+	// Files deleted by the layers applied so far
+	deleted map[string]bool
+}
+
+func newWhiteoutTracker() *whiteoutTracker {
+	return &whiteoutTracker{present: map[string]bool{}, deleted: map[string]bool{}}
+}
+
+// remove marks the file and everything below it as deleted, except for the files the current
+// layer adds
+func (w *whiteoutTracker) remove(p string, keep map[string]bool) {
+	for existing := range w.present {
+		if (existing == p || strings.HasPrefix(existing, p+"/")) && !keep[existing] {
+			delete(w.present, existing)
+			w.deleted[existing] = true
+		}
+	}
+}
+
+// addLayer applies the changes in an uncompressed layer tar stream
+func (w *whiteoutTracker) addLayer(r io.Reader) error {
+	var (
+		added    = map[string]bool{}
+		removed  []string
+		opaques  []string
+		tarFiles = tar.NewReader(r)
+	)
+	for {
+		hdr, err := tarFiles.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read layer")
+		}
+		name := path.Clean("/" + hdr.Name)
+		dir, base := path.Split(name)
+		dir = path.Clean(dir)
+		switch {
+		case base == whiteoutOpaque:
+			opaques = append(opaques, dir)
+		case strings.HasPrefix(base, whiteoutPrefix):
+			removed = append(removed, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+		default:
+			added[name] = true
+		}
+	}
+	for _, p := range removed {
+		w.remove(p, nil)
+	}
+	// Opaque directories hide the lower layers' files, but not the ones added along with the marker
+	for _, dir := range opaques {
+		for existing := range w.present {
+			if strings.HasPrefix(existing, strings.TrimSuffix(dir, "/")+"/") && !added[existing] {
+				delete(w.present, existing)
+				w.deleted[existing] = true
+			}
+		}
+	}
+	for p := range added {
+		w.present[p] = true
+		delete(w.deleted, p)
+	}
+	return nil
+}
+
+// deletedPaths returns the files the layers deleted, sorted
+func (w *whiteoutTracker) deletedPaths() []string {
+	var paths []string
+	for p := range w.deleted {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// checkWhiteouts returns the deleted files that are present in the unpacked root filesystem
+func checkWhiteouts(root string, deleted []string) ([]string, error) {
+	var leaked []string
+	for _, p := range deleted {
+		_, err := os.Lstat(filepath.Join(root, p))
+		if err == nil {
+			leaked = append(leaked, p)
+			continue
+		}
+		// A deleted directory takes its contents with it
+		if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR) {
+			return nil, errors.Wrapf(err, "failed to check %s", p)
+		}
+	}
+	return leaked, nil
+}
+
+// validateWhiteouts checks that the files deleted by the image's layers are absent in the image
+// unpacked into the default snapshotter, since some filesystems don't support overlay whiteouts
+func validateWhiteouts(ctx context.Context, client *containerd.Client, img containerd.Image, matcher platforms.MatchComparer) error {
+	tracker := newWhiteoutTracker()
+	if err := readLayers(ctx, client.ContentStore(), img, matcher, tracker.addLayer); err != nil {
+		return err
+	}
+	deleted := tracker.deletedPaths()
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	var leaked []string
+	err := withUnpackedImage(ctx, client, img, func(root string) error {
+		var err error
+		leaked, err = checkWhiteouts(root, deleted)
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to check whiteouts of %s", img.Name())
+	}
+	if len(leaked) != 0 {
+		return &whiteoutError{Ref: img.Name(), Paths: leaked}
+	}
+	log.G(ctx).WithField("img", img.Name()).WithField("deleted", len(deleted)).Debug("validated whiteouts")
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// layerTar builds an uncompressed layer with empty files at the given paths
+func layerTar(t *testing.T, paths ...string) *bytes.Buffer {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, p := range paths {
+		assert.NoError(t, w.WriteHeader(&tar.Header{Name: p, Mode: 0o644, Typeflag: tar.TypeReg}))
+	}
+	assert.NoError(t, w.Close())
+	return &buf
+}
+
+func TestWhiteoutTracker(t *testing.T) {
+	tests := []struct {
+		name     string
+		layers   [][]string
+		expected []string
+	}{
+		{
+			"no whiteouts",
+			[][]string{{"etc/a"}, {"etc/b"}},
+			nil,
+		},
+		{
+			"deleted file",
+			[][]string{{"etc/secret", "etc/keep"}, {"etc/.wh.secret"}},
+			[]string{"/etc/secret"},
+		},
+		{
+			"deleted directory",
+			[][]string{{"opt/app/a", "opt/app/b"}, {"opt/.wh.app"}},
+			[]string{"/opt/app/a", "/opt/app/b"},
+		},
+		{
+			"opaque directory",
+			[][]string{{"opt/app/a", "opt/keep"}, {"opt/app/.wh..wh..opq", "opt/app/b"}},
+			[]string{"/opt/app/a"},
+		},
+		{
+			"added again",
+			[][]string{{"etc/secret"}, {"etc/.wh.secret"}, {"etc/secret"}},
+			nil,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := newWhiteoutTracker()
+			for _, layer := range tc.layers {
+				assert.NoError(t, tracker.addLayer(layerTar(t, layer...)))
+			}
+			assert.Equal(t, tc.expected, tracker.deletedPaths())
+		})
+	}
+}
+
+func TestCheckWhiteouts(t *testing.T) {
+	// An image whose second layer deletes a file from the first one
+	tracker := newWhiteoutTracker()
+	assert.NoError(t, tracker.addLayer(layerTar(t, "etc/secret", "etc/keep")))
+	assert.NoError(t, tracker.addLayer(layerTar(t, "etc/.wh.secret")))
+	deleted := tracker.deletedPaths()
+
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "etc", "keep"), nil, 0o644))
+
+	t.Run("applied", func(t *testing.T) {
+		leaked, err := checkWhiteouts(root, deleted)
+		assert.NoError(t, err)
+		assert.Empty(t, leaked)
+	})
+	t.Run("leaked", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(filepath.Join(root, "etc", "secret"), nil, 0o644))
+		leaked, err := checkWhiteouts(root, deleted)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"/etc/secret"}, leaked)
+		assert.EqualError(t, &whiteoutError{Ref: "example.com/image:latest", Paths: leaked},
+			"snapshotter failed to apply whiteouts for example.com/image:latest, deleted files are present: /etc/secret")
+	})
+	t.Run("below a file", func(t *testing.T) {
+		leaked, err := checkWhiteouts(root, []string{"/etc/keep/file"})
+		assert.NoError(t, err)
+		assert.Empty(t, leaked)
+	})
+}
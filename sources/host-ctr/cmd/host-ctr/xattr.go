@@ -0,0 +1,152 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// The prefix of PAX records holding extended attributes in layer tar streams
+const paxXattrPrefix = "SCHILY.xattr."
+
+// xattrTracker follows the extended attributes set on files by the layers of an image, in order
+type xattrTracker struct {
+	// Extended attributes by file
+	xattrs map[string]map[string]string
+}
+
+func newXattrTracker() *xattrTracker {
+	return &xattrTracker{xattrs: map[string]map[string]string{}}
+}
+
+// remove forgets the extended attributes of the file and everything below it
+func (x *xattrTracker) remove(p string) {
+	for existing := range x.xattrs {
+		if existing == p || strings.HasPrefix(existing, strings.TrimSuffix(p, "/")+"/") {
+			delete(x.xattrs, existing)
+		}
+	}
+}
+
+// addLayer applies the changes in an uncompressed layer tar stream
+func (x *xattrTracker) addLayer(r io.Reader) error {
+	tarFiles := tar.NewReader(r)
+	for {
+		hdr, err := tarFiles.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read layer")
+		}
+		name := path.Clean("/" + hdr.Name)
+		dir, base := path.Split(name)
+		switch {
+		case base == whiteoutOpaque:
+			x.remove(path.Clean(dir) + "/")
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			x.remove(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+			continue
+		}
+		// A file added again replaces the lower layer's file and its extended attributes
+		delete(x.xattrs, name)
+		for key, value := range hdr.PAXRecords {
+			if attr, ok := strings.CutPrefix(key, paxXattrPrefix); ok {
+				if x.xattrs[name] == nil {
+					x.xattrs[name] = map[string]string{}
+				}
+				x.xattrs[name][attr] = value
+			}
+		}
+	}
+}
+
+// checkXattrs returns the files in the unpacked root filesystem whose extended attributes are
+// missing or differ from the expected ones, and whether the filesystem doesn't support them at all
+func checkXattrs(root string, expected map[string]map[string]string) ([]string, bool, error) {
+	var (
+		paths      []string
+		mismatched []string
+	)
+	for p := range expected {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		for attr, value := range expected[p] {
+			actual, err := lgetxattr(filepath.Join(root, p), attr)
+			if errors.Is(err, unix.ENOTSUP) {
+				return nil, true, nil
+			}
+			if err != nil && !errors.Is(err, unix.ENODATA) {
+				return nil, false, errors.Wrapf(err, "failed to read extended attribute %s of %s", attr, p)
+			}
+			if err != nil || actual != value {
+				mismatched = append(mismatched, p)
+				break
+			}
+		}
+	}
+	return mismatched, false, nil
+}
+
+// lgetxattr returns the value of a file's extended attribute, without following symlinks
+func lgetxattr(p string, attr string) (string, error) {
+	size, err := unix.Lgetxattr(p, attr, nil)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Lgetxattr(p, attr, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:size]), nil
+}
+
+// validateXattrs checks that the extended attributes set by the image's layers, e.g. file
+// capabilities or SELinux labels, are preserved in the image unpacked into the default snapshotter.
+// Extended attributes that weren't preserved are only logged, since containerd ignores those the
+// filesystem rejects when unpacking.
+func validateXattrs(ctx context.Context, client *containerd.Client, img containerd.Image, matcher platforms.MatchComparer) error {
+	tracker := newXattrTracker()
+	if err := readLayers(ctx, client.ContentStore(), img, matcher, tracker.addLayer); err != nil {
+		return err
+	}
+	if len(tracker.xattrs) == 0 {
+		return nil
+	}
+
+	var (
+		mismatched  []string
+		unsupported bool
+	)
+	err := withUnpackedImage(ctx, client, img, func(root string) error {
+		var err error
+		mismatched, unsupported, err = checkXattrs(root, tracker.xattrs)
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to check extended attributes of %s", img.Name())
+	}
+	switch {
+	case unsupported:
+		log.G(ctx).WithField("img", img.Name()).Warn("the snapshotter's filesystem doesn't support extended attributes, which the image's files rely on")
+	case len(mismatched) != 0:
+		log.G(ctx).WithField("img", img.Name()).WithField("paths", mismatched).Warn("extended attributes of the image's files weren't preserved when unpacking")
+	default:
+		log.G(ctx).WithField("img", img.Name()).WithField("files", len(tracker.xattrs)).Debug("validated extended attributes")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// xattrLayer builds an uncompressed layer with empty files, with the given extended attributes
+func xattrLayer(t *testing.T, files map[string]map[string]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for name, xattrs := range files {
+		hdr := &tar.Header{Name: name, Mode: 0o755, Typeflag: tar.TypeReg, Format: tar.FormatPAX}
+		if len(xattrs) != 0 {
+			hdr.PAXRecords = map[string]string{}
+			for attr, value := range xattrs {
+				hdr.PAXRecords[paxXattrPrefix+attr] = value
+			}
+		}
+		assert.NoError(t, w.WriteHeader(hdr))
+	}
+	assert.NoError(t, w.Close())
+	return &buf
+}
+
+func TestXattrTracker(t *testing.T) {
+	capability := "\x01\x00\x00\x02\x00\x20\x00\x00"
+	tracker := newXattrTracker()
+	assert.NoError(t, tracker.addLayer(xattrLayer(t, map[string]map[string]string{
+		"usr/bin/ping":   {"security.capability": capability},
+		"usr/bin/arping": {"security.capability": capability},
+		"etc/shadow":     {"security.selinux": "system_u:object_r:shadow_t:s0"},
+		"etc/hosts":      nil,
+	})))
+	assert.NoError(t, tracker.addLayer(xattrLayer(t, map[string]map[string]string{
+		// Replaced without the capability
+		"usr/bin/arping": nil,
+		// Deleted
+		"etc/.wh.shadow": nil,
+	})))
+	assert.Equal(t, map[string]map[string]string{
+		"/usr/bin/ping": {"security.capability": capability},
+	}, tracker.xattrs)
+}
+
+func TestCheckXattrs(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "usr", "bin"), 0o755))
+	for _, name := range []string{"preserved", "lost"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(root, "usr", "bin", name), nil, 0o755))
+	}
+	err := unix.Lsetxattr(filepath.Join(root, "usr", "bin", "preserved"), "user.bottlerocket", []byte("value"), 0)
+	if errors.Is(err, unix.ENOTSUP) {
+		t.Skip("the temporary directory's filesystem doesn't support extended attributes")
+	}
+	assert.NoError(t, err)
+
+	t.Run("preserved", func(t *testing.T) {
+		mismatched, unsupported, err := checkXattrs(root, map[string]map[string]string{
+			"/usr/bin/preserved": {"user.bottlerocket": "value"},
+		})
+		assert.NoError(t, err)
+		assert.False(t, unsupported)
+		assert.Empty(t, mismatched)
+	})
+	t.Run("lost", func(t *testing.T) {
+		mismatched, unsupported, err := checkXattrs(root, map[string]map[string]string{
+			"/usr/bin/preserved": {"user.bottlerocket": "other value"},
+			"/usr/bin/lost":      {"user.bottlerocket": "value"},
+		})
+		assert.NoError(t, err)
+		assert.False(t, unsupported)
+		assert.Equal(t, []string{"/usr/bin/lost", "/usr/bin/preserved"}, mismatched)
+	})
+}